@@ -2,7 +2,9 @@
 
 #include <stdlib.h>
 #include <string.h>
+#include <algorithm>
 #include <map>
+#include <mutex>
 
 #include "MMCore.h"
 #include "MMEventCallback.h"
@@ -12,11 +14,12 @@ extern "C" {
 class MM_CPP_EventCallback;
 void MM_FreeRegisteredCallback(MM_Session mm);
 static std::map<MM_Session, MM_CPP_EventCallback*> mm_registered_callbacks;
+static std::mutex mm_registered_callbacks_mutex;
 
 void std_to_c_string(std::string std_str, char **c_str) {
     size_t cap_c_str = std_str.size() + 1;
     *c_str = (char *)malloc(cap_c_str);
-    strcpy_s(*c_str, cap_c_str, std_str.c_str());
+    memcpy(*c_str, std_str.c_str(), cap_c_str);
     return;
 }
 
@@ -234,6 +237,7 @@ DllExport void MM_RegisterCallback(MM_Session mm, struct MM_EventCallback *callb
     core->registerCallback(cb);
 
     // Save the pointer
+    std::lock_guard<std::mutex> lock(mm_registered_callbacks_mutex);
     mm_registered_callbacks[mm] = cb;
     return;
 }
@@ -243,16 +247,15 @@ void MM_FreeRegisteredCallback(MM_Session mm) {
 		return;
 	}
 
-    std::map<MM_Session, MM_CPP_EventCallback*>::iterator it = mm_registered_callbacks.begin();
-    while(it != mm_registered_callbacks.end()) {
-        if (it->first == mm) {
-			CMMCore *core = reinterpret_cast<CMMCore *>(mm);
-		    core->registerCallback(nullptr);
+    // Sessions can be used from different threads, so the map is shared between them.
+    std::lock_guard<std::mutex> lock(mm_registered_callbacks_mutex);
+    std::map<MM_Session, MM_CPP_EventCallback*>::iterator it = mm_registered_callbacks.find(mm);
+    if (it != mm_registered_callbacks.end()) {
+        CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+        core->registerCallback(nullptr);
 
-            delete it->second;
-            mm_registered_callbacks.erase(it);
-        }
-        it++;
+        delete it->second;
+        mm_registered_callbacks.erase(it);
     }
 }
 
@@ -358,6 +361,43 @@ DllExport MM_Status MM_GetLoadedDevices(MM_Session mm, char ***labels) {
     return MM_ErrOK;
 }
 
+DllExport MM_Status MM_GetDeviceType(MM_Session mm, const char *label,
+                                     MM_DeviceType *type) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *type = (MM_DeviceType)core->getDeviceType(label);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetDeviceLibrary(MM_Session mm, const char *label,
+                                        char **module_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::string str;
+    try {
+        str = core->getDeviceLibrary(label);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string(str, module_name);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetDeviceName(MM_Session mm, const char *label,
+                                     char **dev_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::string str;
+    try {
+        str = core->getDeviceName(label);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string(str, dev_name);
+    return MM_ErrOK;
+}
+
 DllExport MM_Status MM_GetDevicePropertyNames(MM_Session mm, const char *label,
                                               char ***names) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
@@ -556,6 +596,83 @@ DllExport MM_Status MM_GetPropertyType(MM_Session mm, const char *label,
     return MM_ErrOK;
 }
 
+// describe_property fills in the descriptor. It throws CMMError as the CMMCore calls do.
+static void describe_property(CMMCore *core, const char *label,
+                              const std::string &prop_name,
+                              MM_PropertyDescriptor *desc) {
+    const char *name = prop_name.c_str();
+    std_to_c_string(prop_name, &desc->name);
+    std_to_c_string(core->getProperty(label, name), &desc->value);
+    desc->type = (MM_PropertyType)core->getPropertyType(label, name);
+    desc->read_only = (bool)core->isPropertyReadOnly(label, name);
+    desc->pre_init = (bool)core->isPropertyPreInit(label, name);
+    desc->sequenceable = (bool)core->isPropertySequenceable(label, name);
+    desc->has_limits = (bool)core->hasPropertyLimits(label, name);
+    if (desc->has_limits) {
+        desc->lower_limit = core->getPropertyLowerLimit(label, name);
+        desc->upper_limit = core->getPropertyUpperLimit(label, name);
+    }
+    std_to_c_string_list(core->getAllowedPropertyValues(label, name),
+                         &desc->allowed_values);
+}
+
+DllExport MM_Status MM_DescribeProperty(MM_Session mm, const char *label,
+                                        const char *prop_name,
+                                        MM_PropertyDescriptor **desc) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    *desc = (MM_PropertyDescriptor *)calloc(1, sizeof(MM_PropertyDescriptor));
+    try {
+        describe_property(core, label, prop_name, *desc);
+    } catch (CMMError &e) {
+        MM_PropertyDescriptorsFree(*desc, 1);
+        *desc = NULL;
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DescribeDevice(MM_Session mm, const char *label,
+                                      MM_PropertyDescriptor **descs,
+                                      size_t *len_descs) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    *descs = NULL;
+    *len_descs = 0;
+
+    std::vector<std::string> names;
+    try {
+        names = core->getDevicePropertyNames(label);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+
+    MM_PropertyDescriptor *list = (MM_PropertyDescriptor *)calloc(
+        names.size() + 1, sizeof(MM_PropertyDescriptor));
+    try {
+        for (size_t i = 0; i < names.size(); i++) {
+            describe_property(core, label, names[i], &list[i]);
+        }
+    } catch (CMMError &e) {
+        MM_PropertyDescriptorsFree(list, names.size());
+        return MM_Status(e.getCode());
+    }
+    *descs = list;
+    *len_descs = names.size();
+    return MM_ErrOK;
+}
+
+DllExport void MM_PropertyDescriptorsFree(MM_PropertyDescriptor *descs,
+                                          size_t len_descs) {
+    if (descs == NULL) {
+        return;
+    }
+    for (size_t i = 0; i < len_descs; i++) {
+        MM_StringFree(descs[i].name);
+        MM_StringFree(descs[i].value);
+        MM_StringListFree(descs[i].allowed_values);
+    }
+    free(descs);
+}
+
 DllExport MM_Status MM_DeviceBusy(MM_Session mm, const char *label,
                                   uint8_t *busy) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
@@ -578,6 +695,28 @@ DllExport MM_Status MM_DeviceTypeBusy(MM_Session mm, MM_DeviceType type,
     return MM_ErrOK;
 }
 
+DllExport MM_Status MM_SetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double delay_ms) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->setDeviceDelayMs(label, delay_ms);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double *delay_ms) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *delay_ms = core->getDeviceDelayMs(label);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
 //
 // Manage current devices
 //
@@ -624,7 +763,7 @@ DllExport MM_Status MM_SetXYStageDevice(MM_Session mm, const char *label) {
 DllExport MM_Status MM_SetAutoFocusDevice(MM_Session mm, const char *label) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
     try {
-        core->setXYStageDevice(label);
+        core->setAutoFocusDevice(label);
     } catch (CMMError &e) {
         return MM_Status(e.getCode());
     }
@@ -666,6 +805,403 @@ DllExport void MM_GetAutoFocusDevice(MM_Session mm, char **label) {
     return;
 }
 
+//
+// Configuration groups and presets
+//
+DllExport MM_Status MM_DefineConfigGroup(MM_Session mm, const char *group_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->defineConfigGroup(group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DeleteConfigGroup(MM_Session mm, const char *group_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->deleteConfigGroup(group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_RenameConfigGroup(MM_Session mm, const char *old_group_name,
+                                         const char *new_group_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->renameConfigGroup(old_group_name, new_group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_IsGroupDefined(MM_Session mm, const char *group_name,
+                                      uint8_t *defined) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *defined = (bool)core->isGroupDefined(group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailableConfigGroups(MM_Session mm, char ***group_names) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::vector<std::string> list;
+    try {
+        list = core->getAvailableConfigGroups();
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string_list(list, group_names);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DefineConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->defineConfig(group_name, config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DefineConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name,
+                                           const char *value) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->defineConfig(group_name, config_name, label, prop_name, value);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DeleteConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->deleteConfig(group_name, config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DeleteConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->deleteConfig(group_name, config_name, label, prop_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_RenameConfig(MM_Session mm, const char *group_name,
+                                    const char *old_config_name,
+                                    const char *new_config_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->renameConfig(group_name, old_config_name, new_config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_IsConfigDefined(MM_Session mm, const char *group_name,
+                                       const char *config_name,
+                                       uint8_t *defined) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *defined = (bool)core->isConfigDefined(group_name, config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailableConfigs(MM_Session mm, const char *group_name,
+                                           char ***config_names) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::vector<std::string> list;
+    try {
+        list = core->getAvailableConfigs(group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string_list(list, config_names);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_SetConfig(MM_Session mm, const char *group_name,
+                                 const char *config_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->setConfig(group_name, config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetCurrentConfig(MM_Session mm, const char *group_name,
+                                        char **config_name) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::string str;
+    try {
+        str = core->getCurrentConfig(group_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string(str, config_name);
+    return MM_ErrOK;
+}
+
+// configuration_to_c converts the settings of a configuration.
+static MM_Status configuration_to_c(Configuration &config, MM_PropertySetting **settings,
+                                    size_t *len_settings) {
+    size_t n = config.size();
+    MM_PropertySetting *list = (MM_PropertySetting *)calloc(n + 1, sizeof(MM_PropertySetting));
+    try {
+        for (size_t i = 0; i < n; i++) {
+            PropertySetting setting = config.getSetting(i);
+            std_to_c_string(setting.getDeviceLabel(), &list[i].label);
+            std_to_c_string(setting.getPropertyName(), &list[i].prop_name);
+            std_to_c_string(setting.getPropertyValue(), &list[i].value);
+        }
+    } catch (CMMError &e) {
+        MM_PropertySettingsFree(list, n);
+        return MM_Status(e.getCode());
+    }
+    *settings = list;
+    *len_settings = n;
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetConfigData(MM_Session mm, const char *group_name,
+                                     const char *config_name,
+                                     MM_PropertySetting **settings,
+                                     size_t *len_settings) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    *settings = NULL;
+    *len_settings = 0;
+
+    Configuration config;
+    try {
+        config = core->getConfigData(group_name, config_name);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return configuration_to_c(config, settings, len_settings);
+}
+
+DllExport void MM_PropertySettingsFree(MM_PropertySetting *settings,
+                                       size_t len_settings) {
+    if (settings == NULL) {
+        return;
+    }
+    for (size_t i = 0; i < len_settings; i++) {
+        MM_StringFree(settings[i].label);
+        MM_StringFree(settings[i].prop_name);
+        MM_StringFree(settings[i].value);
+    }
+    free(settings);
+}
+
+//
+// Pixel size configurations
+//
+
+DllExport MM_Status MM_DefinePixelSizeConfig(MM_Session mm, const char *resolution_id,
+                                             const char *label,
+                                             const char *prop_name,
+                                             const char *value) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->definePixelSizeConfig(resolution_id, label, prop_name, value);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_SetPixelSizeUm(MM_Session mm, const char *resolution_id,
+                                      double pixel_size_um) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->setPixelSizeUm(resolution_id, pixel_size_um);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_SetPixelSizeAffine(MM_Session mm, const char *resolution_id,
+                                          const double affine[6]) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->setPixelSizeAffine(resolution_id, std::vector<double>(affine, affine + 6));
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DeletePixelSizeConfig(MM_Session mm, const char *resolution_id) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->deletePixelSizeConfig(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_RenamePixelSizeConfig(MM_Session mm,
+                                             const char *old_resolution_id,
+                                             const char *new_resolution_id) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->renamePixelSizeConfig(old_resolution_id, new_resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_IsPixelSizeConfigDefined(MM_Session mm, const char *resolution_id,
+                                                uint8_t *defined) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *defined = (bool)core->isPixelSizeConfigDefined(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailablePixelSizeConfigs(MM_Session mm, char ***resolution_ids) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::vector<std::string> list;
+    try {
+        list = core->getAvailablePixelSizeConfigs();
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string_list(list, resolution_ids);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeConfigData(MM_Session mm, const char *resolution_id,
+                                              MM_PropertySetting **settings,
+                                              size_t *len_settings) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    *settings = NULL;
+    *len_settings = 0;
+
+    Configuration config;
+    try {
+        config = core->getPixelSizeConfigData(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return configuration_to_c(config, settings, len_settings);
+}
+
+DllExport MM_Status MM_SetPixelSizeConfig(MM_Session mm, const char *resolution_id) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        core->setPixelSizeConfig(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetCurrentPixelSizeConfig(MM_Session mm, char **resolution_id) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::string str;
+    try {
+        str = core->getCurrentPixelSizeConfig();
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    std_to_c_string(str, resolution_id);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeUm(MM_Session mm, double *pixel_size_um) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *pixel_size_um = core->getPixelSizeUm();
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeUmByID(MM_Session mm, const char *resolution_id,
+                                          double *pixel_size_um) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    try {
+        *pixel_size_um = core->getPixelSizeUmByID(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return MM_ErrOK;
+}
+
+// affine_to_c copies an affine transform, which MMCore returns as a vector.
+static MM_Status affine_to_c(const std::vector<double> &v, double affine[6]) {
+    if (v.size() != 6) {
+        return MM_ErrBadAffineTransform;
+    }
+    std::copy(v.begin(), v.end(), affine);
+    return MM_ErrOK;
+}
+
+// MM_GetPixelSizeAffine returns a transform of zeros if no configuration matches,
+// as MM_GetPixelSizeUm returns 0.
+DllExport MM_Status MM_GetPixelSizeAffine(MM_Session mm, double affine[6]) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::vector<double> v(6, 0.0);
+    try {
+        if (!core->getCurrentPixelSizeConfig().empty()) {
+            v = core->getPixelSizeAffine();
+        }
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return affine_to_c(v, affine);
+}
+
+DllExport MM_Status MM_GetPixelSizeAffineByID(MM_Session mm, const char *resolution_id,
+                                              double affine[6]) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    std::vector<double> v;
+    try {
+        v = core->getPixelSizeAffineByID(resolution_id);
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    return affine_to_c(v, affine);
+}
+
 //
 // Image acquisition
 //
@@ -833,13 +1369,13 @@ DllExport MM_Status MM_ClearCircularBuffer(MM_Session mm) {
     return MM_ErrOK;
 }
 
-DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
-                                                int16_t num_images,
-                                                double interval_ms,
-                                                uint8_t stop_on_overflow) {
+DllExport MM_Status MM_StartSequenceAcquisition_v2(MM_Session mm,
+                                                   int32_t num_images,
+                                                   double interval_ms,
+                                                   uint8_t stop_on_overflow) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
     try {
-        core->startSequenceAcquisition(num_images, interval_ms,
+        core->startSequenceAcquisition((long)num_images, interval_ms,
                                        (bool)(stop_on_overflow != 0));
     } catch (CMMError &e) {
         return MM_Status(e.getCode());
@@ -847,6 +1383,14 @@ DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
     return MM_ErrOK;
 }
 
+DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
+                                                int16_t num_images,
+                                                double interval_ms,
+                                                uint8_t stop_on_overflow) {
+    return MM_StartSequenceAcquisition_v2(mm, num_images, interval_ms,
+                                          stop_on_overflow);
+}
+
 DllExport MM_Status MM_StartContinuousSequenceAcquisition(MM_Session mm,
                                                           double interval_ms) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
@@ -894,24 +1438,121 @@ DllExport MM_Status MM_PopNextImage(MM_Session mm, uint8_t **ptr_buffer) {
     return MM_ErrOK;
 }
 
-DllExport void MM_GetRemainingImageCount(MM_Session mm, int16_t *count) {
+// metadata_to_c converts the tags of the metadata of an image.
+// The values of array tags are joined with ",".
+static void metadata_to_c(Metadata &metadata, MM_Metadata *md) {
+    std::vector<std::string> keys = metadata.GetKeys();
+    md->tags = (MM_MetadataTag *)calloc(keys.size() + 1, sizeof(MM_MetadataTag));
+    md->len_tags = 0;
+    for (const std::string &key : keys) {
+        std::string value;
+        try {
+            value = metadata.GetSingleTag(key.c_str()).GetValue();
+        } catch (MetadataKeyError &) {
+            try {
+                MetadataArrayTag tag = metadata.GetArrayTag(key.c_str());
+                for (size_t i = 0; i < tag.GetSize(); i++) {
+                    if (i > 0) {
+                        value += ",";
+                    }
+                    value += tag.GetValue(i);
+                }
+            } catch (MetadataKeyError &) {
+                continue;
+            }
+        }
+        MM_MetadataTag *tag = &md->tags[md->len_tags++];
+        std_to_c_string(key, &tag->key);
+        std_to_c_string(value, &tag->value);
+    }
+}
+
+DllExport MM_Status MM_GetLastImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    md->tags = NULL;
+    md->len_tags = 0;
+
+    Metadata metadata;
+    try {
+        *ptr_buffer = (uint8_t *)(core->getLastImageMD(metadata));
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    metadata_to_c(metadata, md);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_PopNextImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md) {
+    CMMCore *core = reinterpret_cast<CMMCore *>(mm);
+    md->tags = NULL;
+    md->len_tags = 0;
+
+    Metadata metadata;
+    try {
+        *ptr_buffer = (uint8_t *)(core->popNextImageMD(metadata));
+    } catch (CMMError &e) {
+        return MM_Status(e.getCode());
+    }
+    metadata_to_c(metadata, md);
+    return MM_ErrOK;
+}
+
+DllExport void MM_MetadataFree(MM_Metadata *md) {
+    if (md->tags == NULL) {
+        return;
+    }
+    for (size_t i = 0; i < md->len_tags; i++) {
+        MM_StringFree(md->tags[i].key);
+        MM_StringFree(md->tags[i].value);
+    }
+    free(md->tags);
+    md->tags = NULL;
+    md->len_tags = 0;
+}
+
+DllExport void MM_GetRemainingImageCount_v2(MM_Session mm, int64_t *count) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
-    *count = (int16_t)core->getRemainingImageCount();
+    *count = (int64_t)core->getRemainingImageCount();
     return;
 }
 
-DllExport void MM_GetBufferTotalCapacity(MM_Session mm, int16_t *capacity) {
+DllExport void MM_GetBufferTotalCapacity_v2(MM_Session mm, int64_t *capacity) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
-    *capacity = (int16_t)core->getBufferTotalCapacity();
+    *capacity = (int64_t)core->getBufferTotalCapacity();
     return;
 }
 
-DllExport void MM_GetBufferFreeCapacity(MM_Session mm, int16_t *capacity) {
+DllExport void MM_GetBufferFreeCapacity_v2(MM_Session mm, int64_t *capacity) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
-    *capacity = (int16_t)core->getBufferFreeCapacity();
+    *capacity = (int64_t)core->getBufferFreeCapacity();
     return;
 }
 
+// saturate_int16 converts a count for the version 1 functions.
+static int16_t saturate_int16(int64_t n) {
+    return (int16_t)std::min<int64_t>(std::max<int64_t>(n, INT16_MIN), INT16_MAX);
+}
+
+DllExport void MM_GetRemainingImageCount(MM_Session mm, int16_t *count) {
+    int64_t n;
+    MM_GetRemainingImageCount_v2(mm, &n);
+    *count = saturate_int16(n);
+}
+
+DllExport void MM_GetBufferTotalCapacity(MM_Session mm, int16_t *capacity) {
+    int64_t n;
+    MM_GetBufferTotalCapacity_v2(mm, &n);
+    *capacity = saturate_int16(n);
+}
+
+DllExport void MM_GetBufferFreeCapacity(MM_Session mm, int16_t *capacity) {
+    int64_t n;
+    MM_GetBufferFreeCapacity_v2(mm, &n);
+    *capacity = saturate_int16(n);
+}
+
 DllExport void MM_IsBufferOverflowed(MM_Session mm, uint8_t *overflowed) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
     *overflowed = (bool)(core->isBufferOverflowed());
@@ -1245,7 +1886,7 @@ DllExport MM_Status MM_SetRelativeXYPosition(MM_Session mm, const char *label,
                                              double dx, double dy) {
     CMMCore *core = reinterpret_cast<CMMCore *>(mm);
     try {
-        core->setXYPosition(label, dx, dy);
+        core->setRelativeXYPosition(label, dx, dy);
     } catch (CMMError &e) {
         return MM_Status(e.getCode());
     }
@@ -1,9 +1,22 @@
 #ifndef MMCOREC_H_
 #define MMCOREC_H_
 
+#include <stddef.h>
 #include <stdint.h>
 
+#if defined(_WIN32)
 #define DllExport __declspec(dllexport)
+#elif defined(__GNUC__)
+#define DllExport __attribute__((visibility("default")))
+#else
+#define DllExport
+#endif
+
+// MMCOREC_API_VERSION is the version of this API. Version 2 added the _v2
+// functions, which widen the image counts of sequence acquisitions from int16_t.
+// The functions of version 1 keep their names and signatures, and are still
+// exported for existing callers; new callers use the _v2 names.
+#define MMCOREC_API_VERSION 2
 
 typedef void *MM_Session;
 
@@ -84,6 +97,41 @@ typedef enum {
 
 typedef enum { MM_Undef, MM_String, MM_Float, MM_Integer } MM_PropertyType;
 
+// MM_PropertyDescriptor holds the value and the metadata of a property.
+// allowed_values is NULL-terminated, and empty if any value is allowed.
+typedef struct {
+    char *name;
+    char *value;
+    MM_PropertyType type;
+    uint8_t read_only;
+    uint8_t pre_init;
+    uint8_t sequenceable;
+    uint8_t has_limits;
+    double lower_limit;
+    double upper_limit;
+    char **allowed_values;
+} MM_PropertyDescriptor;
+
+// MM_PropertySetting is a property value of a configuration preset.
+typedef struct {
+    char *label;
+    char *prop_name;
+    char *value;
+} MM_PropertySetting;
+
+// MM_MetadataTag is a tag of the metadata of an image.
+// The values of array tags are joined with ",".
+typedef struct {
+    char *key;
+    char *value;
+} MM_MetadataTag;
+
+// MM_Metadata is the metadata of an image, with the tags sorted by key.
+typedef struct {
+    MM_MetadataTag *tags;
+    size_t len_tags;
+} MM_Metadata;
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -138,6 +186,12 @@ DllExport MM_Status MM_GetAvailableDeviceTypes(MM_Session mm,
 
 // Generic device control
 DllExport MM_Status MM_GetLoadedDevices(MM_Session mm, char ***labels);
+DllExport MM_Status MM_GetDeviceType(MM_Session mm, const char *label,
+                                     MM_DeviceType *type);
+DllExport MM_Status MM_GetDeviceLibrary(MM_Session mm, const char *label,
+                                        char **module_name);
+DllExport MM_Status MM_GetDeviceName(MM_Session mm, const char *label,
+                                     char **dev_name);
 DllExport MM_Status MM_GetDevicePropertyNames(MM_Session mm, const char *label,
                                               char ***names);
 DllExport MM_Status MM_HasProperty(MM_Session mm, const char *label,
@@ -187,11 +241,29 @@ DllExport MM_Status MM_GetPropertyType(MM_Session mm, const char *label,
                                        const char *prop_name,
                                        MM_PropertyType *type);
 
+// MM_DescribeProperty and MM_DescribeDevice gather all metadata of the properties
+// in one call. Free the descriptors with MM_PropertyDescriptorsFree.
+DllExport MM_Status MM_DescribeProperty(MM_Session mm, const char *label,
+                                        const char *prop_name,
+                                        MM_PropertyDescriptor **desc);
+DllExport MM_Status MM_DescribeDevice(MM_Session mm, const char *label,
+                                      MM_PropertyDescriptor **descs,
+                                      size_t *len_descs);
+DllExport void MM_PropertyDescriptorsFree(MM_PropertyDescriptor *descs,
+                                          size_t len_descs);
+
 DllExport MM_Status MM_DeviceBusy(MM_Session mm, const char *label,
                                   uint8_t *busy);
 DllExport MM_Status MM_DeviceTypeBusy(MM_Session mm, MM_DeviceType type,
                                       uint8_t *busy);
 
+// The device delay is the time the core waits after a command to the device,
+// set by the Delay lines of configuration files.
+DllExport MM_Status MM_SetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double delay_ms);
+DllExport MM_Status MM_GetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double *delay_ms);
+
 // Manage current devices
 DllExport MM_Status MM_SetCameraDevice(MM_Session mm, const char *label);
 DllExport MM_Status MM_SetShutterDevice(MM_Session mm, const char *label);
@@ -205,6 +277,91 @@ DllExport void MM_GetFocusDevice(MM_Session mm, char **label);
 DllExport void MM_GetXYStageDevice(MM_Session mm, char **label);
 DllExport void MM_GetAutoFocusDevice(MM_Session mm, char **label);
 
+// Configuration groups and presets
+DllExport MM_Status MM_DefineConfigGroup(MM_Session mm, const char *group_name);
+DllExport MM_Status MM_DeleteConfigGroup(MM_Session mm, const char *group_name);
+DllExport MM_Status MM_RenameConfigGroup(MM_Session mm, const char *old_group_name,
+                                         const char *new_group_name);
+DllExport MM_Status MM_IsGroupDefined(MM_Session mm, const char *group_name,
+                                      uint8_t *defined);
+DllExport MM_Status MM_GetAvailableConfigGroups(MM_Session mm, char ***group_names);
+
+// MM_DefineConfig defines an empty preset, and MM_DefineConfigSetting adds a
+// property value to a preset. Both define the group and the preset if needed.
+DllExport MM_Status MM_DefineConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name);
+DllExport MM_Status MM_DefineConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name,
+                                           const char *value);
+DllExport MM_Status MM_DeleteConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name);
+DllExport MM_Status MM_DeleteConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name);
+DllExport MM_Status MM_RenameConfig(MM_Session mm, const char *group_name,
+                                    const char *old_config_name,
+                                    const char *new_config_name);
+DllExport MM_Status MM_IsConfigDefined(MM_Session mm, const char *group_name,
+                                       const char *config_name,
+                                       uint8_t *defined);
+DllExport MM_Status MM_GetAvailableConfigs(MM_Session mm, const char *group_name,
+                                           char ***config_names);
+
+DllExport MM_Status MM_SetConfig(MM_Session mm, const char *group_name,
+                                 const char *config_name);
+DllExport MM_Status MM_GetCurrentConfig(MM_Session mm, const char *group_name,
+                                        char **config_name);
+
+// MM_GetConfigData returns the property values of a preset.
+// Free the settings with MM_PropertySettingsFree.
+DllExport MM_Status MM_GetConfigData(MM_Session mm, const char *group_name,
+                                     const char *config_name,
+                                     MM_PropertySetting **settings,
+                                     size_t *len_settings);
+DllExport void MM_PropertySettingsFree(MM_PropertySetting *settings,
+                                       size_t len_settings);
+
+// Pixel size configurations
+//
+// A pixel size configuration is a set of property values, such as the label of the
+// objective turret, with the pixel size and the affine transform from pixels to stage
+// coordinates that apply when the properties have these values. The affine transform
+// is given by the 6 elements of its first two rows.
+DllExport MM_Status MM_DefinePixelSizeConfig(MM_Session mm, const char *resolution_id,
+                                             const char *label,
+                                             const char *prop_name,
+                                             const char *value);
+DllExport MM_Status MM_SetPixelSizeUm(MM_Session mm, const char *resolution_id,
+                                      double pixel_size_um);
+DllExport MM_Status MM_SetPixelSizeAffine(MM_Session mm, const char *resolution_id,
+                                          const double affine[6]);
+DllExport MM_Status MM_DeletePixelSizeConfig(MM_Session mm, const char *resolution_id);
+DllExport MM_Status MM_RenamePixelSizeConfig(MM_Session mm,
+                                             const char *old_resolution_id,
+                                             const char *new_resolution_id);
+DllExport MM_Status MM_IsPixelSizeConfigDefined(MM_Session mm, const char *resolution_id,
+                                                uint8_t *defined);
+DllExport MM_Status MM_GetAvailablePixelSizeConfigs(MM_Session mm, char ***resolution_ids);
+DllExport MM_Status MM_GetPixelSizeConfigData(MM_Session mm, const char *resolution_id,
+                                              MM_PropertySetting **settings,
+                                              size_t *len_settings);
+DllExport MM_Status MM_SetPixelSizeConfig(MM_Session mm, const char *resolution_id);
+DllExport MM_Status MM_GetCurrentPixelSizeConfig(MM_Session mm, char **resolution_id);
+
+// MM_GetPixelSizeUm and MM_GetPixelSizeAffine return the calibration of the current
+// pixel size configuration, scaled by the binning of the current camera. The pixel
+// size and the transform are 0 if no configuration matches the property values.
+// The ByID variants return the calibration of a configuration as it is defined.
+DllExport MM_Status MM_GetPixelSizeUm(MM_Session mm, double *pixel_size_um);
+DllExport MM_Status MM_GetPixelSizeUmByID(MM_Session mm, const char *resolution_id,
+                                          double *pixel_size_um);
+DllExport MM_Status MM_GetPixelSizeAffine(MM_Session mm, double affine[6]);
+DllExport MM_Status MM_GetPixelSizeAffineByID(MM_Session mm, const char *resolution_id,
+                                              double affine[6]);
+
 // Image acquisition settings
 DllExport MM_Status MM_SetROI(MM_Session mm, int x, int y, int x_size,
                               int y_size);
@@ -231,10 +388,10 @@ DllExport MM_Status MM_GetImage(MM_Session mm, uint8_t **ptr_buffer);
 DllExport MM_Status MM_GetImageOfChannel(MM_Session mm, uint16_t channel, uint8_t **ptr_buffer);
 
 // Image sequence acquisition
-DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
-                                                int16_t num_images,
-                                                double interval_ms,
-                                                uint8_t stop_on_overflow);
+DllExport MM_Status MM_StartSequenceAcquisition_v2(MM_Session mm,
+                                                   int32_t num_images,
+                                                   double interval_ms,
+                                                   uint8_t stop_on_overflow);
 DllExport MM_Status MM_StartContinuousSequenceAcquisition(MM_Session mm,
                                                           double interval_ms);
 DllExport MM_Status MM_StopSequenceAcquisition(MM_Session mm);
@@ -243,10 +400,18 @@ DllExport void MM_IsSequenceRunning(MM_Session mm, uint8_t *status);
 // Image circular buffer
 DllExport MM_Status MM_GetLastImage(MM_Session mm, uint8_t **ptr_buffer);
 DllExport MM_Status MM_PopNextImage(MM_Session mm, uint8_t **ptr_buffer);
-
-DllExport void MM_GetRemainingImageCount(MM_Session mm, int16_t *count);
-DllExport void MM_GetBufferTotalCapacity(MM_Session mm, int16_t *capacity);
-DllExport void MM_GetBufferFreeCapacity(MM_Session mm, int16_t *capacity);
+// MM_GetLastImageMD and MM_PopNextImageMD are MM_GetLastImage and MM_PopNextImage
+// that also return the metadata of the image, such as ElapsedTime-ms and ImageNumber.
+// md is empty if there is no image. Free its tags with MM_MetadataFree.
+DllExport MM_Status MM_GetLastImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md);
+DllExport MM_Status MM_PopNextImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md);
+DllExport void MM_MetadataFree(MM_Metadata *md);
+
+DllExport void MM_GetRemainingImageCount_v2(MM_Session mm, int64_t *count);
+DllExport void MM_GetBufferTotalCapacity_v2(MM_Session mm, int64_t *capacity);
+DllExport void MM_GetBufferFreeCapacity_v2(MM_Session mm, int64_t *capacity);
 DllExport void MM_IsBufferOverflowed(MM_Session mm, uint8_t *overflowed);
 
 DllExport MM_Status MM_SetCircularBufferMemoryFootprint(MM_Session mm,
@@ -352,6 +517,16 @@ DllExport void MM_GetUserId(MM_Session mm, char **userid);
 DllExport void MM_GetHostName(MM_Session mm, char **hostname);
 DllExport void MM_GetMACAddresses(MM_Session mm, char ***addresses);
 
+// Version 1 of the functions widened in version 2. The counts saturate at
+// INT16_MAX instead of wrapping around.
+DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
+                                                int16_t num_images,
+                                                double interval_ms,
+                                                uint8_t stop_on_overflow);
+DllExport void MM_GetRemainingImageCount(MM_Session mm, int16_t *count);
+DllExport void MM_GetBufferTotalCapacity(MM_Session mm, int16_t *capacity);
+DllExport void MM_GetBufferFreeCapacity(MM_Session mm, int16_t *capacity);
+
 #ifdef __cplusplus
 }
 #endif
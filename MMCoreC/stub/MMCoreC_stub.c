@@ -0,0 +1,4003 @@
+// MMCoreC_stub.c implements MMCoreC.h without MMCore and Boost.
+//
+// Devices are simulated in memory after the DemoCamera device adapter:
+// DCam, DWheel, DStateDevice, DObjective, DStage, DXYStage, DLightPath,
+// DAutoFocus, DShutter and DHub. They behave like the devices of the Go
+// simulator in MMCoreGo/sim, and return the same MM_Status codes.
+//
+// As with MMCore, where device adapters notify from their own threads,
+// the MM_EventCallback functions are called from a separate thread of
+// the session, never from the thread calling the API.
+//
+// The stub is meant for testing code that uses MMCoreC, such as the cgo
+// marshalling in MMCoreGo, on machines without Micro-Manager.
+
+// clock_gettime, localtime_r and gethostname are POSIX, and are not declared
+// with -std=c99 otherwise.
+#if !defined(_WIN32) && !defined(_POSIX_C_SOURCE)
+#define _POSIX_C_SOURCE 200809L
+#endif
+
+#include "MMCoreC.h"
+#include "MMCoreC_stub.h"
+
+#include <errno.h>
+#include <math.h>
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+#ifndef _WIN32
+#include <unistd.h>
+#endif
+
+#define STUB_MODULE_NAME "DemoCamera"
+#define STUB_CORE_LABEL "Core"
+#define STUB_XY_STEP_SIZE 0.015
+
+//
+// Devices
+//
+
+typedef struct {
+    char *name;
+    char *value;
+    MM_PropertyType type;
+    uint8_t read_only;
+    uint8_t pre_init;
+    uint8_t sequenceable;
+    uint8_t has_limits;
+    double lower_limit;
+    double upper_limit;
+    char **allowed; // NULL-terminated, or NULL if any value is allowed
+
+    // Constructed properties exist before initialization without being pre-init properties.
+    uint8_t constructed;
+} stub_property;
+
+typedef struct {
+    char *label;
+    char *name;
+    char *parent;
+    MM_DeviceType type;
+    uint8_t initialized;
+    struct timespec busy_until; // set by MMStub_SetDeviceBusy, CLOCK_MONOTONIC
+    double delay_ms;            // stored only, the stub does not wait
+
+    stub_property **props;
+    size_t n_props;
+
+    // Hub
+    char **installed;
+
+    // State device
+    char **state_labels;
+    int32_t n_states;
+    int32_t state;
+
+    // Focus (Z) stage
+    double z;
+    double z_origin;
+    int8_t focus_direction;
+
+    // XY stage
+    double x, y;
+    double x_origin, y_origin;
+
+    // Shutter
+    uint8_t open;
+
+    // Camera
+    int roi_x, roi_y, roi_width, roi_height;
+    uint8_t roi_set;
+} stub_device;
+
+typedef struct {
+    const char *name;
+    const char *description;
+    MM_DeviceType type;
+    const char *driver_description;
+    int32_t n_states;
+} stub_device_info;
+
+static const stub_device_info demo_devices[] = {
+    {"DCam", "Demo camera", MM_CameraDevice, "Demo Camera Device Adapter", 0},
+    {"DWheel", "Demo filter wheel", MM_StateDevice, "Demo filter wheel driver", 10},
+    {"DStateDevice", "Demo State Device", MM_StateDevice, "Demo state device driver", 10},
+    {"DObjective", "Demo objective turret", MM_StateDevice, "Demo objective turret driver", 6},
+    {"DStage", "Demo stage", MM_StageDevice, "Demo stage driver", 0},
+    {"DXYStage", "Demo XY stage", MM_XYStageDevice, "Demo XY stage driver", 0},
+    {"DLightPath", "Demo light path", MM_StateDevice, "Demo light-path driver", 3},
+    {"DAutoFocus", "Demo auto focus", MM_AutoFocusDevice, "Demo auto-focus adapter", 0},
+    {"DShutter", "Demo shutter", MM_ShutterDevice, "Demo shutter driver", 0},
+    {"DHub", "DHub", MM_HubDevice, "Hub (required)", 0},
+};
+
+#define N_DEMO_DEVICES (sizeof(demo_devices) / sizeof(demo_devices[0]))
+
+//
+// Configuration groups
+//
+
+typedef struct {
+    char *label;
+    char *prop_name;
+    char *value;
+} stub_setting;
+
+typedef struct {
+    char *name;
+    stub_setting *settings;
+    size_t n_settings;
+
+    // Calibration of pixel size configurations
+    double pixel_size_um;
+    double affine[6];
+} stub_config;
+
+typedef struct {
+    char *name;
+    stub_config *configs;
+    size_t n_configs;
+} stub_config_group;
+
+//
+// Events
+//
+
+typedef enum {
+    STUB_PROPERTIES_CHANGED,
+    STUB_PROPERTY_CHANGED,
+    STUB_CONFIG_GROUP_CHANGED,
+    STUB_SYSTEM_CONFIGURATION_LOADED,
+    STUB_PIXEL_SIZE_CHANGED,
+    STUB_STAGE_POSITION_CHANGED,
+    STUB_XY_STAGE_POSITION_CHANGED,
+    STUB_EXPOSURE_CHANGED,
+    STUB_SLM_EXPOSURE_CHANGED
+} stub_event_kind;
+
+typedef struct stub_event {
+    stub_event_kind kind;
+    char *str[3];
+    double num[2];
+    struct stub_event *next;
+} stub_event;
+
+//
+// Circular buffer
+//
+
+typedef struct stub_image {
+    uint8_t *data;
+    MM_Metadata md;
+    struct stub_image *next;
+} stub_image;
+
+//
+// Session
+//
+
+typedef struct {
+    pthread_mutex_t mutex;
+
+    char **search_paths;
+    stub_device **devices; // in load order, not including Core
+    size_t n_devices;
+    stub_device *core;
+
+    // Current devices
+    char *camera;
+    char *shutter;
+    char *focus;
+    char *xy_stage;
+    char *auto_focus;
+    uint8_t auto_shutter;
+
+    // Configuration groups, in definition order
+    stub_config_group *groups;
+    size_t n_groups;
+    uint8_t applying_config; // set by MM_SetConfig, which posts a single group change
+
+    // Pixel size configurations, and the pixel size last posted
+    stub_config_group pixel_configs;
+    double pixel_size_um;
+
+    // Camera
+    uint8_t *snapped;
+    uint32_t frame_number;
+
+    // Circular buffer
+    uint32_t buffer_MB;
+    stub_image *buffer_head;
+    stub_image *buffer_tail;
+    size_t buffer_count;
+    uint8_t *last_image;
+    size_t last_image_len;
+    MM_Metadata last_md;
+    uint8_t *popped_image;
+    uint8_t overflowed;
+
+    // Sequence acquisition
+    pthread_t seq_thread;
+    pthread_cond_t seq_cond;
+    uint8_t seq_thread_started;
+    uint8_t seq_running;
+    uint8_t seq_stop;
+    int32_t seq_num_images;
+    double seq_period_ms;
+    uint8_t seq_stop_on_overflow;
+    struct timespec seq_start;
+    int64_t seq_image_number;
+
+    // Autofocus
+    uint8_t continuous_focus;
+    double auto_focus_offset;
+    double focus_score;
+
+    // Events
+    struct MM_EventCallback *callback;
+    pthread_t event_thread;
+    pthread_cond_t event_cond;
+    stub_event *event_head;
+    stub_event *event_tail;
+    uint8_t closing;
+} stub_session;
+
+//
+// Helper functions
+//
+
+static char *stub_strdup(const char *str) {
+    if (str == NULL) {
+        str = "";
+    }
+    size_t len = strlen(str) + 1;
+    char *dup = (char *)malloc(len);
+    memcpy(dup, str, len);
+    return dup;
+}
+
+static void set_string(char **dst, const char *src) {
+    char *dup = stub_strdup(src);
+    free(*dst);
+    *dst = dup;
+}
+
+static size_t string_list_len(char *const *list) {
+    size_t n = 0;
+    while (list != NULL && list[n] != NULL) {
+        n++;
+    }
+    return n;
+}
+
+// string_list_copy copies the first n strings, or all strings if n is (size_t)-1.
+static char **string_list_copy(char *const *list, size_t n) {
+    if (n == (size_t)-1) {
+        n = string_list_len(list);
+    }
+    char **copy = (char **)malloc((n + 1) * sizeof(char *));
+    for (size_t i = 0; i < n; i++) {
+        copy[i] = stub_strdup(list[i]);
+    }
+    copy[n] = NULL;
+    return copy;
+}
+
+static void string_list_free(char **list) {
+    if (list == NULL) {
+        return;
+    }
+    for (size_t i = 0; list[i] != NULL; i++) {
+        free(list[i]);
+    }
+    free(list);
+}
+
+static int string_list_contains(char *const *list, const char *str) {
+    for (size_t i = 0; list != NULL && list[i] != NULL; i++) {
+        if (strcmp(list[i], str) == 0) {
+            return 1;
+        }
+    }
+    return 0;
+}
+
+static int compare_strings(const void *a, const void *b) {
+    return strcmp(*(char *const *)a, *(char *const *)b);
+}
+
+static char *format_float(double v) {
+    char buf[64];
+    snprintf(buf, sizeof(buf), "%.4f", v);
+    return stub_strdup(buf);
+}
+
+static char *format_int(long long v) {
+    char buf[32];
+    snprintf(buf, sizeof(buf), "%lld", v);
+    return stub_strdup(buf);
+}
+
+static int parse_double(const char *str, double *v) {
+    char *end;
+    if (str == NULL || *str == '\0') {
+        return 0;
+    }
+    *v = strtod(str, &end);
+    return *end == '\0';
+}
+
+static stub_session *get_session(MM_Session mm) {
+    return (stub_session *)mm;
+}
+
+//
+// Events
+//
+
+// post_event queues an event for the callback thread.
+// The session must be locked. Events are dropped if no callback is registered.
+static void post_event(stub_session *s, stub_event_kind kind, const char *str0,
+                       const char *str1, const char *str2, double num0,
+                       double num1) {
+    if (s->callback == NULL || s->closing) {
+        return;
+    }
+
+    stub_event *e = (stub_event *)calloc(1, sizeof(stub_event));
+    e->kind = kind;
+    e->str[0] = str0 ? stub_strdup(str0) : NULL;
+    e->str[1] = str1 ? stub_strdup(str1) : NULL;
+    e->str[2] = str2 ? stub_strdup(str2) : NULL;
+    e->num[0] = num0;
+    e->num[1] = num1;
+
+    if (s->event_tail == NULL) {
+        s->event_head = e;
+    } else {
+        s->event_tail->next = e;
+    }
+    s->event_tail = e;
+    pthread_cond_signal(&s->event_cond);
+}
+
+static void post_config_group_changes(stub_session *s, const char *label,
+                                      const char *prop_name);
+static void post_pixel_size_change(stub_session *s);
+
+// post_property_changed posts the property change, followed by the change of
+// the current preset of each group with a setting of the property, as MMCore does,
+// and the change of the pixel size.
+static void post_property_changed(stub_session *s, const char *label,
+                                  const char *prop_name, const char *value) {
+    post_event(s, STUB_PROPERTY_CHANGED, label, prop_name, value, 0, 0);
+    if (!s->applying_config) {
+        post_config_group_changes(s, label, prop_name);
+    }
+    post_pixel_size_change(s);
+}
+
+static void free_event(stub_event *e) {
+    free(e->str[0]);
+    free(e->str[1]);
+    free(e->str[2]);
+    free(e);
+}
+
+static void dispatch_event(MM_Session mm, const struct MM_EventCallback *cb,
+                           stub_event *e) {
+    switch (e->kind) {
+    case STUB_PROPERTIES_CHANGED:
+        if (cb->onPropertiesChanged) {
+            cb->onPropertiesChanged(mm);
+        }
+        break;
+    case STUB_PROPERTY_CHANGED:
+        if (cb->onPropertyChanged) {
+            cb->onPropertyChanged(mm, e->str[0], e->str[1], e->str[2]);
+        }
+        break;
+    case STUB_CONFIG_GROUP_CHANGED:
+        if (cb->onConfigGroupChanged) {
+            cb->onConfigGroupChanged(mm, e->str[0], e->str[1]);
+        }
+        break;
+    case STUB_SYSTEM_CONFIGURATION_LOADED:
+        if (cb->onSystemConfigurationLoaded) {
+            cb->onSystemConfigurationLoaded(mm);
+        }
+        break;
+    case STUB_PIXEL_SIZE_CHANGED:
+        if (cb->onPixelSizeChanged) {
+            cb->onPixelSizeChanged(mm, e->num[0]);
+        }
+        break;
+    case STUB_STAGE_POSITION_CHANGED:
+        if (cb->onStagePositionChanged) {
+            cb->onStagePositionChanged(mm, e->str[0], e->num[0]);
+        }
+        break;
+    case STUB_XY_STAGE_POSITION_CHANGED:
+        if (cb->onXYStagePositionChanged) {
+            cb->onXYStagePositionChanged(mm, e->str[0], e->num[0], e->num[1]);
+        }
+        break;
+    case STUB_EXPOSURE_CHANGED:
+        if (cb->onExposureChanged) {
+            cb->onExposureChanged(mm, e->str[0], e->num[0]);
+        }
+        break;
+    case STUB_SLM_EXPOSURE_CHANGED:
+        if (cb->onSLMExposureChanged) {
+            cb->onSLMExposureChanged(mm, e->str[0], e->num[0]);
+        }
+        break;
+    }
+}
+
+// event_thread delivers the queued events in order, without holding the session lock,
+// so that the callbacks can call back into the API.
+static void *event_thread(void *arg) {
+    stub_session *s = (stub_session *)arg;
+
+    pthread_mutex_lock(&s->mutex);
+    for (;;) {
+        while (s->event_head == NULL && !s->closing) {
+            pthread_cond_wait(&s->event_cond, &s->mutex);
+        }
+        if (s->closing) {
+            break;
+        }
+
+        stub_event *e = s->event_head;
+        s->event_head = e->next;
+        if (s->event_head == NULL) {
+            s->event_tail = NULL;
+        }
+
+        struct MM_EventCallback cb;
+        int has_callback = s->callback != NULL;
+        if (has_callback) {
+            cb = *s->callback;
+        }
+
+        pthread_mutex_unlock(&s->mutex);
+        if (has_callback) {
+            dispatch_event((MM_Session)s, &cb, e);
+        }
+        free_event(e);
+        pthread_mutex_lock(&s->mutex);
+    }
+
+    // Events still queued when the session is closed are dropped.
+    while (s->event_head != NULL) {
+        stub_event *e = s->event_head;
+        s->event_head = e->next;
+        free_event(e);
+    }
+    s->event_tail = NULL;
+    pthread_mutex_unlock(&s->mutex);
+    return NULL;
+}
+
+//
+// Properties
+//
+
+static stub_property *add_property(stub_device *d, const char *name,
+                                   const char *value, MM_PropertyType type) {
+    stub_property *p = (stub_property *)calloc(1, sizeof(stub_property));
+    p->name = stub_strdup(name);
+    p->value = stub_strdup(value);
+    p->type = type;
+
+    d->props = (stub_property **)realloc(d->props, (d->n_props + 1) * sizeof(stub_property *));
+    d->props[d->n_props++] = p;
+    return p;
+}
+
+static stub_property *add_read_only(stub_device *d, const char *name,
+                                    const char *value) {
+    stub_property *p = add_property(d, name, value, MM_String);
+    p->read_only = 1;
+    p->constructed = 1;
+    return p;
+}
+
+static void set_allowed(stub_property *p, const char *const *values, size_t n) {
+    string_list_free(p->allowed);
+    p->allowed = string_list_copy((char *const *)values, n);
+}
+
+static void set_limits(stub_property *p, double lower, double upper) {
+    p->has_limits = 1;
+    p->lower_limit = lower;
+    p->upper_limit = upper;
+}
+
+static void free_property(stub_property *p) {
+    free(p->name);
+    free(p->value);
+    string_list_free(p->allowed);
+    free(p);
+}
+
+// find_property returns the property if it exists in the current state of the device.
+//
+// As with a device adapter, only pre-init properties and a few read-only properties
+// created in the constructor exist before initialization.
+static stub_property *find_property(stub_device *d, const char *name) {
+    for (size_t i = 0; i < d->n_props; i++) {
+        stub_property *p = d->props[i];
+        if (strcmp(p->name, name) == 0) {
+            if (d->initialized || p->pre_init || p->constructed) {
+                return p;
+            }
+            return NULL;
+        }
+    }
+    return NULL;
+}
+
+static long property_int(stub_device *d, const char *name) {
+    for (size_t i = 0; i < d->n_props; i++) {
+        if (strcmp(d->props[i]->name, name) == 0) {
+            return strtol(d->props[i]->value, NULL, 10);
+        }
+    }
+    return 0;
+}
+
+static double property_float(stub_device *d, const char *name) {
+    for (size_t i = 0; i < d->n_props; i++) {
+        if (strcmp(d->props[i]->name, name) == 0) {
+            return strtod(d->props[i]->value, NULL);
+        }
+    }
+    return 0;
+}
+
+static const char *property_string(stub_device *d, const char *name) {
+    for (size_t i = 0; i < d->n_props; i++) {
+        if (strcmp(d->props[i]->name, name) == 0) {
+            return d->props[i]->value;
+        }
+    }
+    return "";
+}
+
+//
+// Devices
+//
+
+static stub_device *new_device(const char *label, const char *name,
+                               const char *description, MM_DeviceType type) {
+    stub_device *d = (stub_device *)calloc(1, sizeof(stub_device));
+    d->label = stub_strdup(label);
+    d->name = stub_strdup(name);
+    d->parent = stub_strdup("");
+    d->type = type;
+    add_read_only(d, "Name", name);
+    add_read_only(d, "Description", description);
+    add_read_only(d, "HubID", "");
+    return d;
+}
+
+static void setup_camera(stub_device *d) {
+    static const char *bit_depths[] = {"10", "12", "14", "16", "8"};
+    static const char *pixel_types[] = {"16bit", "32bitRGB", "8bit"};
+    static const char *binnings[] = {"1", "2", "4", "8"};
+    stub_property *p;
+
+    add_property(d, "CameraName", "DemoCamera-MultiMode", MM_String)->read_only = 1;
+    add_property(d, "CameraID", "V1.0", MM_String)->read_only = 1;
+    add_property(d, "MaximumExposureMs", "10000.0000", MM_Float)->pre_init = 1;
+    p = add_property(d, "Exposure", "10.0000", MM_Float);
+    set_limits(p, 0, 10000);
+    p = add_property(d, "Gain", "0", MM_Integer);
+    set_limits(p, -5, 8);
+    add_property(d, "Offset", "0", MM_Integer);
+    add_property(d, "ReadoutTime", "0.0000", MM_Float);
+    add_property(d, "OnCameraCCDXSize", "512", MM_Integer);
+    add_property(d, "OnCameraCCDYSize", "512", MM_Integer);
+    p = add_property(d, "BitDepth", "8", MM_Integer);
+    set_allowed(p, bit_depths, 5);
+    p = add_property(d, "PixelType", "8bit", MM_String);
+    set_allowed(p, pixel_types, 3);
+    p = add_property(d, "Binning", "1", MM_Integer);
+    set_allowed(p, binnings, 4);
+}
+
+static void setup_state_device(stub_device *d, int32_t n_states) {
+    char buf[32];
+    char **states = (char **)calloc(n_states + 1, sizeof(char *));
+
+    d->n_states = n_states;
+    d->state_labels = (char **)calloc(n_states + 1, sizeof(char *));
+    for (int32_t i = 0; i < n_states; i++) {
+        snprintf(buf, sizeof(buf), "State-%d", (int)i);
+        d->state_labels[i] = stub_strdup(buf);
+        snprintf(buf, sizeof(buf), "%d", (int)i);
+        states[i] = stub_strdup(buf);
+    }
+
+    add_property(d, "ClosedPosition", "0", MM_Integer);
+    add_property(d, "State", "0", MM_Integer)->allowed = states;
+    add_property(d, "Label", "State-0", MM_String);
+}
+
+static void setup_stage(stub_device *d) {
+    static const char *no_yes[] = {"No", "Yes"};
+    set_allowed(add_property(d, "UseSequences", "No", MM_String), no_yes, 2);
+    add_property(d, "Position", "0.0000", MM_Float);
+}
+
+static void setup_xy_stage(stub_device *d) {
+    static const char *zero_one[] = {"0", "1"};
+    set_allowed(add_property(d, "TransposeMirrorX", "0", MM_Integer), zero_one, 2);
+    set_allowed(add_property(d, "TransposeMirrorY", "0", MM_Integer), zero_one, 2);
+}
+
+static void setup_shutter(stub_device *d) {
+    static const char *zero_one[] = {"0", "1"};
+    set_allowed(add_property(d, "State", "0", MM_Integer), zero_one, 2);
+}
+
+static void setup_hub(stub_device *d) {
+    size_t n = 0;
+    d->installed = (char **)calloc(N_DEMO_DEVICES + 1, sizeof(char *));
+    for (size_t i = 0; i < N_DEMO_DEVICES; i++) {
+        if (demo_devices[i].type != MM_HubDevice) {
+            d->installed[n++] = stub_strdup(demo_devices[i].name);
+        }
+    }
+}
+
+static const stub_device_info *find_device_info(const char *name) {
+    for (size_t i = 0; i < N_DEMO_DEVICES; i++) {
+        if (strcmp(demo_devices[i].name, name) == 0) {
+            return &demo_devices[i];
+        }
+    }
+    return NULL;
+}
+
+static stub_device *create_device(const char *label, const stub_device_info *info) {
+    stub_device *d = new_device(label, info->name, info->driver_description, info->type);
+    switch (info->type) {
+    case MM_CameraDevice:
+        setup_camera(d);
+        break;
+    case MM_StateDevice:
+        setup_state_device(d, info->n_states);
+        break;
+    case MM_StageDevice:
+        setup_stage(d);
+        break;
+    case MM_XYStageDevice:
+        setup_xy_stage(d);
+        break;
+    case MM_ShutterDevice:
+        setup_shutter(d);
+        break;
+    case MM_HubDevice:
+        setup_hub(d);
+        break;
+    default:
+        break;
+    }
+    return d;
+}
+
+static void free_device(stub_device *d) {
+    for (size_t i = 0; i < d->n_props; i++) {
+        free_property(d->props[i]);
+    }
+    free(d->props);
+    free(d->label);
+    free(d->name);
+    free(d->parent);
+    string_list_free(d->installed);
+    string_list_free(d->state_labels);
+    free(d);
+}
+
+static stub_device *new_core_device(void) {
+    static const char *zero_one[] = {"0", "1"};
+    static const char *roles[] = {"Camera", "Shutter", "Focus", "XYStage", "AutoFocus"};
+
+    stub_device *d = (stub_device *)calloc(1, sizeof(stub_device));
+    d->label = stub_strdup(STUB_CORE_LABEL);
+    d->name = stub_strdup(STUB_CORE_LABEL);
+    d->parent = stub_strdup("");
+    d->type = MM_CoreDevice;
+    d->initialized = 1;
+    for (size_t i = 0; i < 5; i++) {
+        add_property(d, roles[i], "", MM_String);
+    }
+    set_allowed(add_property(d, "AutoShutter", "1", MM_Integer), zero_one, 2);
+    return d;
+}
+
+//
+// Session helpers
+//
+
+// find_device returns the loaded device with the label, including Core.
+static stub_device *find_device(stub_session *s, const char *label) {
+    if (label == NULL) {
+        return NULL;
+    }
+    if (strcmp(label, STUB_CORE_LABEL) == 0) {
+        return s->core;
+    }
+    for (size_t i = 0; i < s->n_devices; i++) {
+        if (strcmp(s->devices[i]->label, label) == 0) {
+            return s->devices[i];
+        }
+    }
+    return NULL;
+}
+
+static MM_Status get_device(stub_session *s, const char *label, stub_device **d) {
+    *d = find_device(s, label);
+    if (*d == NULL) {
+        return MM_ErrInvalidLabel;
+    }
+    return MM_ErrOK;
+}
+
+// get_device_of_type returns the device with the label, or the current device of the type if label is empty.
+// Like MMCore, it returns MM_ErrGENERIC if the device is not of the type.
+static MM_Status get_device_of_type(stub_session *s, const char *label,
+                                    const char *current, MM_DeviceType type,
+                                    MM_Status err_no_device, stub_device **d) {
+    if (label == NULL || *label == '\0') {
+        label = current;
+        if (label == NULL || *label == '\0') {
+            return err_no_device;
+        }
+    }
+    MM_Status status = get_device(s, label, d);
+    if (status != MM_ErrOK) {
+        return status;
+    }
+    if ((*d)->type != type) {
+        return MM_ErrGENERIC;
+    }
+    return MM_ErrOK;
+}
+
+static MM_Status get_property(stub_session *s, const char *label,
+                              const char *prop_name, stub_device **d,
+                              stub_property **p) {
+    MM_Status status = get_device(s, label, d);
+    if (status != MM_ErrOK) {
+        return status;
+    }
+    *p = find_property(*d, prop_name);
+    if (*p == NULL) {
+        return MM_ErrDEVICE_GENERIC;
+    }
+    return MM_ErrOK;
+}
+
+static MM_Status get_camera(stub_session *s, stub_device **cam) {
+    return get_device_of_type(s, NULL, s->camera, MM_CameraDevice,
+                              MM_ErrCameraNotAvailable, cam);
+}
+
+static char **current_device_slot(stub_session *s, const char *role) {
+    if (strcmp(role, "Camera") == 0) {
+        return &s->camera;
+    } else if (strcmp(role, "Shutter") == 0) {
+        return &s->shutter;
+    } else if (strcmp(role, "Focus") == 0) {
+        return &s->focus;
+    } else if (strcmp(role, "XYStage") == 0) {
+        return &s->xy_stage;
+    } else if (strcmp(role, "AutoFocus") == 0) {
+        return &s->auto_focus;
+    }
+    return NULL;
+}
+
+static MM_DeviceType current_device_type(const char *role) {
+    if (strcmp(role, "Camera") == 0) {
+        return MM_CameraDevice;
+    } else if (strcmp(role, "Shutter") == 0) {
+        return MM_ShutterDevice;
+    } else if (strcmp(role, "Focus") == 0) {
+        return MM_StageDevice;
+    } else if (strcmp(role, "XYStage") == 0) {
+        return MM_XYStageDevice;
+    } else if (strcmp(role, "AutoFocus") == 0) {
+        return MM_AutoFocusDevice;
+    }
+    return MM_UnknownType;
+}
+
+// property_value refreshes the value of the properties that mirror the device state, and returns the value.
+static const char *property_value(stub_session *s, stub_device *d, stub_property *p) {
+    char *value = NULL;
+    char **slot;
+
+    switch (d->type) {
+    case MM_CoreDevice:
+        if ((slot = current_device_slot(s, p->name)) != NULL) {
+            value = stub_strdup(*slot);
+        } else if (strcmp(p->name, "AutoShutter") == 0) {
+            value = format_int(s->auto_shutter);
+        }
+        break;
+    case MM_StateDevice:
+        if (strcmp(p->name, "State") == 0) {
+            value = format_int(d->state);
+        } else if (strcmp(p->name, "Label") == 0) {
+            value = stub_strdup(d->state_labels[d->state]);
+        }
+        break;
+    case MM_StageDevice:
+        if (strcmp(p->name, "Position") == 0) {
+            value = format_float(d->z - d->z_origin);
+        }
+        break;
+    case MM_ShutterDevice:
+        if (strcmp(p->name, "State") == 0) {
+            value = format_int(d->open);
+        }
+        break;
+    default:
+        break;
+    }
+
+    if (value != NULL) {
+        free(p->value);
+        p->value = value;
+    }
+    return p->value;
+}
+
+// property_allowed returns the allowed values of the property as a NULL-terminated list.
+// The strings are borrowed from the session and only the list has to be freed.
+static char **property_allowed(stub_session *s, stub_device *d, stub_property *p) {
+    char **list;
+    size_t n = 0;
+
+    if (d->type == MM_CoreDevice && current_device_slot(s, p->name) != NULL) {
+        MM_DeviceType type = current_device_type(p->name);
+        list = (char **)calloc(s->n_devices + 2, sizeof(char *));
+        list[n++] = (char *)"";
+        for (size_t i = 0; i < s->n_devices; i++) {
+            if (s->devices[i]->type == type) {
+                list[n++] = s->devices[i]->label;
+            }
+        }
+        return list;
+    }
+    if (d->type == MM_StateDevice && strcmp(p->name, "Label") == 0) {
+        list = (char **)calloc(d->n_states + 1, sizeof(char *));
+        memcpy(list, d->state_labels, d->n_states * sizeof(char *));
+        return list;
+    }
+
+    size_t len = string_list_len(p->allowed);
+    list = (char **)calloc(len + 1, sizeof(char *));
+    memcpy(list, p->allowed, len * sizeof(char *));
+    return list;
+}
+
+//
+// Configuration group helpers
+//
+
+// valid_config_name checks a group or preset name, which cannot be empty or contain
+// a comma, the field separator of configuration files.
+static int valid_config_name(const char *name) {
+    return name != NULL && *name != '\0' && strchr(name, ',') == NULL;
+}
+
+static stub_config_group *find_group(stub_session *s, const char *name) {
+    for (size_t i = 0; i < s->n_groups; i++) {
+        if (strcmp(s->groups[i].name, name) == 0) {
+            return &s->groups[i];
+        }
+    }
+    return NULL;
+}
+
+static stub_config *find_config(stub_config_group *g, const char *name) {
+    for (size_t i = 0; i < g->n_configs; i++) {
+        if (strcmp(g->configs[i].name, name) == 0) {
+            return &g->configs[i];
+        }
+    }
+    return NULL;
+}
+
+static stub_setting *find_setting(stub_config *c, const char *label, const char *prop_name) {
+    for (size_t i = 0; i < c->n_settings; i++) {
+        if (strcmp(c->settings[i].label, label) == 0 &&
+            strcmp(c->settings[i].prop_name, prop_name) == 0) {
+            return &c->settings[i];
+        }
+    }
+    return NULL;
+}
+
+// add_group appends a group. Pointers to the other groups are invalidated.
+static stub_config_group *add_group(stub_session *s, const char *name) {
+    s->groups = (stub_config_group *)realloc(s->groups, (s->n_groups + 1) * sizeof(stub_config_group));
+    stub_config_group *g = &s->groups[s->n_groups++];
+    memset(g, 0, sizeof(*g));
+    g->name = stub_strdup(name);
+    return g;
+}
+
+static stub_config *add_config(stub_config_group *g, const char *name) {
+    g->configs = (stub_config *)realloc(g->configs, (g->n_configs + 1) * sizeof(stub_config));
+    stub_config *c = &g->configs[g->n_configs++];
+    memset(c, 0, sizeof(*c));
+    c->name = stub_strdup(name);
+    return c;
+}
+
+static void free_setting(stub_setting *setting) {
+    free(setting->label);
+    free(setting->prop_name);
+    free(setting->value);
+}
+
+static void free_config(stub_config *c) {
+    for (size_t i = 0; i < c->n_settings; i++) {
+        free_setting(&c->settings[i]);
+    }
+    free(c->settings);
+    free(c->name);
+}
+
+static void free_group(stub_config_group *g) {
+    for (size_t i = 0; i < g->n_configs; i++) {
+        free_config(&g->configs[i]);
+    }
+    free(g->configs);
+    free(g->name);
+}
+
+static void clear_groups(stub_session *s) {
+    for (size_t i = 0; i < s->n_groups; i++) {
+        free_group(&s->groups[i]);
+    }
+    free(s->groups);
+    s->groups = NULL;
+    s->n_groups = 0;
+}
+
+// setting_matches tells whether the property has the value of the setting.
+// Numbers are compared by value, as the devices format them their own way.
+static int setting_matches(stub_session *s, const stub_setting *setting) {
+    stub_device *d;
+    stub_property *p;
+    double a, b;
+
+    if (get_property(s, setting->label, setting->prop_name, &d, &p) != MM_ErrOK) {
+        return 0;
+    }
+    const char *value = property_value(s, d, p);
+    if (strcmp(value, setting->value) == 0) {
+        return 1;
+    }
+    return p->type != MM_String && parse_double(value, &a) &&
+           parse_double(setting->value, &b) && a == b;
+}
+
+// current_config returns the name of the first preset of the group whose settings
+// all match the current property values, or "" if there is none.
+static const char *current_config(stub_session *s, stub_config_group *g) {
+    for (size_t i = 0; i < g->n_configs; i++) {
+        stub_config *c = &g->configs[i];
+        size_t j = 0;
+        while (j < c->n_settings && setting_matches(s, &c->settings[j])) {
+            j++;
+        }
+        if (j == c->n_settings) {
+            return c->name;
+        }
+    }
+    return "";
+}
+
+static void post_config_group_changes(stub_session *s, const char *label,
+                                      const char *prop_name) {
+    for (size_t i = 0; i < s->n_groups; i++) {
+        stub_config_group *g = &s->groups[i];
+        for (size_t j = 0; j < g->n_configs; j++) {
+            if (find_setting(&g->configs[j], label, prop_name) != NULL) {
+                post_event(s, STUB_CONFIG_GROUP_CHANGED, g->name, current_config(s, g), NULL, 0, 0);
+                break;
+            }
+        }
+    }
+}
+
+//
+// Pixel size helpers
+//
+
+static void clear_pixel_configs(stub_session *s) {
+    free_group(&s->pixel_configs);
+    memset(&s->pixel_configs, 0, sizeof(s->pixel_configs));
+    s->pixel_size_um = 0;
+}
+
+// binning_factor returns the binning of the current camera, which scales the pixel size.
+static double binning_factor(stub_session *s) {
+    stub_device *cam = *s->camera != '\0' ? find_device(s, s->camera) : NULL;
+    long binning = cam != NULL ? property_int(cam, "Binning") : 1;
+    return binning >= 1 ? (double)binning : 1;
+}
+
+// current_pixel_size returns the pixel size of the current pixel size configuration,
+// scaled by the binning, and the scaled affine transform if affine is not NULL.
+// Both are 0 if no configuration matches.
+static double current_pixel_size(stub_session *s, double affine[6]) {
+    const char *name = current_config(s, &s->pixel_configs);
+    stub_config *c = *name != '\0' ? find_config(&s->pixel_configs, name) : NULL;
+    double factor = binning_factor(s);
+
+    if (affine != NULL) {
+        for (int i = 0; i < 6; i++) {
+            affine[i] = c == NULL ? 0 : (i % 3 == 2 ? c->affine[i] : c->affine[i] * factor);
+        }
+    }
+    return c == NULL ? 0 : c->pixel_size_um * factor;
+}
+
+// post_pixel_size_change posts the pixel size if it changed since it was last posted.
+static void post_pixel_size_change(stub_session *s) {
+    double pixel_size_um = current_pixel_size(s, NULL);
+    if (pixel_size_um != s->pixel_size_um) {
+        s->pixel_size_um = pixel_size_um;
+        post_event(s, STUB_PIXEL_SIZE_CHANGED, NULL, NULL, NULL, pixel_size_um, 0);
+    }
+}
+
+static void post_stage_position(stub_session *s, stub_device *d) {
+    post_event(s, STUB_STAGE_POSITION_CHANGED, d->label, NULL, NULL, d->z - d->z_origin, 0);
+}
+
+static void post_xy_stage_position(stub_session *s, stub_device *d) {
+    post_event(s, STUB_XY_STAGE_POSITION_CHANGED, d->label, NULL, NULL,
+               d->x - d->x_origin, d->y - d->y_origin);
+}
+
+// apply_property applies the side effects of setting a validated value.
+static MM_Status apply_property(stub_session *s, stub_device *d,
+                                stub_property *p, const char *value) {
+    char **slot;
+
+    switch (d->type) {
+    case MM_CoreDevice:
+        if ((slot = current_device_slot(s, p->name)) != NULL) {
+            if (*value != '\0' && find_device(s, value)->type == MM_CameraDevice && s->seq_running) {
+                return MM_ErrNotAllowedDuringSequenceAcquisition;
+            }
+            set_string(slot, value);
+        } else if (strcmp(p->name, "AutoShutter") == 0) {
+            s->auto_shutter = strcmp(value, "1") == 0;
+        }
+        break;
+    case MM_StateDevice:
+        if (strcmp(p->name, "State") == 0) {
+            d->state = (int32_t)strtol(value, NULL, 10);
+        } else if (strcmp(p->name, "Label") == 0) {
+            for (int32_t i = 0; i < d->n_states; i++) {
+                if (strcmp(d->state_labels[i], value) == 0) {
+                    d->state = i;
+                }
+            }
+        }
+        break;
+    case MM_StageDevice:
+        if (strcmp(p->name, "Position") == 0) {
+            d->z = strtod(value, NULL) + d->z_origin;
+            post_stage_position(s, d);
+        }
+        break;
+    case MM_ShutterDevice:
+        if (strcmp(p->name, "State") == 0) {
+            d->open = strcmp(value, "1") == 0;
+        }
+        break;
+    case MM_CameraDevice:
+        // Changing the binning or the sensor size resets the ROI, as in DemoCamera.
+        if (strcmp(p->name, "Binning") == 0 ||
+            strcmp(p->name, "OnCameraCCDXSize") == 0 ||
+            strcmp(p->name, "OnCameraCCDYSize") == 0) {
+            d->roi_set = 0;
+        }
+        break;
+    default:
+        break;
+    }
+    return MM_ErrOK;
+}
+
+// set_property validates, normalizes and stores the value, and posts the property change.
+static MM_Status set_property(stub_session *s, stub_device *d,
+                              stub_property *p, const char *value) {
+    char *normalized;
+    double v;
+
+    if (p->read_only) {
+        return MM_ErrDEVICE_GENERIC;
+    }
+
+    switch (p->type) {
+    case MM_Float:
+        if (!parse_double(value, &v) || (p->has_limits && (v < p->lower_limit || v > p->upper_limit))) {
+            return MM_ErrDEVICE_GENERIC;
+        }
+        normalized = format_float(v);
+        break;
+    case MM_Integer:
+        if (!parse_double(value, &v) || v != floor(v) ||
+            (p->has_limits && (v < p->lower_limit || v > p->upper_limit))) {
+            return MM_ErrDEVICE_GENERIC;
+        }
+        normalized = format_int((long long)v);
+        break;
+    default:
+        normalized = stub_strdup(value);
+        break;
+    }
+
+    char **allowed = property_allowed(s, d, p);
+    int ok = allowed[0] == NULL || string_list_contains(allowed, normalized);
+    free(allowed);
+    if (!ok) {
+        free(normalized);
+        return MM_ErrDEVICE_GENERIC;
+    }
+
+    MM_Status status = apply_property(s, d, p, normalized);
+    if (status != MM_ErrOK) {
+        free(normalized);
+        return status;
+    }
+    free(p->value);
+    p->value = normalized;
+    post_property_changed(s, d->label, p->name, property_value(s, d, p));
+    if (d->type == MM_CameraDevice && strcmp(p->name, "Exposure") == 0) {
+        post_event(s, STUB_EXPOSURE_CHANGED, d->label, NULL, NULL, strtod(p->value, NULL), 0);
+    }
+    return MM_ErrOK;
+}
+
+static MM_Status set_property_string(MM_Session mm, const char *label,
+                                     const char *prop_name, const char *value) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    stub_property *p;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_property(s, label, prop_name, &d, &p);
+    if (status == MM_ErrOK) {
+        status = set_property(s, d, p, value);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+static void clear_buffer(stub_session *s) {
+    while (s->buffer_head != NULL) {
+        stub_image *img = s->buffer_head;
+        s->buffer_head = img->next;
+        free(img->data);
+        MM_MetadataFree(&img->md);
+        free(img);
+    }
+    s->buffer_tail = NULL;
+    s->buffer_count = 0;
+    free(s->last_image);
+    s->last_image = NULL;
+    s->last_image_len = 0;
+    MM_MetadataFree(&s->last_md);
+    s->overflowed = 0;
+}
+
+static void unload_device(stub_session *s, size_t index) {
+    stub_device *d = s->devices[index];
+    char **current[] = {&s->camera, &s->shutter, &s->focus, &s->xy_stage, &s->auto_focus};
+
+    for (size_t i = 0; i < 5; i++) {
+        if (strcmp(*current[i], d->label) == 0) {
+            set_string(current[i], "");
+        }
+    }
+    memmove(&s->devices[index], &s->devices[index + 1],
+            (s->n_devices - index - 1) * sizeof(stub_device *));
+    s->n_devices--;
+    free_device(d);
+}
+
+static void unload_all_devices(stub_session *s) {
+    while (s->n_devices > 0) {
+        unload_device(s, s->n_devices - 1);
+    }
+    free(s->snapped);
+    s->snapped = NULL;
+    clear_buffer(s);
+    s->continuous_focus = 0;
+    clear_groups(s);
+    clear_pixel_configs(s);
+}
+
+//
+// Camera
+//
+
+static void sensor_size(stub_device *cam, int *width, int *height) {
+    long binning = property_int(cam, "Binning");
+    if (binning < 1) {
+        binning = 1;
+    }
+    *width = (int)(property_int(cam, "OnCameraCCDXSize") / binning);
+    *height = (int)(property_int(cam, "OnCameraCCDYSize") / binning);
+}
+
+// camera_roi returns the current ROI, which is the whole sensor if no ROI is set.
+static void camera_roi(stub_device *cam, int *x, int *y, int *width, int *height) {
+    if (cam->roi_set) {
+        *x = cam->roi_x;
+        *y = cam->roi_y;
+        *width = cam->roi_width;
+        *height = cam->roi_height;
+        return;
+    }
+    *x = 0;
+    *y = 0;
+    sensor_size(cam, width, height);
+}
+
+static int bytes_per_pixel(stub_device *cam) {
+    const char *pixel_type = property_string(cam, "PixelType");
+    if (strcmp(pixel_type, "16bit") == 0) {
+        return 2;
+    } else if (strcmp(pixel_type, "32bitRGB") == 0) {
+        return 4;
+    }
+    return 1;
+}
+
+static int bit_depth(stub_device *cam) {
+    if (strcmp(property_string(cam, "PixelType"), "16bit") != 0) {
+        return 8;
+    }
+    long depth = property_int(cam, "BitDepth");
+    if (depth > 8) {
+        return (int)depth;
+    }
+    return 16;
+}
+
+static size_t image_size(stub_device *cam) {
+    int x, y, width, height;
+    camera_roi(cam, &x, &y, &width, &height);
+    return (size_t)width * (size_t)height * (size_t)bytes_per_pixel(cam);
+}
+
+// render generates the next image of the camera.
+//
+// The image is a diagonal ramp that shifts by one pixel every frame,
+// saturated to the bit depth of the camera. RGB images are stored as BGRA.
+static uint8_t *render(stub_session *s, stub_device *cam) {
+    int x0, y0, width, height;
+    camera_roi(cam, &x0, &y0, &width, &height);
+    int n_bytes = bytes_per_pixel(cam);
+    uint32_t max = (1u << bit_depth(cam)) - 1;
+    uint32_t frame = s->frame_number++;
+
+    uint8_t *buf = (uint8_t *)calloc((size_t)width * height * n_bytes + 1, 1);
+    for (int y = 0; y < height; y++) {
+        for (int x = 0; x < width; x++) {
+            uint32_t v = (uint32_t)(x0 + x + y0 + y + frame) % (max + 1);
+            uint8_t *px = buf + ((size_t)y * width + x) * n_bytes;
+            switch (n_bytes) {
+            case 1:
+                px[0] = (uint8_t)v;
+                break;
+            case 2:
+                px[0] = (uint8_t)(v & 0xff);
+                px[1] = (uint8_t)(v >> 8);
+                break;
+            case 4:
+                px[0] = (uint8_t)(x0 + x + frame);
+                px[1] = (uint8_t)(y0 + y + frame);
+                px[2] = (uint8_t)v;
+                break;
+            }
+        }
+    }
+    return buf;
+}
+
+// metadata_add appends a tag to md, copying the key and the value.
+static void metadata_add(MM_Metadata *md, const char *key, const char *value) {
+    md->tags = (MM_MetadataTag *)realloc(md->tags, (md->len_tags + 1) * sizeof(MM_MetadataTag));
+    md->tags[md->len_tags].key = stub_strdup(key);
+    md->tags[md->len_tags].value = stub_strdup(value);
+    md->len_tags++;
+}
+
+static void metadata_copy(MM_Metadata *dst, const MM_Metadata *src) {
+    dst->tags = NULL;
+    dst->len_tags = 0;
+    for (size_t i = 0; i < src->len_tags; i++) {
+        metadata_add(dst, src->tags[i].key, src->tags[i].value);
+    }
+}
+
+// image_metadata returns the metadata of the next image of the sequence acquisition,
+// with the tags that MMCore adds, sorted by key.
+static MM_Metadata image_metadata(stub_session *s, stub_device *cam) {
+    MM_Metadata md = {NULL, 0};
+    char buf[64];
+    int x, y, width, height;
+    camera_roi(cam, &x, &y, &width, &height);
+
+    struct timespec now;
+    clock_gettime(CLOCK_REALTIME, &now);
+    double elapsed_ms = (double)(now.tv_sec - s->seq_start.tv_sec) * 1e3 +
+                        (double)(now.tv_nsec - s->seq_start.tv_nsec) / 1e6;
+    struct tm local;
+    time_t sec = now.tv_sec;
+#ifdef _WIN32
+    localtime_s(&local, &sec);
+#else
+    localtime_r(&sec, &local);
+#endif
+
+    metadata_add(&md, "Binning", property_string(cam, "Binning"));
+    metadata_add(&md, "Camera", cam->label);
+    snprintf(buf, sizeof(buf), "%.4f", elapsed_ms);
+    metadata_add(&md, "ElapsedTime-ms", buf);
+    snprintf(buf, sizeof(buf), "%d", height);
+    metadata_add(&md, "Height", buf);
+    snprintf(buf, sizeof(buf), "%lld", (long long)s->seq_image_number++);
+    metadata_add(&md, "ImageNumber", buf);
+    metadata_add(&md, "PixelType", property_string(cam, "PixelType"));
+    snprintf(buf, sizeof(buf), "%d", x);
+    metadata_add(&md, "ROI-X-start", buf);
+    snprintf(buf, sizeof(buf), "%d", y);
+    metadata_add(&md, "ROI-Y-start", buf);
+    size_t n = strftime(buf, sizeof(buf), "%Y-%m-%d %H:%M:%S", &local);
+    snprintf(buf + n, sizeof(buf) - n, ".%06ld", (long)(now.tv_nsec / 1000));
+    metadata_add(&md, "TimeReceivedByCore", buf);
+    snprintf(buf, sizeof(buf), "%d", width);
+    metadata_add(&md, "Width", buf);
+    return md;
+}
+
+// buffer_capacity returns the number of images of the size that fit into the circular buffer.
+static size_t buffer_capacity(stub_session *s, size_t size) {
+    if (size == 0) {
+        return 0;
+    }
+    return (size_t)(((uint64_t)s->buffer_MB << 20) / size);
+}
+
+static size_t current_capacity(stub_session *s) {
+    stub_device *cam;
+    if (get_camera(s, &cam) != MM_ErrOK) {
+        return 0;
+    }
+    return buffer_capacity(s, image_size(cam));
+}
+
+// insert_image inserts an image into the circular buffer, taking the ownership of data and md.
+// It returns 0 if the buffer overflowed and the acquisition should stop.
+static int insert_image(stub_session *s, uint8_t *data, size_t size, MM_Metadata md,
+                        uint8_t stop_on_overflow) {
+    if (s->buffer_count >= buffer_capacity(s, size)) {
+        if (stop_on_overflow) {
+            s->overflowed = 1;
+            free(data);
+            MM_MetadataFree(&md);
+            return 0;
+        }
+        clear_buffer(s);
+    }
+
+    stub_image *img = (stub_image *)calloc(1, sizeof(stub_image));
+    img->data = data;
+    img->md = md;
+    if (s->buffer_tail == NULL) {
+        s->buffer_head = img;
+    } else {
+        s->buffer_tail->next = img;
+    }
+    s->buffer_tail = img;
+    s->buffer_count++;
+
+    free(s->last_image);
+    s->last_image = (uint8_t *)malloc(size + 1);
+    memcpy(s->last_image, data, size);
+    s->last_image_len = size;
+    MM_MetadataFree(&s->last_md);
+    metadata_copy(&s->last_md, &md);
+    return 1;
+}
+
+static void add_ms(struct timespec *t, double ms) {
+    long long ns = t->tv_nsec + (long long)(ms * 1e6);
+    t->tv_sec += (time_t)(ns / 1000000000LL);
+    t->tv_nsec = (long)(ns % 1000000000LL);
+}
+
+static void *sequence_thread(void *arg) {
+    stub_session *s = (stub_session *)arg;
+    struct timespec deadline;
+
+    pthread_mutex_lock(&s->mutex);
+    clock_gettime(CLOCK_REALTIME, &deadline);
+    for (int32_t i = 0; s->seq_num_images <= 0 || i < s->seq_num_images; i++) {
+        add_ms(&deadline, s->seq_period_ms);
+        while (!s->seq_stop) {
+            if (pthread_cond_timedwait(&s->seq_cond, &s->mutex, &deadline) == ETIMEDOUT) {
+                break;
+            }
+        }
+        if (s->seq_stop) {
+            break;
+        }
+
+        stub_device *cam;
+        if (get_camera(s, &cam) != MM_ErrOK) {
+            break;
+        }
+        MM_Metadata md = image_metadata(s, cam);
+        if (!insert_image(s, render(s, cam), image_size(cam), md, s->seq_stop_on_overflow)) {
+            break;
+        }
+    }
+    s->seq_running = 0;
+    pthread_mutex_unlock(&s->mutex);
+    return NULL;
+}
+
+// join_sequence stops the acquisition thread, if any, and waits for it to finish.
+// The session must not be locked.
+static void join_sequence(stub_session *s) {
+    pthread_mutex_lock(&s->mutex);
+    if (!s->seq_thread_started) {
+        pthread_mutex_unlock(&s->mutex);
+        return;
+    }
+    pthread_t thread = s->seq_thread;
+    s->seq_thread_started = 0;
+    s->seq_stop = 1;
+    pthread_cond_broadcast(&s->seq_cond);
+    pthread_mutex_unlock(&s->mutex);
+
+    pthread_join(thread, NULL);
+
+    pthread_mutex_lock(&s->mutex);
+    s->seq_running = 0;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+static MM_Status start_sequence(MM_Session mm, int32_t num_images,
+                                double interval_ms, uint8_t stop_on_overflow) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK && s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    if (status != MM_ErrOK) {
+        return status;
+    }
+
+    // Reap the thread of the previous acquisition, which has finished.
+    join_sequence(s);
+
+    pthread_mutex_lock(&s->mutex);
+    status = get_camera(s, &cam);
+    if (status == MM_ErrOK && s->seq_thread_started) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    }
+    if (status != MM_ErrOK) {
+        pthread_mutex_unlock(&s->mutex);
+        return status;
+    }
+
+    double period_ms = property_float(cam, "Exposure");
+    if (interval_ms > period_ms) {
+        period_ms = interval_ms;
+    }
+    if (period_ms < 1) {
+        period_ms = 1;
+    }
+
+    clear_buffer(s);
+    clock_gettime(CLOCK_REALTIME, &s->seq_start);
+    s->seq_image_number = 0;
+    s->seq_num_images = num_images;
+    s->seq_period_ms = period_ms;
+    s->seq_stop_on_overflow = stop_on_overflow;
+    s->seq_stop = 0;
+    s->seq_running = 1;
+    if (pthread_create(&s->seq_thread, NULL, sequence_thread, s) != 0) {
+        s->seq_running = 0;
+        pthread_mutex_unlock(&s->mutex);
+        return MM_ErrGENERIC;
+    }
+    s->seq_thread_started = 1;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+//
+// Session
+//
+
+DllExport void MM_Open(MM_Session *mm) {
+    stub_session *s = (stub_session *)calloc(1, sizeof(stub_session));
+    pthread_mutex_init(&s->mutex, NULL);
+    pthread_cond_init(&s->seq_cond, NULL);
+    pthread_cond_init(&s->event_cond, NULL);
+
+    s->search_paths = string_list_copy(NULL, 0);
+    s->core = new_core_device();
+    s->camera = stub_strdup("");
+    s->shutter = stub_strdup("");
+    s->focus = stub_strdup("");
+    s->xy_stage = stub_strdup("");
+    s->auto_focus = stub_strdup("");
+    s->auto_shutter = 1;
+    s->buffer_MB = 250;
+
+    pthread_create(&s->event_thread, NULL, event_thread, s);
+    *mm = (MM_Session)s;
+}
+
+DllExport void MM_Close(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    if (s == NULL) {
+        return;
+    }
+
+    join_sequence(s);
+
+    pthread_mutex_lock(&s->mutex);
+    s->closing = 1;
+    pthread_cond_signal(&s->event_cond);
+    pthread_mutex_unlock(&s->mutex);
+    pthread_join(s->event_thread, NULL);
+
+    unload_all_devices(s);
+    free_device(s->core);
+    free(s->devices);
+    string_list_free(s->search_paths);
+    free(s->camera);
+    free(s->shutter);
+    free(s->focus);
+    free(s->xy_stage);
+    free(s->auto_focus);
+    free(s->popped_image);
+
+    pthread_cond_destroy(&s->event_cond);
+    pthread_cond_destroy(&s->seq_cond);
+    pthread_mutex_destroy(&s->mutex);
+    free(s);
+}
+
+DllExport void MM_GetVersionInfo(MM_Session mm, char **info) {
+    (void)mm;
+    *info = stub_strdup("MMCore version 10.2.0 (stub)");
+}
+
+DllExport void MM_GetAPIVersionInfo(MM_Session mm, char **info) {
+    (void)mm;
+    *info = stub_strdup("Device API version 70, Module API version 10");
+}
+
+//
+//
+//
+
+DllExport void MM_StringFree(char *str) {
+    if (str != NULL) {
+        free(str);
+    }
+}
+
+DllExport void MM_StringListFree(char **str_list) {
+    string_list_free(str_list);
+}
+
+//
+// Device initialization and setup
+//
+
+DllExport MM_Status MM_LoadDevice(MM_Session mm, const char *label,
+                                  const char *module_name,
+                                  const char *device_name) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    const stub_device_info *info = find_device_info(device_name);
+    if (label == NULL || *label == '\0') {
+        status = MM_ErrInvalidLabel;
+    } else if (find_device(s, label) != NULL) {
+        status = MM_ErrDuplicateLabel;
+    } else if (strcmp(module_name, STUB_MODULE_NAME) != 0) {
+        status = MM_ErrLoadLibraryFailed;
+    } else if (info == NULL) {
+        status = MM_ErrCreateFailed;
+    }
+    if (status != MM_ErrOK) {
+        pthread_mutex_unlock(&s->mutex);
+        return status;
+    }
+
+    stub_device *d = create_device(label, info);
+    s->devices = (stub_device **)realloc(s->devices, (s->n_devices + 1) * sizeof(stub_device *));
+    s->devices[s->n_devices++] = d;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_UnloadDevice(MM_Session mm, const char *label) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrInvalidLabel;
+
+    pthread_mutex_lock(&s->mutex);
+    for (size_t i = 0; i < s->n_devices; i++) {
+        if (strcmp(s->devices[i]->label, label) == 0) {
+            if (strcmp(label, s->camera) == 0 && s->seq_running) {
+                status = MM_ErrNotAllowedDuringSequenceAcquisition;
+            } else {
+                unload_device(s, i);
+                status = MM_ErrOK;
+            }
+            break;
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_UnloadAllDevices(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    if (s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    } else {
+        unload_all_devices(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_InitializeAllDevices initializes all devices that have not been initialized, hubs first.
+DllExport MM_Status MM_InitializeAllDevices(MM_Session mm) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    for (int hubs_first = 1; hubs_first >= 0; hubs_first--) {
+        for (size_t i = 0; i < s->n_devices; i++) {
+            if ((s->devices[i]->type == MM_HubDevice) == hubs_first) {
+                s->devices[i]->initialized = 1;
+            }
+        }
+    }
+    post_event(s, STUB_PROPERTIES_CHANGED, NULL, NULL, NULL, 0, 0);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_InitializeDevice(MM_Session mm, const char *label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status == MM_ErrOK && d->initialized) {
+        status = MM_ErrGENERIC;
+    }
+    if (status == MM_ErrOK) {
+        d->initialized = 1;
+        post_event(s, STUB_PROPERTIES_CHANGED, NULL, NULL, NULL, 0, 0);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_Reset unloads all devices and, as with MMCoreC, unregisters the event callback.
+DllExport MM_Status MM_Reset(MM_Session mm) {
+    stub_session *s = get_session(mm);
+
+    join_sequence(s);
+
+    pthread_mutex_lock(&s->mutex);
+    s->callback = NULL;
+    unload_all_devices(s);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+//
+// Event callback
+//
+
+// MM_RegisterCallback registers the callback, which must stay valid until
+// another callback is registered or the session is closed.
+DllExport void MM_RegisterCallback(MM_Session mm, struct MM_EventCallback *callback) {
+    stub_session *s = get_session(mm);
+    if (s == NULL) {
+        return;
+    }
+
+    pthread_mutex_lock(&s->mutex);
+    s->callback = callback;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+//
+// Device listing
+//
+
+DllExport void MM_SetDeviceAdapterSearchPaths(MM_Session mm,
+                                              const char **paths) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    string_list_free(s->search_paths);
+    s->search_paths = string_list_copy((char *const *)paths, (size_t)-1);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MM_GetDeviceAdapterSearchPaths(MM_Session mm, char ***paths) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *paths = string_list_copy(s->search_paths, (size_t)-1);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport MM_Status MM_GetDeviceAdapterNames(MM_Session mm, char ***names) {
+    static char *module_names[] = {(char *)STUB_MODULE_NAME, NULL};
+    (void)mm;
+    *names = string_list_copy(module_names, (size_t)-1);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailableDevices(MM_Session mm, const char *library,
+                                           char ***names) {
+    (void)mm;
+    if (strcmp(library, STUB_MODULE_NAME) != 0) {
+        *names = string_list_copy(NULL, 0);
+        return MM_ErrLoadLibraryFailed;
+    }
+    *names = (char **)calloc(N_DEMO_DEVICES + 1, sizeof(char *));
+    for (size_t i = 0; i < N_DEMO_DEVICES; i++) {
+        (*names)[i] = stub_strdup(demo_devices[i].name);
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailableDeviceDescriptions(MM_Session mm,
+                                                      const char *library,
+                                                      char ***descriptions) {
+    (void)mm;
+    if (strcmp(library, STUB_MODULE_NAME) != 0) {
+        *descriptions = string_list_copy(NULL, 0);
+        return MM_ErrLoadLibraryFailed;
+    }
+    *descriptions = (char **)calloc(N_DEMO_DEVICES + 1, sizeof(char *));
+    for (size_t i = 0; i < N_DEMO_DEVICES; i++) {
+        (*descriptions)[i] = stub_strdup(demo_devices[i].description);
+    }
+    return MM_ErrOK;
+}
+
+// MM_GetAvailableDeviceTypes writes the types into the array provided by the caller
+// at *types, as does MMCoreC. If *types is NULL, only the number of types is returned.
+DllExport MM_Status MM_GetAvailableDeviceTypes(MM_Session mm,
+                                               const char *library,
+                                               MM_DeviceType **types,
+                                               size_t *len_types) {
+    (void)mm;
+    if (strcmp(library, STUB_MODULE_NAME) != 0) {
+        *len_types = 0;
+        return MM_ErrLoadLibraryFailed;
+    }
+    *len_types = N_DEMO_DEVICES;
+    if (types == NULL || *types == NULL) {
+        return MM_ErrOK;
+    }
+    for (size_t i = 0; i < N_DEMO_DEVICES; i++) {
+        (*types)[i] = demo_devices[i].type;
+    }
+    return MM_ErrOK;
+}
+
+//
+// Generic device control
+//
+
+// MM_GetLoadedDevices returns the labels of the loaded devices in load order, followed by "Core".
+DllExport MM_Status MM_GetLoadedDevices(MM_Session mm, char ***labels) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *labels = (char **)calloc(s->n_devices + 2, sizeof(char *));
+    for (size_t i = 0; i < s->n_devices; i++) {
+        (*labels)[i] = stub_strdup(s->devices[i]->label);
+    }
+    (*labels)[s->n_devices] = stub_strdup(STUB_CORE_LABEL);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetDeviceType(MM_Session mm, const char *label,
+                                     MM_DeviceType *type) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *type = status == MM_ErrOK ? d->type : MM_UnknownType;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_GetDeviceLibrary returns the module of the device, which is empty for Core.
+DllExport MM_Status MM_GetDeviceLibrary(MM_Session mm, const char *label,
+                                        char **module_name) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *module_name = stub_strdup(status == MM_ErrOK && d != s->core ? STUB_MODULE_NAME : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetDeviceName(MM_Session mm, const char *label,
+                                     char **dev_name) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *dev_name = stub_strdup(status == MM_ErrOK ? d->name : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetDevicePropertyNames(MM_Session mm, const char *label,
+                                              char ***names) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    size_t n = 0;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status != MM_ErrOK) {
+        *names = string_list_copy(NULL, 0);
+        pthread_mutex_unlock(&s->mutex);
+        return status;
+    }
+    *names = (char **)calloc(d->n_props + 1, sizeof(char *));
+    for (size_t i = 0; i < d->n_props; i++) {
+        if (find_property(d, d->props[i]->name) != NULL) {
+            (*names)[n++] = stub_strdup(d->props[i]->name);
+        }
+    }
+    qsort(*names, n, sizeof(char *), compare_strings);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_HasProperty(MM_Session mm, const char *label,
+                                   const char *prop_name,
+                                   uint8_t *has_property) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *has_property = status == MM_ErrOK && find_property(d, prop_name) != NULL;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetProperty(MM_Session mm, const char *label,
+                                   const char *prop_name, char **value) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    stub_property *p;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_property(s, label, prop_name, &d, &p);
+    *value = stub_strdup(status == MM_ErrOK ? property_value(s, d, p) : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetPropertyString(MM_Session mm, const char *label,
+                                         const char *prop_name,
+                                         const char *value) {
+    return set_property_string(mm, label, prop_name, value);
+}
+
+DllExport MM_Status MM_SetPropertyBool(MM_Session mm, const char *label,
+                                       const char *prop_name,
+                                       const uint8_t value) {
+    return set_property_string(mm, label, prop_name, value ? "1" : "0");
+}
+
+DllExport MM_Status MM_SetPropertyInt(MM_Session mm, const char *label,
+                                      const char *prop_name,
+                                      const int32_t value) {
+    char buf[32];
+    snprintf(buf, sizeof(buf), "%d", (int)value);
+    return set_property_string(mm, label, prop_name, buf);
+}
+
+DllExport MM_Status MM_SetPropertyFloat(MM_Session mm, const char *label,
+                                        const char *prop_name,
+                                        const float value) {
+    char buf[64];
+    snprintf(buf, sizeof(buf), "%.9g", (double)value);
+    return set_property_string(mm, label, prop_name, buf);
+}
+
+DllExport MM_Status MM_SetPropertyDouble(MM_Session mm, const char *label,
+                                         const char *prop_name,
+                                         const double value) {
+    char buf[64];
+    snprintf(buf, sizeof(buf), "%.17g", value);
+    return set_property_string(mm, label, prop_name, buf);
+}
+
+DllExport MM_Status MM_GetAllowedPropertyValues(MM_Session mm,
+                                                const char *label,
+                                                const char *prop_name,
+                                                char ***values) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    stub_property *p;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_property(s, label, prop_name, &d, &p);
+    if (status != MM_ErrOK) {
+        *values = string_list_copy(NULL, 0);
+    } else {
+        char **allowed = property_allowed(s, d, p);
+        *values = string_list_copy(allowed, (size_t)-1);
+        free(allowed);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// STUB_PROPERTY_GETTER reads a field of a property under the session lock.
+#define STUB_PROPERTY_GETTER(mm, label, prop_name, out, expr)                 \
+    do {                                                                      \
+        stub_session *s = get_session(mm);                                    \
+        stub_device *d;                                                       \
+        stub_property *p;                                                     \
+        pthread_mutex_lock(&s->mutex);                                        \
+        MM_Status status = get_property(s, label, prop_name, &d, &p);        \
+        *(out) = status == MM_ErrOK ? (expr) : 0;                             \
+        pthread_mutex_unlock(&s->mutex);                                      \
+        return status;                                                        \
+    } while (0)
+
+DllExport MM_Status MM_IsPropertyReadOnly(MM_Session mm, const char *label,
+                                          const char *prop_name,
+                                          uint8_t *read_only) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, read_only, p->read_only);
+}
+
+DllExport MM_Status MM_IsPropertyPreInit(MM_Session mm, const char *label,
+                                         const char *prop_name,
+                                         uint8_t *pre_init) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, pre_init, p->pre_init);
+}
+
+DllExport MM_Status MM_IsPropertySequenceable(MM_Session mm, const char *label,
+                                              const char *prop_name,
+                                              uint8_t *sequenceable) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, sequenceable, p->sequenceable);
+}
+
+DllExport MM_Status MM_HasPropertyLimits(MM_Session mm, const char *label,
+                                         const char *prop_name,
+                                         uint8_t *has_limits) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, has_limits, p->has_limits);
+}
+
+DllExport MM_Status MM_GetPropertyLowerLimit(MM_Session mm, const char *label,
+                                             const char *prop_name,
+                                             double *lower_limit) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, lower_limit, p->lower_limit);
+}
+
+DllExport MM_Status MM_GetPropertyUpperLimit(MM_Session mm, const char *label,
+                                             const char *prop_name,
+                                             double *upper_limit) {
+    STUB_PROPERTY_GETTER(mm, label, prop_name, upper_limit, p->upper_limit);
+}
+
+DllExport MM_Status MM_GetPropertyType(MM_Session mm, const char *label,
+                                       const char *prop_name,
+                                       MM_PropertyType *type) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    stub_property *p;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_property(s, label, prop_name, &d, &p);
+    *type = status == MM_ErrOK ? p->type : MM_Undef;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// describe_property fills in the descriptor. The session must be locked.
+static void describe_property(stub_session *s, stub_device *d, stub_property *p,
+                              MM_PropertyDescriptor *desc) {
+    desc->name = stub_strdup(p->name);
+    desc->value = stub_strdup(property_value(s, d, p));
+    desc->type = p->type;
+    desc->read_only = p->read_only;
+    desc->pre_init = p->pre_init;
+    desc->sequenceable = p->sequenceable;
+    desc->has_limits = p->has_limits;
+    desc->lower_limit = p->lower_limit;
+    desc->upper_limit = p->upper_limit;
+
+    char **allowed = property_allowed(s, d, p);
+    desc->allowed_values = string_list_copy(allowed, (size_t)-1);
+    free(allowed);
+}
+
+DllExport MM_Status MM_DescribeProperty(MM_Session mm, const char *label,
+                                        const char *prop_name,
+                                        MM_PropertyDescriptor **desc) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    stub_property *p;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_property(s, label, prop_name, &d, &p);
+    if (status == MM_ErrOK) {
+        *desc = (MM_PropertyDescriptor *)calloc(1, sizeof(MM_PropertyDescriptor));
+        describe_property(s, d, p, *desc);
+    } else {
+        *desc = NULL;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+static int compare_descriptors(const void *a, const void *b) {
+    return strcmp(((const MM_PropertyDescriptor *)a)->name,
+                  ((const MM_PropertyDescriptor *)b)->name);
+}
+
+// MM_DescribeDevice returns the descriptors in the order of MM_GetDevicePropertyNames.
+DllExport MM_Status MM_DescribeDevice(MM_Session mm, const char *label,
+                                      MM_PropertyDescriptor **descs,
+                                      size_t *len_descs) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+    size_t n = 0;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status != MM_ErrOK) {
+        *descs = NULL;
+        *len_descs = 0;
+        pthread_mutex_unlock(&s->mutex);
+        return status;
+    }
+    *descs = (MM_PropertyDescriptor *)calloc(d->n_props + 1, sizeof(MM_PropertyDescriptor));
+    for (size_t i = 0; i < d->n_props; i++) {
+        stub_property *p = find_property(d, d->props[i]->name);
+        if (p != NULL) {
+            describe_property(s, d, p, &(*descs)[n++]);
+        }
+    }
+    qsort(*descs, n, sizeof(MM_PropertyDescriptor), compare_descriptors);
+    *len_descs = n;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport void MM_PropertyDescriptorsFree(MM_PropertyDescriptor *descs,
+                                          size_t len_descs) {
+    if (descs == NULL) {
+        return;
+    }
+    for (size_t i = 0; i < len_descs; i++) {
+        free(descs[i].name);
+        free(descs[i].value);
+        string_list_free(descs[i].allowed_values);
+    }
+    free(descs);
+}
+
+// device_busy reports whether the device is busy. Simulated devices finish
+// their moves immediately, and are only busy after MMStub_SetDeviceBusy.
+static uint8_t device_busy(stub_device *d) {
+    struct timespec now;
+    clock_gettime(CLOCK_MONOTONIC, &now);
+    return now.tv_sec < d->busy_until.tv_sec ||
+           (now.tv_sec == d->busy_until.tv_sec && now.tv_nsec < d->busy_until.tv_nsec);
+}
+
+DllExport MM_Status MM_DeviceBusy(MM_Session mm, const char *label,
+                                  uint8_t *busy) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *busy = status == MM_ErrOK ? device_busy(d) : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_DeviceTypeBusy(MM_Session mm, MM_DeviceType type,
+                                      uint8_t *busy) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *busy = 0;
+    for (size_t i = 0; i < s->n_devices && !*busy; i++) {
+        stub_device *d = s->devices[i];
+        if (type == MM_AnyType || d->type == type) {
+            *busy = device_busy(d);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+// Like MMCore, the delay of the Core device is ignored and always 0.
+DllExport MM_Status MM_SetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double delay_ms) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status == MM_ErrOK && d != s->core) {
+        d->delay_ms = delay_ms;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetDeviceDelayMs(MM_Session mm, const char *label,
+                                        double *delay_ms) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *delay_ms = status == MM_ErrOK ? d->delay_ms : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Manage current devices
+//
+
+// set_current_device sets the current device through the property of the Core device.
+static MM_Status set_current_device(MM_Session mm, const char *role, const char *label) {
+    stub_session *s = get_session(mm);
+    stub_property *p;
+
+    if (label == NULL) {
+        label = "";
+    }
+
+    pthread_mutex_lock(&s->mutex);
+    p = find_property(s->core, role);
+    char **allowed = property_allowed(s, s->core, p);
+    int ok = string_list_contains(allowed, label);
+    free(allowed);
+
+    MM_Status status;
+    if (!ok) {
+        status = find_device(s, label) == NULL ? MM_ErrInvalidLabel : MM_ErrGENERIC;
+    } else {
+        status = set_property(s, s->core, p, label);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+static void get_current_device(MM_Session mm, char *const *(*slot)(stub_session *), char **label) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *label = stub_strdup(*slot(s));
+    pthread_mutex_unlock(&s->mutex);
+}
+
+static char *const *camera_slot(stub_session *s) { return &s->camera; }
+static char *const *shutter_slot(stub_session *s) { return &s->shutter; }
+static char *const *focus_slot(stub_session *s) { return &s->focus; }
+static char *const *xy_stage_slot(stub_session *s) { return &s->xy_stage; }
+static char *const *auto_focus_slot(stub_session *s) { return &s->auto_focus; }
+
+DllExport MM_Status MM_SetCameraDevice(MM_Session mm, const char *label) {
+    return set_current_device(mm, "Camera", label);
+}
+
+DllExport MM_Status MM_SetShutterDevice(MM_Session mm, const char *label) {
+    return set_current_device(mm, "Shutter", label);
+}
+
+DllExport MM_Status MM_SetFocusDevice(MM_Session mm, const char *label) {
+    return set_current_device(mm, "Focus", label);
+}
+
+DllExport MM_Status MM_SetXYStageDevice(MM_Session mm, const char *label) {
+    return set_current_device(mm, "XYStage", label);
+}
+
+DllExport MM_Status MM_SetAutoFocusDevice(MM_Session mm, const char *label) {
+    return set_current_device(mm, "AutoFocus", label);
+}
+
+DllExport void MM_GetCameraDevice(MM_Session mm, char **label) {
+    get_current_device(mm, camera_slot, label);
+}
+
+DllExport void MM_GetShutterDevice(MM_Session mm, char **label) {
+    get_current_device(mm, shutter_slot, label);
+}
+
+DllExport void MM_GetFocusDevice(MM_Session mm, char **label) {
+    get_current_device(mm, focus_slot, label);
+}
+
+DllExport void MM_GetXYStageDevice(MM_Session mm, char **label) {
+    get_current_device(mm, xy_stage_slot, label);
+}
+
+DllExport void MM_GetAutoFocusDevice(MM_Session mm, char **label) {
+    get_current_device(mm, auto_focus_slot, label);
+}
+
+//
+// Configuration groups and presets
+//
+
+DllExport MM_Status MM_DefineConfigGroup(MM_Session mm, const char *group_name) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    if (!valid_config_name(group_name)) {
+        status = MM_ErrBadConfigName;
+    } else if (find_group(s, group_name) != NULL) {
+        status = MM_ErrDuplicateConfigGroup;
+    } else {
+        add_group(s, group_name);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_DeleteConfigGroup(MM_Session mm, const char *group_name) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrNoConfigGroup;
+
+    pthread_mutex_lock(&s->mutex);
+    stub_config_group *g = group_name != NULL ? find_group(s, group_name) : NULL;
+    if (g != NULL) {
+        size_t index = (size_t)(g - s->groups);
+        free_group(g);
+        memmove(&s->groups[index], &s->groups[index + 1],
+                (s->n_groups - index - 1) * sizeof(stub_config_group));
+        s->n_groups--;
+        status = MM_ErrOK;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_RenameConfigGroup(MM_Session mm, const char *old_group_name,
+                                         const char *new_group_name) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    stub_config_group *g = old_group_name != NULL ? find_group(s, old_group_name) : NULL;
+    if (g == NULL) {
+        status = MM_ErrNoConfigGroup;
+    } else if (!valid_config_name(new_group_name)) {
+        status = MM_ErrBadConfigName;
+    } else if (find_group(s, new_group_name) != NULL) {
+        status = MM_ErrDuplicateConfigGroup;
+    } else {
+        set_string(&g->name, new_group_name);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_IsGroupDefined(MM_Session mm, const char *group_name,
+                                      uint8_t *defined) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *defined = group_name != NULL && find_group(s, group_name) != NULL;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailableConfigGroups(MM_Session mm, char ***group_names) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *group_names = (char **)calloc(s->n_groups + 1, sizeof(char *));
+    for (size_t i = 0; i < s->n_groups; i++) {
+        (*group_names)[i] = stub_strdup(s->groups[i].name);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+// define_config returns the preset, and defines the group and the preset if needed.
+static MM_Status define_config(stub_session *s, const char *group_name,
+                               const char *config_name, stub_config **c) {
+    if (!valid_config_name(group_name) || !valid_config_name(config_name)) {
+        return MM_ErrBadConfigName;
+    }
+    stub_config_group *g = find_group(s, group_name);
+    if (g == NULL) {
+        g = add_group(s, group_name);
+    }
+    *c = find_config(g, config_name);
+    if (*c == NULL) {
+        *c = add_config(g, config_name);
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DefineConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = define_config(s, group_name, config_name, &c);
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_DefineConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name,
+                                           const char *value) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    if (label == NULL || *label == '\0' || prop_name == NULL || *prop_name == '\0') {
+        return MM_ErrInvalidLabel;
+    }
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = define_config(s, group_name, config_name, &c);
+    if (status == MM_ErrOK) {
+        stub_setting *setting = find_setting(c, label, prop_name);
+        if (setting == NULL) {
+            c->settings = (stub_setting *)realloc(c->settings, (c->n_settings + 1) * sizeof(stub_setting));
+            setting = &c->settings[c->n_settings++];
+            setting->label = stub_strdup(label);
+            setting->prop_name = stub_strdup(prop_name);
+            setting->value = NULL;
+        }
+        set_string(&setting->value, value);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// get_config returns the preset, or MM_ErrNoConfigGroup or MM_ErrNoConfiguration.
+static MM_Status get_config(stub_session *s, const char *group_name,
+                            const char *config_name, stub_config_group **g,
+                            stub_config **c) {
+    *g = group_name != NULL ? find_group(s, group_name) : NULL;
+    if (*g == NULL) {
+        return MM_ErrNoConfigGroup;
+    }
+    *c = config_name != NULL ? find_config(*g, config_name) : NULL;
+    if (*c == NULL) {
+        return MM_ErrNoConfiguration;
+    }
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_DeleteConfig(MM_Session mm, const char *group_name,
+                                    const char *config_name) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_config(s, group_name, config_name, &g, &c);
+    if (status == MM_ErrOK) {
+        size_t index = (size_t)(c - g->configs);
+        free_config(c);
+        memmove(&g->configs[index], &g->configs[index + 1],
+                (g->n_configs - index - 1) * sizeof(stub_config));
+        g->n_configs--;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_DeleteConfigSetting(MM_Session mm, const char *group_name,
+                                           const char *config_name,
+                                           const char *label,
+                                           const char *prop_name) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_config(s, group_name, config_name, &g, &c);
+    if (status == MM_ErrOK) {
+        stub_setting *setting = label != NULL && prop_name != NULL ? find_setting(c, label, prop_name) : NULL;
+        if (setting == NULL) {
+            status = MM_ErrNoConfiguration;
+        } else {
+            size_t index = (size_t)(setting - c->settings);
+            free_setting(setting);
+            memmove(&c->settings[index], &c->settings[index + 1],
+                    (c->n_settings - index - 1) * sizeof(stub_setting));
+            c->n_settings--;
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_RenameConfig(MM_Session mm, const char *group_name,
+                                    const char *old_config_name,
+                                    const char *new_config_name) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_config(s, group_name, old_config_name, &g, &c);
+    if (status == MM_ErrOK) {
+        if (!valid_config_name(new_config_name)) {
+            status = MM_ErrBadConfigName;
+        } else if (find_config(g, new_config_name) != NULL) {
+            status = MM_ErrDuplicateConfigGroup;
+        } else {
+            set_string(&c->name, new_config_name);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_IsConfigDefined(MM_Session mm, const char *group_name,
+                                       const char *config_name,
+                                       uint8_t *defined) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    *defined = get_config(s, group_name, config_name, &g, &c) == MM_ErrOK;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+// MM_GetAvailableConfigs returns the presets of the group, and no preset for an undefined group.
+DllExport MM_Status MM_GetAvailableConfigs(MM_Session mm, const char *group_name,
+                                           char ***config_names) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    stub_config_group *g = group_name != NULL ? find_group(s, group_name) : NULL;
+    size_t n = g != NULL ? g->n_configs : 0;
+    *config_names = (char **)calloc(n + 1, sizeof(char *));
+    for (size_t i = 0; i < n; i++) {
+        (*config_names)[i] = stub_strdup(g->configs[i].name);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+// MM_SetConfig sets the properties of the preset in order, stopping at the first error,
+// and then posts the group change.
+DllExport MM_Status MM_SetConfig(MM_Session mm, const char *group_name,
+                                 const char *config_name) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_config(s, group_name, config_name, &g, &c);
+    s->applying_config = 1;
+    for (size_t i = 0; status == MM_ErrOK && i < c->n_settings; i++) {
+        stub_device *d;
+        stub_property *p;
+        status = get_property(s, c->settings[i].label, c->settings[i].prop_name, &d, &p);
+        if (status == MM_ErrOK) {
+            status = set_property(s, d, p, c->settings[i].value);
+        }
+    }
+    s->applying_config = 0;
+    if (status == MM_ErrOK) {
+        post_event(s, STUB_CONFIG_GROUP_CHANGED, g->name, c->name, NULL, 0, 0);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetCurrentConfig(MM_Session mm, const char *group_name,
+                                        char **config_name) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    stub_config_group *g = group_name != NULL ? find_group(s, group_name) : NULL;
+    if (g == NULL) {
+        status = MM_ErrNoConfigGroup;
+    }
+    *config_name = stub_strdup(g != NULL ? current_config(s, g) : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetConfigData(MM_Session mm, const char *group_name,
+                                     const char *config_name,
+                                     MM_PropertySetting **settings,
+                                     size_t *len_settings) {
+    stub_session *s = get_session(mm);
+    stub_config_group *g;
+    stub_config *c;
+
+    *settings = NULL;
+    *len_settings = 0;
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_config(s, group_name, config_name, &g, &c);
+    if (status == MM_ErrOK) {
+        *settings = (MM_PropertySetting *)calloc(c->n_settings + 1, sizeof(MM_PropertySetting));
+        for (size_t i = 0; i < c->n_settings; i++) {
+            (*settings)[i].label = stub_strdup(c->settings[i].label);
+            (*settings)[i].prop_name = stub_strdup(c->settings[i].prop_name);
+            (*settings)[i].value = stub_strdup(c->settings[i].value);
+        }
+        *len_settings = c->n_settings;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport void MM_PropertySettingsFree(MM_PropertySetting *settings,
+                                       size_t len_settings) {
+    if (settings == NULL) {
+        return;
+    }
+    for (size_t i = 0; i < len_settings; i++) {
+        free(settings[i].label);
+        free(settings[i].prop_name);
+        free(settings[i].value);
+    }
+    free(settings);
+}
+
+//
+// Pixel size configurations
+//
+
+// get_pixel_config returns the pixel size configuration, or MM_ErrNoConfiguration.
+static MM_Status get_pixel_config(stub_session *s, const char *resolution_id, stub_config **c) {
+    *c = resolution_id != NULL ? find_config(&s->pixel_configs, resolution_id) : NULL;
+    return *c != NULL ? MM_ErrOK : MM_ErrNoConfiguration;
+}
+
+// MM_DefinePixelSizeConfig adds a property value to a pixel size configuration, and defines
+// the configuration if needed, with an identity affine transform.
+DllExport MM_Status MM_DefinePixelSizeConfig(MM_Session mm, const char *resolution_id,
+                                             const char *label,
+                                             const char *prop_name,
+                                             const char *value) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    if (label == NULL || *label == '\0' || prop_name == NULL || *prop_name == '\0') {
+        return MM_ErrInvalidLabel;
+    }
+    pthread_mutex_lock(&s->mutex);
+    if (!valid_config_name(resolution_id)) {
+        status = MM_ErrBadConfigName;
+    } else {
+        stub_config *c = find_config(&s->pixel_configs, resolution_id);
+        if (c == NULL) {
+            c = add_config(&s->pixel_configs, resolution_id);
+            c->affine[0] = 1;
+            c->affine[4] = 1;
+        }
+        stub_setting *setting = find_setting(c, label, prop_name);
+        if (setting == NULL) {
+            c->settings = (stub_setting *)realloc(c->settings, (c->n_settings + 1) * sizeof(stub_setting));
+            setting = &c->settings[c->n_settings++];
+            setting->label = stub_strdup(label);
+            setting->prop_name = stub_strdup(prop_name);
+            setting->value = NULL;
+        }
+        set_string(&setting->value, value);
+        post_pixel_size_change(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetPixelSizeUm(MM_Session mm, const char *resolution_id,
+                                      double pixel_size_um) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    if (status == MM_ErrOK) {
+        c->pixel_size_um = pixel_size_um;
+        post_pixel_size_change(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetPixelSizeAffine(MM_Session mm, const char *resolution_id,
+                                          const double affine[6]) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    if (affine == NULL) {
+        return MM_ErrBadAffineTransform;
+    }
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    if (status == MM_ErrOK) {
+        memcpy(c->affine, affine, sizeof(c->affine));
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_DeletePixelSizeConfig(MM_Session mm, const char *resolution_id) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    if (status == MM_ErrOK) {
+        stub_config_group *g = &s->pixel_configs;
+        size_t index = (size_t)(c - g->configs);
+        free_config(c);
+        memmove(&g->configs[index], &g->configs[index + 1],
+                (g->n_configs - index - 1) * sizeof(stub_config));
+        g->n_configs--;
+        post_pixel_size_change(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_RenamePixelSizeConfig(MM_Session mm,
+                                             const char *old_resolution_id,
+                                             const char *new_resolution_id) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, old_resolution_id, &c);
+    if (status == MM_ErrOK) {
+        if (!valid_config_name(new_resolution_id)) {
+            status = MM_ErrBadConfigName;
+        } else if (find_config(&s->pixel_configs, new_resolution_id) != NULL) {
+            status = MM_ErrDuplicateConfigGroup;
+        } else {
+            set_string(&c->name, new_resolution_id);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_IsPixelSizeConfigDefined(MM_Session mm, const char *resolution_id,
+                                                uint8_t *defined) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    *defined = get_pixel_config(s, resolution_id, &c) == MM_ErrOK;
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetAvailablePixelSizeConfigs(MM_Session mm, char ***resolution_ids) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    stub_config_group *g = &s->pixel_configs;
+    *resolution_ids = (char **)calloc(g->n_configs + 1, sizeof(char *));
+    for (size_t i = 0; i < g->n_configs; i++) {
+        (*resolution_ids)[i] = stub_strdup(g->configs[i].name);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeConfigData(MM_Session mm, const char *resolution_id,
+                                              MM_PropertySetting **settings,
+                                              size_t *len_settings) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    *settings = NULL;
+    *len_settings = 0;
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    if (status == MM_ErrOK) {
+        *settings = (MM_PropertySetting *)calloc(c->n_settings + 1, sizeof(MM_PropertySetting));
+        for (size_t i = 0; i < c->n_settings; i++) {
+            (*settings)[i].label = stub_strdup(c->settings[i].label);
+            (*settings)[i].prop_name = stub_strdup(c->settings[i].prop_name);
+            (*settings)[i].value = stub_strdup(c->settings[i].value);
+        }
+        *len_settings = c->n_settings;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_SetPixelSizeConfig sets the properties of the configuration in order, stopping at
+// the first error. The pixel size change is posted with the property changes.
+DllExport MM_Status MM_SetPixelSizeConfig(MM_Session mm, const char *resolution_id) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    for (size_t i = 0; status == MM_ErrOK && i < c->n_settings; i++) {
+        stub_device *d;
+        stub_property *p;
+        status = get_property(s, c->settings[i].label, c->settings[i].prop_name, &d, &p);
+        if (status == MM_ErrOK) {
+            status = set_property(s, d, p, c->settings[i].value);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetCurrentPixelSizeConfig(MM_Session mm, char **resolution_id) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *resolution_id = stub_strdup(current_config(s, &s->pixel_configs));
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeUm(MM_Session mm, double *pixel_size_um) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *pixel_size_um = current_pixel_size(s, NULL);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeUmByID(MM_Session mm, const char *resolution_id,
+                                          double *pixel_size_um) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    *pixel_size_um = status == MM_ErrOK ? c->pixel_size_um : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetPixelSizeAffine(MM_Session mm, double affine[6]) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    current_pixel_size(s, affine);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+DllExport MM_Status MM_GetPixelSizeAffineByID(MM_Session mm, const char *resolution_id,
+                                              double affine[6]) {
+    stub_session *s = get_session(mm);
+    stub_config *c;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_pixel_config(s, resolution_id, &c);
+    if (status == MM_ErrOK) {
+        memcpy(affine, c->affine, sizeof(c->affine));
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Image acquisition settings
+//
+
+// MM_SetROI sets the region of interest of the current camera in binned pixels.
+DllExport MM_Status MM_SetROI(MM_Session mm, int x, int y, int x_size,
+                              int y_size) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+    int width, height;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK && s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    }
+    if (status == MM_ErrOK) {
+        sensor_size(cam, &width, &height);
+        if (x < 0 || y < 0 || x_size <= 0 || y_size <= 0 || x + x_size > width || y + y_size > height) {
+            status = MM_ErrDEVICE_GENERIC;
+        }
+    }
+    if (status == MM_ErrOK) {
+        cam->roi_x = x;
+        cam->roi_y = y;
+        cam->roi_width = x_size;
+        cam->roi_height = y_size;
+        cam->roi_set = 1;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetROI(MM_Session mm, int *x, int *y, int *x_size,
+                              int *y_size) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK) {
+        camera_roi(cam, x, y, x_size, y_size);
+    } else {
+        *x = *y = *x_size = *y_size = 0;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_ClearROI(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK && s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    }
+    if (status == MM_ErrOK) {
+        cam->roi_set = 0;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetExposure(MM_Session mm, double exp) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+    char buf[64];
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK) {
+        snprintf(buf, sizeof(buf), "%.17g", exp);
+        status = set_property(s, cam, find_property(cam, "Exposure"), buf);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetExposure(MM_Session mm, double *exp) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    *exp = status == MM_ErrOK ? property_float(cam, "Exposure") : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// camera_info returns a value computed from the current camera, or 0 if there is no camera.
+static int camera_info(MM_Session mm, int (*info)(stub_device *cam)) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+    int value = 0;
+
+    pthread_mutex_lock(&s->mutex);
+    if (get_camera(s, &cam) == MM_ErrOK) {
+        value = info(cam);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return value;
+}
+
+static int info_width(stub_device *cam) {
+    int x, y, width, height;
+    camera_roi(cam, &x, &y, &width, &height);
+    return width;
+}
+
+static int info_height(stub_device *cam) {
+    int x, y, width, height;
+    camera_roi(cam, &x, &y, &width, &height);
+    return height;
+}
+
+static int info_components(stub_device *cam) {
+    return strcmp(property_string(cam, "PixelType"), "32bitRGB") == 0 ? 4 : 1;
+}
+
+static int info_channels(stub_device *cam) {
+    (void)cam;
+    return 1;
+}
+
+static int info_buffer_size(stub_device *cam) {
+    return (int)image_size(cam);
+}
+
+DllExport void MM_GetImageWidth(MM_Session mm, uint16_t *width) {
+    *width = (uint16_t)camera_info(mm, info_width);
+}
+
+DllExport void MM_GetImageHeight(MM_Session mm, uint16_t *height) {
+    *height = (uint16_t)camera_info(mm, info_height);
+}
+
+DllExport void MM_GetBytesPerPixel(MM_Session mm, uint8_t *bytes) {
+    *bytes = (uint8_t)camera_info(mm, bytes_per_pixel);
+}
+
+DllExport void MM_GetImageBitDepth(MM_Session mm, uint8_t *bit_depth_out) {
+    *bit_depth_out = (uint8_t)camera_info(mm, bit_depth);
+}
+
+DllExport void MM_GetNumberOfComponents(MM_Session mm,
+                                        uint8_t *n_components) {
+    *n_components = (uint8_t)camera_info(mm, info_components);
+}
+
+DllExport void MM_GetNumberOfCameraChannels(MM_Session mm,
+                                            uint8_t *n_channels) {
+    *n_channels = (uint8_t)camera_info(mm, info_channels);
+}
+
+DllExport void MM_GetImageBufferSize(MM_Session mm, uint32_t *len) {
+    *len = (uint32_t)camera_info(mm, info_buffer_size);
+}
+
+//
+// Image acquisition
+//
+
+// MM_SnapImage acquires an image with the current camera. The simulated exposure finishes immediately.
+DllExport MM_Status MM_SnapImage(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK && s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    }
+    if (status == MM_ErrOK) {
+        free(s->snapped);
+        s->snapped = render(s, cam);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_GetImage returns the image acquired by the last MM_SnapImage.
+// The buffer is owned by the session and is valid until the next MM_SnapImage.
+DllExport MM_Status MM_GetImage(MM_Session mm, uint8_t **ptr_buffer) {
+    return MM_GetImageOfChannel(mm, 0, ptr_buffer);
+}
+
+DllExport MM_Status MM_GetImageOfChannel(MM_Session mm, uint16_t channel, uint8_t **ptr_buffer) {
+    stub_session *s = get_session(mm);
+    stub_device *cam;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_camera(s, &cam);
+    if (status == MM_ErrOK && (s->snapped == NULL || channel != 0)) {
+        status = MM_ErrCameraBufferReadFailed;
+    }
+    *ptr_buffer = status == MM_ErrOK ? s->snapped : NULL;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Image sequence acquisition
+//
+
+DllExport MM_Status MM_StartSequenceAcquisition_v2(MM_Session mm,
+                                                   int32_t num_images,
+                                                   double interval_ms,
+                                                   uint8_t stop_on_overflow) {
+    if (num_images <= 0) {
+        return MM_ErrInvalidImageSequence;
+    }
+    return start_sequence(mm, num_images, interval_ms, stop_on_overflow);
+}
+
+DllExport MM_Status MM_StartSequenceAcquisition(MM_Session mm,
+                                                int16_t num_images,
+                                                double interval_ms,
+                                                uint8_t stop_on_overflow) {
+    return MM_StartSequenceAcquisition_v2(mm, num_images, interval_ms,
+                                          stop_on_overflow);
+}
+
+DllExport MM_Status MM_StartContinuousSequenceAcquisition(MM_Session mm,
+                                                          double interval_ms) {
+    return start_sequence(mm, 0, interval_ms, 0);
+}
+
+DllExport MM_Status MM_StopSequenceAcquisition(MM_Session mm) {
+    join_sequence(get_session(mm));
+    return MM_ErrOK;
+}
+
+DllExport void MM_IsSequenceRunning(MM_Session mm, uint8_t *status) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *status = s->seq_running;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+//
+// Image circular buffer
+//
+
+// MM_GetLastImage returns the last image inserted into the circular buffer.
+// The buffer is owned by the session and is valid until the next image is inserted.
+DllExport MM_Status MM_GetLastImage(MM_Session mm, uint8_t **ptr_buffer) {
+    MM_Metadata md;
+    MM_Status status = MM_GetLastImageMD(mm, ptr_buffer, &md);
+    MM_MetadataFree(&md);
+    return status;
+}
+
+DllExport MM_Status MM_GetLastImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    *ptr_buffer = s->last_image;
+    metadata_copy(md, &s->last_md);
+    if (s->last_image == NULL) {
+        status = MM_ErrCircularBufferEmpty;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_PopNextImage removes the oldest image from the circular buffer.
+// The buffer is owned by the session and is valid until the next MM_PopNextImage.
+DllExport MM_Status MM_PopNextImage(MM_Session mm, uint8_t **ptr_buffer) {
+    MM_Metadata md;
+    MM_Status status = MM_PopNextImageMD(mm, ptr_buffer, &md);
+    MM_MetadataFree(&md);
+    return status;
+}
+
+DllExport MM_Status MM_PopNextImageMD(MM_Session mm, uint8_t **ptr_buffer,
+                                      MM_Metadata *md) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    md->tags = NULL;
+    md->len_tags = 0;
+    pthread_mutex_lock(&s->mutex);
+    stub_image *img = s->buffer_head;
+    if (img == NULL) {
+        *ptr_buffer = NULL;
+        status = MM_ErrCircularBufferEmpty;
+    } else {
+        s->buffer_head = img->next;
+        if (s->buffer_head == NULL) {
+            s->buffer_tail = NULL;
+        }
+        s->buffer_count--;
+
+        free(s->popped_image);
+        s->popped_image = img->data;
+        *md = img->md;
+        free(img);
+        *ptr_buffer = s->popped_image;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport void MM_MetadataFree(MM_Metadata *md) {
+    for (size_t i = 0; i < md->len_tags; i++) {
+        free(md->tags[i].key);
+        free(md->tags[i].value);
+    }
+    free(md->tags);
+    md->tags = NULL;
+    md->len_tags = 0;
+}
+
+DllExport void MM_GetRemainingImageCount_v2(MM_Session mm, int64_t *count) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *count = (int64_t)s->buffer_count;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MM_GetBufferTotalCapacity_v2(MM_Session mm, int64_t *capacity) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *capacity = (int64_t)current_capacity(s);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MM_GetBufferFreeCapacity_v2(MM_Session mm, int64_t *capacity) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *capacity = (int64_t)current_capacity(s) - (int64_t)s->buffer_count;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+// saturate_int16 converts a count for the version 1 functions.
+static int16_t saturate_int16(int64_t n) {
+    if (n > INT16_MAX) {
+        return INT16_MAX;
+    }
+    if (n < INT16_MIN) {
+        return INT16_MIN;
+    }
+    return (int16_t)n;
+}
+
+DllExport void MM_GetRemainingImageCount(MM_Session mm, int16_t *count) {
+    int64_t n;
+    MM_GetRemainingImageCount_v2(mm, &n);
+    *count = saturate_int16(n);
+}
+
+DllExport void MM_GetBufferTotalCapacity(MM_Session mm, int16_t *capacity) {
+    int64_t n;
+    MM_GetBufferTotalCapacity_v2(mm, &n);
+    *capacity = saturate_int16(n);
+}
+
+DllExport void MM_GetBufferFreeCapacity(MM_Session mm, int16_t *capacity) {
+    int64_t n;
+    MM_GetBufferFreeCapacity_v2(mm, &n);
+    *capacity = saturate_int16(n);
+}
+
+DllExport void MM_IsBufferOverflowed(MM_Session mm, uint8_t *overflowed) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *overflowed = s->overflowed;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport MM_Status MM_SetCircularBufferMemoryFootprint(MM_Session mm,
+                                                        uint32_t size_MB) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    if (s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    } else if (size_MB == 0) {
+        status = MM_ErrCircularBufferFailedToInitialize;
+    } else {
+        s->buffer_MB = size_MB;
+        clear_buffer(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport void MM_GetCircularBufferMemoryFootprint(MM_Session mm,
+                                                   uint32_t *size_MB) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *size_MB = s->buffer_MB;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport MM_Status MM_InitializeCircularBuffer(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    MM_Status status = MM_ErrOK;
+
+    pthread_mutex_lock(&s->mutex);
+    if (s->seq_running) {
+        status = MM_ErrNotAllowedDuringSequenceAcquisition;
+    } else {
+        clear_buffer(s);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_ClearCircularBuffer(MM_Session mm) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    clear_buffer(s);
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+//
+// Shutter control
+//
+
+static MM_Status get_shutter(stub_session *s, const char *label, stub_device **d) {
+    return get_device_of_type(s, label, s->shutter, MM_ShutterDevice,
+                              MM_ErrNoDevice, d);
+}
+
+DllExport MM_Status MM_SetShutterOpen(MM_Session mm, const char *label,
+                                  uint8_t is_open) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_shutter(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->open = is_open != 0;
+        post_property_changed(s, d->label, "State", d->open ? "1" : "0");
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetShutterOpen(MM_Session mm, const char *label,
+                                  uint8_t *is_open) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_shutter(s, label, &d);
+    *is_open = status == MM_ErrOK ? d->open : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Autofocus control
+//
+
+static MM_Status get_auto_focus(stub_session *s, MM_Status err_no_device) {
+    stub_device *d;
+    return get_device_of_type(s, NULL, s->auto_focus, MM_AutoFocusDevice,
+                              err_no_device, &d);
+}
+
+static MM_Status get_stage(stub_session *s, const char *label, stub_device **d) {
+    return get_device_of_type(s, label, s->focus, MM_StageDevice,
+                              MM_ErrNoDevice, d);
+}
+
+DllExport void MM_GetLastFocusScore(MM_Session mm, double *score) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    *score = s->focus_score;
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MM_GetCurrentFocusScore(MM_Session mm, double *score) {
+    MM_GetLastFocusScore(mm, score);
+}
+
+static MM_Status enable_continuous_focus(MM_Session mm, uint8_t enable) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_auto_focus(s, MM_ErrContFocusNotAvailable);
+    if (status == MM_ErrOK) {
+        s->continuous_focus = enable;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_EnableContinuousFocus(MM_Session mm) {
+    return enable_continuous_focus(mm, 1);
+}
+
+DllExport MM_Status MM_DisableContinuousFocus(MM_Session mm) {
+    return enable_continuous_focus(mm, 0);
+}
+
+DllExport MM_Status MM_IsContinuousFocusEnabled(MM_Session mm, uint8_t *enabled) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_auto_focus(s, MM_ErrContFocusNotAvailable);
+    *enabled = status == MM_ErrOK ? s->continuous_focus : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_IsContinuousFocusLocked reports that continuous focus is locked whenever it is enabled.
+DllExport MM_Status MM_IsContinuousFocusLocked(MM_Session mm, uint8_t *locked) {
+    return MM_IsContinuousFocusEnabled(mm, locked);
+}
+
+DllExport MM_Status MM_IsContinuousFocusDrive(
+    MM_Session mm, const char *label, uint8_t *is_continuous_focus_drive) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *is_continuous_focus_drive = 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_FullFocus moves the current focus device to the focal plane, which is at the autofocus offset.
+DllExport MM_Status MM_FullFocus(MM_Session mm) {
+    stub_session *s = get_session(mm);
+    stub_device *stage;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_auto_focus(s, MM_ErrAutoFocusNotAvailable);
+    if (status == MM_ErrOK) {
+        if (get_stage(s, NULL, &stage) == MM_ErrOK) {
+            stage->z = s->auto_focus_offset + stage->z_origin;
+            post_stage_position(s, stage);
+        }
+        s->focus_score = 1;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_IncrementalFocus(MM_Session mm) {
+    return MM_FullFocus(mm);
+}
+
+DllExport MM_Status MM_SetAutoFocusOffset(MM_Session mm, double offset) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_auto_focus(s, MM_ErrAutoFocusNotAvailable);
+    if (status == MM_ErrOK) {
+        s->auto_focus_offset = offset;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetAutoFocusOffset(MM_Session mm, double *offset) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_auto_focus(s, MM_ErrAutoFocusNotAvailable);
+    *offset = status == MM_ErrOK ? s->auto_focus_offset : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// State device control
+//
+
+static MM_Status get_state_device(stub_session *s, const char *label, stub_device **d) {
+    return get_device_of_type(s, label, NULL, MM_StateDevice,
+                              MM_ErrInvalidLabel, d);
+}
+
+static MM_Status set_state(stub_session *s, stub_device *d, int32_t state) {
+    char buf[32];
+
+    if (state < 0 || state >= d->n_states) {
+        return MM_ErrDEVICE_GENERIC;
+    }
+    d->state = state;
+    snprintf(buf, sizeof(buf), "%d", (int)state);
+    post_property_changed(s, d->label, "State", buf);
+    post_property_changed(s, d->label, "Label", d->state_labels[state]);
+    return MM_ErrOK;
+}
+
+static int32_t find_state_label(stub_device *d, const char *state_label) {
+    for (int32_t i = 0; i < d->n_states; i++) {
+        if (strcmp(d->state_labels[i], state_label) == 0) {
+            return i;
+        }
+    }
+    return -1;
+}
+
+DllExport MM_Status MM_SetState(MM_Session mm, const char *label,
+                                int32_t state) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    if (status == MM_ErrOK) {
+        status = set_state(s, d, state);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetState(MM_Session mm, const char *label,
+                                int32_t *state) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    *state = status == MM_ErrOK ? d->state : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetNumberOfStates(MM_Session mm, const char *label,
+                                         int32_t *state) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    *state = status == MM_ErrOK ? d->n_states : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetStateLabel(MM_Session mm, const char *label,
+                                     const char *state_label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    if (status == MM_ErrOK) {
+        int32_t state = find_state_label(d, state_label);
+        status = state < 0 ? MM_ErrDEVICE_GENERIC : set_state(s, d, state);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetStateLabel(MM_Session mm, const char *label,
+                                     char **state_label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    *state_label = stub_strdup(status == MM_ErrOK ? d->state_labels[d->state] : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_DefineStateLabel gives a label to the state. A label can only be used for one state.
+DllExport MM_Status MM_DefineStateLabel(MM_Session mm, const char *label,
+                                        int32_t state, const char *state_label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    if (status == MM_ErrOK) {
+        int32_t existing = find_state_label(d, state_label);
+        if (state < 0 || state >= d->n_states || *state_label == '\0' ||
+            (existing >= 0 && existing != state)) {
+            status = MM_ErrDEVICE_GENERIC;
+        } else {
+            set_string(&d->state_labels[state], state_label);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetStateLabels(MM_Session mm, const char *label,
+                                      char ***state_labels) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    *state_labels = string_list_copy(status == MM_ErrOK ? d->state_labels : NULL, (size_t)-1);
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetStateFromLabel(MM_Session mm, const char *label,
+                                         const char *state_label,
+                                         int32_t *state) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_state_device(s, label, &d);
+    *state = 0;
+    if (status == MM_ErrOK) {
+        int32_t i = find_state_label(d, state_label);
+        if (i < 0) {
+            status = MM_ErrDEVICE_GENERIC;
+        } else {
+            *state = i;
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Focus (Z) stage control
+//
+
+DllExport MM_Status MM_SetPosition(MM_Session mm, const char *label,
+                                   double position) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->z = position + d->z_origin;
+        post_stage_position(s, d);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetPosition(MM_Session mm, const char *label,
+                                   double *position) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    *position = status == MM_ErrOK ? d->z - d->z_origin : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetRelativePosition(MM_Session mm, const char *label,
+                                           double delta) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->z += delta;
+        post_stage_position(s, d);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_SetOrigin makes the current position the origin (zero) of the stage.
+DllExport MM_Status MM_SetOrigin(MM_Session mm, const char *label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->z_origin = d->z;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_SetAdapterOrigin sets the current position of the stage to new_z_um.
+DllExport MM_Status MM_SetAdapterOrigin(MM_Session mm, const char *label,
+                                        double new_z_um) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->z_origin = d->z - new_z_um;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport void MM_SetFocusDirection(MM_Session mm, const char *label,
+                                    int8_t sign) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    if (get_stage(s, label, &d) == MM_ErrOK) {
+        d->focus_direction = (int8_t)((sign > 0) - (sign < 0));
+    }
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport MM_Status MM_GetFocusDirection(MM_Session mm, const char *label,
+                                         int8_t *sign) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_stage(s, label, &d);
+    *sign = status == MM_ErrOK ? d->focus_direction : 0;
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// XY stage control
+//
+
+static MM_Status get_xy_stage(stub_session *s, const char *label, stub_device **d) {
+    return get_device_of_type(s, label, s->xy_stage, MM_XYStageDevice,
+                              MM_ErrNoDevice, d);
+}
+
+// move_xy_stage moves the stage to the position in device coordinates,
+// rounded to the 0.015 um step size of the stage.
+static void move_xy_stage(stub_session *s, stub_device *d, double x, double y) {
+    d->x = round(x / STUB_XY_STEP_SIZE) * STUB_XY_STEP_SIZE;
+    d->y = round(y / STUB_XY_STEP_SIZE) * STUB_XY_STEP_SIZE;
+    post_xy_stage_position(s, d);
+}
+
+DllExport MM_Status MM_SetXYPosition(MM_Session mm, const char *label, double x,
+                                     double y) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        move_xy_stage(s, d, x + d->x_origin, y + d->y_origin);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetRelativeXYPosition(MM_Session mm, const char *label,
+                                             double dx, double dy) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        move_xy_stage(s, d, d->x + dx, d->y + dy);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetXYPosition(MM_Session mm, const char *label,
+                                     double *x, double *y) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        *x = d->x - d->x_origin;
+        *y = d->y - d->y_origin;
+    } else {
+        *x = *y = 0;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetXPosition(MM_Session mm, const char *label,
+                                    double *x) {
+    double y;
+    return MM_GetXYPosition(mm, label, x, &y);
+}
+
+DllExport MM_Status MM_GetYPosition(MM_Session mm, const char *label,
+                                    double *y) {
+    double x;
+    return MM_GetXYPosition(mm, label, &x, y);
+}
+
+// MM_Stop only checks the label, because simulated moves finish immediately.
+DllExport MM_Status MM_Stop(MM_Session mm, const char *label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+// MM_Home moves the XY stage to the home position at (0, 0) in device coordinates.
+DllExport MM_Status MM_Home(MM_Session mm, const char *label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        move_xy_stage(s, d, 0, 0);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+static MM_Status set_origin_xy(MM_Session mm, const char *label, int set_x, int set_y) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        if (set_x) {
+            d->x_origin = d->x;
+        }
+        if (set_y) {
+            d->y_origin = d->y;
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_SetOriginXY(MM_Session mm, const char *label) {
+    return set_origin_xy(mm, label, 1, 1);
+}
+
+DllExport MM_Status MM_SetOriginX(MM_Session mm, const char *label) {
+    return set_origin_xy(mm, label, 1, 0);
+}
+
+DllExport MM_Status MM_SetOriginY(MM_Session mm, const char *label) {
+    return set_origin_xy(mm, label, 0, 1);
+}
+
+// MM_SetAdpaterOriginXY sets the current position of the XY stage to (new_x_um, new_y_um).
+DllExport MM_Status MM_SetAdpaterOriginXY(MM_Session mm, const char *label,
+                                          double new_x_um, double new_y_um) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_xy_stage(s, label, &d);
+    if (status == MM_ErrOK) {
+        d->x_origin = d->x - new_x_um;
+        d->y_origin = d->y - new_y_um;
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+//
+// Hub and peripheral devices
+//
+
+DllExport MM_Status MM_SetParentLabel(MM_Session mm, const char *label,
+                                      const char *parent_label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status == MM_ErrOK) {
+        set_string(&d->parent, parent_label);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetParentLabel(MM_Session mm, const char *label,
+                                      char **parent_label) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    *parent_label = stub_strdup(status == MM_ErrOK ? d->parent : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+static MM_Status get_hub(stub_session *s, const char *hub_label, stub_device **d) {
+    return get_device_of_type(s, hub_label, NULL, MM_HubDevice,
+                              MM_ErrInvalidLabel, d);
+}
+
+DllExport MM_Status MM_GetInstalledDevices(MM_Session mm, const char *hub_label,
+                                           char ***names) {
+    stub_session *s = get_session(mm);
+    stub_device *hub;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_hub(s, hub_label, &hub);
+    *names = string_list_copy(status == MM_ErrOK ? hub->installed : NULL, (size_t)-1);
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetInstalledDeviceDescription(MM_Session mm,
+                                                     const char *hub_label,
+                                                     const char *name,
+                                                     char **descriptions) {
+    stub_session *s = get_session(mm);
+    stub_device *hub;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_hub(s, hub_label, &hub);
+    if (status == MM_ErrOK && !string_list_contains(hub->installed, name)) {
+        status = MM_ErrDEVICE_GENERIC;
+    }
+    *descriptions = stub_strdup(status == MM_ErrOK ? "N/A" : "");
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
+
+DllExport MM_Status MM_GetLoadedPeripheralDevices(MM_Session mm,
+                                                  const char *hub_label,
+                                                  char ***labels) {
+    stub_session *s = get_session(mm);
+    size_t n = 0;
+
+    pthread_mutex_lock(&s->mutex);
+    *labels = (char **)calloc(s->n_devices + 1, sizeof(char *));
+    for (size_t i = 0; i < s->n_devices; i++) {
+        if (strcmp(s->devices[i]->parent, hub_label) == 0) {
+            (*labels)[n++] = stub_strdup(s->devices[i]->label);
+        }
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return MM_ErrOK;
+}
+
+//
+// Miscellaneous
+//
+
+DllExport void MM_GetUserId(MM_Session mm, char **userid) {
+    (void)mm;
+    const char *user = getenv("USER");
+    if (user == NULL || *user == '\0') {
+        user = getenv("USERNAME");
+    }
+    *userid = stub_strdup(user);
+}
+
+DllExport void MM_GetHostName(MM_Session mm, char **hostname) {
+    (void)mm;
+#ifdef _WIN32
+    *hostname = stub_strdup(getenv("COMPUTERNAME"));
+#else
+    char buf[256] = {0};
+    if (gethostname(buf, sizeof(buf) - 1) != 0) {
+        buf[0] = '\0';
+    }
+    *hostname = stub_strdup(buf);
+#endif
+}
+
+DllExport void MM_GetMACAddresses(MM_Session mm, char ***addresses) {
+    (void)mm;
+    *addresses = string_list_copy(NULL, 0);
+}
+
+//
+// Stub events
+//
+
+DllExport void MMStub_PostConfigGroupChanged(MM_Session mm, const char *group_name,
+                                             const char *config_name) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    post_event(s, STUB_CONFIG_GROUP_CHANGED, group_name, config_name, NULL, 0, 0);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MMStub_PostSystemConfigurationLoaded(MM_Session mm) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    post_event(s, STUB_SYSTEM_CONFIGURATION_LOADED, NULL, NULL, NULL, 0, 0);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MMStub_PostPixelSizeChanged(MM_Session mm, double pixel_size_um) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    post_event(s, STUB_PIXEL_SIZE_CHANGED, NULL, NULL, NULL, pixel_size_um, 0);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+DllExport void MMStub_PostSLMExposureChanged(MM_Session mm, const char *label,
+                                             double exposure_ms) {
+    stub_session *s = get_session(mm);
+
+    pthread_mutex_lock(&s->mutex);
+    post_event(s, STUB_SLM_EXPOSURE_CHANGED, label, NULL, NULL, exposure_ms, 0);
+    pthread_mutex_unlock(&s->mutex);
+}
+
+//
+// Stub device state
+//
+
+DllExport MM_Status MMStub_SetDeviceBusy(MM_Session mm, const char *label,
+                                         double busy_ms) {
+    stub_session *s = get_session(mm);
+    stub_device *d;
+
+    pthread_mutex_lock(&s->mutex);
+    MM_Status status = get_device(s, label, &d);
+    if (status == MM_ErrOK) {
+        clock_gettime(CLOCK_MONOTONIC, &d->busy_until);
+        add_ms(&d->busy_until, busy_ms);
+    }
+    pthread_mutex_unlock(&s->mutex);
+    return status;
+}
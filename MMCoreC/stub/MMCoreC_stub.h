@@ -0,0 +1,30 @@
+#ifndef MMCOREC_STUB_H_
+#define MMCOREC_STUB_H_
+
+#include "MMCoreC.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// Functions only available in the stub.
+//
+// The stub devices cannot trigger some of the events of MM_EventCallback.
+// These functions post such events to the callback thread of the session,
+// so that their delivery can be tested.
+DllExport void MMStub_PostConfigGroupChanged(MM_Session mm, const char *group_name,
+                                             const char *config_name);
+DllExport void MMStub_PostSystemConfigurationLoaded(MM_Session mm);
+DllExport void MMStub_PostPixelSizeChanged(MM_Session mm, double pixel_size_um);
+DllExport void MMStub_PostSLMExposureChanged(MM_Session mm, const char *label,
+                                             double exposure_ms);
+
+// MMStub_SetDeviceBusy makes MM_DeviceBusy report the device as busy for busy_ms.
+DllExport MM_Status MMStub_SetDeviceBusy(MM_Session mm, const char *label,
+                                         double busy_ms);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
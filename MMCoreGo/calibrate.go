@@ -0,0 +1,438 @@
+package mmcore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// CalibrationOptions configures CalibratePixelSize.
+type CalibrationOptions struct {
+	Camera  string // camera to calibrate, or "" for the current camera
+	XYStage string // XY stage to move, or "" for the current XY stage
+
+	// Moves are the relative stage moves in um. Each move starts from the starting
+	// position of the stage, which is restored after the image is taken.
+	// If Moves is empty, the stage moves by StepUm along x, y and the diagonals.
+	Moves [][2]float64
+
+	// StepUm is the length of the default moves. If it is 0, the moves shift the
+	// image by an eighth of its size according to the current pixel size.
+	StepUm float64
+}
+
+// CalibrationMeasurement is the image shift measured for one stage move.
+type CalibrationMeasurement struct {
+	StageDX, StageDY     float64 // stage move in um
+	PixelDX, PixelDY     float64 // shift of the image in pixels
+	ResidualX, ResidualY float64 // error of the fitted transform in um
+}
+
+// PixelCalibration is the result of CalibratePixelSize.
+type PixelCalibration struct {
+	// Affine maps pixel displacements to stage displacements, as in PixelTransform,
+	// for the binning of the camera during the calibration. Its translation,
+	// Affine[2] and Affine[5], is 0.
+	Affine       [6]float64
+	PixelSizeUm  float64 // square root of the determinant of Affine
+	Binning      int
+	Measurements []CalibrationMeasurement
+	RMSResidual  float64 // in um
+}
+
+// CalibrationError is returned by CalibratePixelSize when an image shift cannot be
+// measured, or when the moves do not determine the transform.
+type CalibrationError struct {
+	StageDX, StageDY float64 // the move, if the error is about a move
+	Reason           string
+}
+
+func (e *CalibrationError) Error() string {
+	if e.StageDX != 0 || e.StageDY != 0 {
+		return fmt.Sprintf("pixel calibration failed for the move (%g, %g) um: %s", e.StageDX, e.StageDY, e.Reason)
+	}
+	return "pixel calibration failed: " + e.Reason
+}
+
+// CalibratePixelSize measures the affine transform between the pixels of a camera and
+// an XY stage. It snaps an image, and for each move it moves the stage with
+// SetRelativeXYPosition, snaps again and measures the shift of the image from the
+// first one by cross-correlation. The linear part of the transform is fitted to the
+// shifts by least squares; a displacement has no translation, so it is 0.
+//
+// Only the central window of the images is correlated, binned so that it is at most
+// 512 pixels square. The sample must have enough structure to correlate at that
+// binning, and each move must shift the image by less than half of its shorter
+// side. The stage is returned to its starting position,
+// and the current camera is restored if opts.Camera is another camera.
+func CalibratePixelSize(ctx context.Context, c Core, opts CalibrationOptions) (result *PixelCalibration, err error) {
+	camera := c.CameraDevice()
+	if opts.Camera != "" && opts.Camera != camera {
+		if err := c.SetCameraDevice(opts.Camera); err != nil {
+			return nil, err
+		}
+		defer func(label string) {
+			if restore_err := c.SetCameraDevice(label); err == nil {
+				err = restore_err
+			}
+		}(camera)
+		camera = opts.Camera
+	}
+	if camera == "" {
+		return nil, ErrCameraNotAvailable
+	}
+	stage := opts.XYStage
+	if stage == "" {
+		stage = c.XYStageDevice()
+	}
+	if stage == "" {
+		return nil, ErrInvalidXYStageDevice
+	}
+
+	result = &PixelCalibration{Binning: 1}
+	if has, _ := c.HasProperty(camera, "Binning"); has {
+		if result.Binning, err = c.GetPropertyInt(camera, "Binning"); err != nil {
+			return nil, err
+		}
+	}
+	moves := opts.Moves
+	if len(moves) == 0 {
+		step := opts.StepUm
+		if step == 0 {
+			pixel_size_um, _ := c.GetPixelSizeUm()
+			step = pixel_size_um * float64(minInt(c.ImageWidth(), c.ImageHeight())) / 8
+		}
+		if step == 0 {
+			return nil, &CalibrationError{Reason: "no step size and no current pixel size"}
+		}
+		moves = [][2]float64{{step, 0}, {0, step}, {-step, 0}, {0, -step},
+			{step, step}, {-step, step}, {-step, -step}, {step, -step}}
+	}
+	if !spanPlane(moves) {
+		return nil, &CalibrationError{Reason: "the moves do not determine the transform"}
+	}
+
+	ref, err := snapCorrelationImage(c)
+	if err != nil {
+		return nil, err
+	}
+	x0, y0, err := c.GetXYPosition(stage)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if restore_err := c.SetXYPosition(stage, x0, y0); err == nil {
+			err = restore_err
+		}
+	}()
+
+	for _, move := range moves {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := c.SetRelativeXYPosition(stage, move[0], move[1]); err != nil {
+			return nil, err
+		}
+		if err := c.WaitForDevice(ctx, stage); err != nil {
+			return nil, err
+		}
+		img, err := snapCorrelationImage(c)
+		if err != nil {
+			return nil, err
+		}
+		dx, dy, reason := ref.shift(img)
+		if reason != "" {
+			return nil, &CalibrationError{move[0], move[1], reason}
+		}
+		result.Measurements = append(result.Measurements, CalibrationMeasurement{
+			StageDX: move[0], StageDY: move[1], PixelDX: dx, PixelDY: dy,
+		})
+		if err := c.SetXYPosition(stage, x0, y0); err != nil {
+			return nil, err
+		}
+		if err := c.WaitForDevice(ctx, stage); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := result.fit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Apply sets the pixel size and the affine transform of a pixel size configuration
+// to the calibration, corrected for the binning of the calibration.
+func (p *PixelCalibration) Apply(c PixelSizeConfigs, resolution_id string) error {
+	binning := float64(p.Binning)
+	if binning < 1 {
+		binning = 1
+	}
+	a := p.Affine
+	affine := []float64{a[0] / binning, a[1] / binning, 0, a[3] / binning, a[4] / binning, 0}
+	if err := c.SetPixelSizeUm(resolution_id, p.PixelSizeUm/binning); err != nil {
+		return err
+	}
+	return c.SetPixelSizeAffine(resolution_id, affine)
+}
+
+// fit fits the linear part of Affine to the measurements, and sets the residuals.
+//
+// The image of a point of the sample shifts by -A⁻¹ of the stage move, so each move
+// gives A·(pixel shift) = -(stage move), where A is the 2x2 linear part. A stage move
+// of 0 does not shift the image, so the translation is 0.
+func (p *PixelCalibration) fit() error {
+	var m [2][2]float64
+	var bx, by [2]float64
+	for _, meas := range p.Measurements {
+		u := [2]float64{meas.PixelDX, meas.PixelDY}
+		for i := range u {
+			for j := range u {
+				m[i][j] += u[i] * u[j]
+			}
+			bx[i] -= u[i] * meas.StageDX
+			by[i] -= u[i] * meas.StageDY
+		}
+	}
+	ax, ok_x := solve2(m, bx)
+	ay, ok_y := solve2(m, by)
+	if !ok_x || !ok_y {
+		return &CalibrationError{Reason: "the moves do not determine the transform"}
+	}
+	p.Affine = [6]float64{ax[0], ax[1], 0, ay[0], ay[1], 0}
+	p.PixelSizeUm = math.Sqrt(math.Abs(ax[0]*ay[1] - ax[1]*ay[0]))
+
+	var sum float64
+	for i := range p.Measurements {
+		meas := &p.Measurements[i]
+		meas.ResidualX = ax[0]*meas.PixelDX + ax[1]*meas.PixelDY + meas.StageDX
+		meas.ResidualY = ay[0]*meas.PixelDX + ay[1]*meas.PixelDY + meas.StageDY
+		sum += meas.ResidualX*meas.ResidualX + meas.ResidualY*meas.ResidualY
+	}
+	p.RMSResidual = math.Sqrt(sum / float64(len(p.Measurements)))
+	return nil
+}
+
+// spanPlane reports whether the moves are not all along one direction, which the fit
+// of a linear transform needs.
+func spanPlane(moves [][2]float64) bool {
+	var size float64
+	for _, m := range moves {
+		size = math.Max(size, math.Hypot(m[0], m[1]))
+	}
+	for _, m := range moves {
+		for _, n := range moves {
+			if area := m[0]*n[1] - m[1]*n[0]; math.Abs(area) > 1e-6*size*size {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// solve2 solves m·x = b by Cramer's rule.
+func solve2(m [2][2]float64, b [2]float64) (x [2]float64, ok bool) {
+	det := m[0][0]*m[1][1] - m[0][1]*m[1][0]
+	scale := math.Max(math.Abs(m[0][0]), math.Abs(m[1][1]))
+	if math.Abs(det) <= 1e-12*scale*scale {
+		return x, false
+	}
+	x[0] = (b[0]*m[1][1] - m[0][1]*b[1]) / det
+	x[1] = (m[0][0]*b[1] - m[1][0]*b[0]) / det
+	return x, true
+}
+
+// minCorrelation is the correlation coefficient of the overlap of two images
+// below which they are considered not to match.
+const minCorrelation = 0.5
+
+// correlationWindow is the largest size of the window of an image that is
+// cross-correlated. Larger images are binned by powers of 2 until they fit, so that
+// the window covers every shift of less than half the image, and the three padded
+// spectra of a calibration step take at most 3 x 1024² complex128, 48 MB.
+const correlationWindow = 512
+
+// correlationImage is the central window of an image prepared for cross-correlation,
+// binned by bin: the mean is subtracted, and the window is padded with zeros to
+// twice its size so that the correlation does not wrap.
+type correlationImage struct {
+	width, height int // size of the window, in binned pixels
+	bin           int
+	n_x, n_y      int // padded size, powers of 2
+	variance      float64
+	spectrum      []complex128
+}
+
+// snapCorrelationImage snaps an image with the current camera and transforms
+// its central window.
+func snapCorrelationImage(c Core) (*correlationImage, error) {
+	if err := c.SnapImage(); err != nil {
+		return nil, err
+	}
+	data, err := c.GetImageData()
+	if err != nil {
+		return nil, err
+	}
+	if data.Width <= 0 || data.Height <= 0 {
+		return nil, ErrCameraBufferReadFailed
+	}
+	bin := 1
+	for minInt(data.Width, data.Height)/bin > correlationWindow {
+		bin *= 2
+	}
+	width, height := minInt(data.Width/bin, correlationWindow), minInt(data.Height/bin, correlationWindow)
+	x0, y0 := (data.Width-width*bin)/2, (data.Height-height*bin)/2
+
+	img := &correlationImage{width: width, height: height, bin: bin, n_x: 1, n_y: 1}
+	for img.n_x < 2*width {
+		img.n_x *= 2
+	}
+	for img.n_y < 2*height {
+		img.n_y *= 2
+	}
+	img.spectrum = make([]complex128, img.n_x*img.n_y)
+	var mean float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var v float64
+			for j := 0; j < bin; j++ {
+				for i := 0; i < bin; i++ {
+					v += float64(data.Value(x0+x*bin+i, y0+y*bin+j))
+				}
+			}
+			img.spectrum[y*img.n_x+x] = complex(v, 0)
+			mean += v
+		}
+	}
+	mean /= float64(width * height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := real(img.spectrum[y*img.n_x+x]) - mean
+			img.spectrum[y*img.n_x+x] = complex(v, 0)
+			img.variance += v * v
+		}
+	}
+	img.variance /= float64(width * height)
+	fft2(img.spectrum, img.n_x, img.n_y, false)
+	return img, nil
+}
+
+// shift returns the shift of other from img, in unbinned pixels, or why it cannot
+// be measured.
+//
+// The shift is the peak of the cross-correlation normalized by the overlap of the
+// images, among shifts of up to half the image, refined by fitting a parabola.
+// The images must correlate with a coefficient of at least minCorrelation at the peak.
+func (img *correlationImage) shift(other *correlationImage) (dx, dy float64, reason string) {
+	if other.width != img.width || other.height != img.height || other.bin != img.bin {
+		return 0, 0, "the image size changed"
+	}
+	n_x, n_y := img.n_x, img.n_y
+	corr := make([]complex128, len(img.spectrum))
+	for i := range corr {
+		corr[i] = cmplx.Conj(img.spectrum[i]) * other.spectrum[i]
+	}
+	fft2(corr, n_x, n_y, true)
+
+	max_x, max_y := img.width/2, img.height/2
+	at := func(sx, sy int) float64 {
+		overlap := float64((img.width - absInt(sx)) * (img.height - absInt(sy)))
+		return real(corr[((sy+n_y)%n_y)*n_x+(sx+n_x)%n_x]) / overlap
+	}
+	best_x, best_y, best := 0, 0, math.Inf(-1)
+	for sy := -max_y; sy <= max_y; sy++ {
+		for sx := -max_x; sx <= max_x; sx++ {
+			if v := at(sx, sy); v > best {
+				best_x, best_y, best = sx, sy, v
+			}
+		}
+	}
+	if img.variance == 0 || other.variance == 0 || best < minCorrelation*math.Sqrt(img.variance*other.variance) {
+		return 0, 0, "the images do not correlate"
+	}
+	if absInt(best_x) == max_x || absInt(best_y) == max_y {
+		return 0, 0, "the image shifted by half its size or more"
+	}
+	dx = float64(best_x) + parabolaPeak(at(best_x-1, best_y), best, at(best_x+1, best_y))
+	dy = float64(best_y) + parabolaPeak(at(best_x, best_y-1), best, at(best_x, best_y+1))
+	return dx * float64(img.bin), dy * float64(img.bin), ""
+}
+
+// parabolaPeak returns the offset from the middle sample of the peak of the parabola
+// through three samples.
+func parabolaPeak(left, middle, right float64) float64 {
+	d := left - 2*middle + right
+	if d >= 0 {
+		return 0
+	}
+	return (left - right) / (2 * d)
+}
+
+// fft2 transforms an n_x by n_y array in place, where n_x and n_y are powers of 2.
+// The inverse transform is scaled by 1/(n_x*n_y).
+func fft2(a []complex128, n_x, n_y int, inverse bool) {
+	for y := 0; y < n_y; y++ {
+		fft(a[y*n_x:(y+1)*n_x], inverse)
+	}
+	col := make([]complex128, n_y)
+	for x := 0; x < n_x; x++ {
+		for y := range col {
+			col[y] = a[y*n_x+x]
+		}
+		fft(col, inverse)
+		for y := range col {
+			a[y*n_x+x] = col[y]
+		}
+	}
+	if inverse {
+		scale := complex(1/float64(n_x*n_y), 0)
+		for i := range a {
+			a[i] *= scale
+		}
+	}
+}
+
+// fft is an iterative radix-2 FFT. len(a) must be a power of 2.
+func fft(a []complex128, inverse bool) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := a[start+k], a[start+k+size/2]*wk
+				a[start+k], a[start+k+size/2] = u+v, u-v
+				wk *= w
+			}
+		}
+	}
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
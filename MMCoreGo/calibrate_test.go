@@ -0,0 +1,163 @@
+package mmcore_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+// stageCamera is a simulated session whose camera images a textured sample under the
+// XY stage, with the pixel to stage transform affine.
+type stageCamera struct {
+	*sim.Session
+	affine [6]float64
+	img    []byte
+}
+
+func (c *stageCamera) SnapImage() error {
+	if err := c.Session.SnapImage(); err != nil {
+		return err
+	}
+	stage_x, stage_y, err := c.GetXYPosition(c.XYStageDevice())
+	if err != nil {
+		return err
+	}
+	a := c.affine
+	w, h := c.ImageWidth(), c.ImageHeight()
+	c.img = make([]byte, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)-float64(w)/2, float64(y)-float64(h)/2
+			c.img[y*w+x] = byte(sampleTexture(stage_x+a[0]*dx+a[1]*dy+a[2], stage_y+a[3]*dx+a[4]*dy+a[5]))
+		}
+	}
+	return nil
+}
+
+func (c *stageCamera) GetImageData() (*mmcore.Image, error) {
+	return mmcore.NewImage(c.img, c.ImageWidth(), c.ImageHeight(), 1, 8, 1)
+}
+
+// sampleTexture is a smooth random texture of values from 0 to 255, with a cell of 2 um.
+func sampleTexture(x, y float64) float64 {
+	value := func(i, j int) float64 {
+		h := uint32(i)*73856093 ^ uint32(j)*19349663
+		h ^= h >> 13
+		h *= 0x5bd1e995
+		h ^= h >> 15
+		return float64(h & 0xff)
+	}
+	fx, fy := math.Floor(x/2), math.Floor(y/2)
+	tx, ty := x/2-fx, y/2-fy
+	i, j := int(fx), int(fy)
+	top := value(i, j)*(1-tx) + value(i+1, j)*tx
+	bottom := value(i, j+1)*(1-tx) + value(i+1, j+1)*tx
+	return top*(1-ty) + bottom*ty
+}
+
+func TestCalibratePixelSize(t *testing.T) {
+	mmc := &stageCamera{Session: sim.NewSession()}
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.LoadDevice("XY", "DemoCamera", "DXYStage"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetProperty("Camera", "Binning", 2),
+		mmc.SetROI(0, 0, 128, 96),
+		mmc.SetXYPosition("XY", 1000, 2000),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{StepUm: 10}); err != mmcore.ErrInvalidXYStageDevice {
+		t.Errorf("without an XY stage: %v", err)
+	}
+	if err := mmc.SetXYStageDevice("XY"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.5 um binned pixels, rotated by 10 degrees.
+	sin, cos := math.Sincos(10 * math.Pi / 180)
+	mmc.affine = [6]float64{0.5 * cos, -0.5 * sin, 0, 0.5 * sin, 0.5 * cos, 0}
+	cal, err := mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{StepUm: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range mmc.affine {
+		if math.Abs(cal.Affine[i]-want) > 0.005 {
+			t.Errorf("Affine: %v, want %v", cal.Affine, mmc.affine)
+			break
+		}
+	}
+	if math.Abs(cal.PixelSizeUm-0.5) > 0.005 || cal.Binning != 2 || len(cal.Measurements) != 8 || cal.RMSResidual > 0.1 {
+		t.Errorf("PixelSizeUm %g, Binning %d, %d measurements, RMSResidual %g", cal.PixelSizeUm, cal.Binning, len(cal.Measurements), cal.RMSResidual)
+	}
+	if x, y, _ := mmc.GetXYPosition("XY"); math.Abs(x-1000) > 0.01 || math.Abs(y-2000) > 0.01 {
+		t.Errorf("stage at %g, %g after the calibration", x, y)
+	}
+
+	// The calibration is stored for unbinned pixels.
+	for _, err := range []error{
+		mmc.DefinePixelSizeConfig("Res", "Camera", "Binning", "2"),
+		cal.Apply(mmc, "Res"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, _ := mmc.GetPixelSizeUmByID("Res")
+	affine, _ := mmc.GetPixelSizeAffine()
+	if size != cal.PixelSizeUm/2 || affine[0] != cal.Affine[0] || affine[4] != cal.Affine[4] || affine[2] != 0 || affine[5] != 0 {
+		t.Errorf("after Apply: %g um, affine %v", size, affine)
+	}
+
+	// Two moves in different directions determine the linear transform.
+	cal, err = mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{Moves: [][2]float64{{10, 0}, {10, 10}}})
+	if err != nil || math.Abs(cal.PixelSizeUm-0.5) > 0.005 || cal.Affine[2] != 0 || cal.Affine[5] != 0 {
+		t.Errorf("two moves: %v, %+v", err, cal)
+	}
+
+	// The default moves follow the current pixel size.
+	cal, err = mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{})
+	if want := cal.PixelSizeUm * 96 / 8; err != nil || math.Abs(cal.Measurements[0].StageDX-want) > 0.01 {
+		t.Errorf("default moves: %v, %+v, want a step of %g", err, cal, want)
+	}
+
+	for _, test := range []struct {
+		moves  [][2]float64
+		reason string
+	}{
+		{[][2]float64{{10, 0}, {0, 10}, {200, 0}}, "the images do not correlate"},
+		{[][2]float64{{10, 0}, {-10, 0}, {5, 0}}, "the moves do not determine the transform"},
+	} {
+		_, err := mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{Moves: test.moves})
+		if cal_err, ok := err.(*mmcore.CalibrationError); !ok || cal_err.Reason != test.reason {
+			t.Errorf("moves %v: error %v, want %q", test.moves, err, test.reason)
+		}
+	}
+
+	// Images larger than the correlation window are binned before correlating.
+	for _, err := range []error{
+		mmc.SetProperty("Camera", "OnCameraCCDXSize", 1280),
+		mmc.SetProperty("Camera", "OnCameraCCDYSize", 1152),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cal, err = mmcore.CalibratePixelSize(context.Background(), mmc, mmcore.CalibrationOptions{Moves: [][2]float64{{40, 0}, {0, 40}}})
+	if err != nil || math.Abs(cal.PixelSizeUm-0.5) > 0.005 || cal.RMSResidual > 0.5 {
+		t.Errorf("640x576 images: %v, %+v", err, cal)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := mmcore.CalibratePixelSize(ctx, mmc, mmcore.CalibrationOptions{StepUm: 10}); err != context.Canceled {
+		t.Errorf("canceled: %v", err)
+	}
+}
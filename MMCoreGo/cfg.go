@@ -0,0 +1,552 @@
+package mmcore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CFGCommand is the first field of a line of a Micro-Manager hardware configuration (.cfg) file.
+type CFGCommand string
+
+const (
+	CFGDevice          CFGCommand = "Device"          // Device,<label>,<module>,<device>
+	CFGProperty        CFGCommand = "Property"        // Property,<label>,<property>,<value>
+	CFGParent          CFGCommand = "Parent"          // Parent,<label>,<hub label>
+	CFGLabel           CFGCommand = "Label"           // Label,<label>,<state>,<state label>
+	CFGDelay           CFGCommand = "Delay"           // Delay,<label>,<delay ms>
+	CFGFocusDirection  CFGCommand = "FocusDirection"  // FocusDirection,<label>,<sign>
+	CFGConfigGroup     CFGCommand = "ConfigGroup"     // ConfigGroup,<group>,<preset>,<label>,<property>,<value>
+	CFGConfig          CFGCommand = "Config"          // Config,<preset>,<label>,<property>,<value>
+	CFGConfigPixelSize CFGCommand = "ConfigPixelSize" // ConfigPixelSize,<resolution>,<label>,<property>,<value>
+	CFGPixelSize       CFGCommand = "PixelSize_um"    // PixelSize_um,<resolution>,<size>
+	CFGPixelSizeAffine CFGCommand = "PixelSizeAffine" // PixelSizeAffine,<resolution>,<a>,<b>,<c>,<d>,<e>,<f>
+	CFGEquipment       CFGCommand = "Equipment"       // obsolete, ignored
+	CFGImageSynchro    CFGCommand = "ImageSynchro"    // obsolete, ignored
+)
+
+// cfgInitializeProperty is the property of the Core device that controls the devices in
+// configuration files: "Property,Core,Initialize,0" unloads all devices,
+// and "Property,Core,Initialize,1" initializes all loaded devices.
+const cfgInitializeProperty = "Initialize"
+
+// The preset that is set after a configuration file is loaded, if defined.
+const (
+	startupGroup  = "System"
+	startupConfig = "Startup"
+)
+
+// cfgArgs is the number of fields after the command, from min to max, for each command.
+var cfgArgs = map[CFGCommand][2]int{
+	CFGDevice:          {3, 3},
+	CFGProperty:        {2, 3},
+	CFGParent:          {2, 2},
+	CFGLabel:           {3, 3},
+	CFGDelay:           {2, 2},
+	CFGFocusDirection:  {2, 2},
+	CFGConfigGroup:     {1, 5},
+	CFGConfig:          {3, 4},
+	CFGConfigPixelSize: {3, 4},
+	CFGPixelSize:       {2, 2},
+	CFGPixelSizeAffine: {7, 7},
+	CFGEquipment:       {0, -1},
+	CFGImageSynchro:    {0, -1},
+}
+
+// CFGLine is a line of a configuration file. Blank lines and comments are kept,
+// with an empty Command, so that a file can be written back as it was read.
+type CFGLine struct {
+	Number  int // line number, starting at 1
+	Command CFGCommand
+	Args    []string // the fields after the command
+	Comment string   // text of a comment line, including the "#"
+}
+
+// String returns the line as written in a configuration file.
+func (l CFGLine) String() string {
+	if l.Command == "" {
+		return l.Comment
+	}
+	return strings.Join(append([]string{string(l.Command)}, l.Args...), ",")
+}
+
+// arg returns the i-th field after the command, or "" if the line is shorter.
+// Trailing empty fields, such as an empty property value, can be left out in a file.
+func (l CFGLine) arg(i int) string {
+	if i < len(l.Args) {
+		return l.Args[i]
+	}
+	return ""
+}
+
+// SystemConfiguration is the content of a Micro-Manager hardware configuration (.cfg) file.
+type SystemConfiguration struct {
+	Lines []CFGLine
+}
+
+// CFGError reports an invalid or failed line of a configuration file.
+//
+// Err is ErrInvalidCFGEntry. For a line that cannot be applied, Cause is the error of
+// the core, so that both errors.Is(err, ErrInvalidCFGEntry) and errors.Is(err, cause)
+// hold.
+type CFGError struct {
+	Line   int
+	Text   string
+	Err    error
+	Reason string // why the line is invalid, if it cannot be parsed
+	Cause  error  // the error of the core, if the line cannot be applied
+}
+
+func (e *CFGError) Error() string {
+	msg := fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return fmt.Sprintf("%s: %q", msg, e.Text)
+}
+
+// Is reports whether target is Err, so that errors.Is finds Err as well as Cause.
+func (e *CFGError) Is(target error) bool {
+	return target == e.Err
+}
+
+// Unwrap returns Cause, or Err if the line could not be parsed.
+func (e *CFGError) Unwrap() error {
+	if e.Cause != nil {
+		return e.Cause
+	}
+	return e.Err
+}
+
+// ParseSystemConfiguration parses a configuration file. Fields are separated by commas
+// and lines starting with "#" are comments. It returns a *CFGError for the first
+// invalid line, such as an unknown command or a wrong number of fields.
+func ParseSystemConfiguration(r io.Reader) (*SystemConfiguration, error) {
+	var cfg SystemConfiguration
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		line := CFGLine{Number: number}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			line.Comment = text
+		} else {
+			fields := strings.Split(text, ",")
+			line.Command = CFGCommand(fields[0])
+			line.Args = fields[1:]
+			if reason := checkCFGLine(line); reason != "" {
+				return nil, &CFGError{Line: number, Text: text, Err: ErrInvalidCFGEntry, Reason: reason}
+			}
+		}
+		cfg.Lines = append(cfg.Lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ReadSystemConfiguration reads and parses a configuration file.
+func ReadSystemConfiguration(path string) (*SystemConfiguration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSystemConfiguration(f)
+}
+
+// WriteTo writes the configuration file, one line per CFGLine.
+func (cfg *SystemConfiguration) WriteTo(w io.Writer) (n int64, err error) {
+	var b strings.Builder
+	for _, line := range cfg.Lines {
+		b.WriteString(line.String())
+		b.WriteByte('\n')
+	}
+	m, err := io.WriteString(w, b.String())
+	return int64(m), err
+}
+
+// WriteSystemConfiguration writes a configuration file.
+func WriteSystemConfiguration(path string, cfg *SystemConfiguration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// add appends a line with the command and its fields.
+func (cfg *SystemConfiguration) add(command CFGCommand, args ...string) {
+	cfg.Lines = append(cfg.Lines, CFGLine{Number: len(cfg.Lines) + 1, Command: command, Args: args})
+}
+
+// comment appends a comment line, or a blank line if text is empty.
+func (cfg *SystemConfiguration) comment(text string) {
+	if text != "" {
+		text = "# " + text
+	}
+	cfg.Lines = append(cfg.Lines, CFGLine{Number: len(cfg.Lines) + 1, Comment: text})
+}
+
+// checkCFGLine returns why the line is invalid, or "" if it is valid.
+func checkCFGLine(line CFGLine) string {
+	n, ok := cfgArgs[line.Command]
+	if !ok {
+		return fmt.Sprintf("unknown command %q", line.Command)
+	}
+	if len(line.Args) < n[0] || (n[1] >= 0 && len(line.Args) > n[1]) {
+		if n[0] == n[1] {
+			return fmt.Sprintf("%s needs %d fields", line.Command, n[0])
+		}
+		return fmt.Sprintf("%s needs %d to %d fields", line.Command, n[0], n[1])
+	}
+
+	isInt := func(s string) bool {
+		_, err := strconv.Atoi(s)
+		return err == nil
+	}
+	isFloat := func(s string) bool {
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	}
+	switch line.Command {
+	case CFGEquipment, CFGImageSynchro:
+		return ""
+	case CFGProperty:
+		if line.arg(0) == coreLabel && line.arg(1) == cfgInitializeProperty && line.arg(2) != "0" && line.arg(2) != "1" {
+			return "the value of Core Initialize must be 0 or 1"
+		}
+	case CFGLabel:
+		if !isInt(line.arg(1)) {
+			return "the state is not an integer"
+		}
+	case CFGDelay:
+		if !isFloat(line.arg(1)) {
+			return "the delay is not a number"
+		}
+	case CFGFocusDirection:
+		if !isInt(line.arg(1)) {
+			return "the sign is not an integer"
+		}
+	case CFGConfigGroup:
+		if len(line.Args) == 3 {
+			return "ConfigGroup needs a property after the device label"
+		}
+	case CFGPixelSize:
+		if !isFloat(line.arg(1)) {
+			return "the pixel size is not a number"
+		}
+	case CFGPixelSizeAffine:
+		for _, s := range line.Args[1:] {
+			if !isFloat(s) {
+				return "the affine transform is not a list of numbers"
+			}
+		}
+	}
+	if line.arg(0) == "" {
+		return "empty name"
+	}
+	return ""
+}
+
+// ApplySystemConfiguration applies a configuration to the core, line by line, as
+// MMCore does when it loads a configuration file: devices are loaded, properties set,
+// hubs, state labels and configuration presets defined, and the devices initialized by
+// "Property,Core,Initialize,1". The current devices are set by the properties of the
+// Core device. At the end, the preset "Startup" of the group "System" is set, if defined.
+//
+// Delays are set with SetDeviceDelayMs. The Config lines of old files are not applied.
+//
+// If a line fails, ApplySystemConfiguration unloads all devices, so that no device is
+// left loaded but not initialized, and returns a *CFGError with Err ErrInvalidCFGEntry
+// and the error of the core as Cause.
+func ApplySystemConfiguration(c Core, cfg *SystemConfiguration) error {
+	for _, line := range cfg.Lines {
+		if err := applyCFGLine(c, line); err != nil {
+			c.UnloadAllDevices()
+			return &CFGError{Line: line.Number, Text: line.String(), Err: ErrInvalidCFGEntry, Cause: err}
+		}
+	}
+
+	startup, err := c.IsConfigDefined(startupGroup, startupConfig)
+	if err == nil && startup {
+		err = c.SetConfig(startupGroup, startupConfig)
+	}
+	if err != nil {
+		c.UnloadAllDevices()
+		return err
+	}
+	return nil
+}
+
+func applyCFGLine(c Core, line CFGLine) error {
+	switch line.Command {
+	case CFGDevice:
+		return c.LoadDevice(line.arg(0), line.arg(1), line.arg(2))
+	case CFGProperty:
+		if line.arg(0) == coreLabel && line.arg(1) == cfgInitializeProperty {
+			if line.arg(2) == "0" {
+				return c.UnloadAllDevices()
+			}
+			return c.InitializeAllDevices()
+		}
+		return c.SetProperty(line.arg(0), line.arg(1), line.arg(2))
+	case CFGParent:
+		return c.SetParentLabel(line.arg(0), line.arg(1))
+	case CFGLabel:
+		state, _ := strconv.Atoi(line.arg(1))
+		return c.DefineStateLabel(line.arg(0), state, line.arg(2))
+	case CFGDelay:
+		delay_ms, _ := strconv.ParseFloat(line.arg(1), 64)
+		return c.SetDeviceDelayMs(line.arg(0), delay_ms)
+	case CFGFocusDirection:
+		sign, _ := strconv.Atoi(line.arg(1))
+		c.SetFocusDirection(line.arg(0), sign)
+	case CFGConfigGroup:
+		switch len(line.Args) {
+		case 1:
+			defined, err := c.IsGroupDefined(line.arg(0))
+			if err != nil || defined {
+				return err
+			}
+			return c.DefineConfigGroup(line.arg(0))
+		case 2:
+			return c.DefineConfig(line.arg(0), line.arg(1))
+		}
+		return c.DefineConfigSetting(line.arg(0), line.arg(1), line.arg(2), line.arg(3), line.arg(4))
+	case CFGConfigPixelSize:
+		return c.DefinePixelSizeConfig(line.arg(0), line.arg(1), line.arg(2), line.arg(3))
+	case CFGPixelSize:
+		size, _ := strconv.ParseFloat(line.arg(1), 64)
+		return c.SetPixelSizeUm(line.arg(0), size)
+	case CFGPixelSizeAffine:
+		affine := make([]float64, 6)
+		for i := range affine {
+			affine[i], _ = strconv.ParseFloat(line.arg(i+1), 64)
+		}
+		return c.SetPixelSizeAffine(line.arg(0), affine)
+	}
+	return nil
+}
+
+// formatCFGFloat formats a number of a configuration file, such as a pixel size.
+func formatCFGFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// TakeSystemConfiguration returns the configuration of the devices loaded in c, in the
+// sections that MMCore writes: the devices, their pre-init properties, the hubs,
+// the delays, the focus directions, the current devices, the state labels, the configuration
+// groups and the pixel size configurations. Other property values are not part of
+// a configuration file.
+func TakeSystemConfiguration(c Core) (*SystemConfiguration, error) {
+	all, err := c.GetLoadedDevices()
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, label := range all {
+		if label != coreLabel {
+			labels = append(labels, label)
+		}
+	}
+
+	cfg := &SystemConfiguration{}
+	cfg.comment("Unload all devices")
+	cfg.add(CFGProperty, coreLabel, cfgInitializeProperty, "0")
+
+	cfg.comment("")
+	cfg.comment("Load devices")
+	types := make(map[string]DeviceType)
+	for _, label := range labels {
+		module_name, err := c.GetDeviceLibrary(label)
+		if err != nil {
+			return nil, err
+		}
+		dev_name, err := c.GetDeviceName(label)
+		if err != nil {
+			return nil, err
+		}
+		if types[label], err = c.GetDeviceType(label); err != nil {
+			return nil, err
+		}
+		cfg.add(CFGDevice, label, module_name, dev_name)
+	}
+
+	cfg.comment("")
+	cfg.comment("Pre-initialization properties")
+	for _, label := range labels {
+		descs, err := c.DescribeDevice(label)
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range descs {
+			if desc.PreInit && !desc.ReadOnly {
+				cfg.add(CFGProperty, label, desc.Name, desc.Value)
+			}
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Hub (parent) references")
+	for _, label := range labels {
+		parent_label, err := c.GetParentLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		if parent_label != "" {
+			cfg.add(CFGParent, label, parent_label)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Initialize")
+	cfg.add(CFGProperty, coreLabel, cfgInitializeProperty, "1")
+
+	// As in HardwareProfile.SystemConfiguration, the section is only written
+	// if a device has a delay.
+	var delays []CFGLine
+	for _, label := range labels {
+		delay_ms, err := c.GetDeviceDelayMs(label)
+		if err != nil {
+			return nil, err
+		}
+		if delay_ms != 0 {
+			delays = append(delays, CFGLine{Command: CFGDelay, Args: []string{label, formatCFGFloat(delay_ms)}})
+		}
+	}
+	if len(delays) > 0 {
+		cfg.comment("")
+		cfg.comment("Delays")
+		for _, line := range delays {
+			cfg.add(line.Command, line.Args...)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Focus directions")
+	for _, label := range labels {
+		if types[label] != StageDeviceType {
+			continue
+		}
+		sign, err := c.GetFocusDirection(label)
+		if err != nil {
+			return nil, err
+		}
+		cfg.add(CFGFocusDirection, label, strconv.Itoa(sign))
+	}
+
+	cfg.comment("")
+	cfg.comment("Roles")
+	for _, role := range []struct{ property, label string }{
+		{"Camera", c.CameraDevice()},
+		{"Shutter", c.ShutterDevice()},
+		{"Focus", c.FocusDevice()},
+		{"XYStage", c.XYStageDevice()},
+		{"AutoFocus", c.AutoFocusDevice()},
+	} {
+		if role.label != "" {
+			cfg.add(CFGProperty, coreLabel, role.property, role.label)
+		}
+	}
+	auto_shutter, err := c.GetProperty(coreLabel, "AutoShutter")
+	if err != nil {
+		return nil, err
+	}
+	cfg.add(CFGProperty, coreLabel, "AutoShutter", auto_shutter)
+
+	cfg.comment("")
+	cfg.comment("Labels")
+	for _, label := range labels {
+		if types[label] != StateDeviceType {
+			continue
+		}
+		state_labels, err := c.GetStateLabels(label)
+		if err != nil {
+			return nil, err
+		}
+		cfg.comment(label)
+		for state, state_label := range state_labels {
+			cfg.add(CFGLabel, label, strconv.Itoa(state), state_label)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Configuration presets")
+	group_names, err := c.GetAvailableConfigGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group_name := range group_names {
+		cfg.comment("Group: " + group_name)
+		config_names, err := c.GetAvailableConfigs(group_name)
+		if err != nil {
+			return nil, err
+		}
+		if len(config_names) == 0 {
+			cfg.add(CFGConfigGroup, group_name)
+		}
+		for _, config_name := range config_names {
+			settings, err := c.GetConfigData(group_name, config_name)
+			if err != nil {
+				return nil, err
+			}
+			cfg.comment("Preset: " + config_name)
+			if len(settings) == 0 {
+				cfg.add(CFGConfigGroup, group_name, config_name)
+			}
+			for _, setting := range settings {
+				cfg.add(CFGConfigGroup, group_name, config_name, setting.Label, setting.Property, setting.Value)
+			}
+		}
+		cfg.comment("")
+	}
+
+	resolution_ids, err := c.GetAvailablePixelSizeConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if len(resolution_ids) > 0 {
+		cfg.comment("Pixel size calibrations")
+	}
+	for _, resolution_id := range resolution_ids {
+		settings, err := c.GetPixelSizeConfigData(resolution_id)
+		if err != nil {
+			return nil, err
+		}
+		pixel_size_um, err := c.GetPixelSizeUmByID(resolution_id)
+		if err != nil {
+			return nil, err
+		}
+		affine, err := c.GetPixelSizeAffineByID(resolution_id)
+		if err != nil {
+			return nil, err
+		}
+		cfg.comment("Resolution preset: " + resolution_id)
+		for _, setting := range settings {
+			cfg.add(CFGConfigPixelSize, resolution_id, setting.Label, setting.Property, setting.Value)
+		}
+		cfg.add(CFGPixelSize, resolution_id, formatCFGFloat(pixel_size_um))
+		args := []string{resolution_id}
+		for _, a := range affine {
+			args = append(args, formatCFGFloat(a))
+		}
+		cfg.add(CFGPixelSizeAffine, args...)
+	}
+	if len(resolution_ids) > 0 {
+		cfg.comment("")
+	}
+	return cfg, nil
+}
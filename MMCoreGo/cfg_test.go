@@ -0,0 +1,226 @@
+package mmcore_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+const demoCFG = `# Unload all devices
+Property,Core,Initialize,0
+
+# Load devices
+Device,Camera,DemoCamera,DCam
+Device,Wheel,DemoCamera,DWheel
+Device,Z,DemoCamera,DStage
+
+# Pre-initialization properties
+Property,Camera,MaximumExposureMs,500
+
+# Initialize
+Property,Core,Initialize,1
+
+# Labels
+Label,Wheel,0,DAPI
+Label,Wheel,1,FITC
+FocusDirection,Z,-1
+
+# Configuration presets
+ConfigGroup,Channel,DAPI,Wheel,Label,DAPI
+
+# Core properties
+Property,Core,Camera,Camera
+Property,Core,Focus,Z
+`
+
+func ExampleApplySystemConfiguration() {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(demoCFG))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	if err := mmcore.ApplySystemConfiguration(mmc, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	labels, _ := mmc.GetStateLabels("Wheel")
+	sign, _ := mmc.GetFocusDirection("Z")
+	max, _ := mmc.GetProperty("Camera", "MaximumExposureMs")
+	fmt.Println("Camera:", mmc.CameraDevice(), "Focus:", mmc.FocusDevice())
+	fmt.Println("Wheel labels:", labels[:3])
+	fmt.Println("Z focus direction:", sign)
+	fmt.Println("Camera MaximumExposureMs:", max)
+
+	// Output:
+	// Camera: Camera Focus: Z
+	// Wheel labels: [DAPI FITC State-2]
+	// Z focus direction: -1
+	// Camera MaximumExposureMs: 500.0000
+}
+
+func ExampleTakeSystemConfiguration() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.LoadDevice("Z", "DemoCamera", "DStage"),
+		mmc.SetProperty("Camera", "MaximumExposureMs", 500.0),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetFocusDevice("Z"),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cfg, err := mmcore.TakeSystemConfiguration(mmc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.WriteTo(os.Stdout)
+
+	// Output:
+	// # Unload all devices
+	// Property,Core,Initialize,0
+	//
+	// # Load devices
+	// Device,Camera,DemoCamera,DCam
+	// Device,Z,DemoCamera,DStage
+	//
+	// # Pre-initialization properties
+	// Property,Camera,MaximumExposureMs,500.0000
+	//
+	// # Hub (parent) references
+	//
+	// # Initialize
+	// Property,Core,Initialize,1
+	//
+	// # Focus directions
+	// FocusDirection,Z,0
+	//
+	// # Roles
+	// Property,Core,Camera,Camera
+	// Property,Core,Focus,Z
+	// Property,Core,AutoShutter,1
+	//
+	// # Labels
+	//
+	// # Configuration presets
+}
+
+func TestSystemConfigurationErrors(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		err  error
+	}{
+		{"Devices,Camera,DemoCamera,DCam", mmcore.ErrInvalidCFGEntry},
+		{"Device,Camera,DemoCamera", mmcore.ErrInvalidCFGEntry},
+		{"Label,Wheel,first,DAPI", mmcore.ErrInvalidCFGEntry},
+		{"Property,Core,Initialize,2", mmcore.ErrInvalidCFGEntry},
+		{"PixelSizeAffine,Res10x,1,0,0,0,1", mmcore.ErrInvalidCFGEntry},
+		{"Device,Camera,DemoCamera,DNoSuchCamera", mmcore.ErrCreateFailed},
+		{"Property,Wheel,NoSuchProperty,1", mmcore.ErrDEVICE_GENERIC},
+		{"Label,Camera,0,DAPI", mmcore.ErrInvalidLabel},
+		{"Delay,Camera,10", mmcore.ErrInvalidLabel},
+	} {
+		text := "# Test\nDevice,Wheel,DemoCamera,DWheel\n" + test.line + "\n"
+		mmc := sim.NewSession()
+		cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(text))
+		if err == nil {
+			err = mmcore.ApplySystemConfiguration(mmc, cfg)
+		}
+		cfg_err, ok := err.(*mmcore.CFGError)
+		if !ok {
+			t.Errorf("%s: got %v, want a *CFGError", test.line, err)
+		} else if cfg_err.Line != 3 || cfg_err.Text != test.line || cfg_err.Err != mmcore.ErrInvalidCFGEntry {
+			t.Errorf("%s: got line %d %q %v, want line 3 %v", test.line, cfg_err.Line, cfg_err.Text, cfg_err.Err, mmcore.ErrInvalidCFGEntry)
+		}
+		if !errors.Is(err, mmcore.ErrInvalidCFGEntry) || !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want an invalid entry and %v", test.line, err, test.err)
+		}
+		if labels, _ := mmc.GetLoadedDevices(); len(labels) != 1 {
+			t.Errorf("%s: devices %v are still loaded", test.line, labels)
+		}
+		mmc.Close()
+	}
+}
+
+func TestSaveSystemConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmcore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(demoCFG + "Device,Hub,DemoCamera,DHub\nDevice,Shutter,DemoCamera,DShutter\nParent,Shutter,Hub\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mmcore.ApplySystemConfiguration(mmc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetShutterDevice("Shutter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetDeviceDelayMs("Shutter", 12.5); err != nil {
+		t.Fatal(err)
+	}
+	saved := dir + "/saved.cfg"
+	if err := mmc.SaveSystemConfiguration(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading the saved file must restore the same state, and save the same file.
+	loaded := sim.NewSession()
+	defer loaded.Close()
+	if err := loaded.LoadSystemConfiguration(saved); err != nil {
+		t.Fatal(err)
+	}
+	if parent, _ := loaded.GetParentLabel("Shutter"); parent != "Hub" {
+		t.Errorf("parent of Shutter is %q, want Hub", parent)
+	}
+	if delay_ms, _ := loaded.GetDeviceDelayMs("Shutter"); delay_ms != 12.5 {
+		t.Errorf("delay of Shutter is %g ms, want 12.5", delay_ms)
+	}
+	before, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := loaded.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := mmcore.Diff(before, after); len(changes) > 0 {
+		t.Errorf("changes after loading the saved file: %v", changes)
+	}
+	if before.CurrentDevices != after.CurrentDevices {
+		t.Errorf("current devices %+v, want %+v", after.CurrentDevices, before.CurrentDevices)
+	}
+
+	resaved := dir + "/resaved.cfg"
+	if err := loaded.SaveSystemConfiguration(resaved); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := ioutil.ReadFile(saved)
+	b, _ := ioutil.ReadFile(resaved)
+	if !bytes.Equal(a, b) {
+		t.Errorf("saved again:\n%s\nwant:\n%s", b, a)
+	}
+}
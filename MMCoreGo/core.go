@@ -0,0 +1,260 @@
+package mmcore
+
+import "context"
+
+// Core is the method set of a Micro-Manager core session.
+//
+// *Session implements Core by calling into MMCore through MMCoreC.
+// Acquisition code that accepts a Core (or one of the smaller interfaces below)
+// instead of *Session can be run against a fake or simulated core.
+type Core interface {
+	DeviceControl
+	ConfigGroups
+	PixelSizeConfigs
+	Camera
+	Stage
+	XYStage
+	StateDevice
+	Shutter
+	AutoFocus
+
+	Close()
+}
+
+// DeviceControl covers device loading and initialization, device listing,
+// generic property access, current device assignment, hubs and event notification.
+type DeviceControl interface {
+	VersionInfo() string
+	APIVersionInfo() string
+
+	// Initialization and setup
+	LoadDevice(label, module_name, dev_name string) error
+	UnloadDevice(label string) error
+	UnloadAllDevices() error
+	InitializeAllDevices() error
+	InitializeDevice(label string) error
+	Reset() error
+
+	// Event notification
+	NotifyPropertiesChanged(event chan<- *PropertiesChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifyPropertyChanged(event chan<- *PropertyChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifyConfigGroupChanged(event chan<- *ConfigGroupChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifySystemConfigurationLoaded(event chan<- *SystemConfigurationLoadedEvent, opts ...SubscribeOption) *Subscription
+	NotifyPixelSizeChanged(event chan<- *PixelSizeChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifyStagePositionChanged(event chan<- *StagePositionChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifyXYStagePositionChanged(event chan<- *XYStagePositionChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifyExposureChanged(event chan<- *ExposureChangedEvent, opts ...SubscribeOption) *Subscription
+	NotifySLMExposureChanged(event chan<- *SLMExposureChangedEvent, opts ...SubscribeOption) *Subscription
+
+	// Device listing
+	DeviceAdapterSearchPaths() (paths []string)
+	SetDeviceAdapterSearchPaths(paths []string)
+	GetDeviceAdapterNames() (names []string, err error)
+	GetAvailableDevices(module_name string) (dev_names []string, err error)
+	GetAvailableDeviceDescriptions(module_name string) (descriptions []string, err error)
+
+	// Generic device control
+	GetLoadedDevices() (labels []string, err error)
+	GetDeviceType(label string) (device_type DeviceType, err error)
+	GetDeviceLibrary(label string) (module_name string, err error)
+	GetDeviceName(label string) (dev_name string, err error)
+	GetDevicePropertyNames(label string) (names []string, err error)
+	HasProperty(label string, property string) (has_property bool, err error)
+	GetProperty(label string, property string) (value string, err error)
+	SetProperty(label string, property string, state interface{}) (err error)
+	GetAllowedPropertyValues(label string, property string) (values []string, err error)
+	IsPropertyReadOnly(label string, property string) (read_only bool, err error)
+	IsPropertyPreInit(label string, property string) (pre_init bool, err error)
+	IsPropertySequenceable(label string, property string) (sequenceable bool, err error)
+	HasPropertyLimits(label string, property string) (has_limits bool, err error)
+	GetPropertyLowerLimit(label string, property string) (lower_limit float64, err error)
+	GetPropertyUpperLimit(label string, property string) (upper_limit float64, err error)
+	PropertyType(label string, property string) (property_type PropertyType, err error)
+	GetPropertyFloat(label string, property string) (value float64, err error)
+	GetPropertyInt(label string, property string) (value int, err error)
+	GetPropertyBool(label string, property string) (value bool, err error)
+	DescribeProperty(label string, property string) (desc PropertyDescriptor, err error)
+	DescribeDevice(label string) (descs []PropertyDescriptor, err error)
+
+	// Device status
+	DeviceBusy(label string) (busy bool, err error)
+	DeviceTypeBusy(device_type DeviceType) (busy bool, err error)
+	WaitForDevice(ctx context.Context, label string) error
+	WaitForDeviceType(ctx context.Context, device_type DeviceType) error
+	WaitForSystem(ctx context.Context) error
+	SetDeviceDelayMs(label string, delay_ms float64) error
+	GetDeviceDelayMs(label string) (delay_ms float64, err error)
+
+	// Manage current devices
+	SetCameraDevice(label string) error
+	SetShutterDevice(label string) error
+	SetFocusDevice(label string) error
+	SetXYStageDevice(label string) error
+	SetAutoFocusDevice(label string) error
+	CameraDevice() (label string)
+	ShutterDevice() (label string)
+	FocusDevice() (label string)
+	XYStageDevice() (label string)
+	AutoFocusDevice() (label string)
+
+	// Hub and peripheral devices
+	SetParentLabel(label string, parent_label string) (err error)
+	GetParentLabel(label string) (parent_label string, err error)
+	GetInstalledDevices(hub_label string) (names []string, err error)
+	GetInstalledDeviceDescription(hub_label string, name string) (descriptions string, err error)
+	GetLoadedPeripheralDevices(hub_label string) (labels []string, err error)
+
+	// Miscellaneous
+	UserId() (userid string)
+	HostName() (hostname string)
+	MACAddresses() (addresses []string)
+}
+
+// ConfigGroups covers configuration groups and their presets.
+type ConfigGroups interface {
+	DefineConfigGroup(group_name string) error
+	DeleteConfigGroup(group_name string) error
+	RenameConfigGroup(old_group_name string, new_group_name string) error
+	IsGroupDefined(group_name string) (defined bool, err error)
+	GetAvailableConfigGroups() (group_names []string, err error)
+
+	DefineConfig(group_name string, config_name string) error
+	DefineConfigSetting(group_name string, config_name string, label string, property string, value string) error
+	DeleteConfig(group_name string, config_name string) error
+	DeleteConfigSetting(group_name string, config_name string, label string, property string) error
+	RenameConfig(group_name string, old_config_name string, new_config_name string) error
+	IsConfigDefined(group_name string, config_name string) (defined bool, err error)
+	GetAvailableConfigs(group_name string) (config_names []string, err error)
+
+	SetConfig(group_name string, config_name string) error
+	GetCurrentConfig(group_name string) (config_name string, err error)
+	WaitForConfig(ctx context.Context, group_name string, config_name string) error
+	GetConfigData(group_name string, config_name string) (settings []PropertySetting, err error)
+}
+
+// PixelSizeConfigs covers pixel size configurations and the calibration of the current one.
+type PixelSizeConfigs interface {
+	DefinePixelSizeConfig(resolution_id string, label string, property string, value string) error
+	SetPixelSizeUm(resolution_id string, pixel_size_um float64) error
+	SetPixelSizeAffine(resolution_id string, affine []float64) error
+	DeletePixelSizeConfig(resolution_id string) error
+	RenamePixelSizeConfig(old_resolution_id string, new_resolution_id string) error
+	IsPixelSizeConfigDefined(resolution_id string) (defined bool, err error)
+	GetAvailablePixelSizeConfigs() (resolution_ids []string, err error)
+	GetPixelSizeConfigData(resolution_id string) (settings []PropertySetting, err error)
+
+	SetPixelSizeConfig(resolution_id string) error
+	GetCurrentPixelSizeConfig() (resolution_id string, err error)
+	GetPixelSizeUm() (pixel_size_um float64, err error)
+	GetPixelSizeUmByID(resolution_id string) (pixel_size_um float64, err error)
+	GetPixelSizeAffine() (affine []float64, err error)
+	GetPixelSizeAffineByID(resolution_id string) (affine []float64, err error)
+}
+
+// Camera covers image acquisition settings, snapping, sequence acquisition
+// and the circular buffer of the current camera.
+type Camera interface {
+	// Image acquisition settings
+	SetROI(x int, y int, x_size int, y_size int) error
+	GetROI() (x int, y int, x_size int, y_size int, err error)
+	ClearROI() error
+	SetExposureTime(exposure_ms float64) error
+	ExposureTime() (exposure_ms float64, err error)
+	ImageBufferSize() (len int)
+	ImageWidth() (width int)
+	ImageHeight() (height int)
+	BytesPerPixel() (bytes_per_pixel int)
+	ImageBitDepth() (bit_depth int)
+	NumberOfComponents() (n_components int)
+	NumberOfCameraChannels() (n_channels int)
+
+	// Image acquisition
+	SnapImage() error
+	GetImage() (buf []byte, err error)
+	GetImageOfChannel(channel int) (buf []byte, err error)
+	GetImageData() (img *Image, err error)
+
+	// Image sequence acquisition
+	StartSequenceAcquisition(num_images int, interval_ms float64, stop_on_overflow bool) error
+	StartContinuousSequenceAcquisition(interval_ms float64) error
+	StopSequenceAcquisition() error
+	IsSequenceRunning() bool
+
+	// Image circular buffer
+	GetLastImage() (buf []byte, err error)
+	PopNextImage() (buf []byte, err error)
+	GetLastImageData() (img *Image, err error)
+	PopNextImageData() (img *Image, err error)
+	GetLastImageWithMetadata() (img *Image, md *ImageMetadata, err error)
+	PopNextImageWithMetadata() (img *Image, md *ImageMetadata, err error)
+	PopNextImageInto(dst []byte) (img *Image, err error)
+	PopNextImagePooled(pool *ImagePool) (img *Image, err error)
+	BorrowNextImage() (img *BorrowedImage, err error)
+	GetRemainingImageCount() (count int)
+	GetBufferTotalCapacity() (capacity int)
+	GetBufferFreeCapacity() (capacity int)
+	IsBufferOverflowed() (overflowed bool)
+	SetCircularBufferMemoryFootprint(size_MB uint32) error
+	GetCircularBufferMemoryFootprint() (size_MB uint32)
+	InitializeCircularBuffer() error
+	ClearCircularBuffer() error
+}
+
+// Stage covers focus (Z) stage control.
+type Stage interface {
+	SetPosition(label string, position float64) error
+	SetRelativePosition(label string, delta float64) error
+	GetPosition(label string) (position float64, err error)
+	SetOrigin(label string) (err error)
+	SetAdapterOrigin(label string, new_z_um float64) (err error)
+	SetFocusDirection(label string, sign int)
+	GetFocusDirection(label string) (sign int, err error)
+}
+
+// XYStage covers XY stage control.
+type XYStage interface {
+	SetXYPosition(label string, x float64, y float64) (err error)
+	SetRelativeXYPosition(label string, dx float64, dy float64) (err error)
+	GetXYPosition(label string) (x float64, y float64, err error)
+	GetXPosition(label string) (x float64, err error)
+	GetYPosition(label string) (y float64, err error)
+	Stop(label string) (err error)
+	Home(label string) (err error)
+	SetOriginXY(label string) (err error)
+	SetOriginX(label string) (err error)
+	SetOriginY(label string) (err error)
+	SetAdpaterOriginXY(label string, new_x_um float64, new_y_um float64) (err error)
+}
+
+// StateDevice covers state device control, such as filter wheels and objective turrets.
+type StateDevice interface {
+	SetState(label string, state int) error
+	GetState(label string) (state int, err error)
+	NumberOfStates(label string) (n_states int, err error)
+	SetStateLabel(label string, state_label string) error
+	GetStateLabel(label string) (state_label string, err error)
+	DefineStateLabel(label string, state int, state_label string) error
+	GetStateLabels(label string) (state_labels []string, err error)
+	GetStateFromLabel(label string, state_label string) (state int, err error)
+}
+
+// Shutter covers shutter control.
+type Shutter interface {
+	SetShutterOpen(label string, is_open bool) error
+	GetShutterOpen(label string) (is_open bool, err error)
+}
+
+// AutoFocus covers autofocus control.
+type AutoFocus interface {
+	LastFocusScore() (score float64)
+	CurrentFocusScore() (score float64)
+	EnableContinuousFocus() error
+	DisableContinuousFocus() error
+	IsContinuousFocusEnabled() (enabled bool, err error)
+	IsContinuousFocusLocked() (locked bool, err error)
+	IsContinuousFocusDrive(label string) (is_continuous_focus_drive bool, err error)
+	FullFocus() (err error)
+	IncrementalFocus() (err error)
+	SetAutoFocusOffset(offset float64) error
+	GetAutoFocusOffset() (offset float64, err error)
+}
@@ -0,0 +1,100 @@
+package mmcore
+
+import (
+	"context"
+	"time"
+)
+
+// DeviceType is the type of a device, as MM_DeviceType in MMCoreC.
+// String returns the name used by MMCore, such as "CameraDevice".
+type DeviceType int
+
+const (
+	UnknownType DeviceType = iota
+	AnyType
+	CameraDeviceType
+	ShutterDeviceType
+	StateDeviceType
+	StageDeviceType
+	XYStageDeviceType
+	SerialDeviceType
+	GenericDeviceType
+	AutoFocusDeviceType
+	CoreDeviceType
+	ImageProcessorDeviceType
+	SignalIODeviceType
+	MagnifierDeviceType
+	SLMDeviceType
+	HubDeviceType
+	GalvoDeviceType
+)
+
+var deviceTypeText = map[DeviceType]string{
+	UnknownType:              "UnknownType",
+	AnyType:                  "AnyType",
+	CameraDeviceType:         "CameraDevice",
+	ShutterDeviceType:        "ShutterDevice",
+	StateDeviceType:          "StateDevice",
+	StageDeviceType:          "StageDevice",
+	XYStageDeviceType:        "XYStageDevice",
+	SerialDeviceType:         "SerialDevice",
+	GenericDeviceType:        "GenericDevice",
+	AutoFocusDeviceType:      "AutoFocusDevice",
+	CoreDeviceType:           "CoreDevice",
+	ImageProcessorDeviceType: "ImageProcessorDevice",
+	SignalIODeviceType:       "SignalIODevice",
+	MagnifierDeviceType:      "MagnifierDevice",
+	SLMDeviceType:            "SLMDevice",
+	HubDeviceType:            "HubDevice",
+	GalvoDeviceType:          "GalvoDevice",
+}
+
+func (t DeviceType) String() string {
+	if s, ok := deviceTypeText[t]; ok {
+		return s
+	}
+	return "UnknownType"
+}
+
+// busyPollInterval is the time between two busy checks while waiting for devices.
+const busyPollInterval = 5 * time.Millisecond
+
+// waitWhileBusy calls busy until it returns false or an error, or until ctx is done.
+//
+// It returns ErrDevicePollingTimeout when the deadline of ctx is exceeded,
+// and ctx.Err() when ctx is canceled.
+func waitWhileBusy(ctx context.Context, busy func() (bool, error)) error {
+	ticker := time.NewTicker(busyPollInterval)
+	defer ticker.Stop()
+	for {
+		is_busy, err := busy()
+		if err != nil || !is_busy {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrDevicePollingTimeout
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForConfig waits until none of the devices of the preset is busy.
+func waitForConfig(ctx context.Context, c Core, group_name, config_name string) error {
+	settings, err := c.GetConfigData(group_name, config_name)
+	if err != nil {
+		return err
+	}
+	return waitWhileBusy(ctx, func() (bool, error) {
+		for _, setting := range settings {
+			if busy, err := c.DeviceBusy(setting.Label); err != nil || busy {
+				return busy, err
+			}
+		}
+		return false, nil
+	})
+}
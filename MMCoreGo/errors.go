@@ -1,23 +1,12 @@
 package mmcore
 
-// #cgo CFLAGS: -I../MMCoreC
-//
-// #include "MMCoreC.h"
-import "C"
-
 import (
 	"fmt"
 )
 
+// Error is an MMCore error code, as returned in MM_Status by MMCoreC.
 type Error int
 
-func statusToError(status C.MM_Status) error {
-	if int(C.int(status)) == 0 {
-		return nil
-	}
-	return Error(int(C.int(status)))
-}
-
 func (e Error) Error() string {
 	s := errText[e]
 	if s == "s" {
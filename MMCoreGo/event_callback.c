@@ -1,19 +1,57 @@
+#include <stdlib.h>
 #include <string.h>
 #include "_cgo_export.h"
 
+void c_onPropertiesChanged(MM_Session mm) {
+    onPropertiesChanged(mm);
+}
+
 void c_onPropertyChanged(MM_Session mm, const char* label, const char* property, const char* value) {
     onPropertyChanged(mm, (char *)label, (char *)property, (char *)value);
 }
 
+void c_onConfigGroupChanged(MM_Session mm, const char* group_name, const char* config_name) {
+    onConfigGroupChanged(mm, (char *)group_name, (char *)config_name);
+}
+
+void c_onSystemConfigurationLoaded(MM_Session mm) {
+    onSystemConfigurationLoaded(mm);
+}
+
+void c_onPixelSizeChanged(MM_Session mm, double pixel_size_um) {
+    onPixelSizeChanged(mm, pixel_size_um);
+}
+
 void c_onStagePositionChanged(MM_Session mm, char* label, double pos) {
     onStagePositionChanged(mm, label, pos);
 }
 
-void c_registerCallback(MM_Session mm) {
+void c_onXYStagePositionChanged(MM_Session mm, char* label, double x, double y) {
+    onXYStagePositionChanged(mm, label, x, y);
+}
+
+void c_onExposureChanged(MM_Session mm, char* label, double exposure_ms) {
+    onExposureChanged(mm, label, exposure_ms);
+}
+
+void c_onSLMExposureChanged(MM_Session mm, char* label, double exposure_ms) {
+    onSLMExposureChanged(mm, label, exposure_ms);
+}
+
+// c_newCallback returns a callback that forwards all events to Go.
+// The callback is owned by the Go session, which frees it after MM_Close.
+struct MM_EventCallback *c_newCallback(void) {
     struct MM_EventCallback *callback = (struct MM_EventCallback *)malloc(sizeof(struct MM_EventCallback));
     memset(callback, 0, sizeof(*callback));
 
+    callback->onPropertiesChanged = &c_onPropertiesChanged;
     callback->onPropertyChanged = &c_onPropertyChanged;
+    callback->onConfigGroupChanged = &c_onConfigGroupChanged;
+    callback->onSystemConfigurationLoaded = &c_onSystemConfigurationLoaded;
+    callback->onPixelSizeChanged = &c_onPixelSizeChanged;
     callback->onStagePositionChanged = &c_onStagePositionChanged;
-    MM_RegisterCallback(mm, callback);
-}
\ No newline at end of file
+    callback->onXYStagePositionChanged = &c_onXYStagePositionChanged;
+    callback->onExposureChanged = &c_onExposureChanged;
+    callback->onSLMExposureChanged = &c_onSLMExposureChanged;
+    return callback;
+}
@@ -0,0 +1,319 @@
+package mmcore
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy selects what happens to an event when the queue of a subscriber is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued event to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the new event.
+	DropNewest
+	// Block waits up to the block timeout for room in the queue, and then discards the new event.
+	// The publisher, usually the MMCore callback thread, is blocked while waiting.
+	Block
+)
+
+const (
+	DefaultQueueSize    = 64
+	DefaultBlockTimeout = time.Second
+)
+
+type subscribeOptions struct {
+	queueSize    int
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscribeOptions)
+
+// WithQueueSize sets the number of events queued for the subscriber. The default is DefaultQueueSize.
+func WithQueueSize(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if n > 0 {
+			o.queueSize = n
+		}
+	}
+}
+
+// WithOverflowPolicy sets the overflow policy. The default is DropOldest.
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = policy
+	}
+}
+
+// WithBlockTimeout sets how long the Block policy waits. The default is DefaultBlockTimeout.
+func WithBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.blockTimeout = timeout
+	}
+}
+
+// Subscription is a registration of a channel for one event type.
+//
+// Events are queued for the subscriber and sent to its channel from a goroutine
+// of the subscription, so a slow subscriber only fills its own queue.
+// When the queue is full, the overflow policy decides which event is dropped.
+type Subscription struct {
+	dropped uint64 // accessed atomically, kept first for 64-bit alignment
+
+	bus          *EventBus
+	typ          reflect.Type
+	queue        chan interface{}
+	send         func(event interface{}, done <-chan struct{})
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Unsubscribe stops the delivery of events. Events still queued are discarded.
+// The channel of the subscriber is not closed. Unsubscribe may be called more than once.
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.bus.remove(sub)
+		close(sub.done)
+	})
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Queued returns the number of events waiting in the queue.
+func (sub *Subscription) Queued() int {
+	return len(sub.queue)
+}
+
+func (sub *Subscription) run() {
+	for {
+		select {
+		case event := <-sub.queue:
+			sub.send(event, sub.done)
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// offer queues the event according to the overflow policy.
+func (sub *Subscription) offer(event interface{}) {
+	select {
+	case <-sub.done:
+		return
+	case sub.queue <- event:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case DropNewest:
+		atomic.AddUint64(&sub.dropped, 1)
+	case Block:
+		timer := time.NewTimer(sub.blockTimeout)
+		defer timer.Stop()
+		select {
+		case sub.queue <- event:
+		case <-sub.done:
+		case <-timer.C:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	default:
+		for {
+			select {
+			case <-sub.queue:
+				atomic.AddUint64(&sub.dropped, 1)
+			default:
+			}
+			select {
+			case sub.queue <- event:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// EventBus delivers events to the subscribed channels.
+//
+// Session uses an EventBus for the MMCore callbacks. Other implementations of Core
+// can use one to provide the same delivery guarantees. The zero value is ready to use.
+type EventBus struct {
+	mu     sync.Mutex
+	subs   map[reflect.Type][]*Subscription
+	closed bool
+}
+
+// Publish queues the event for the subscribers of its type.
+// Only the Block policy can make Publish wait.
+func (b *EventBus) Publish(event interface{}) {
+	typ := reflect.TypeOf(event)
+	b.mu.Lock()
+	subs := b.subs[typ]
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.offer(event)
+	}
+}
+
+// Close unsubscribes all subscribers. Later subscriptions are inactive.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	b.closed = true
+	var subs []*Subscription
+	for _, list := range b.subs {
+		subs = append(subs, list...)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+func (b *EventBus) subscribe(event interface{}, send func(event interface{}, done <-chan struct{}), opts []SubscribeOption) *Subscription {
+	o := subscribeOptions{
+		queueSize:    DefaultQueueSize,
+		policy:       DropOldest,
+		blockTimeout: DefaultBlockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	sub := &Subscription{
+		bus:          b,
+		typ:          reflect.TypeOf(event),
+		queue:        make(chan interface{}, o.queueSize),
+		send:         send,
+		policy:       o.policy,
+		blockTimeout: o.blockTimeout,
+		done:         make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		sub.once.Do(func() { close(sub.done) })
+		return sub
+	}
+	if b.subs == nil {
+		b.subs = make(map[reflect.Type][]*Subscription)
+	}
+	// The slice is copied, so that Publish can use the old one without holding the lock.
+	list := b.subs[sub.typ]
+	b.subs[sub.typ] = append(list[:len(list):len(list)], sub)
+	go sub.run()
+	return sub
+}
+
+func (b *EventBus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := b.subs[sub.typ]
+	for i, s := range list {
+		if s == sub {
+			updated := make([]*Subscription, 0, len(list)-1)
+			updated = append(updated, list[:i]...)
+			b.subs[sub.typ] = append(updated, list[i+1:]...)
+			return
+		}
+	}
+}
+
+//
+// Subscriptions for each event type
+//
+
+func (b *EventBus) NotifyPropertiesChanged(event chan<- *PropertiesChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*PropertiesChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*PropertiesChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyPropertyChanged(event chan<- *PropertyChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*PropertyChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*PropertyChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyConfigGroupChanged(event chan<- *ConfigGroupChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*ConfigGroupChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*ConfigGroupChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifySystemConfigurationLoaded(event chan<- *SystemConfigurationLoadedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*SystemConfigurationLoadedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*SystemConfigurationLoadedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyPixelSizeChanged(event chan<- *PixelSizeChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*PixelSizeChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*PixelSizeChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyStagePositionChanged(event chan<- *StagePositionChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*StagePositionChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*StagePositionChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyXYStagePositionChanged(event chan<- *XYStagePositionChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*XYStagePositionChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*XYStagePositionChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifyExposureChanged(event chan<- *ExposureChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*ExposureChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*ExposureChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
+
+func (b *EventBus) NotifySLMExposureChanged(event chan<- *SLMExposureChangedEvent, opts ...SubscribeOption) *Subscription {
+	return b.subscribe((*SLMExposureChangedEvent)(nil), func(e interface{}, done <-chan struct{}) {
+		select {
+		case event <- e.(*SLMExposureChangedEvent):
+		case <-done:
+		}
+	}, opts)
+}
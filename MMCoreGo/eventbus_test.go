@@ -0,0 +1,151 @@
+package mmcore_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+func ExampleEventBus() {
+	var bus mmcore.EventBus
+	defer bus.Close()
+
+	events := make(chan *mmcore.StagePositionChangedEvent)
+	sub := bus.NotifyStagePositionChanged(events, mmcore.WithQueueSize(16), mmcore.WithOverflowPolicy(mmcore.DropNewest))
+	defer sub.Unsubscribe()
+
+	bus.Publish(&mmcore.StagePositionChangedEvent{Label: "Z", Pos: 12.5})
+	event := <-events
+	fmt.Printf("%s: %.1f\n", event.Label, event.Pos)
+	fmt.Printf("Dropped: %d\n", sub.Dropped())
+
+	// Output:
+	// Z: 12.5
+	// Dropped: 0
+}
+
+// publishStalled publishes event 0 and waits until the subscription holds it while
+// sending it to the channel, which nobody reads. Then it publishes the events 1 to n.
+func publishStalled(t *testing.T, bus *mmcore.EventBus, sub *mmcore.Subscription, n int) {
+	t.Helper()
+	bus.Publish(&mmcore.StagePositionChangedEvent{Pos: 0})
+	deadline := time.Now().Add(5 * time.Second)
+	for sub.Queued() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the first event to be dequeued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= n; i++ {
+		bus.Publish(&mmcore.StagePositionChangedEvent{Pos: float64(i)})
+	}
+}
+
+func receive(t *testing.T, events <-chan *mmcore.StagePositionChangedEvent, n int) []float64 {
+	t.Helper()
+	var positions []float64
+	for i := 0; i < n; i++ {
+		select {
+		case event := <-events:
+			positions = append(positions, event.Pos)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout after receiving %v", positions)
+		}
+	}
+	return positions
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	tests := []struct {
+		policy mmcore.OverflowPolicy
+		want   string
+	}{
+		{mmcore.DropOldest, "[0 4 5]"},
+		{mmcore.DropNewest, "[0 1 2]"},
+		{mmcore.Block, "[0 1 2]"},
+	}
+	for _, tt := range tests {
+		var bus mmcore.EventBus
+		events := make(chan *mmcore.StagePositionChangedEvent)
+		sub := bus.NotifyStagePositionChanged(events,
+			mmcore.WithQueueSize(2),
+			mmcore.WithOverflowPolicy(tt.policy),
+			mmcore.WithBlockTimeout(time.Millisecond))
+
+		publishStalled(t, &bus, sub, 5)
+		if got := fmt.Sprint(receive(t, events, 3)); got != tt.want {
+			t.Errorf("policy %d: received %s, want %s", tt.policy, got, tt.want)
+		}
+		if sub.Dropped() != 3 {
+			t.Errorf("policy %d: Dropped() = %d, want 3", tt.policy, sub.Dropped())
+		}
+		bus.Close()
+	}
+}
+
+func TestEventBusBlock(t *testing.T) {
+	var bus mmcore.EventBus
+	defer bus.Close()
+
+	events := make(chan *mmcore.StagePositionChangedEvent)
+	sub := bus.NotifyStagePositionChanged(events,
+		mmcore.WithQueueSize(1),
+		mmcore.WithOverflowPolicy(mmcore.Block),
+		mmcore.WithBlockTimeout(time.Minute))
+
+	// Publish waits for the subscriber instead of dropping events.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bus.Publish(&mmcore.StagePositionChangedEvent{Pos: float64(i)})
+		}
+		close(done)
+	}()
+	if got := fmt.Sprint(receive(t, events, 10)); got != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("received %s", got)
+	}
+	<-done
+	if sub.Dropped() != 0 {
+		t.Errorf("Dropped() = %d, want 0", sub.Dropped())
+	}
+}
+
+func TestEventBusUnsubscribe(t *testing.T) {
+	var bus mmcore.EventBus
+	defer bus.Close()
+
+	stalled := make(chan *mmcore.StagePositionChangedEvent)
+	events := make(chan *mmcore.StagePositionChangedEvent, 1)
+	stalledSub := bus.NotifyStagePositionChanged(stalled)
+	sub := bus.NotifyStagePositionChanged(events)
+
+	// A subscriber that never reads does not hold up the others.
+	bus.Publish(&mmcore.StagePositionChangedEvent{Pos: 1})
+	if got := receive(t, events, 1); got[0] != 1 {
+		t.Errorf("received %v", got)
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	stalledSub.Unsubscribe()
+	bus.Publish(&mmcore.StagePositionChangedEvent{Pos: 2})
+	select {
+	case event := <-events:
+		t.Errorf("received %+v after Unsubscribe", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Events of other types are not delivered.
+	sub = bus.NotifyStagePositionChanged(events)
+	bus.Publish(&mmcore.XYStagePositionChangedEvent{Label: "XY"})
+	select {
+	case event := <-events:
+		t.Errorf("received %+v for an XYStagePositionChangedEvent", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("Dropped() = %d, want 0", sub.Dropped())
+	}
+}
@@ -0,0 +1,47 @@
+package mmcore
+
+//
+// Event notification
+//
+
+// PropertiesChangedEvent is sent when many properties may have changed at once,
+// for example after the devices are initialized.
+type PropertiesChangedEvent struct{}
+
+type PropertyChangedEvent struct {
+	Label    string
+	Property string
+	Value    string
+}
+
+type ConfigGroupChangedEvent struct {
+	GroupName  string
+	ConfigName string
+}
+
+type SystemConfigurationLoadedEvent struct{}
+
+type PixelSizeChangedEvent struct {
+	PixelSizeUm float64
+}
+
+type StagePositionChangedEvent struct {
+	Label string
+	Pos   float64
+}
+
+type XYStagePositionChangedEvent struct {
+	Label string
+	X     float64
+	Y     float64
+}
+
+type ExposureChangedEvent struct {
+	Label      string
+	ExposureMs float64
+}
+
+type SLMExposureChangedEvent struct {
+	Label      string
+	ExposureMs float64
+}
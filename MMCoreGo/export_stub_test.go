@@ -0,0 +1,34 @@
+//go:build mmcorestub
+// +build mmcorestub
+
+package mmcore
+
+// Export the stub functions for the tests in package mmcore_test.
+
+func (s *Session) StubPostConfigGroupChanged(group_name, config_name string) {
+	s.stubPostConfigGroupChanged(group_name, config_name)
+}
+
+func (s *Session) StubPostSystemConfigurationLoaded() {
+	s.stubPostSystemConfigurationLoaded()
+}
+
+func (s *Session) StubPostPixelSizeChanged(pixel_size_um float64) {
+	s.stubPostPixelSizeChanged(pixel_size_um)
+}
+
+func (s *Session) StubPostSLMExposureChanged(label string, exposure_ms float64) {
+	s.stubPostSLMExposureChanged(label, exposure_ms)
+}
+
+func (s *Session) StubSetDeviceBusy(label string, busy_ms float64) error {
+	return s.stubSetDeviceBusy(label, busy_ms)
+}
+
+func (s *Session) StubStartSequenceAcquisitionV1(num_images int16) error {
+	return s.stubStartSequenceAcquisitionV1(num_images)
+}
+
+func (s *Session) StubBufferCountsV1() (remaining, total, free int16) {
+	return s.stubBufferCountsV1()
+}
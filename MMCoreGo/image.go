@@ -0,0 +1,127 @@
+package mmcore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Image is an image buffer of a camera with its geometry, as returned by GetImageData,
+// GetLastImageData and PopNextImageData.
+//
+// Image implements image.Image with the color model of the pixel type: color.GrayModel
+// for 8-bit, color.Gray16Model for 16-bit and color.RGBAModel for 32-bit RGB pixels.
+// At scales the pixels from BitDepth to the full range of the model, while Value returns
+// the raw values.
+type Image struct {
+	Pix           []byte // pixels row by row; 16-bit pixels are little-endian, RGB pixels are BGRA
+	Width, Height int
+	BytesPerPixel int
+	BitDepth      int // significant bits of each pixel value or color component
+	NumComponents int // 1 for monochrome images, 4 for RGB images
+}
+
+// ImageFormatError is returned by NewImage for an unsupported pixel type,
+// or for a buffer that does not match the geometry.
+type ImageFormatError struct {
+	Len           int
+	Width, Height int
+	BytesPerPixel int
+	NumComponents int
+}
+
+func (e *ImageFormatError) Error() string {
+	return fmt.Sprintf("image of %d bytes does not match %dx%d pixels of %d bytes with %d components",
+		e.Len, e.Width, e.Height, e.BytesPerPixel, e.NumComponents)
+}
+
+// NewImage returns an Image of the buffer, which is not copied. A bit depth of 0
+// means all the bits of the pixel type.
+//
+// The supported pixel types are 1 and 2 bytes with 1 component, and 4 bytes with 4 components.
+func NewImage(pix []byte, width, height, bytes_per_pixel, bit_depth, n_components int) (*Image, error) {
+	supported := (n_components == 1 && (bytes_per_pixel == 1 || bytes_per_pixel == 2)) ||
+		(n_components == 4 && bytes_per_pixel == 4)
+	if !supported || width < 0 || height < 0 || len(pix) != width*height*bytes_per_pixel {
+		return nil, &ImageFormatError{len(pix), width, height, bytes_per_pixel, n_components}
+	}
+	max_bit_depth := 8 * bytes_per_pixel / n_components
+	if bit_depth <= 0 || bit_depth > max_bit_depth {
+		bit_depth = max_bit_depth
+	}
+	return &Image{pix, width, height, bytes_per_pixel, bit_depth, n_components}, nil
+}
+
+func (img *Image) ColorModel() color.Model {
+	switch {
+	case img.NumComponents == 4:
+		return color.RGBAModel
+	case img.BytesPerPixel == 2:
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+func (img *Image) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.Width, img.Height)
+}
+
+// At returns the color of the pixel, scaled from BitDepth to the full range of the color model.
+// RGB pixels are opaque.
+func (img *Image) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return img.ColorModel().Convert(color.Transparent)
+	}
+	p := img.Pix[img.PixOffset(x, y):]
+	switch {
+	case img.NumComponents == 4:
+		return color.RGBA{
+			R: uint8(scaleBits(uint32(p[2]), img.BitDepth, 8)),
+			G: uint8(scaleBits(uint32(p[1]), img.BitDepth, 8)),
+			B: uint8(scaleBits(uint32(p[0]), img.BitDepth, 8)),
+			A: 0xff,
+		}
+	case img.BytesPerPixel == 2:
+		return color.Gray16{Y: uint16(scaleBits(uint32(binary.LittleEndian.Uint16(p)), img.BitDepth, 16))}
+	}
+	return color.Gray{Y: uint8(scaleBits(uint32(p[0]), img.BitDepth, 8))}
+}
+
+// PixOffset returns the index of the first byte of the pixel (x, y) in Pix.
+func (img *Image) PixOffset(x, y int) int {
+	return (y*img.Width + x) * img.BytesPerPixel
+}
+
+// Value returns the raw value of the pixel (x, y) of a monochrome image,
+// or the mean of the color components of an RGB image.
+// Values above MaxValue are returned as they are.
+func (img *Image) Value(x, y int) uint16 {
+	p := img.Pix[img.PixOffset(x, y):]
+	switch {
+	case img.NumComponents == 4:
+		return uint16((uint32(p[0]) + uint32(p[1]) + uint32(p[2])) / 3)
+	case img.BytesPerPixel == 2:
+		return binary.LittleEndian.Uint16(p)
+	}
+	return uint16(p[0])
+}
+
+// MaxValue returns the largest value of a pixel or a color component for BitDepth.
+func (img *Image) MaxValue() uint16 {
+	return uint16(1<<uint(img.BitDepth) - 1)
+}
+
+// scaleBits scales v from a range of from bits to a range of to bits.
+// Values out of range saturate.
+func scaleBits(v uint32, from, to int) uint32 {
+	max_from, max_to := uint32(1)<<uint(from)-1, uint32(1)<<uint(to)-1
+	if v >= max_from {
+		return max_to
+	}
+	if from == to {
+		return v
+	}
+	return uint32(math.Round(float64(v) * float64(max_to) / float64(max_from)))
+}
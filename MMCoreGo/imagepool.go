@@ -0,0 +1,61 @@
+package mmcore
+
+import (
+	"sync"
+)
+
+// ImagePool is a pool of pixel buffers, which PopNextImagePooled fills to avoid
+// allocating a buffer for each image. The zero value is an empty pool.
+//
+// An image returned by PopNextImagePooled belongs to the caller until its pixels are
+// passed to Put, and must not be used after. Each image must be put back at most once;
+// images that are not put back are garbage collected as usual.
+type ImagePool struct {
+	pool sync.Pool
+}
+
+// Get returns a buffer of size bytes from the pool, or a new one. Its contents are undefined.
+func (p *ImagePool) Get(size int) []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok && cap(*buf) >= size {
+		return (*buf)[:size]
+	}
+	return make([]byte, size)
+}
+
+// Put returns a buffer to the pool, such as the pixels of an image. The buffer
+// must not be used after.
+func (p *ImagePool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	buf = buf[:0]
+	p.pool.Put(&buf)
+}
+
+// BorrowedImage is an image returned by BorrowNextImage, whose pixels may refer to
+// memory of the core instead of Go memory.
+//
+// The pixels are valid until Release, which must be called as soon as the image
+// is not needed. They must not be modified, and must be copied to be kept
+// after Release. The embedded Image is nil after Release.
+type BorrowedImage struct {
+	*Image
+	release func()
+	once    sync.Once
+}
+
+// NewBorrowedImage returns a BorrowedImage of img that calls release on Release,
+// for implementations of Core. release can be nil.
+func NewBorrowedImage(img *Image, release func()) *BorrowedImage {
+	return &BorrowedImage{Image: img, release: release}
+}
+
+// Release gives the image back to the core. Calling it again has no effect.
+func (b *BorrowedImage) Release() {
+	b.once.Do(func() {
+		b.Image = nil
+		if b.release != nil {
+			b.release()
+		}
+	})
+}
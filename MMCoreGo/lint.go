@@ -0,0 +1,254 @@
+package mmcore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// LintIssue is a problem found in a configuration file by LintSystemConfiguration.
+//
+// Err is the error that loading the line would cause, such as ErrDuplicateLabel,
+// or nil for lines that would load but not as intended.
+type LintIssue struct {
+	Line    int
+	Text    string
+	Err     error
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("line %d: %s: %q", i.Line, i.Message, i.Text)
+}
+
+// presetSetting is a property value of a preset, with the line that defines it.
+type presetSetting struct {
+	line                   CFGLine
+	kind, group, preset    string
+	label, property, value string
+}
+
+// LintSystemConfiguration checks a configuration file without loading it. It reports:
+//   - devices loaded twice with the same label,
+//   - properties, hubs and state labels of devices that are not loaded,
+//   - presets that refer to devices that are never loaded,
+//   - devices loaded after initialization, which stay uninitialized,
+//   - pre-init properties set after initialization, where a property is pre-init
+//     if the file also sets it before initialization,
+//   - preset values of state device labels that the file does not define.
+//
+// The issues are sorted by line.
+func LintSystemConfiguration(cfg *SystemConfiguration) []LintIssue {
+	var issues []LintIssue
+	report := func(line CFGLine, err error, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{line.Number, line.String(), err, fmt.Sprintf(format, args...)})
+	}
+
+	ever_loaded := make(map[string]bool)
+	for _, line := range cfg.Lines {
+		if line.Command == CFGDevice {
+			ever_loaded[line.arg(0)] = true
+		}
+	}
+	checkLoaded := func(line CFGLine, label string, loaded map[string]int) {
+		if _, ok := loaded[label]; ok || label == coreLabel {
+			return
+		}
+		if ever_loaded[label] {
+			report(line, ErrInvalidLabel, "device %q is not loaded yet", label)
+		} else {
+			report(line, ErrInvalidLabel, "device %q is never loaded", label)
+		}
+	}
+
+	loaded := make(map[string]int) // label -> line of the Device line
+	initialized := false
+	pre_init := make(map[[2]string]bool)
+	state_labels := make(map[string]map[string]bool)
+	var settings []presetSetting
+	for _, line := range cfg.Lines {
+		label := line.arg(0)
+		switch line.Command {
+		case CFGDevice:
+			if label == coreLabel {
+				report(line, ErrDuplicateLabel, "%q is the label of the core", label)
+			} else if number, ok := loaded[label]; ok {
+				report(line, ErrDuplicateLabel, "device %q is already loaded at line %d", label, number)
+			} else {
+				loaded[label] = line.Number
+			}
+			if initialized {
+				report(line, nil, "device %q is loaded after initialization and is not initialized", label)
+			}
+		case CFGProperty:
+			if label == coreLabel {
+				if line.arg(1) == cfgInitializeProperty {
+					initialized = line.arg(2) == "1"
+					if !initialized {
+						loaded = make(map[string]int)
+					}
+				}
+				continue
+			}
+			checkLoaded(line, label, loaded)
+			key := [2]string{label, line.arg(1)}
+			if !initialized {
+				pre_init[key] = true
+			} else if pre_init[key] {
+				report(line, nil, "pre-init property %q of %q is set after initialization", line.arg(1), label)
+			}
+		case CFGParent:
+			checkLoaded(line, label, loaded)
+			checkLoaded(line, line.arg(1), loaded)
+		case CFGLabel:
+			checkLoaded(line, label, loaded)
+			if state_labels[label] == nil {
+				state_labels[label] = make(map[string]bool)
+			}
+			state_labels[label][line.arg(2)] = true
+		case CFGDelay, CFGFocusDirection:
+			checkLoaded(line, label, loaded)
+		case CFGConfigGroup:
+			if len(line.Args) >= 4 {
+				settings = append(settings, presetSetting{line, "group", line.arg(0), line.arg(1), line.arg(2), line.arg(3), line.arg(4)})
+			}
+		case CFGConfig:
+			settings = append(settings, presetSetting{line, "configuration", "", line.arg(0), line.arg(1), line.arg(2), line.arg(3)})
+		case CFGConfigPixelSize:
+			settings = append(settings, presetSetting{line, "pixel size configuration", "", line.arg(0), line.arg(1), line.arg(2), line.arg(3)})
+		}
+	}
+
+	for _, s := range settings {
+		preset := fmt.Sprintf("%s %q", s.kind, s.preset)
+		if s.group != "" {
+			preset = fmt.Sprintf("preset %q of group %q", s.preset, s.group)
+		}
+		if s.label != coreLabel && !ever_loaded[s.label] {
+			report(s.line, ErrInvalidLabel, "%s refers to device %q, which is never loaded", preset, s.label)
+			continue
+		}
+		if s.property == labelProperty {
+			if labels, ok := state_labels[s.label]; ok && !labels[s.value] {
+				report(s.line, nil, "%s sets %q to %q, which is not a state label of the device", preset, s.label, s.value)
+			}
+		}
+		if s.property == stateProperty && state_labels[s.label] != nil {
+			if _, err := strconv.Atoi(s.value); err != nil {
+				report(s.line, nil, "%s sets the state of %q to %q, which is not a number", preset, s.label, s.value)
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+// LintSystemConfigurationAgainst checks a configuration file with LintSystemConfiguration,
+// and checks the modules and the devices of the Device lines against the devices
+// available to c, as listed by GetAvailableDevices. No device is loaded.
+func LintSystemConfigurationAgainst(c DeviceControl, cfg *SystemConfiguration) []LintIssue {
+	issues := LintSystemConfiguration(cfg)
+
+	type module struct {
+		dev_names map[string]bool
+		err       error
+	}
+	modules := make(map[string]*module)
+	for _, line := range cfg.Lines {
+		if line.Command != CFGDevice {
+			continue
+		}
+		module_name, dev_name := line.arg(1), line.arg(2)
+		m, ok := modules[module_name]
+		if !ok {
+			m = &module{dev_names: make(map[string]bool)}
+			var dev_names []string
+			if dev_names, m.err = c.GetAvailableDevices(module_name); m.err == nil {
+				for _, name := range dev_names {
+					m.dev_names[name] = true
+				}
+			}
+			modules[module_name] = m
+		}
+		if m.err != nil {
+			issues = append(issues, LintIssue{line.Number, line.String(), m.err,
+				fmt.Sprintf("module %q is not available: %v", module_name, m.err)})
+		} else if !m.dev_names[dev_name] {
+			issues = append(issues, LintIssue{line.Number, line.String(), ErrCreateNotFound,
+				fmt.Sprintf("module %q has no device %q", module_name, dev_name)})
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+// LintPreInitProperties reports the pre-init properties of the devices that a
+// configuration file sets after initialization only, which LintSystemConfiguration
+// cannot tell from the file. To find them, it loads the devices of the file into
+// scratch, without initializing them, and unloads them at the end.
+//
+// scratch must be a session used only for this, never one that controls the
+// hardware: loading a device creates an instance of its adapter, which may open
+// ports. If a device is loaded in scratch, LintPreInitProperties returns
+// ErrDuplicateLabel. Devices that cannot be loaded are skipped; see
+// LintSystemConfigurationAgainst.
+func LintPreInitProperties(scratch DeviceControl, cfg *SystemConfiguration) ([]LintIssue, error) {
+	labels, err := scratch.GetLoadedDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range labels {
+		if label != coreLabel {
+			return nil, ErrDuplicateLabel
+		}
+	}
+	defer scratch.UnloadAllDevices()
+
+	pre_init := make(map[string]map[string]bool) // label -> pre-init properties
+	for _, line := range cfg.Lines {
+		label := line.arg(0)
+		if _, ok := pre_init[label]; line.Command != CFGDevice || ok || label == coreLabel {
+			continue
+		}
+		pre_init[label] = nil
+		if err := scratch.LoadDevice(label, line.arg(1), line.arg(2)); err != nil {
+			continue
+		}
+		descs, err := scratch.DescribeDevice(label)
+		if err != nil {
+			return nil, err
+		}
+		pre_init[label] = make(map[string]bool)
+		for _, desc := range descs {
+			if desc.PreInit {
+				pre_init[label][desc.Name] = true
+			}
+		}
+	}
+
+	var issues []LintIssue
+	initialized := false
+	set_before := make(map[[2]string]bool)
+	for _, line := range cfg.Lines {
+		label, property := line.arg(0), line.arg(1)
+		if line.Command != CFGProperty {
+			continue
+		}
+		if label == coreLabel {
+			if property == cfgInitializeProperty {
+				initialized = line.arg(2) == "1"
+			}
+			continue
+		}
+		key := [2]string{label, property}
+		if !initialized {
+			set_before[key] = true
+		} else if pre_init[label][property] && !set_before[key] {
+			issues = append(issues, LintIssue{line.Number, line.String(), nil,
+				fmt.Sprintf("pre-init property %q of %q is set after initialization", property, label)})
+		}
+	}
+	return issues, nil
+}
@@ -0,0 +1,134 @@
+package mmcore_test
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+const goodCFG = `Property,Core,Initialize,0
+Device,Camera,DemoCamera,DCam
+Device,Wheel,DemoCamera,DWheel
+Property,Camera,MaximumExposureMs,500
+Property,Core,Initialize,1
+Label,Wheel,0,DAPI
+ConfigGroup,Channel,DAPI,Wheel,Label,DAPI
+Property,Core,Camera,Camera
+`
+
+const badCFG = `Property,Core,Initialize,0
+Device,Camera,DemoCamera,DCam
+Device,Wheel,DemoCamera,DWheel
+Device,Camera,DemoCamera,DStage
+Device,XY,DemoCamera,DXYStages
+Device,Laser,Vendor,Laser
+Property,Camera,MaximumExposureMs,500
+Property,Shutter,State,0
+Property,Core,Initialize,1
+Property,Camera,MaximumExposureMs,1000
+Label,Wheel,0,DAPI
+Label,Wheel,1,FITC
+ConfigGroup,Channel,DAPI,Wheel,Label,DAPI
+ConfigGroup,Channel,TRITC,Wheel,Label,TRITC
+ConfigGroup,Channel,TRITC,Shutter,State,1
+`
+
+func ExampleLintSystemConfigurationAgainst() {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(badCFG))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, issue := range mmcore.LintSystemConfigurationAgainst(mmc, cfg) {
+		fmt.Println(issue)
+	}
+
+	// Output:
+	// line 4: device "Camera" is already loaded at line 2: "Device,Camera,DemoCamera,DStage"
+	// line 5: module "DemoCamera" has no device "DXYStages": "Device,XY,DemoCamera,DXYStages"
+	// line 6: module "Vendor" is not available: load library failed: "Device,Laser,Vendor,Laser"
+	// line 8: device "Shutter" is never loaded: "Property,Shutter,State,0"
+	// line 10: pre-init property "MaximumExposureMs" of "Camera" is set after initialization: "Property,Camera,MaximumExposureMs,1000"
+	// line 14: preset "TRITC" of group "Channel" sets "Wheel" to "TRITC", which is not a state label of the device: "ConfigGroup,Channel,TRITC,Wheel,Label,TRITC"
+	// line 15: preset "TRITC" of group "Channel" refers to device "Shutter", which is never loaded: "ConfigGroup,Channel,TRITC,Shutter,State,1"
+}
+
+func TestLintSystemConfiguration(t *testing.T) {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(goodCFG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := mmcore.LintSystemConfigurationAgainst(sim.NewSession(), cfg); len(issues) != 0 {
+		t.Errorf("good configuration: unexpected issues %v", issues)
+	}
+
+	tests := []struct {
+		cfg string
+		err error
+	}{
+		{"Device,Core,DemoCamera,DCam", mmcore.ErrDuplicateLabel},
+		{"Device,Z,DemoCamera,DStage\nDevice,Z,DemoCamera,DStage", mmcore.ErrDuplicateLabel},
+		{"Property,Z,Position,0\nDevice,Z,DemoCamera,DStage", mmcore.ErrInvalidLabel},
+		{"Device,Z,DemoCamera,DStage\nProperty,Core,Initialize,0\nFocusDirection,Z,1", mmcore.ErrInvalidLabel},
+		{"Device,Hub,DemoCamera,DHub\nParent,Z,Hub", mmcore.ErrInvalidLabel},
+		{"Label,Wheel,0,DAPI", mmcore.ErrInvalidLabel},
+		{"Config,Channel,Wheel,State,1", mmcore.ErrInvalidLabel},
+		{"Device,Wheel,DemoCamera,DWheel\nLabel,Wheel,0,DAPI\nConfigGroup,Channel,DAPI,Wheel,State,DAPI", nil},
+		{"Property,Core,Initialize,1\nDevice,Z,DemoCamera,DStage", nil},
+		{"Device,Camera,DemoCamera,DCam\nProperty,Camera,MaximumExposureMs,500\nProperty,Core,Initialize,1\nProperty,Camera,MaximumExposureMs,1000", nil},
+	}
+	for _, test := range tests {
+		cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(test.cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		issues := mmcore.LintSystemConfiguration(cfg)
+		if len(issues) != 1 {
+			t.Errorf("%q: issues %v, want one", test.cfg, issues)
+			continue
+		}
+		if issues[0].Err != test.err {
+			t.Errorf("%q: %v: error %v, want %v", test.cfg, issues[0], issues[0].Err, test.err)
+		}
+	}
+}
+
+func TestLintPreInitProperties(t *testing.T) {
+	// MaximumExposureMs is a pre-init property of the camera, but the file
+	// sets it only after initialization.
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(
+		"Device,Camera,DemoCamera,DCam\nProperty,Core,Initialize,1\nProperty,Camera,MaximumExposureMs,1000\nProperty,Camera,Exposure,20\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := mmcore.LintSystemConfiguration(cfg); len(issues) != 0 {
+		t.Errorf("LintSystemConfiguration: unexpected issues %v", issues)
+	}
+
+	scratch := sim.NewSession()
+	defer scratch.Close()
+	issues, err := mmcore.LintPreInitProperties(scratch, cfg)
+	if err != nil || len(issues) != 1 || issues[0].Line != 3 {
+		t.Errorf("LintPreInitProperties: issues %v, %v, want one at line 3", issues, err)
+	}
+	if labels, _ := scratch.GetLoadedDevices(); len(labels) != 1 {
+		t.Errorf("devices %v are still loaded after linting", labels)
+	}
+
+	// A session with devices is refused, and left as it is.
+	if err := scratch.LoadDevice("Camera", "DemoCamera", "DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mmcore.LintPreInitProperties(scratch, cfg); err != mmcore.ErrDuplicateLabel {
+		t.Errorf("LintPreInitProperties with a loaded device: %v, want ErrDuplicateLabel", err)
+	}
+	if labels, _ := scratch.GetLoadedDevices(); len(labels) != 2 {
+		t.Errorf("devices %v after a refused lint", labels)
+	}
+}
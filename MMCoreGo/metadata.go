@@ -0,0 +1,76 @@
+package mmcore
+
+import (
+	"strconv"
+	"time"
+)
+
+// Keys of the metadata tags that MMCore adds to the images of sequence acquisitions.
+const (
+	MetadataBinning            = "Binning"
+	MetadataCamera             = "Camera"
+	MetadataCameraChannelName  = "CameraChannelName"
+	MetadataCameraChannelIndex = "CameraChannelIndex"
+	MetadataElapsedTime        = "ElapsedTime-ms"
+	MetadataHeight             = "Height"
+	MetadataImageNumber        = "ImageNumber"
+	MetadataPixelType          = "PixelType"
+	MetadataROIX               = "ROI-X-start"
+	MetadataROIY               = "ROI-Y-start"
+	MetadataTimeReceived       = "TimeReceivedByCore"
+	MetadataWidth              = "Width"
+)
+
+// metadataTimeReceivedFormat is the layout of TimeReceivedByCore. Parsing also
+// accepts other numbers of fractional digits.
+const metadataTimeReceivedFormat = "2006-01-02 15:04:05.000000"
+
+// ImageMetadata is the metadata of an image of a sequence acquisition.
+//
+// The fields are parsed from the tags, and are zero if their tag is missing or
+// cannot be parsed. Tags has all the tags, including the ones of the fields.
+type ImageMetadata struct {
+	Camera       string
+	ImageNumber  int64
+	ElapsedTime  time.Duration // since the start of the sequence acquisition
+	TimeReceived time.Time     // when the core received the image
+	Binning      int
+	PixelType    string
+	ROIX, ROIY   int    // position of the ROI on the sensor, in binned pixels
+	Channel      string // channel of a multi-channel camera
+	ChannelIndex int
+	Tags         map[string]string
+}
+
+// NewImageMetadata parses metadata tags, for implementations of Core.
+// TimeReceivedByCore is parsed in the local time zone, as MMCore writes it.
+func NewImageMetadata(tags map[string]string) *ImageMetadata {
+	atoi := func(key string) int {
+		v, _ := strconv.Atoi(tags[key])
+		return v
+	}
+	md := &ImageMetadata{
+		Camera:       tags[MetadataCamera],
+		Binning:      atoi(MetadataBinning),
+		PixelType:    tags[MetadataPixelType],
+		ROIX:         atoi(MetadataROIX),
+		ROIY:         atoi(MetadataROIY),
+		Channel:      tags[MetadataCameraChannelName],
+		ChannelIndex: atoi(MetadataCameraChannelIndex),
+		Tags:         tags,
+	}
+	md.ImageNumber, _ = strconv.ParseInt(tags[MetadataImageNumber], 10, 64)
+	if ms, err := strconv.ParseFloat(tags[MetadataElapsedTime], 64); err == nil {
+		md.ElapsedTime = time.Duration(ms * float64(time.Millisecond))
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", tags[MetadataTimeReceived], time.Local); err == nil {
+		md.TimeReceived = t
+	}
+	return md
+}
+
+// FormatMetadataTimeReceived formats a time as the TimeReceivedByCore tag of MMCore,
+// for implementations of Core.
+func FormatMetadataTimeReceived(t time.Time) string {
+	return t.Local().Format(metadataTimeReceivedFormat)
+}
@@ -1,47 +1,112 @@
 // Package mmcore provides Go interface to Micro-Manager Core API for automated microscopy.
 package mmcore
 
-// #cgo CFLAGS: -I../MMCoreC
-// #cgo LDFLAGS: -L../lib -lMMCoreC
+// #cgo CFLAGS: -I${SRCDIR}/../MMCoreC
 //
 // #include <stdlib.h>
 //
 // #include "MMCoreC.h"
 //
-// void c_registerCallback(MM_Session mm);
+// struct MM_EventCallback *c_newCallback(void);
+// extern void onPropertiesChanged(MM_Session mm);
 // extern void onPropertyChanged(MM_Session mm, char *label, char *property, char *value);
+// extern void onConfigGroupChanged(MM_Session mm, char *group_name, char *config_name);
+// extern void onSystemConfigurationLoaded(MM_Session mm);
+// extern void onPixelSizeChanged(MM_Session mm, double pixel_size_um);
 // extern void onStagePositionChanged(MM_Session mm, char *label, double pos);
+// extern void onXYStagePositionChanged(MM_Session mm, char *label, double x, double y);
+// extern void onExposureChanged(MM_Session mm, char *label, double exposure_ms);
+// extern void onSLMExposureChanged(MM_Session mm, char *label, double exposure_ms);
 import "C"
 
 import (
+	"context"
+	"io"
+	"math"
 	"sync"
 	"unsafe"
 )
 
-var go_session map[C.MM_Session]*Session
+// go_session maps the C sessions to the Go sessions, for the event callbacks,
+// which are called from the threads of MMCore and the device adapters.
+var go_session = struct {
+	sync.RWMutex
+	m map[C.MM_Session]*Session
+}{m: make(map[C.MM_Session]*Session)}
 
+var _ Core = (*Session)(nil)
+
+// Session is a Micro-Manager Core session.
+//
+// A Session is safe for concurrent use by multiple goroutines, except for Close,
+// which must not be called concurrently with other calls. The session must not be
+// used after Close.
+//
+// MMCore serializes the access to each device, so calls to different devices can
+// run in parallel, and calls to the same device run one after another.
+//
+// While a sequence acquisition is running, images can be taken from the circular buffer
+// with PopNextImage, GetLastImage and GetRemainingImageCount, and other devices can be
+// controlled from other goroutines. MMCore refuses SnapImage and changes of the current
+// camera or the ROI with ErrNotAllowedDuringSequenceAcquisition. The calls that could
+// change the size of the images or invalidate the image buffers wait until the images
+// being copied into Go memory are copied, and until borrowed images are released.
+//
+// Events are delivered to the subscribers from goroutines of the subscriptions,
+// never from the thread of the call that caused them.
 type Session struct {
-	mmcore C.MM_Session
+	mmcore    C.MM_Session
+	closeOnce sync.Once
+
+	// imageMu is held while an image is copied from a buffer of MMCore,
+	// and by the calls that can change the image size or free the buffers.
+	// bufferMu is held by the calls of the circular buffer, by the calls that
+	// can change the image size, and by a BorrowedImage until its Release.
+	imageMu  sync.Mutex
+	bufferMu sync.Mutex
 
 	// Events
-	c_callback_registered bool
-	propertyChanged       []chan<- *PropertyChangedEvent
-	stagePositionChanged  []chan<- *StagePositionChangedEvent
+	callbackMu sync.Mutex
+	c_callback *C.struct_MM_EventCallback
+	events     EventBus
 }
 
 func NewSession() *Session {
 	var s Session
 	C.MM_Open(&s.mmcore)
-	if go_session == nil {
-		go_session = make(map[C.MM_Session]*Session)
-	}
-	go_session[s.mmcore] = &s
+
+	go_session.Lock()
+	go_session.m[s.mmcore] = &s
+	go_session.Unlock()
 	return &s
 }
 
+// Close closes the session and unsubscribes all event subscribers.
+// Calling Close more than once has no effect.
 func (s *Session) Close() {
-	delete(go_session, s.mmcore)
-	C.MM_Close(s.mmcore)
+	s.closeOnce.Do(func() {
+		C.MM_Close(s.mmcore)
+
+		go_session.Lock()
+		delete(go_session.m, s.mmcore)
+		go_session.Unlock()
+
+		s.events.Close()
+
+		s.callbackMu.Lock()
+		if s.c_callback != nil {
+			C.free(unsafe.Pointer(s.c_callback))
+			s.c_callback = nil
+		}
+		s.callbackMu.Unlock()
+	})
+}
+
+// lookupSession returns the Go session of the C session, or nil if it is closed.
+func lookupSession(mmcore C.MM_Session) *Session {
+	go_session.RLock()
+	defer go_session.RUnlock()
+	return go_session.m[mmcore]
 }
 
 // VersionInfo returns the version of Micro-Manager Core.
@@ -83,6 +148,9 @@ func (s *Session) LoadDevice(label, module_name, dev_name string) error {
 }
 
 func (s *Session) UnloadDevice(label string) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	c_label := C.CString(label)
 	defer C.free(unsafe.Pointer(c_label))
 
@@ -91,16 +159,25 @@ func (s *Session) UnloadDevice(label string) error {
 }
 
 func (s *Session) UnloadAllDevices() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_UnloadAllDevices(s.mmcore)
 	return statusToError(status)
 }
 
 func (s *Session) InitializeAllDevices() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_InitializeAllDevices(s.mmcore)
 	return statusToError(status)
 }
 
 func (s *Session) InitializeDevice(label string) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	c_label := C.CString(label)
 	defer C.free(unsafe.Pointer(c_label))
 
@@ -108,8 +185,20 @@ func (s *Session) InitializeDevice(label string) error {
 	return statusToError(status)
 }
 
+// Reset unloads all devices and resets the core to the initial state.
+// The event subscriptions stay active.
 func (s *Session) Reset() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+	s.callbackMu.Lock()
+	defer s.callbackMu.Unlock()
+
 	status := C.MM_Reset(s.mmcore)
+
+	// MM_Reset unregisters the callback, so register it again.
+	if s.c_callback != nil {
+		C.MM_RegisterCallback(s.mmcore, s.c_callback)
+	}
 	return statusToError(status)
 }
 
@@ -117,37 +206,77 @@ func (s *Session) Reset() error {
 // Event notification
 //
 
-type PropertyChangedEvent struct {
-	Label    string
-	Property string
-	Value    string
-}
+// The Notify methods subscribe a channel to an event type. Events are queued for
+// each subscriber, so that the MMCore callback thread is not held up by slow
+// subscribers. See Subscription and the SubscribeOption functions.
+
+// registerCallback registers the callback on the C side, if not already done.
+func (s *Session) registerCallback() {
+	s.callbackMu.Lock()
+	defer s.callbackMu.Unlock()
 
-type StagePositionChangedEvent struct {
-	Label string
-	Pos   float64
+	if s.c_callback == nil {
+		s.c_callback = C.c_newCallback()
+		C.MM_RegisterCallback(s.mmcore, s.c_callback)
+	}
 }
 
-func (s *Session) NotifyPropertyChanged(event chan<- *PropertyChangedEvent) {
-	// Register the callback on the C side, if not already done.
-	if !s.c_callback_registered {
-		C.c_registerCallback(s.mmcore)
-		s.c_callback_registered = true
+// publish delivers an event of the C session to the subscribers of the Go session.
+func publish(mmcore C.MM_Session, event interface{}) {
+	if s := lookupSession(mmcore); s != nil {
+		s.events.Publish(event)
 	}
+}
 
-	// Save the channel for sending events.
-	s.propertyChanged = append(s.propertyChanged, event)
+func (s *Session) NotifyPropertiesChanged(event chan<- *PropertiesChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyPropertiesChanged(event, opts...)
 }
 
-func (s *Session) NotifyStagePositionChanged(event chan<- *StagePositionChangedEvent) {
-	// Register the callback on the C side, if not already done.
-	if !s.c_callback_registered {
-		C.c_registerCallback(s.mmcore)
-		s.c_callback_registered = true
-	}
+func (s *Session) NotifyPropertyChanged(event chan<- *PropertyChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyPropertyChanged(event, opts...)
+}
+
+func (s *Session) NotifyConfigGroupChanged(event chan<- *ConfigGroupChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyConfigGroupChanged(event, opts...)
+}
+
+func (s *Session) NotifySystemConfigurationLoaded(event chan<- *SystemConfigurationLoadedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifySystemConfigurationLoaded(event, opts...)
+}
+
+func (s *Session) NotifyPixelSizeChanged(event chan<- *PixelSizeChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyPixelSizeChanged(event, opts...)
+}
+
+func (s *Session) NotifyStagePositionChanged(event chan<- *StagePositionChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyStagePositionChanged(event, opts...)
+}
 
-	// Save the channel for sending events.
-	s.stagePositionChanged = append(s.stagePositionChanged, event)
+func (s *Session) NotifyXYStagePositionChanged(event chan<- *XYStagePositionChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyXYStagePositionChanged(event, opts...)
+}
+
+func (s *Session) NotifyExposureChanged(event chan<- *ExposureChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifyExposureChanged(event, opts...)
+}
+
+func (s *Session) NotifySLMExposureChanged(event chan<- *SLMExposureChangedEvent, opts ...SubscribeOption) *Subscription {
+	s.registerCallback()
+	return s.events.NotifySLMExposureChanged(event, opts...)
+}
+
+//export onPropertiesChanged
+func onPropertiesChanged(mmcore C.MM_Session) {
+	event := &PropertiesChangedEvent{}
+	publish(mmcore, event)
 }
 
 //export onPropertyChanged
@@ -157,17 +286,30 @@ func onPropertyChanged(mmcore C.MM_Session, label *C.char, property *C.char, val
 		Property: C.GoString(property),
 		Value:    C.GoString(value),
 	}
+	publish(mmcore, event)
+}
+
+//export onConfigGroupChanged
+func onConfigGroupChanged(mmcore C.MM_Session, group_name *C.char, config_name *C.char) {
+	event := &ConfigGroupChangedEvent{
+		GroupName:  C.GoString(group_name),
+		ConfigName: C.GoString(config_name),
+	}
+	publish(mmcore, event)
+}
 
-	// Notify the listeners
-	var wg sync.WaitGroup
-	for _, ch := range go_session[mmcore].propertyChanged {
-		wg.Add(1)
-		go func() {
-			ch <- event
-			wg.Done()
-		}()
+//export onSystemConfigurationLoaded
+func onSystemConfigurationLoaded(mmcore C.MM_Session) {
+	event := &SystemConfigurationLoadedEvent{}
+	publish(mmcore, event)
+}
+
+//export onPixelSizeChanged
+func onPixelSizeChanged(mmcore C.MM_Session, pixel_size_um C.double) {
+	event := &PixelSizeChangedEvent{
+		PixelSizeUm: float64(pixel_size_um),
 	}
-	wg.Wait()
+	publish(mmcore, event)
 }
 
 //export onStagePositionChanged
@@ -176,17 +318,35 @@ func onStagePositionChanged(mmcore C.MM_Session, label *C.char, pos C.double) {
 		Label: C.GoString(label),
 		Pos:   float64(pos),
 	}
+	publish(mmcore, event)
+}
+
+//export onXYStagePositionChanged
+func onXYStagePositionChanged(mmcore C.MM_Session, label *C.char, x C.double, y C.double) {
+	event := &XYStagePositionChangedEvent{
+		Label: C.GoString(label),
+		X:     float64(x),
+		Y:     float64(y),
+	}
+	publish(mmcore, event)
+}
 
-	// Notify the listeners
-	var wg sync.WaitGroup
-	for _, ch := range go_session[mmcore].stagePositionChanged {
-		wg.Add(1)
-		go func() {
-			ch <- event
-			wg.Done()
-		}()
+//export onExposureChanged
+func onExposureChanged(mmcore C.MM_Session, label *C.char, exposure_ms C.double) {
+	event := &ExposureChangedEvent{
+		Label:      C.GoString(label),
+		ExposureMs: float64(exposure_ms),
 	}
-	wg.Wait()
+	publish(mmcore, event)
+}
+
+//export onSLMExposureChanged
+func onSLMExposureChanged(mmcore C.MM_Session, label *C.char, exposure_ms C.double) {
+	event := &SLMExposureChangedEvent{
+		Label:      C.GoString(label),
+		ExposureMs: float64(exposure_ms),
+	}
+	publish(mmcore, event)
 }
 
 //
@@ -277,6 +437,47 @@ func (s *Session) GetLoadedDevices() (labels []string, err error) {
 	return
 }
 
+// GetDeviceType returns the type of the device.
+func (s *Session) GetDeviceType(label string) (device_type DeviceType, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_type C.MM_DeviceType
+	status := C.MM_GetDeviceType(s.mmcore, c_label, &c_type)
+
+	device_type = DeviceType(c_type)
+	err = statusToError(status)
+	return
+}
+
+// GetDeviceLibrary returns the name of the device adapter module the device was loaded from.
+func (s *Session) GetDeviceLibrary(label string) (module_name string, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_module_name *C.char
+	status := C.MM_GetDeviceLibrary(s.mmcore, c_label, &c_module_name)
+	defer C.MM_StringFree(c_module_name)
+
+	module_name = C.GoString(c_module_name)
+	err = statusToError(status)
+	return
+}
+
+// GetDeviceName returns the name of the device in its device adapter module.
+func (s *Session) GetDeviceName(label string) (dev_name string, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_dev_name *C.char
+	status := C.MM_GetDeviceName(s.mmcore, c_label, &c_dev_name)
+	defer C.MM_StringFree(c_dev_name)
+
+	dev_name = C.GoString(c_dev_name)
+	err = statusToError(status)
+	return
+}
+
 // GetDevicePropertyNames returns all property names supported by the device.
 func (s *Session) GetDevicePropertyNames(label string) (names []string, err error) {
 	c_label := C.CString(label)
@@ -321,30 +522,77 @@ func (s *Session) GetProperty(label string, property string) (value string, err
 	return
 }
 
+// changesImage reports whether setting a property of the device can change the
+// geometry of the images: the properties of the current camera, and of the core,
+// which selects the camera. Only these writes wait for the image copies and the
+// borrowed images.
+func (s *Session) changesImage(label string) bool {
+	return label == coreLabel || label == s.CameraDevice()
+}
+
+// settingsChangeImage reports whether applying the settings of a preset can change
+// the geometry of the images.
+func (s *Session) settingsChangeImage(settings []PropertySetting) bool {
+	for _, setting := range settings {
+		if s.changesImage(setting.Label) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockBuffer locks the circular buffer and the image copies, for the calls that can
+// free the circular buffer or change the geometry of the images.
+// bufferMu is always locked before imageMu.
+func (s *Session) lockBuffer() {
+	s.bufferMu.Lock()
+	s.imageMu.Lock()
+}
+
+func (s *Session) unlockBuffer() {
+	s.imageMu.Unlock()
+	s.bufferMu.Unlock()
+}
+
+// SetProperty sets the property value of the device.
+//
+// state can be a bool, a number of any kind, a string or a fmt.Stringer, converted as
+// by FormatPropertyValue. Other types and nil values are refused with a
+// *PropertyValueError. The value is not checked on the client side; use
+// SetPropertyChecked for that.
 func (s *Session) SetProperty(label string, property string, state interface{}) (err error) {
+	value, ok := toPropertyValue(state)
+	if !ok {
+		_, err = FormatPropertyValue(label, property, state)
+		return err
+	}
+
+	if s.changesImage(label) {
+		s.lockBuffer()
+		defer s.unlockBuffer()
+	}
+
 	c_label := C.CString(label)
 	c_property := C.CString(property)
 	defer C.free(unsafe.Pointer(c_label))
 	defer C.free(unsafe.Pointer(c_property))
 
 	var status C.MM_Status
-	switch state.(type) {
-	case bool:
+	switch value.kind {
+	case boolValue:
 		var c_state C.uint8_t
-		if state.(bool) {
+		if value.b {
 			c_state = 1
-		} else {
-			c_state = 0
 		}
 		status = C.MM_SetPropertyBool(s.mmcore, c_label, c_property, c_state)
-	case int:
-		status = C.MM_SetPropertyInt(s.mmcore, c_label, c_property, (C.int32_t)(state.(int)))
-	case float32:
-		status = C.MM_SetPropertyFloat(s.mmcore, c_label, c_property, (C.float)(state.(float32)))
-	case float64:
-		status = C.MM_SetPropertyDouble(s.mmcore, c_label, c_property, (C.double)(state.(float64)))
-	case string:
-		c_state := C.CString(state.(string))
+	case intValue:
+		status = C.MM_SetPropertyInt(s.mmcore, c_label, c_property, C.int32_t(value.i))
+	case float32Value:
+		status = C.MM_SetPropertyFloat(s.mmcore, c_label, c_property, C.float(value.f))
+	case float64Value:
+		status = C.MM_SetPropertyDouble(s.mmcore, c_label, c_property, C.double(value.f))
+	default:
+		c_state := C.CString(value.s)
 		status = C.MM_SetPropertyString(s.mmcore, c_label, c_property, c_state)
 		C.free(unsafe.Pointer(c_state))
 	}
@@ -450,11 +698,182 @@ func (s *Session) GetPropertyUpperLimit(label string, property string) (upper_li
 	return
 }
 
+// PropertyType returns the type of the property value.
+func (s *Session) PropertyType(label string, property string) (property_type PropertyType, err error) {
+	c_label := C.CString(label)
+	c_property := C.CString(property)
+	defer C.free(unsafe.Pointer(c_label))
+	defer C.free(unsafe.Pointer(c_property))
+
+	var c_type C.MM_PropertyType
+	status := C.MM_GetPropertyType(s.mmcore, c_label, c_property, &c_type)
+
+	property_type = PropertyType(c_type)
+	err = statusToError(status)
+	return
+}
+
+// DescribeProperty returns the value and all metadata of the property in a single call to MMCoreC.
+func (s *Session) DescribeProperty(label string, property string) (desc PropertyDescriptor, err error) {
+	c_label := C.CString(label)
+	c_property := C.CString(property)
+	defer C.free(unsafe.Pointer(c_label))
+	defer C.free(unsafe.Pointer(c_property))
+
+	var c_desc *C.MM_PropertyDescriptor
+	status := C.MM_DescribeProperty(s.mmcore, c_label, c_property, &c_desc)
+	defer C.MM_PropertyDescriptorsFree(c_desc, 1)
+
+	if err = statusToError(status); err != nil {
+		return
+	}
+	desc = goPropertyDescriptor(c_desc)
+	return
+}
+
+// DescribeDevice returns the descriptors of all properties of the device,
+// sorted by name as with GetDevicePropertyNames, in a single call to MMCoreC.
+func (s *Session) DescribeDevice(label string) (descs []PropertyDescriptor, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_descs *C.MM_PropertyDescriptor
+	var c_len C.size_t
+	status := C.MM_DescribeDevice(s.mmcore, c_label, &c_descs, &c_len)
+	defer C.MM_PropertyDescriptorsFree(c_descs, c_len)
+
+	if err = statusToError(status); err != nil {
+		return
+	}
+	descs = make([]PropertyDescriptor, int(c_len))
+	if c_len > 0 {
+		c_desc_slice := (*[1 << 20]C.MM_PropertyDescriptor)(unsafe.Pointer(c_descs))[:c_len:c_len]
+		for i := range c_desc_slice {
+			descs[i] = goPropertyDescriptor(&c_desc_slice[i])
+		}
+	}
+	return
+}
+
+// getPropertyWithType returns the value and the type of the property.
+func (s *Session) getPropertyWithType(label string, property string) (value string, property_type PropertyType, err error) {
+	if value, err = s.GetProperty(label, property); err != nil {
+		return
+	}
+	property_type, err = s.PropertyType(label, property)
+	return
+}
+
+// GetPropertyFloat returns the value of a Float or Integer property.
+// It returns a *PropertyTypeError for properties of other types.
+func (s *Session) GetPropertyFloat(label string, property string) (value float64, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return ParsePropertyFloat(label, property, str, property_type)
+}
+
+// GetPropertyInt returns the value of an Integer property.
+// It returns a *PropertyTypeError for properties of other types.
+func (s *Session) GetPropertyInt(label string, property string) (value int, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return ParsePropertyInt(label, property, str, property_type)
+}
+
+// GetPropertyBool returns the value of a property that is "0" or "1".
+// It returns a *PropertyTypeError for other values, and for Float properties.
+func (s *Session) GetPropertyBool(label string, property string) (value bool, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return false, err
+	}
+	return ParsePropertyBool(label, property, str, property_type)
+}
+
+//
+// Device status.
+//
+
+// DeviceBusy reports whether the device is busy, for example while a stage is moving.
+func (s *Session) DeviceBusy(label string) (busy bool, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_busy C.uint8_t
+	status := C.MM_DeviceBusy(s.mmcore, c_label, &c_busy)
+
+	busy = goBool(c_busy)
+	err = statusToError(status)
+	return
+}
+
+// DeviceTypeBusy reports whether any loaded device of the type is busy.
+func (s *Session) DeviceTypeBusy(device_type DeviceType) (busy bool, err error) {
+	var c_busy C.uint8_t
+	status := C.MM_DeviceTypeBusy(s.mmcore, C.MM_DeviceType(device_type), &c_busy)
+
+	busy = goBool(c_busy)
+	err = statusToError(status)
+	return
+}
+
+// WaitForDevice waits until the device is no longer busy.
+// It returns ErrDevicePollingTimeout if the deadline of ctx is exceeded first,
+// and ctx.Err() if ctx is canceled.
+func (s *Session) WaitForDevice(ctx context.Context, label string) error {
+	return waitWhileBusy(ctx, func() (bool, error) {
+		return s.DeviceBusy(label)
+	})
+}
+
+// WaitForDeviceType waits until no loaded device of the type is busy.
+// It returns ErrDevicePollingTimeout if the deadline of ctx is exceeded first,
+// and ctx.Err() if ctx is canceled.
+func (s *Session) WaitForDeviceType(ctx context.Context, device_type DeviceType) error {
+	return waitWhileBusy(ctx, func() (bool, error) {
+		return s.DeviceTypeBusy(device_type)
+	})
+}
+
+// WaitForSystem waits until no loaded device is busy.
+func (s *Session) WaitForSystem(ctx context.Context) error {
+	return s.WaitForDeviceType(ctx, AnyType)
+}
+
+// SetDeviceDelayMs sets the time the core waits after a command to the device,
+// as the Delay lines of configuration files do.
+func (s *Session) SetDeviceDelayMs(label string, delay_ms float64) error {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	status := C.MM_SetDeviceDelayMs(s.mmcore, c_label, C.double(delay_ms))
+	return statusToError(status)
+}
+
+func (s *Session) GetDeviceDelayMs(label string) (delay_ms float64, err error) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+
+	var c_delay_ms C.double
+	status := C.MM_GetDeviceDelayMs(s.mmcore, c_label, &c_delay_ms)
+
+	delay_ms = float64(c_delay_ms)
+	err = statusToError(status)
+	return
+}
+
 //
 // Manage current devices.
 //
 
 func (s *Session) SetCameraDevice(label string) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	c_label := C.CString(label)
 	defer C.free(unsafe.Pointer(c_label))
 
@@ -539,11 +958,400 @@ func (s *Session) AutoFocusDevice() (label string) {
 	return label
 }
 
+//
+// Configuration groups and presets.
+//
+// A configuration group, such as "Channel" or "Objective", has presets, such as
+// "DAPI" or "FITC". A preset is a list of property values that are set together.
+//
+
+func (s *Session) DefineConfigGroup(group_name string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+
+	status := C.MM_DefineConfigGroup(s.mmcore, c_group_name)
+	return statusToError(status)
+}
+
+func (s *Session) DeleteConfigGroup(group_name string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+
+	status := C.MM_DeleteConfigGroup(s.mmcore, c_group_name)
+	return statusToError(status)
+}
+
+func (s *Session) RenameConfigGroup(old_group_name string, new_group_name string) error {
+	c_old_group_name := C.CString(old_group_name)
+	defer C.free(unsafe.Pointer(c_old_group_name))
+	c_new_group_name := C.CString(new_group_name)
+	defer C.free(unsafe.Pointer(c_new_group_name))
+
+	status := C.MM_RenameConfigGroup(s.mmcore, c_old_group_name, c_new_group_name)
+	return statusToError(status)
+}
+
+func (s *Session) IsGroupDefined(group_name string) (defined bool, err error) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+
+	var c_defined C.uint8_t
+	status := C.MM_IsGroupDefined(s.mmcore, c_group_name, &c_defined)
+
+	defined = goBool(c_defined)
+	err = statusToError(status)
+	return
+}
+
+func (s *Session) GetAvailableConfigGroups() (group_names []string, err error) {
+	var c_group_names **C.char
+	status := C.MM_GetAvailableConfigGroups(s.mmcore, &c_group_names)
+	defer C.MM_StringListFree(c_group_names)
+
+	group_names = goStringList(c_group_names)
+	err = statusToError(status)
+	return
+}
+
+// DefineConfig defines an empty preset, and the group if needed.
+func (s *Session) DefineConfig(group_name string, config_name string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+
+	status := C.MM_DefineConfig(s.mmcore, c_group_name, c_config_name)
+	return statusToError(status)
+}
+
+// DefineConfigSetting adds a property value to a preset, or replaces it.
+// The group and the preset are defined if needed.
+func (s *Session) DefineConfigSetting(group_name string, config_name string, label string, property string, value string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+	c_property := C.CString(property)
+	defer C.free(unsafe.Pointer(c_property))
+	c_value := C.CString(value)
+	defer C.free(unsafe.Pointer(c_value))
+
+	status := C.MM_DefineConfigSetting(s.mmcore, c_group_name, c_config_name, c_label, c_property, c_value)
+	return statusToError(status)
+}
+
+func (s *Session) DeleteConfig(group_name string, config_name string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+
+	status := C.MM_DeleteConfig(s.mmcore, c_group_name, c_config_name)
+	return statusToError(status)
+}
+
+// DeleteConfigSetting removes a property value from a preset.
+func (s *Session) DeleteConfigSetting(group_name string, config_name string, label string, property string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+	c_property := C.CString(property)
+	defer C.free(unsafe.Pointer(c_property))
+
+	status := C.MM_DeleteConfigSetting(s.mmcore, c_group_name, c_config_name, c_label, c_property)
+	return statusToError(status)
+}
+
+func (s *Session) RenameConfig(group_name string, old_config_name string, new_config_name string) error {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_old_config_name := C.CString(old_config_name)
+	defer C.free(unsafe.Pointer(c_old_config_name))
+	c_new_config_name := C.CString(new_config_name)
+	defer C.free(unsafe.Pointer(c_new_config_name))
+
+	status := C.MM_RenameConfig(s.mmcore, c_group_name, c_old_config_name, c_new_config_name)
+	return statusToError(status)
+}
+
+func (s *Session) IsConfigDefined(group_name string, config_name string) (defined bool, err error) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+
+	var c_defined C.uint8_t
+	status := C.MM_IsConfigDefined(s.mmcore, c_group_name, c_config_name, &c_defined)
+
+	defined = goBool(c_defined)
+	err = statusToError(status)
+	return
+}
+
+func (s *Session) GetAvailableConfigs(group_name string) (config_names []string, err error) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+
+	var c_config_names **C.char
+	status := C.MM_GetAvailableConfigs(s.mmcore, c_group_name, &c_config_names)
+	defer C.MM_StringListFree(c_config_names)
+
+	config_names = goStringList(c_config_names)
+	err = statusToError(status)
+	return
+}
+
+// SetConfig sets the property values of the preset, and publishes a ConfigGroupChangedEvent.
+func (s *Session) SetConfig(group_name string, config_name string) error {
+	if settings, err := s.GetConfigData(group_name, config_name); err != nil || s.settingsChangeImage(settings) {
+		s.lockBuffer()
+		defer s.unlockBuffer()
+	}
+
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+
+	status := C.MM_SetConfig(s.mmcore, c_group_name, c_config_name)
+	return statusToError(status)
+}
+
+// GetCurrentConfig returns the preset of the group that matches the current property
+// values, or "" if no preset matches.
+func (s *Session) GetCurrentConfig(group_name string) (config_name string, err error) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+
+	var c_config_name *C.char
+	status := C.MM_GetCurrentConfig(s.mmcore, c_group_name, &c_config_name)
+	defer C.MM_StringFree(c_config_name)
+
+	config_name = C.GoString(c_config_name)
+	err = statusToError(status)
+	return
+}
+
+// WaitForConfig waits until no device of the preset is busy.
+// It returns ErrDevicePollingTimeout if the deadline of ctx is exceeded first,
+// and ctx.Err() if ctx is canceled.
+func (s *Session) WaitForConfig(ctx context.Context, group_name string, config_name string) error {
+	return waitForConfig(ctx, s, group_name, config_name)
+}
+
+// GetConfigData returns the property values of the preset.
+func (s *Session) GetConfigData(group_name string, config_name string) (settings []PropertySetting, err error) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+
+	var c_settings *C.MM_PropertySetting
+	var c_len C.size_t
+	status := C.MM_GetConfigData(s.mmcore, c_group_name, c_config_name, &c_settings, &c_len)
+	defer C.MM_PropertySettingsFree(c_settings, c_len)
+
+	if err = statusToError(status); err != nil {
+		return
+	}
+	settings = goPropertySettings(c_settings, c_len)
+	return
+}
+
+//
+// Pixel size configurations.
+//
+// A pixel size configuration, such as "Res10x", is a list of property values, such as
+// the label of the objective turret, with the pixel size and the affine transform from
+// image pixels to stage coordinates that apply when the properties have these values.
+//
+
+// DefinePixelSizeConfig adds a property value to a pixel size configuration, or replaces it.
+// The configuration is defined if needed.
+func (s *Session) DefinePixelSizeConfig(resolution_id string, label string, property string, value string) error {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+	c_property := C.CString(property)
+	defer C.free(unsafe.Pointer(c_property))
+	c_value := C.CString(value)
+	defer C.free(unsafe.Pointer(c_value))
+
+	status := C.MM_DefinePixelSizeConfig(s.mmcore, c_resolution_id, c_label, c_property, c_value)
+	return statusToError(status)
+}
+
+func (s *Session) SetPixelSizeUm(resolution_id string, pixel_size_um float64) error {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	status := C.MM_SetPixelSizeUm(s.mmcore, c_resolution_id, C.double(pixel_size_um))
+	return statusToError(status)
+}
+
+// SetPixelSizeAffine sets the affine transform of the configuration, given by the 6
+// elements of its first two rows. It returns ErrBadAffineTransform if affine has
+// another length.
+func (s *Session) SetPixelSizeAffine(resolution_id string, affine []float64) error {
+	if len(affine) != 6 {
+		return ErrBadAffineTransform
+	}
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	var c_affine [6]C.double
+	for i, a := range affine {
+		c_affine[i] = C.double(a)
+	}
+	status := C.MM_SetPixelSizeAffine(s.mmcore, c_resolution_id, &c_affine[0])
+	return statusToError(status)
+}
+
+func (s *Session) DeletePixelSizeConfig(resolution_id string) error {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	status := C.MM_DeletePixelSizeConfig(s.mmcore, c_resolution_id)
+	return statusToError(status)
+}
+
+func (s *Session) RenamePixelSizeConfig(old_resolution_id string, new_resolution_id string) error {
+	c_old_resolution_id := C.CString(old_resolution_id)
+	defer C.free(unsafe.Pointer(c_old_resolution_id))
+	c_new_resolution_id := C.CString(new_resolution_id)
+	defer C.free(unsafe.Pointer(c_new_resolution_id))
+
+	status := C.MM_RenamePixelSizeConfig(s.mmcore, c_old_resolution_id, c_new_resolution_id)
+	return statusToError(status)
+}
+
+func (s *Session) IsPixelSizeConfigDefined(resolution_id string) (defined bool, err error) {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	var c_defined C.uint8_t
+	status := C.MM_IsPixelSizeConfigDefined(s.mmcore, c_resolution_id, &c_defined)
+
+	defined = goBool(c_defined)
+	err = statusToError(status)
+	return
+}
+
+func (s *Session) GetAvailablePixelSizeConfigs() (resolution_ids []string, err error) {
+	var c_resolution_ids **C.char
+	status := C.MM_GetAvailablePixelSizeConfigs(s.mmcore, &c_resolution_ids)
+	defer C.MM_StringListFree(c_resolution_ids)
+
+	resolution_ids = goStringList(c_resolution_ids)
+	err = statusToError(status)
+	return
+}
+
+// GetPixelSizeConfigData returns the property values of the pixel size configuration.
+func (s *Session) GetPixelSizeConfigData(resolution_id string) (settings []PropertySetting, err error) {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	var c_settings *C.MM_PropertySetting
+	var c_len C.size_t
+	status := C.MM_GetPixelSizeConfigData(s.mmcore, c_resolution_id, &c_settings, &c_len)
+	defer C.MM_PropertySettingsFree(c_settings, c_len)
+
+	if err = statusToError(status); err != nil {
+		return
+	}
+	settings = goPropertySettings(c_settings, c_len)
+	return
+}
+
+// SetPixelSizeConfig sets the property values of the pixel size configuration.
+func (s *Session) SetPixelSizeConfig(resolution_id string) error {
+	if settings, err := s.GetPixelSizeConfigData(resolution_id); err != nil || s.settingsChangeImage(settings) {
+		s.lockBuffer()
+		defer s.unlockBuffer()
+	}
+
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	status := C.MM_SetPixelSizeConfig(s.mmcore, c_resolution_id)
+	return statusToError(status)
+}
+
+// GetCurrentPixelSizeConfig returns the pixel size configuration that matches the current
+// property values, or "" if no configuration matches.
+func (s *Session) GetCurrentPixelSizeConfig() (resolution_id string, err error) {
+	var c_resolution_id *C.char
+	status := C.MM_GetCurrentPixelSizeConfig(s.mmcore, &c_resolution_id)
+	defer C.MM_StringFree(c_resolution_id)
+
+	resolution_id = C.GoString(c_resolution_id)
+	err = statusToError(status)
+	return
+}
+
+// GetPixelSizeUm returns the pixel size of the current pixel size configuration in um,
+// scaled by the binning of the current camera, or 0 if no configuration matches.
+func (s *Session) GetPixelSizeUm() (pixel_size_um float64, err error) {
+	var c_pixel_size_um C.double
+	status := C.MM_GetPixelSizeUm(s.mmcore, &c_pixel_size_um)
+
+	pixel_size_um = float64(c_pixel_size_um)
+	err = statusToError(status)
+	return
+}
+
+// GetPixelSizeUmByID returns the pixel size of the configuration, without binning.
+func (s *Session) GetPixelSizeUmByID(resolution_id string) (pixel_size_um float64, err error) {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	var c_pixel_size_um C.double
+	status := C.MM_GetPixelSizeUmByID(s.mmcore, c_resolution_id, &c_pixel_size_um)
+
+	pixel_size_um = float64(c_pixel_size_um)
+	err = statusToError(status)
+	return
+}
+
+// GetPixelSizeAffine returns the affine transform of the current pixel size configuration,
+// scaled by the binning of the current camera, or zeros if no configuration matches.
+func (s *Session) GetPixelSizeAffine() (affine []float64, err error) {
+	var c_affine [6]C.double
+	status := C.MM_GetPixelSizeAffine(s.mmcore, &c_affine[0])
+	if err = statusToError(status); err != nil {
+		return nil, err
+	}
+	return goAffine(c_affine), nil
+}
+
+// GetPixelSizeAffineByID returns the affine transform of the configuration, without binning.
+func (s *Session) GetPixelSizeAffineByID(resolution_id string) (affine []float64, err error) {
+	c_resolution_id := C.CString(resolution_id)
+	defer C.free(unsafe.Pointer(c_resolution_id))
+
+	var c_affine [6]C.double
+	status := C.MM_GetPixelSizeAffineByID(s.mmcore, c_resolution_id, &c_affine[0])
+	if err = statusToError(status); err != nil {
+		return nil, err
+	}
+	return goAffine(c_affine), nil
+}
+
 //
 // Image acquisition settings
 //
 
 func (s *Session) SetROI(x int, y int, x_size int, y_size int) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_SetROI(s.mmcore, (C.int)(x), (C.int)(y), (C.int)(x_size), (C.int)(y_size))
 	return statusToError(status)
 }
@@ -557,13 +1365,16 @@ func (s *Session) GetROI() (x int, y int, x_size int, y_size int, err error) {
 
 	x = int(c_x)
 	y = int(c_y)
-	x_size = int(x_size)
-	y_size = int(y_size)
+	x_size = int(c_x_size)
+	y_size = int(c_y_size)
 	err = statusToError(status)
 	return
 }
 
 func (s *Session) ClearROI() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_ClearROI(s.mmcore)
 	return statusToError(status)
 }
@@ -660,6 +1471,9 @@ func (s *Session) NumberOfCameraChannels() (n_channels int) {
 //
 // It does not wait for the read-out and data transfering.
 func (s *Session) SnapImage() error {
+	s.imageMu.Lock()
+	defer s.imageMu.Unlock()
+
 	status := C.MM_SnapImage(s.mmcore)
 	return statusToError(status)
 }
@@ -671,6 +1485,31 @@ func (s *Session) SnapImage() error {
 //
 // In the case of multi-channel camera, image data of the first channel is returned.
 func (s *Session) GetImage() (buf []byte, err error) {
+	s.imageMu.Lock()
+	defer s.imageMu.Unlock()
+
+	return s.getImage()
+}
+
+// GetImageData returns the image of GetImage with the geometry of the current camera.
+func (s *Session) GetImageData() (img *Image, err error) {
+	s.imageMu.Lock()
+	defer s.imageMu.Unlock()
+
+	buf, err := s.getImage()
+	if err != nil {
+		return nil, err
+	}
+	return s.newImage(buf)
+}
+
+// newImage returns an Image of buf with the geometry of the current camera.
+// It is called with imageMu held, so that the geometry cannot change after the buffer is copied.
+func (s *Session) newImage(buf []byte) (*Image, error) {
+	return NewImage(buf, s.ImageWidth(), s.ImageHeight(), s.BytesPerPixel(), s.ImageBitDepth(), s.NumberOfComponents())
+}
+
+func (s *Session) getImage() (buf []byte, err error) {
 	len := s.ImageBufferSize()
 
 	var c_pbuf *C.uint8_t
@@ -683,6 +1522,9 @@ func (s *Session) GetImage() (buf []byte, err error) {
 }
 
 func (s *Session) GetImageOfChannel(channel int) (buf []byte, err error) {
+	s.imageMu.Lock()
+	defer s.imageMu.Unlock()
+
 	len := s.ImageBufferSize()
 
 	var c_pbuf *C.uint8_t
@@ -698,7 +1540,17 @@ func (s *Session) GetImageOfChannel(channel int) (buf []byte, err error) {
 // Image sequence acquisition
 //
 
-func (s *Session) StartSequenceAcquisition(num_images int16, interval_ms float64, stop_on_overflow bool) error {
+// StartSequenceAcquisition starts acquiring num_images images into the circular buffer.
+// It returns ErrInvalidImageSequence if num_images is not positive or does not fit
+// in 32 bits.
+func (s *Session) StartSequenceAcquisition(num_images int, interval_ms float64, stop_on_overflow bool) error {
+	if num_images <= 0 || num_images > math.MaxInt32 {
+		return ErrInvalidImageSequence
+	}
+
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	var c_stop_on_overflow C.uint8_t
 	if stop_on_overflow {
 		c_stop_on_overflow = 1
@@ -706,11 +1558,14 @@ func (s *Session) StartSequenceAcquisition(num_images int16, interval_ms float64
 		c_stop_on_overflow = 0
 	}
 
-	status := C.MM_StartSequenceAcquisition(s.mmcore, (C.int16_t)(num_images), (C.double)(interval_ms), c_stop_on_overflow)
+	status := C.MM_StartSequenceAcquisition_v2(s.mmcore, (C.int32_t)(num_images), (C.double)(interval_ms), c_stop_on_overflow)
 	return statusToError(status)
 }
 
 func (s *Session) StartContinuousSequenceAcquisition(interval_ms float64) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_StartContinuousSequenceAcquisition(s.mmcore, (C.double)(interval_ms))
 	return statusToError(status)
 }
@@ -729,12 +1584,37 @@ func (s *Session) IsSequenceRunning() bool {
 	return true
 }
 
+// StreamSequence starts a sequence acquisition and sends its images with their metadata
+// to the frame channel until it finishes or ctx is done. See the StreamSequence function.
+func (s *Session) StreamSequence(ctx context.Context, opts StreamOptions) (frames <-chan Frame, errs <-chan error, err error) {
+	return StreamSequence(ctx, s, opts)
+}
+
 //
 // Image circular buffer
 //
 
 // GetLastImage gets the last image from the circular buffer. It returns nil if the buffer is empty.
 func (s *Session) GetLastImage() (buf []byte, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	return s.getLastImage()
+}
+
+// GetLastImageData returns the image of GetLastImage with the geometry of the current camera.
+func (s *Session) GetLastImageData() (img *Image, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	buf, err := s.getLastImage()
+	if err != nil {
+		return nil, err
+	}
+	return s.newImage(buf)
+}
+
+func (s *Session) getLastImage() (buf []byte, err error) {
 	var c_pbuf *C.uint8_t
 	status := C.MM_GetLastImage(s.mmcore, &c_pbuf)
 
@@ -750,6 +1630,25 @@ func (s *Session) GetLastImage() (buf []byte, err error) {
 
 // PopNextImage gets the removes the next image from the circular buffer. It returns nil if the buffer is empty.
 func (s *Session) PopNextImage() (buf []byte, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	return s.popNextImage()
+}
+
+// PopNextImageData returns the image of PopNextImage with the geometry of the current camera.
+func (s *Session) PopNextImageData() (img *Image, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	buf, err := s.popNextImage()
+	if err != nil {
+		return nil, err
+	}
+	return s.newImage(buf)
+}
+
+func (s *Session) popNextImage() (buf []byte, err error) {
 	var c_pbuf *C.uint8_t
 	status := C.MM_PopNextImage(s.mmcore, &c_pbuf)
 
@@ -763,21 +1662,149 @@ func (s *Session) PopNextImage() (buf []byte, err error) {
 	return
 }
 
+// GetLastImageWithMetadata returns the image of GetLastImage with its geometry and its metadata.
+func (s *Session) GetLastImageWithMetadata() (img *Image, md *ImageMetadata, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	var c_pbuf *C.uint8_t
+	var c_md C.MM_Metadata
+	status := C.MM_GetLastImageMD(s.mmcore, &c_pbuf, &c_md)
+	defer C.MM_MetadataFree(&c_md)
+	return s.imageWithMetadata(status, c_pbuf, &c_md)
+}
+
+// PopNextImageWithMetadata returns the image of PopNextImage with its geometry and its metadata,
+// such as the time since the start of the sequence acquisition and the image number.
+func (s *Session) PopNextImageWithMetadata() (img *Image, md *ImageMetadata, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	var c_pbuf *C.uint8_t
+	var c_md C.MM_Metadata
+	status := C.MM_PopNextImageMD(s.mmcore, &c_pbuf, &c_md)
+	defer C.MM_MetadataFree(&c_md)
+	return s.imageWithMetadata(status, c_pbuf, &c_md)
+}
+
+// imageWithMetadata copies an image of the circular buffer and its metadata into Go memory.
+// It is called with imageMu held.
+func (s *Session) imageWithMetadata(status C.MM_Status, c_pbuf *C.uint8_t, c_md *C.MM_Metadata) (*Image, *ImageMetadata, error) {
+	if err := statusToError(status); err != nil {
+		return nil, nil, err
+	}
+	if unsafe.Pointer(c_pbuf) == C.NULL {
+		return nil, nil, ErrCircularBufferEmpty
+	}
+	img, err := s.newImage(C.GoBytes(unsafe.Pointer(c_pbuf), C.int(s.ImageBufferSize())))
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := make(map[string]string, int(c_md.len_tags))
+	if c_md.len_tags > 0 {
+		c_tags := (*[1 << 20]C.MM_MetadataTag)(unsafe.Pointer(c_md.tags))[:c_md.len_tags:c_md.len_tags]
+		for _, c_tag := range c_tags {
+			tags[C.GoString(c_tag.key)] = C.GoString(c_tag.value)
+		}
+	}
+	return img, NewImageMetadata(tags), nil
+}
+
+// PopNextImageInto removes the next image from the circular buffer and copies it into dst,
+// without allocating a buffer. The pixels of the returned image are dst[:ImageBufferSize()],
+// which stays owned by the caller; nothing is retained after the call.
+//
+// Only the capacity of dst is checked, so its length may be anything. If the capacity is
+// less than ImageBufferSize, it returns io.ErrShortBuffer and the image stays in the
+// circular buffer.
+func (s *Session) PopNextImageInto(dst []byte) (img *Image, err error) {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
+	len := s.ImageBufferSize()
+	if cap(dst) < len {
+		return nil, io.ErrShortBuffer
+	}
+	var c_pbuf *C.uint8_t
+	if err := statusToError(C.MM_PopNextImage(s.mmcore, &c_pbuf)); err != nil {
+		return nil, err
+	}
+	if unsafe.Pointer(c_pbuf) == C.NULL {
+		return nil, ErrCircularBufferEmpty
+	}
+	dst = dst[:len]
+	copy(dst, cBytes(c_pbuf, len))
+	return s.newImage(dst)
+}
+
+// PopNextImagePooled removes the next image from the circular buffer and copies it into
+// a buffer from pool. See ImagePool for the lifetime of the image.
+func (s *Session) PopNextImagePooled(pool *ImagePool) (img *Image, err error) {
+	buf := pool.Get(s.ImageBufferSize())
+	if img, err = s.PopNextImageInto(buf); err != nil {
+		pool.Put(buf)
+		return nil, err
+	}
+	return img, nil
+}
+
+// BorrowNextImage removes the next image from the circular buffer like PopNextImage,
+// and returns it without copying: its pixels are in the circular buffer of MMCore.
+//
+// The calls of the circular buffer, such as PopNextImage and ClearCircularBuffer, and
+// the calls that can change the geometry of the images, such as SetROI and SetProperty
+// of the current camera, block until the image is released, so the goroutine that
+// borrowed the image must release it before calling them. The other calls, such as
+// SnapImage or SetProperty of other devices, do not wait. The sequence acquisition
+// keeps inserting images meanwhile, and MMCore reuses the memory of the image when
+// the circular buffer wraps around, so the image must be released before
+// GetBufferTotalCapacity more images are acquired.
+func (s *Session) BorrowNextImage() (img *BorrowedImage, err error) {
+	s.bufferMu.Lock()
+	s.imageMu.Lock()
+	defer s.imageMu.Unlock()
+
+	len := s.ImageBufferSize()
+	var c_pbuf *C.uint8_t
+	if err := statusToError(C.MM_PopNextImage(s.mmcore, &c_pbuf)); err != nil {
+		s.bufferMu.Unlock()
+		return nil, err
+	}
+	if unsafe.Pointer(c_pbuf) == C.NULL {
+		s.bufferMu.Unlock()
+		return nil, ErrCircularBufferEmpty
+	}
+	data, err := s.newImage(cBytes(c_pbuf, len))
+	if err != nil {
+		s.bufferMu.Unlock()
+		return nil, err
+	}
+	return NewBorrowedImage(data, s.bufferMu.Unlock), nil
+}
+
+// cBytes returns a slice of the C buffer without copying it.
+func cBytes(p *C.uint8_t, len int) []byte {
+	if len == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(p))[:len:len]
+}
+
 func (s *Session) GetRemainingImageCount() (count int) {
-	var c_count C.int16_t
-	C.MM_GetRemainingImageCount(s.mmcore, &c_count)
+	var c_count C.int64_t
+	C.MM_GetRemainingImageCount_v2(s.mmcore, &c_count)
 	return int(c_count)
 }
 
 func (s *Session) GetBufferTotalCapacity() (capacity int) {
-	var c_capacity C.int16_t
-	C.MM_GetBufferTotalCapacity(s.mmcore, &c_capacity)
+	var c_capacity C.int64_t
+	C.MM_GetBufferTotalCapacity_v2(s.mmcore, &c_capacity)
 	return int(c_capacity)
 }
 
 func (s *Session) GetBufferFreeCapacity() (capacity int) {
-	var c_capacity C.int16_t
-	C.MM_GetBufferFreeCapacity(s.mmcore, &c_capacity)
+	var c_capacity C.int64_t
+	C.MM_GetBufferFreeCapacity_v2(s.mmcore, &c_capacity)
 	return int(c_capacity)
 }
 
@@ -788,6 +1815,9 @@ func (s *Session) IsBufferOverflowed() (overflowed bool) {
 }
 
 func (s *Session) SetCircularBufferMemoryFootprint(size_MB uint32) error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_SetCircularBufferMemoryFootprint(s.mmcore, (C.uint32_t)(size_MB))
 	return statusToError(status)
 }
@@ -799,11 +1829,17 @@ func (s *Session) GetCircularBufferMemoryFootprint() (size_MB uint32) {
 }
 
 func (s *Session) InitializeCircularBuffer() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_InitializeCircularBuffer(s.mmcore)
 	return statusToError(status)
 }
 
 func (s *Session) ClearCircularBuffer() error {
+	s.lockBuffer()
+	defer s.unlockBuffer()
+
 	status := C.MM_ClearCircularBuffer(s.mmcore)
 	return statusToError(status)
 }
@@ -1287,6 +2323,62 @@ func (s *Session) MACAddresses() (addresses []string) {
 	return
 }
 
+//
+// System state
+//
+
+// Snapshot records the state of all loaded devices. See TakeSnapshot.
+func (s *Session) Snapshot() (*Snapshot, error) {
+	return TakeSnapshot(s)
+}
+
+// ApplySnapshot restores the state of a snapshot. See ApplySnapshot.
+func (s *Session) ApplySnapshot(snap *Snapshot) (ApplyReport, error) {
+	return ApplySnapshot(s, snap)
+}
+
+// LoadSystemConfiguration loads a Micro-Manager hardware configuration (.cfg) file
+// with ApplySystemConfiguration, and then publishes a SystemConfigurationLoadedEvent.
+func (s *Session) LoadSystemConfiguration(path string) error {
+	cfg, err := ReadSystemConfiguration(path)
+	if err != nil {
+		return err
+	}
+	if err := ApplySystemConfiguration(s, cfg); err != nil {
+		return err
+	}
+	s.events.Publish(&SystemConfigurationLoadedEvent{})
+	return nil
+}
+
+// LoadHardwareProfile loads a hardware profile file (.json, .yaml or .yml) the way
+// LoadSystemConfiguration loads the configuration file it converts to. See HardwareProfile.
+func (s *Session) LoadHardwareProfile(path string) error {
+	p, err := ReadHardwareProfile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := p.SystemConfiguration()
+	if err != nil {
+		return err
+	}
+	if err := ApplySystemConfiguration(s, cfg); err != nil {
+		return err
+	}
+	s.events.Publish(&SystemConfigurationLoadedEvent{})
+	return nil
+}
+
+// SaveSystemConfiguration writes the configuration of the loaded devices to a
+// hardware configuration (.cfg) file. See TakeSystemConfiguration.
+func (s *Session) SaveSystemConfiguration(path string) error {
+	cfg, err := TakeSystemConfiguration(s)
+	if err != nil {
+		return err
+	}
+	return WriteSystemConfiguration(path, cfg)
+}
+
 //
 // Helper function
 //
@@ -1310,6 +2402,54 @@ func goStringList(c_str_list **C.char) []string {
 	return strs
 }
 
+// goPropertyDescriptor converts a property descriptor to Go.
+func goPropertyDescriptor(c_desc *C.MM_PropertyDescriptor) PropertyDescriptor {
+	return PropertyDescriptor{
+		Name:          C.GoString(c_desc.name),
+		Value:         C.GoString(c_desc.value),
+		Type:          PropertyType(c_desc._type),
+		ReadOnly:      goBool(c_desc.read_only),
+		PreInit:       goBool(c_desc.pre_init),
+		Sequenceable:  goBool(c_desc.sequenceable),
+		HasLimits:     goBool(c_desc.has_limits),
+		LowerLimit:    float64(c_desc.lower_limit),
+		UpperLimit:    float64(c_desc.upper_limit),
+		AllowedValues: goStringList(c_desc.allowed_values),
+	}
+}
+
+// goPropertySettings converts an array of property settings to Go.
+func goPropertySettings(c_settings *C.MM_PropertySetting, c_len C.size_t) []PropertySetting {
+	settings := make([]PropertySetting, int(c_len))
+	if c_len > 0 {
+		c_setting_slice := (*[1 << 20]C.MM_PropertySetting)(unsafe.Pointer(c_settings))[:c_len:c_len]
+		for i, c_setting := range c_setting_slice {
+			settings[i] = PropertySetting{
+				Label:    C.GoString(c_setting.label),
+				Property: C.GoString(c_setting.prop_name),
+				Value:    C.GoString(c_setting.value),
+			}
+		}
+	}
+	return settings
+}
+
+// goAffine converts an affine transform to Go.
+func goAffine(c_affine [6]C.double) []float64 {
+	affine := make([]float64, 6)
+	for i, a := range c_affine {
+		affine[i] = float64(a)
+	}
+	return affine
+}
+
+func statusToError(status C.MM_Status) error {
+	if int(C.int(status)) == 0 {
+		return nil
+	}
+	return Error(int(C.int(status)))
+}
+
 func goBool(c_bool C.uint8_t) bool {
 	if c_bool != 0 {
 		return true
@@ -1,6 +1,10 @@
+//go:build !mmcorestub
+// +build !mmcorestub
+
 package mmcore_test
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -161,28 +165,24 @@ func ExampleSession_StartContinuousSequenceAcquisition() {
 	}
 	fmt.Printf("Exposure time: %.6f ms\n", exposure)
 
-	err = mmc.StartContinuousSequenceAcquisition(0)
+	// StreamSequence starts the continuous acquisition and pops the images in a goroutine.
+	// Canceling the context stops the acquisition and closes the frame channel.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frames, errs, err := mmc.StreamSequence(ctx, mmcore.StreamOptions{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	n_images := 0
-	for {
-		if mmc.GetRemainingImageCount() > 0 {
-			_, err := mmc.PopNextImage()
-			if err != nil {
-				mmc.StopSequenceAcquisition()
-				log.Fatal(err)
-			}
-			n_images++
-			if n_images == 10 {
-				break
-			}
+	for range frames {
+		n_images++
+		if n_images == 10 {
+			cancel()
+			break
 		}
 	}
-
-	err = mmc.StopSequenceAcquisition()
-	if err != nil {
+	if err := <-errs; err != nil && err != context.Canceled {
 		log.Fatal(err)
 	}
 
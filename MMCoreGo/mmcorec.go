@@ -0,0 +1,12 @@
+//go:build !mmcorestub
+// +build !mmcorestub
+
+package mmcore
+
+// The bindings link to MMCoreC in the lib directory of the repository.
+// Build with the mmcorestub tag to use the stub implementation instead.
+
+// #cgo windows LDFLAGS: -L${SRCDIR}/../lib -lMMCoreC
+// #cgo linux LDFLAGS: -L${SRCDIR}/../lib -lMMCoreC -Wl,-rpath,${SRCDIR}/../lib
+// #cgo darwin LDFLAGS: -L${SRCDIR}/../lib -lMMCoreC -Wl,-rpath,${SRCDIR}/../lib
+import "C"
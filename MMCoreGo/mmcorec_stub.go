@@ -0,0 +1,62 @@
+//go:build mmcorestub
+// +build mmcorestub
+
+package mmcore
+
+// With the mmcorestub tag, MMCoreC is replaced by the stub implementation
+// in MMCoreC/stub, which simulates devices in memory and needs neither
+// MMCore nor Boost. It is compiled into the package by stub.c.
+
+// #cgo !windows LDFLAGS: -lpthread -lm
+//
+// #include <stdlib.h>
+//
+// #include "stub/MMCoreC_stub.h"
+import "C"
+
+import "unsafe"
+
+// The stub devices cannot trigger all events.
+// The functions below post them directly, for testing the event callbacks.
+
+func (s *Session) stubPostConfigGroupChanged(group_name, config_name string) {
+	c_group_name := C.CString(group_name)
+	defer C.free(unsafe.Pointer(c_group_name))
+	c_config_name := C.CString(config_name)
+	defer C.free(unsafe.Pointer(c_config_name))
+	C.MMStub_PostConfigGroupChanged(s.mmcore, c_group_name, c_config_name)
+}
+
+func (s *Session) stubPostSystemConfigurationLoaded() {
+	C.MMStub_PostSystemConfigurationLoaded(s.mmcore)
+}
+
+func (s *Session) stubPostPixelSizeChanged(pixel_size_um float64) {
+	C.MMStub_PostPixelSizeChanged(s.mmcore, C.double(pixel_size_um))
+}
+
+func (s *Session) stubPostSLMExposureChanged(label string, exposure_ms float64) {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+	C.MMStub_PostSLMExposureChanged(s.mmcore, c_label, C.double(exposure_ms))
+}
+
+func (s *Session) stubSetDeviceBusy(label string, busy_ms float64) error {
+	c_label := C.CString(label)
+	defer C.free(unsafe.Pointer(c_label))
+	return statusToError(C.MMStub_SetDeviceBusy(s.mmcore, c_label, C.double(busy_ms)))
+}
+
+// The version 1 functions of MMCoreC, for testing the compatibility with their callers.
+
+func (s *Session) stubStartSequenceAcquisitionV1(num_images int16) error {
+	return statusToError(C.MM_StartSequenceAcquisition(s.mmcore, C.int16_t(num_images), 0, 0))
+}
+
+func (s *Session) stubBufferCountsV1() (remaining, total, free int16) {
+	var c_remaining, c_total, c_free C.int16_t
+	C.MM_GetRemainingImageCount(s.mmcore, &c_remaining)
+	C.MM_GetBufferTotalCapacity(s.mmcore, &c_total)
+	C.MM_GetBufferFreeCapacity(s.mmcore, &c_free)
+	return int16(c_remaining), int16(c_total), int16(c_free)
+}
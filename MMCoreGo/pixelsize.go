@@ -0,0 +1,64 @@
+package mmcore
+
+// PixelTransform converts between the pixel coordinates of the images of the current
+// camera and stage coordinates in um.
+//
+// Pixel coordinates are in binned pixels from the top left corner of the image, which is
+// the ROI of the camera, and the center of the image is at the position of the XY stage.
+// Affine maps a displacement in pixels (dx, dy) to a displacement of the stage:
+//
+//	stage_dx = Affine[0]*dx + Affine[1]*dy + Affine[2]
+//	stage_dy = Affine[3]*dx + Affine[4]*dy + Affine[5]
+type PixelTransform struct {
+	Affine         [6]float64 // as returned by GetPixelSizeAffine, for the current binning
+	Width, Height  int        // size of the ROI in binned pixels
+	StageX, StageY float64    // position of the XY stage in um
+}
+
+// CurrentPixelTransform returns the transform for the current pixel size configuration,
+// the ROI and the binning of the current camera, and the position of the current XY stage.
+// The stage position is 0 if there is no current XY stage, so that the transform gives
+// positions relative to the stage.
+func CurrentPixelTransform(c Core) (t PixelTransform, err error) {
+	affine, err := c.GetPixelSizeAffine()
+	if err != nil {
+		return t, err
+	}
+	if len(affine) != 6 {
+		return t, ErrBadAffineTransform
+	}
+	copy(t.Affine[:], affine)
+	if _, _, t.Width, t.Height, err = c.GetROI(); err != nil {
+		return t, err
+	}
+	if label := c.XYStageDevice(); label != "" {
+		if t.StageX, t.StageY, err = c.GetXYPosition(label); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// PixelToStage returns the stage position in um of the pixel (x, y).
+func (t PixelTransform) PixelToStage(x, y float64) (stage_x, stage_y float64) {
+	a := t.Affine
+	dx := x - float64(t.Width)/2
+	dy := y - float64(t.Height)/2
+	return t.StageX + a[0]*dx + a[1]*dy + a[2], t.StageY + a[3]*dx + a[4]*dy + a[5]
+}
+
+// StageToPixel returns the pixel at the stage position in um, which can be outside of
+// the image. It returns ErrBadAffineTransform if the transform cannot be inverted,
+// such as when no pixel size configuration matches.
+func (t PixelTransform) StageToPixel(stage_x, stage_y float64) (x, y float64, err error) {
+	a := t.Affine
+	det := a[0]*a[4] - a[1]*a[3]
+	if det == 0 {
+		return 0, 0, ErrBadAffineTransform
+	}
+	sx := stage_x - t.StageX - a[2]
+	sy := stage_y - t.StageY - a[5]
+	dx := (a[4]*sx - a[1]*sy) / det
+	dy := (a[0]*sy - a[3]*sx) / det
+	return dx + float64(t.Width)/2, dy + float64(t.Height)/2, nil
+}
@@ -0,0 +1,500 @@
+package mmcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// HardwareProfile is a hardware configuration in a structured form, to be stored as
+// JSON or YAML and reviewed more easily than a .cfg file. It holds the content of
+// a configuration file: NewHardwareProfile converts a configuration file to a profile,
+// and SystemConfiguration converts it back.
+type HardwareProfile struct {
+	Devices      []ProfileDevice      `json:"devices" yaml:"devices"`                                 // in load order
+	CorePreInit  []ProfileProperty    `json:"core_pre_init,omitempty" yaml:"core_pre_init,omitempty"` // properties of the Core device set before initialization
+	Core         []ProfileProperty    `json:"core,omitempty" yaml:"core,omitempty"`                   // properties of the Core device, such as the current devices
+	ConfigGroups []ProfileConfigGroup `json:"config_groups,omitempty" yaml:"config_groups,omitempty"` // configuration groups and their presets
+	PixelSizes   []ProfilePixelSize   `json:"pixel_sizes,omitempty" yaml:"pixel_sizes,omitempty"`     // pixel size calibrations
+}
+
+// ProfileDevice is a device of a hardware profile.
+// PreInit properties are set before the devices are initialized, and Properties after.
+type ProfileDevice struct {
+	Label          string              `json:"label" yaml:"label"`
+	Module         string              `json:"module" yaml:"module"`
+	Device         string              `json:"device" yaml:"device"`
+	Parent         string              `json:"parent,omitempty" yaml:"parent,omitempty"`
+	PreInit        []ProfileProperty   `json:"pre_init,omitempty" yaml:"pre_init,omitempty"`
+	Properties     []ProfileProperty   `json:"properties,omitempty" yaml:"properties,omitempty"`
+	DelayMs        *float64            `json:"delay_ms,omitempty" yaml:"delay_ms,omitempty"`
+	FocusDirection *int                `json:"focus_direction,omitempty" yaml:"focus_direction,omitempty"`
+	StateLabels    []ProfileStateLabel `json:"state_labels,omitempty" yaml:"state_labels,omitempty"`
+}
+
+// ProfileProperty is a property value of a hardware profile.
+type ProfileProperty struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// ProfileStateLabel is the label of a position of a state device.
+type ProfileStateLabel struct {
+	State int    `json:"state" yaml:"state"`
+	Label string `json:"label" yaml:"label"`
+}
+
+// ProfileConfigGroup is a configuration group and its presets.
+type ProfileConfigGroup struct {
+	Name    string          `json:"name" yaml:"name"`
+	Presets []ProfilePreset `json:"presets,omitempty" yaml:"presets,omitempty"`
+}
+
+// ProfilePreset is a preset of a configuration group.
+type ProfilePreset struct {
+	Name     string            `json:"name" yaml:"name"`
+	Settings []PropertySetting `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// ProfilePixelSize is a pixel size calibration: the pixel size in um and the affine
+// transform from pixels to stage coordinates that apply when the property values of
+// Settings are set. Affine is nil or the 6 elements of the first two rows of the transform.
+type ProfilePixelSize struct {
+	Name        string            `json:"name" yaml:"name"`
+	PixelSizeUm *float64          `json:"pixel_size_um,omitempty" yaml:"pixel_size_um,omitempty"`
+	Affine      []float64         `json:"affine,omitempty" yaml:"affine,omitempty"`
+	Settings    []PropertySetting `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// NewHardwareProfile converts a configuration file to a hardware profile.
+//
+// Comments, the obsolete commands and the Config lines of old files are left out, as
+// they are not applied. The content of the file is otherwise kept, grouped by device,
+// configuration group and pixel size calibration in the order of their first line, with
+// the roles of the Core device before its other properties, so that the profile converts
+// back to an equivalent file, as described at SystemConfiguration. NewHardwareProfile returns a *CFGError for the lines that a
+// profile cannot represent: lines for devices that are not loaded, devices loaded after
+// initialization, and devices unloaded or initialized twice.
+func NewHardwareProfile(cfg *SystemConfiguration) (*HardwareProfile, error) {
+	p := &HardwareProfile{}
+	devices := make(map[string]int) // label -> index in p.Devices
+	initialized := false
+	for _, line := range cfg.Lines {
+		invalid := func(reason string) error {
+			return &CFGError{Line: line.Number, Text: line.String(), Err: ErrInvalidCFGEntry, Reason: reason}
+		}
+		device := func() (*ProfileDevice, error) {
+			i, ok := devices[line.arg(0)]
+			if !ok {
+				return nil, invalid("the device is not loaded")
+			}
+			return &p.Devices[i], nil
+		}
+
+		switch line.Command {
+		case CFGDevice:
+			if initialized {
+				return nil, invalid("the device is loaded after initialization")
+			}
+			if _, ok := devices[line.arg(0)]; ok || line.arg(0) == coreLabel {
+				return nil, invalid("the label is already used")
+			}
+			devices[line.arg(0)] = len(p.Devices)
+			p.Devices = append(p.Devices, ProfileDevice{Label: line.arg(0), Module: line.arg(1), Device: line.arg(2)})
+		case CFGProperty:
+			property := ProfileProperty{line.arg(1), line.arg(2)}
+			if line.arg(0) == coreLabel {
+				switch {
+				case property.Name != cfgInitializeProperty && !initialized:
+					p.CorePreInit = append(p.CorePreInit, property)
+				case property.Name != cfgInitializeProperty:
+					p.Core = append(p.Core, property)
+				case property.Value == "0" && (initialized || len(p.Devices) > 0):
+					return nil, invalid("devices are unloaded after they are loaded")
+				case property.Value == "1" && initialized:
+					return nil, invalid("devices are initialized twice")
+				case property.Value == "1":
+					initialized = true
+				}
+				continue
+			}
+			d, err := device()
+			if err != nil {
+				return nil, err
+			}
+			if initialized {
+				d.Properties = append(d.Properties, property)
+			} else {
+				d.PreInit = append(d.PreInit, property)
+			}
+		case CFGParent:
+			d, err := device()
+			if err != nil {
+				return nil, err
+			}
+			d.Parent = line.arg(1)
+		case CFGLabel:
+			d, err := device()
+			if err != nil {
+				return nil, err
+			}
+			state, _ := strconv.Atoi(line.arg(1))
+			d.StateLabels = append(d.StateLabels, ProfileStateLabel{state, line.arg(2)})
+		case CFGDelay:
+			d, err := device()
+			if err != nil {
+				return nil, err
+			}
+			delay, _ := strconv.ParseFloat(line.arg(1), 64)
+			d.DelayMs = &delay
+		case CFGFocusDirection:
+			d, err := device()
+			if err != nil {
+				return nil, err
+			}
+			sign, _ := strconv.Atoi(line.arg(1))
+			d.FocusDirection = &sign
+		case CFGConfigGroup:
+			g := p.configGroup(line.arg(0))
+			if len(line.Args) > 1 {
+				preset := g.preset(line.arg(1))
+				if len(line.Args) > 2 {
+					preset.Settings = append(preset.Settings, PropertySetting{line.arg(2), line.arg(3), line.arg(4)})
+				}
+			}
+		case CFGConfigPixelSize:
+			ps := p.pixelSize(line.arg(0))
+			ps.Settings = append(ps.Settings, PropertySetting{line.arg(1), line.arg(2), line.arg(3)})
+		case CFGPixelSize:
+			size, _ := strconv.ParseFloat(line.arg(1), 64)
+			p.pixelSize(line.arg(0)).PixelSizeUm = &size
+		case CFGPixelSizeAffine:
+			affine := make([]float64, 6)
+			for i := range affine {
+				affine[i], _ = strconv.ParseFloat(line.arg(i+1), 64)
+			}
+			p.pixelSize(line.arg(0)).Affine = affine
+		}
+	}
+	// SystemConfiguration writes the roles before the other Core properties.
+	sort.SliceStable(p.Core, func(i, j int) bool {
+		return coreRoleProperties[p.Core[i].Name] && !coreRoleProperties[p.Core[j].Name]
+	})
+	return p, nil
+}
+
+func (p *HardwareProfile) configGroup(name string) *ProfileConfigGroup {
+	for i := range p.ConfigGroups {
+		if p.ConfigGroups[i].Name == name {
+			return &p.ConfigGroups[i]
+		}
+	}
+	p.ConfigGroups = append(p.ConfigGroups, ProfileConfigGroup{Name: name})
+	return &p.ConfigGroups[len(p.ConfigGroups)-1]
+}
+
+func (g *ProfileConfigGroup) preset(name string) *ProfilePreset {
+	for i := range g.Presets {
+		if g.Presets[i].Name == name {
+			return &g.Presets[i]
+		}
+	}
+	g.Presets = append(g.Presets, ProfilePreset{Name: name})
+	return &g.Presets[len(g.Presets)-1]
+}
+
+func (p *HardwareProfile) pixelSize(name string) *ProfilePixelSize {
+	for i := range p.PixelSizes {
+		if p.PixelSizes[i].Name == name {
+			return &p.PixelSizes[i]
+		}
+	}
+	p.PixelSizes = append(p.PixelSizes, ProfilePixelSize{Name: name})
+	return &p.PixelSizes[len(p.PixelSizes)-1]
+}
+
+// Properties of the Core device written in the roles section of a configuration file.
+var coreRoleProperties = map[string]bool{
+	"Camera":      true,
+	"Shutter":     true,
+	"Focus":       true,
+	"XYStage":     true,
+	"AutoFocus":   true,
+	"AutoShutter": true,
+}
+
+// SystemConfiguration converts the profile to a configuration file, in the sections
+// written by TakeSystemConfiguration, followed by the properties set after
+// initialization, the pixel size calibrations and the other properties of the Core
+// device. Within a section, the lines are in the order of the devices, groups and
+// calibrations of the profile. The properties set after initialization come after the
+// state labels and the presets, so that they can select a state label. Only the current
+// devices and AutoShutter are written as roles; the other Core properties, such as
+// ChannelGroup, come last, so that they can refer to a group or a calibration. Sections
+// for delays, properties set after initialization and other Core properties are only
+// written if the profile has any.
+//
+// A configuration file converted to a profile by NewHardwareProfile and back by
+// SystemConfiguration applies as the original file does, but its comments, obsolete
+// commands and Config lines are dropped, and its lines are reordered as above.
+// Converting the result to a profile again gives the same profile.
+//
+// SystemConfiguration returns a *CFGError for a line that would not read back as
+// written, such as a name with a comma.
+func (p *HardwareProfile) SystemConfiguration() (*SystemConfiguration, error) {
+	cfg := &SystemConfiguration{}
+	cfg.comment("Unload all devices")
+	cfg.add(CFGProperty, coreLabel, cfgInitializeProperty, "0")
+
+	cfg.comment("")
+	cfg.comment("Load devices")
+	for _, d := range p.Devices {
+		cfg.add(CFGDevice, d.Label, d.Module, d.Device)
+	}
+
+	cfg.comment("")
+	cfg.comment("Pre-initialization properties")
+	for _, d := range p.Devices {
+		for _, property := range d.PreInit {
+			cfg.add(CFGProperty, d.Label, property.Name, property.Value)
+		}
+	}
+	for _, property := range p.CorePreInit {
+		cfg.add(CFGProperty, coreLabel, property.Name, property.Value)
+	}
+
+	cfg.comment("")
+	cfg.comment("Hub (parent) references")
+	for _, d := range p.Devices {
+		if d.Parent != "" {
+			cfg.add(CFGParent, d.Label, d.Parent)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Initialize")
+	cfg.add(CFGProperty, coreLabel, cfgInitializeProperty, "1")
+
+	var delays, properties bool
+	for _, d := range p.Devices {
+		delays = delays || d.DelayMs != nil
+		properties = properties || len(d.Properties) > 0
+	}
+	if delays {
+		cfg.comment("")
+		cfg.comment("Delays")
+		for _, d := range p.Devices {
+			if d.DelayMs != nil {
+				cfg.add(CFGDelay, d.Label, formatCFGFloat(*d.DelayMs))
+			}
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Focus directions")
+	for _, d := range p.Devices {
+		if d.FocusDirection != nil {
+			cfg.add(CFGFocusDirection, d.Label, strconv.Itoa(*d.FocusDirection))
+		}
+	}
+
+	var core_properties []ProfileProperty
+	cfg.comment("")
+	cfg.comment("Roles")
+	for _, property := range p.Core {
+		if coreRoleProperties[property.Name] {
+			cfg.add(CFGProperty, coreLabel, property.Name, property.Value)
+		} else {
+			core_properties = append(core_properties, property)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Labels")
+	for _, d := range p.Devices {
+		if len(d.StateLabels) == 0 {
+			continue
+		}
+		cfg.comment(d.Label)
+		for _, l := range d.StateLabels {
+			cfg.add(CFGLabel, d.Label, strconv.Itoa(l.State), l.Label)
+		}
+	}
+
+	cfg.comment("")
+	cfg.comment("Configuration presets")
+	for _, g := range p.ConfigGroups {
+		cfg.comment("Group: " + g.Name)
+		if len(g.Presets) == 0 {
+			cfg.add(CFGConfigGroup, g.Name)
+		}
+		for _, preset := range g.Presets {
+			cfg.comment("Preset: " + preset.Name)
+			if len(preset.Settings) == 0 {
+				cfg.add(CFGConfigGroup, g.Name, preset.Name)
+			}
+			for _, s := range preset.Settings {
+				cfg.add(CFGConfigGroup, g.Name, preset.Name, s.Label, s.Property, s.Value)
+			}
+		}
+		cfg.comment("")
+	}
+
+	if properties {
+		cfg.comment("Properties")
+		for _, d := range p.Devices {
+			for _, property := range d.Properties {
+				cfg.add(CFGProperty, d.Label, property.Name, property.Value)
+			}
+		}
+		cfg.comment("")
+	}
+
+	if len(p.PixelSizes) > 0 {
+		cfg.comment("Pixel size calibrations")
+		for _, ps := range p.PixelSizes {
+			cfg.comment("Resolution preset: " + ps.Name)
+			for _, s := range ps.Settings {
+				cfg.add(CFGConfigPixelSize, ps.Name, s.Label, s.Property, s.Value)
+			}
+			if ps.PixelSizeUm != nil {
+				cfg.add(CFGPixelSize, ps.Name, formatCFGFloat(*ps.PixelSizeUm))
+			}
+			if ps.Affine != nil {
+				if len(ps.Affine) != 6 {
+					return nil, &CFGError{Line: len(cfg.Lines) + 1, Text: ps.Name, Err: ErrInvalidCFGEntry,
+						Reason: "the affine transform needs 6 elements"}
+				}
+				args := []string{ps.Name}
+				for _, a := range ps.Affine {
+					args = append(args, formatCFGFloat(a))
+				}
+				cfg.add(CFGPixelSizeAffine, args...)
+			}
+		}
+		cfg.comment("")
+	}
+
+	if len(core_properties) > 0 {
+		cfg.comment("Core properties")
+		for _, property := range core_properties {
+			cfg.add(CFGProperty, coreLabel, property.Name, property.Value)
+		}
+		cfg.comment("")
+	}
+
+	// Check that the lines read back as written.
+	text := new(bytes.Buffer)
+	if _, err := cfg.WriteTo(text); err != nil {
+		return nil, err
+	}
+	parsed, err := ParseSystemConfiguration(text)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range parsed.Lines {
+		if i >= len(cfg.Lines) || line.Command != cfg.Lines[i].Command || len(line.Args) != len(cfg.Lines[i].Args) {
+			return nil, &CFGError{Line: line.Number, Text: line.String(), Err: ErrInvalidCFGEntry,
+				Reason: "a name or a value contains a comma or a line break"}
+		}
+	}
+	return cfg, nil
+}
+
+// WriteJSON writes the profile as indented JSON.
+func (p *HardwareProfile) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteYAML writes the profile as YAML.
+func (p *HardwareProfile) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ReadHardwareProfileJSON reads a profile written by WriteJSON. Unknown fields are errors.
+func ReadHardwareProfileJSON(r io.Reader) (*HardwareProfile, error) {
+	var p HardwareProfile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ReadHardwareProfileYAML reads a profile written by WriteYAML. Unknown fields are errors.
+func ReadHardwareProfileYAML(r io.Reader) (*HardwareProfile, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var p HardwareProfile
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ReadHardwareProfile reads a profile file, as JSON if the extension of path is
+// ".json", as YAML if it is ".yaml" or ".yml", and as a configuration file with
+// NewHardwareProfile if it is ".cfg". Other extensions are ErrInvalidConfigurationFile.
+func ReadHardwareProfile(path string) (*HardwareProfile, error) {
+	var read func(r io.Reader) (*HardwareProfile, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		read = ReadHardwareProfileJSON
+	case ".yaml", ".yml":
+		read = ReadHardwareProfileYAML
+	case ".cfg":
+		cfg, err := ReadSystemConfiguration(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewHardwareProfile(cfg)
+	default:
+		return nil, ErrInvalidConfigurationFile
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return read(bytes.NewReader(b))
+}
+
+// WriteHardwareProfile writes a profile file in the format of the extension of path,
+// as ReadHardwareProfile reads it.
+func WriteHardwareProfile(path string, p *HardwareProfile) error {
+	var b bytes.Buffer
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = p.WriteJSON(&b)
+	case ".yaml", ".yml":
+		err = p.WriteYAML(&b)
+	case ".cfg":
+		var cfg *SystemConfiguration
+		if cfg, err = p.SystemConfiguration(); err == nil {
+			_, err = cfg.WriteTo(&b)
+		}
+	default:
+		err = ErrInvalidConfigurationFile
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b.Bytes(), 0666)
+}
@@ -0,0 +1,294 @@
+package mmcore_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+func ExampleHardwareProfile_WriteYAML() {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(`Property,Core,Initialize,0
+Device,Wheel,DemoCamera,DWheel
+Device,Z,DemoCamera,DStage
+Property,Core,Initialize,1
+Label,Wheel,0,DAPI
+Label,Wheel,1,FITC
+FocusDirection,Z,-1
+ConfigGroup,Channel,DAPI,Wheel,Label,DAPI
+ConfigPixelSize,Res10x,Wheel,State,0
+PixelSize_um,Res10x,0.65
+Property,Core,Focus,Z
+`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := mmcore.NewHardwareProfile(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.WriteYAML(os.Stdout)
+
+	// Output:
+	// devices:
+	// - label: Wheel
+	//   module: DemoCamera
+	//   device: DWheel
+	//   state_labels:
+	//   - state: 0
+	//     label: DAPI
+	//   - state: 1
+	//     label: FITC
+	// - label: Z
+	//   module: DemoCamera
+	//   device: DStage
+	//   focus_direction: -1
+	// core:
+	// - name: Focus
+	//   value: Z
+	// config_groups:
+	// - name: Channel
+	//   presets:
+	//   - name: DAPI
+	//     settings:
+	//     - label: Wheel
+	//       property: Label
+	//       value: DAPI
+	// pixel_sizes:
+	// - name: Res10x
+	//   pixel_size_um: 0.65
+	//   settings:
+	//   - label: Wheel
+	//     property: State
+	//     value: "0"
+}
+
+// A configuration file converted to a profile and back applies as the original does,
+// without its comments and Config lines, and with the lines in the order of the sections.
+func ExampleHardwareProfile_SystemConfiguration() {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(`# Filter wheel
+Property,Core,Initialize,0
+Device,Wheel,DemoCamera,DWheel
+Property,Core,AutoShutter,0
+Property,Core,Initialize,1
+Label,Wheel,1,FITC
+Property,Wheel,Label,FITC
+Config,Old,Wheel,State,1
+ConfigGroup,Channel,FITC,Wheel,Label,FITC
+`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := mmcore.NewHardwareProfile(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	converted, err := p.SystemConfiguration()
+	if err != nil {
+		log.Fatal(err)
+	}
+	converted.WriteTo(os.Stdout)
+
+	// Output:
+	// # Unload all devices
+	// Property,Core,Initialize,0
+	//
+	// # Load devices
+	// Device,Wheel,DemoCamera,DWheel
+	//
+	// # Pre-initialization properties
+	// Property,Core,AutoShutter,0
+	//
+	// # Hub (parent) references
+	//
+	// # Initialize
+	// Property,Core,Initialize,1
+	//
+	// # Focus directions
+	//
+	// # Roles
+	//
+	// # Labels
+	// # Wheel
+	// Label,Wheel,1,FITC
+	//
+	// # Configuration presets
+	// # Group: Channel
+	// # Preset: FITC
+	// ConfigGroup,Channel,FITC,Wheel,Label,FITC
+	//
+	// # Properties
+	// Property,Wheel,Label,FITC
+}
+
+func TestHardwareProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmcore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	text := strings.Replace(demoCFG, "Device,Z,DemoCamera,DStage\n",
+		"Device,Z,DemoCamera,DStage\nDevice,Hub,DemoCamera,DHub\nDevice,Shutter,DemoCamera,DShutter\n", 1)
+	text = strings.Replace(text, "Property,Camera,MaximumExposureMs,500\n",
+		"Property,Camera,MaximumExposureMs,500\nProperty,Core,AutoShutter,0\n", 1)
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(text + `Parent,Shutter,Hub
+Delay,Shutter,12.5
+Property,Shutter,State,1
+Property,Wheel,Label,FITC
+ConfigGroup,Empty
+ConfigGroup,Channel,Dark
+ConfigPixelSize,Res10x,Wheel,Label,DAPI
+PixelSize_um,Res10x,0.65
+PixelSizeAffine,Res10x,0.65,0,0,0,0.65,0
+Equipment,obsolete
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := mmcore.NewHardwareProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := p.SystemConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := mmcore.NewHardwareProfile(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, p) {
+		t.Errorf("profile of the converted configuration:\n%+v\nwant:\n%+v", again, p)
+	}
+
+	// The profile reads back as written, and loads as the configuration file does.
+	for _, name := range []string{"profile.json", "profile.yaml", "profile.cfg"} {
+		path := dir + "/" + name
+		if err := mmcore.WriteHardwareProfile(path, p); err != nil {
+			t.Fatal(err)
+		}
+		read, err := mmcore.ReadHardwareProfile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, p) {
+			t.Errorf("%s: read\n%+v\nwant:\n%+v", name, read, p)
+		}
+	}
+	from_cfg := sim.NewSession()
+	defer from_cfg.Close()
+	if err := mmcore.ApplySystemConfiguration(from_cfg, cfg); err != nil {
+		t.Fatal(err)
+	}
+	from_profile := sim.NewSession()
+	defer from_profile.Close()
+	if err := from_profile.LoadHardwareProfile(dir + "/profile.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := mmcore.TakeSystemConfiguration(from_cfg)
+	b, _ := mmcore.TakeSystemConfiguration(from_profile)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("configuration loaded from the profile:\n%v\nwant:\n%v", b, a)
+	}
+	for _, mmc := range []*sim.Session{from_cfg, from_profile} {
+		label, _ := mmc.GetProperty("Wheel", "Label")
+		auto_shutter, _ := mmc.GetProperty("Core", "AutoShutter")
+		delay_ms, _ := mmc.GetDeviceDelayMs("Shutter")
+		if label != "FITC" || auto_shutter != "0" || delay_ms != 12.5 {
+			t.Errorf("wheel label %q, auto shutter %q, shutter delay %g, want FITC, 0 and 12.5", label, auto_shutter, delay_ms)
+		}
+	}
+
+	// A saved configuration converts back to the same file.
+	saved, err := mmcore.NewHardwareProfile(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := saved.SystemConfiguration(); !reflect.DeepEqual(c, a) {
+		t.Errorf("converted saved configuration:\n%v\nwant:\n%v", c, a)
+	}
+
+	for _, text := range []string{
+		"Property,Camera,Exposure,10",
+		"Device,Camera,DemoCamera,DCam\nDevice,Camera,DemoCamera,DCam",
+		"Device,Camera,DemoCamera,DCam\nProperty,Core,Initialize,0",
+		"Property,Core,Initialize,1\nProperty,Core,Initialize,1",
+		"Property,Core,Initialize,1\nDevice,Camera,DemoCamera,DCam",
+	} {
+		cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mmcore.NewHardwareProfile(cfg); err == nil || err.(*mmcore.CFGError).Err != mmcore.ErrInvalidCFGEntry {
+			t.Errorf("%q: error %v, want ErrInvalidCFGEntry", text, err)
+		}
+	}
+	bad := &mmcore.HardwareProfile{Devices: []mmcore.ProfileDevice{{Label: "A,B", Module: "DemoCamera", Device: "DCam"}}}
+	if _, err := bad.SystemConfiguration(); err == nil {
+		t.Errorf("label with a comma: no error")
+	}
+	if _, err := mmcore.ReadHardwareProfileJSON(strings.NewReader(`{"devices": [], "camera": "Camera"}`)); err == nil {
+		t.Errorf("unknown field: no error")
+	}
+}
+
+func TestHardwareProfileCoreProperties(t *testing.T) {
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(`Property,Core,Initialize,0
+Device,Camera,DemoCamera,DCam
+Device,Wheel,DemoCamera,DWheel
+Property,Core,Initialize,1
+Property,Core,ChannelGroup,Channel
+Property,Core,Camera,Camera
+Label,Wheel,0,DAPI
+ConfigGroup,Channel,DAPI,Wheel,Label,DAPI
+PixelSize_um,Res10x,0.65
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := mmcore.NewHardwareProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := p.SystemConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The camera is a role, but the channel group is set after the groups
+	// and the pixel size calibrations.
+	var order []string
+	for _, line := range converted.Lines {
+		switch line.Command {
+		case mmcore.CFGProperty, mmcore.CFGLabel, mmcore.CFGConfigGroup, mmcore.CFGPixelSize:
+			order = append(order, line.String())
+		}
+	}
+	want := []string{
+		"Property,Core,Initialize,0",
+		"Property,Core,Initialize,1",
+		"Property,Core,Camera,Camera",
+		"Label,Wheel,0,DAPI",
+		"ConfigGroup,Channel,DAPI,Wheel,Label,DAPI",
+		"PixelSize_um,Res10x,0.65",
+		"Property,Core,ChannelGroup,Channel",
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("lines %q, want %q", order, want)
+	}
+
+	again, err := mmcore.NewHardwareProfile(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, p) {
+		t.Errorf("profile of the converted configuration:\n%+v\nwant:\n%+v", again, p)
+	}
+}
@@ -0,0 +1,311 @@
+package mmcore
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PropertyType is the type of a property value, as MM_PropertyType in MMCoreC.
+// MMCore keeps all values as strings, and the type tells how to parse them.
+type PropertyType int
+
+const (
+	UndefProperty PropertyType = iota
+	StringProperty
+	FloatProperty
+	IntegerProperty
+)
+
+func (t PropertyType) String() string {
+	switch t {
+	case StringProperty:
+		return "String"
+	case FloatProperty:
+		return "Float"
+	case IntegerProperty:
+		return "Integer"
+	}
+	return "Undef"
+}
+
+// PropertyDescriptor holds the value and the metadata of a device property,
+// as returned by DescribeProperty and DescribeDevice.
+type PropertyDescriptor struct {
+	Name          string       `json:"name"`
+	Value         string       `json:"value"`
+	Type          PropertyType `json:"type"`
+	ReadOnly      bool         `json:"read_only"`
+	PreInit       bool         `json:"pre_init"`
+	Sequenceable  bool         `json:"sequenceable"`
+	HasLimits     bool         `json:"has_limits"`
+	LowerLimit    float64      `json:"lower_limit"` // only valid if HasLimits
+	UpperLimit    float64      `json:"upper_limit"` // only valid if HasLimits
+	AllowedValues []string     `json:"allowed_values"`
+}
+
+// PropertySetting is a property value of a configuration preset.
+type PropertySetting struct {
+	Label    string `json:"label" yaml:"label"`
+	Property string `json:"property" yaml:"property"`
+	Value    string `json:"value" yaml:"value"`
+}
+
+// PropertyTypeError is returned by the typed property getters, such as GetPropertyFloat,
+// when the property has another type or its value cannot be parsed.
+type PropertyTypeError struct {
+	Label    string
+	Property string
+	Type     PropertyType // type of the property
+	Value    string       // value of the property
+	Want     string       // requested Go type
+}
+
+func (e *PropertyTypeError) Error() string {
+	return fmt.Sprintf("property %q of %q is not a %s: type %s, value %q", e.Property, e.Label, e.Want, e.Type, e.Value)
+}
+
+// ParsePropertyFloat parses the value of a Float or Integer property.
+//
+// ParsePropertyFloat, ParsePropertyInt and ParsePropertyBool implement the typed
+// property getters of Session, and can be used by other implementations of Core.
+func ParsePropertyFloat(label, property, value string, property_type PropertyType) (float64, error) {
+	if property_type == FloatProperty || property_type == IntegerProperty {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return v, nil
+		}
+	}
+	return 0, &PropertyTypeError{label, property, property_type, value, "float64"}
+}
+
+// ParsePropertyInt parses the value of an Integer property.
+func ParsePropertyInt(label, property, value string, property_type PropertyType) (int, error) {
+	if property_type == IntegerProperty {
+		if v, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return v, nil
+		}
+	}
+	return 0, &PropertyTypeError{label, property, property_type, value, "int"}
+}
+
+// ParsePropertyBool parses a value of "0" or "1", as written by SetProperty with a bool.
+// Float properties are not accepted.
+func ParsePropertyBool(label, property, value string, property_type PropertyType) (bool, error) {
+	if property_type != FloatProperty {
+		switch strings.TrimSpace(value) {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+	}
+	return false, &PropertyTypeError{label, property, property_type, value, "bool"}
+}
+
+// PropertyValueError is returned by SetProperty for values of unsupported types,
+// and by CheckPropertyValue for values that the property would reject.
+type PropertyValueError struct {
+	Label    string
+	Property string
+	Value    interface{}
+	Reason   string
+}
+
+func (e *PropertyValueError) Error() string {
+	return fmt.Sprintf("cannot set property %q of %q to %v: %s", e.Property, e.Label, e.Value, e.Reason)
+}
+
+type propertyValueKind int
+
+const (
+	boolValue propertyValueKind = iota
+	intValue
+	float32Value
+	float64Value
+	stringValue
+)
+
+// propertyValue is a value of SetProperty, converted for one of the setters of MMCoreC.
+type propertyValue struct {
+	kind propertyValueKind
+	b    bool
+	i    int32
+	f    float64
+	s    string
+}
+
+// toPropertyValue converts a value of SetProperty. The kinds are tried in order:
+//
+//  1. nil, and nil pointers, maps, slices, funcs, channels and interfaces are refused.
+//  2. Values of bool and numeric kinds are bools and numbers, even if the type implements
+//     fmt.Stringer, so a time.Duration is set in nanoseconds. Integers that do not fit
+//     in 32 bits are converted to decimal strings, which MMCore parses.
+//  3. Values that implement fmt.Stringer are set to their String, including named
+//     string types.
+//  4. Other values of string kind are set as they are.
+func toPropertyValue(value interface{}) (v propertyValue, ok bool) {
+	rv := reflect.ValueOf(value)
+	if isNil(value) {
+		return propertyValue{}, false
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return propertyValue{kind: boolValue, b: rv.Bool()}, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return propertyValue{kind: stringValue, s: strconv.FormatInt(i, 10)}, true
+		}
+		return propertyValue{kind: intValue, i: int32(i)}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt32 {
+			return propertyValue{kind: stringValue, s: strconv.FormatUint(u, 10)}, true
+		}
+		return propertyValue{kind: intValue, i: int32(u)}, true
+	case reflect.Float32:
+		return propertyValue{kind: float32Value, f: rv.Float()}, true
+	case reflect.Float64:
+		return propertyValue{kind: float64Value, f: rv.Float()}, true
+	}
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return propertyValue{kind: stringValue, s: stringer.String()}, true
+	}
+	if rv.Kind() == reflect.String {
+		return propertyValue{kind: stringValue, s: rv.String()}, true
+	}
+	return propertyValue{}, false
+}
+
+// isNil reports whether the value is nil, or a nil pointer, map, slice, func, channel or interface.
+func isNil(value interface{}) bool {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func (v propertyValue) String() string {
+	switch v.kind {
+	case boolValue:
+		if v.b {
+			return "1"
+		}
+		return "0"
+	case intValue:
+		return strconv.Itoa(int(v.i))
+	case float32Value:
+		return strconv.FormatFloat(v.f, 'g', -1, 32)
+	case float64Value:
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	}
+	return v.s
+}
+
+// FormatPropertyValue returns the value of SetProperty as a property string:
+// bools are "0" or "1", numbers are formatted in decimal, and other values are
+// their String or the string itself. Numeric kinds take precedence over
+// fmt.Stringer, so a time.Duration is formatted in nanoseconds.
+// It returns a *PropertyValueError for the types that SetProperty does not support.
+func FormatPropertyValue(label, property string, value interface{}) (string, error) {
+	v, ok := toPropertyValue(value)
+	if !ok {
+		reason := fmt.Sprintf("unsupported type %T", value)
+		if isNil(value) {
+			reason = "nil value"
+		}
+		return "", &PropertyValueError{label, property, value, reason}
+	}
+	return v.String(), nil
+}
+
+// CheckPropertyValue checks a value of SetProperty on the client side, against the
+// allowed values and the limits of the property. It returns a *PropertyValueError that
+// explains the rejection, which the device would only report as a generic error.
+func CheckPropertyValue(c DeviceControl, label, property string, value interface{}) error {
+	str, err := FormatPropertyValue(label, property, value)
+	if err != nil {
+		return err
+	}
+	reject := func(format string, args ...interface{}) error {
+		return &PropertyValueError{label, property, value, fmt.Sprintf(format, args...)}
+	}
+
+	read_only, err := c.IsPropertyReadOnly(label, property)
+	if err != nil {
+		return err
+	}
+	if read_only {
+		return reject("the property is read-only")
+	}
+
+	property_type, err := c.PropertyType(label, property)
+	if err != nil {
+		return err
+	}
+	number, number_err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	switch property_type {
+	case FloatProperty:
+		if number_err != nil {
+			return reject("the value is not a number")
+		}
+	case IntegerProperty:
+		if number_err != nil || number != math.Trunc(number) {
+			return reject("the value is not an integer")
+		}
+	}
+
+	allowed, err := c.GetAllowedPropertyValues(label, property)
+	if err != nil {
+		return err
+	}
+	if len(allowed) > 0 {
+		found := false
+		for _, a := range allowed {
+			if a == str {
+				found = true
+				break
+			}
+			if property_type == FloatProperty || property_type == IntegerProperty {
+				if f, err := strconv.ParseFloat(a, 64); err == nil && f == number {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return reject("allowed values are %q", allowed)
+		}
+	}
+
+	has_limits, err := c.HasPropertyLimits(label, property)
+	if err != nil || !has_limits {
+		return err
+	}
+	lower, err := c.GetPropertyLowerLimit(label, property)
+	if err != nil {
+		return err
+	}
+	upper, err := c.GetPropertyUpperLimit(label, property)
+	if err != nil {
+		return err
+	}
+	if number_err != nil || number < lower || number > upper {
+		return reject("the value must be in the range [%g, %g]", lower, upper)
+	}
+	return nil
+}
+
+// SetPropertyChecked checks the value with CheckPropertyValue before it calls SetProperty.
+func SetPropertyChecked(c DeviceControl, label, property string, value interface{}) error {
+	if err := CheckPropertyValue(c, label, property, value); err != nil {
+		return err
+	}
+	return c.SetProperty(label, property, value)
+}
@@ -0,0 +1,135 @@
+package mmcore_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+// pixelType is a named string type with a String method.
+type pixelType string
+
+func (p pixelType) String() string {
+	return string(p) + "bit"
+}
+
+// level is a numeric type with a String method.
+type level int
+
+func (l level) String() string {
+	return fmt.Sprintf("level %d", int(l))
+}
+
+func ExampleFormatPropertyValue() {
+	for _, value := range []interface{}{
+		true,
+		12,
+		int64(1) << 40,
+		0.5,
+		20 * time.Millisecond,
+		pixelType("16"),
+		"8bit",
+	} {
+		str, _ := mmcore.FormatPropertyValue("Camera", "Property", value)
+		fmt.Printf("%T: %s\n", value, str)
+	}
+
+	// Output:
+	// bool: 1
+	// int: 12
+	// int64: 1099511627776
+	// float64: 0.5
+	// time.Duration: 20000000
+	// mmcore_test.pixelType: 16bit
+	// string: 8bit
+}
+
+func TestFormatPropertyValue(t *testing.T) {
+	var builder strings.Builder
+	builder.WriteString("12bit")
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{level(3), "3"}, // numeric kinds take precedence over fmt.Stringer
+		{uint8(255), "255"},
+		{float32(0.25), "0.25"},
+		{uint64(1) << 63, "9223372036854775808"},
+		{&builder, "12bit"},      // fmt.Stringer
+		{pixelType("8"), "8bit"}, // fmt.Stringer before the string kind
+	} {
+		if got, err := mmcore.FormatPropertyValue("Camera", "Property", tt.value); err != nil || got != tt.want {
+			t.Errorf("FormatPropertyValue(%T %v) = %q, %v, want %q", tt.value, tt.value, got, err, tt.want)
+		}
+	}
+
+	var nil_builder *strings.Builder
+	var nil_stringer fmt.Stringer
+	for _, value := range []interface{}{nil, nil_builder, nil_stringer, struct{}{}, []string{"a"}} {
+		_, err := mmcore.FormatPropertyValue("Camera", "Property", value)
+		if _, ok := err.(*mmcore.PropertyValueError); !ok {
+			t.Errorf("FormatPropertyValue(%T): got error %v, want a *PropertyValueError", value, err)
+		}
+	}
+}
+
+func TestSetProperty(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	if err := mmc.LoadDevice("Camera", "DemoCamera", "DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		property string
+		value    interface{}
+		want     string
+	}{
+		{"Gain", int64(3), "3"},
+		{"Gain", level(4), "4"},
+		{"Offset", time.Duration(7), "7"},
+		{"Exposure", float32(2.5), "2.5000"},
+		{"PixelType", pixelType("16"), "16bit"},
+	} {
+		if err := mmc.SetProperty("Camera", tt.property, tt.value); err != nil {
+			t.Errorf("SetProperty(%s, %T): %v", tt.property, tt.value, err)
+			continue
+		}
+		if got, _ := mmc.GetProperty("Camera", tt.property); got != tt.want {
+			t.Errorf("SetProperty(%s, %T): value is %q, want %q", tt.property, tt.value, got, tt.want)
+		}
+	}
+
+	err := mmc.SetProperty("Camera", "Gain", []int{1})
+	if err, ok := err.(*mmcore.PropertyValueError); !ok || err.Reason != "unsupported type []int" {
+		t.Errorf("SetProperty([]int): got error %v", err)
+	}
+
+	// The checked setter explains why the device would refuse the value.
+	for _, tt := range []struct {
+		property string
+		value    interface{}
+		want     string
+	}{
+		{"Binning", 3, `cannot set property "Binning" of "Camera" to 3: allowed values are ["1" "2" "4" "8"]`},
+		{"Gain", 9, `cannot set property "Gain" of "Camera" to 9: the value must be in the range [-5, 8]`},
+		{"Gain", 1.5, `cannot set property "Gain" of "Camera" to 1.5: the value is not an integer`},
+		{"Name", "x", `cannot set property "Name" of "Camera" to x: the property is read-only`},
+	} {
+		err := mmcore.SetPropertyChecked(mmc, "Camera", tt.property, tt.value)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("SetPropertyChecked(%s, %v): got error %v, want %s", tt.property, tt.value, err, tt.want)
+		}
+	}
+	if err := mmcore.SetPropertyChecked(mmc, "Camera", "Binning", uint8(2)); err != nil {
+		t.Errorf("SetPropertyChecked(Binning, 2): %v", err)
+	}
+}
@@ -0,0 +1,683 @@
+package sim
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+//
+// Image acquisition settings
+//
+
+func (s *Session) cameraDevice() (*device, error) {
+	return s.deviceOfType("", s.camera, cameraDevice, mmcore.ErrCameraNotAvailable)
+}
+
+// SetROI sets the region of interest of the current camera in binned pixels.
+func (s *Session) SetROI(x int, y int, x_size int, y_size int) error {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return err
+	}
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	full_w, full_h := sensorSize(cam)
+	if x < 0 || y < 0 || x_size <= 0 || y_size <= 0 || x+x_size > full_w || y+y_size > full_h {
+		return mmcore.ErrDEVICE_GENERIC
+	}
+	cam.roiX, cam.roiY, cam.roiW, cam.roiH = x, y, x_size, y_size
+	cam.roiSet = true
+	return nil
+}
+
+func (s *Session) GetROI() (x int, y int, x_size int, y_size int, err error) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return
+	}
+	x, y, x_size, y_size = roi(cam)
+	return
+}
+
+func (s *Session) ClearROI() error {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return err
+	}
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	cam.roiSet = false
+	return nil
+}
+
+func (s *Session) SetExposureTime(exposure_ms float64) error {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return err
+	}
+	p := cam.props["Exposure"]
+	if err := p.set(formatFloat(exposure_ms)); err != nil {
+		return err
+	}
+	s.emitPropertyChanged(cam.label, "Exposure", p.get())
+	s.emitExposureChanged(cam.label, cam.floatProperty("Exposure"))
+	return nil
+}
+
+func (s *Session) ExposureTime() (exposure_ms float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0, err
+	}
+	return cam.floatProperty("Exposure"), nil
+}
+
+// ImageBufferSize returns the size of the image buffer, or 0 if there is no camera.
+func (s *Session) ImageBufferSize() (len int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	_, _, w, h := roi(cam)
+	return w * h * bytesPerPixel(cam)
+}
+
+func (s *Session) ImageWidth() (width int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	_, _, width, _ = roi(cam)
+	return
+}
+
+func (s *Session) ImageHeight() (height int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	_, _, _, height = roi(cam)
+	return
+}
+
+func (s *Session) BytesPerPixel() (bytes_per_pixel int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	return bytesPerPixel(cam)
+}
+
+func (s *Session) ImageBitDepth() (bit_depth int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	return bitDepth(cam)
+}
+
+func (s *Session) NumberOfComponents() (n_components int) {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	if cam.props["PixelType"].get() == "32bitRGB" {
+		return 4
+	}
+	return 1
+}
+
+func (s *Session) NumberOfCameraChannels() (n_channels int) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.cameraDevice(); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// sensorSize returns the size of the sensor in binned pixels.
+func sensorSize(cam *device) (width, height int) {
+	binning := cam.intProperty("Binning")
+	return cam.intProperty("OnCameraCCDXSize") / binning, cam.intProperty("OnCameraCCDYSize") / binning
+}
+
+// roi returns the current ROI, which is the whole sensor if no ROI is set.
+func roi(cam *device) (x, y, width, height int) {
+	if cam.roiSet {
+		return cam.roiX, cam.roiY, cam.roiW, cam.roiH
+	}
+	width, height = sensorSize(cam)
+	return 0, 0, width, height
+}
+
+func bytesPerPixel(cam *device) int {
+	switch cam.props["PixelType"].get() {
+	case "16bit":
+		return 2
+	case "32bitRGB":
+		return 4
+	}
+	return 1
+}
+
+func bitDepth(cam *device) int {
+	if cam.props["PixelType"].get() != "16bit" {
+		return 8
+	}
+	if bit_depth := cam.intProperty("BitDepth"); bit_depth > 8 {
+		return bit_depth
+	}
+	return 16
+}
+
+// render generates the next image of the camera.
+//
+// The image is a diagonal ramp that shifts by one pixel every frame,
+// saturated to the bit depth of the camera. RGB images are stored as BGRA.
+func (s *Session) render(cam *device) *mmcore.Image {
+	x0, y0, w, h := roi(cam)
+	n_bytes := bytesPerPixel(cam)
+	max := 1<<uint(bitDepth(cam)) - 1
+	frame := s.frameNumber
+	s.frameNumber++
+
+	buf := make([]byte, w*h*n_bytes)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := (x0 + x + y0 + y + frame) % (max + 1)
+			i := (y*w + x) * n_bytes
+			switch n_bytes {
+			case 1:
+				buf[i] = byte(v)
+			case 2:
+				binary.LittleEndian.PutUint16(buf[i:], uint16(v))
+			case 4:
+				buf[i] = byte(x0 + x + frame)
+				buf[i+1] = byte(y0 + y + frame)
+				buf[i+2] = byte(v)
+			}
+		}
+	}
+	n_components := 1
+	if n_bytes == 4 {
+		n_components = 4
+	}
+	return &mmcore.Image{Pix: buf, Width: w, Height: h, BytesPerPixel: n_bytes, BitDepth: bitDepth(cam), NumComponents: n_components}
+}
+
+// copyImage returns a copy of img that does not share its pixels.
+func copyImage(img *mmcore.Image) *mmcore.Image {
+	c := *img
+	c.Pix = append([]byte(nil), img.Pix...)
+	return &c
+}
+
+//
+// Image acquisition
+//
+
+// SnapImage acquires an image with the current camera.
+//
+// The simulated exposure finishes immediately.
+func (s *Session) SnapImage() error {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return err
+	}
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	s.snapped = s.render(cam)
+	return nil
+}
+
+// GetImage returns the image acquired by the last SnapImage.
+func (s *Session) GetImage() (buf []byte, err error) {
+	return s.GetImageOfChannel(0)
+}
+
+func (s *Session) GetImageOfChannel(channel int) (buf []byte, err error) {
+	img, err := s.getImage(channel)
+	if err != nil {
+		return nil, err
+	}
+	return img.Pix, nil
+}
+
+// GetImageData returns the image acquired by the last SnapImage with its geometry.
+func (s *Session) GetImageData() (img *mmcore.Image, err error) {
+	return s.getImage(0)
+}
+
+func (s *Session) getImage(channel int) (*mmcore.Image, error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.cameraDevice(); err != nil {
+		return nil, err
+	}
+	if s.snapped == nil || channel != 0 {
+		return nil, mmcore.ErrCameraBufferReadFailed
+	}
+	return copyImage(s.snapped), nil
+}
+
+//
+// Image sequence acquisition
+//
+
+// StartSequenceAcquisition starts acquiring num_images images into the circular buffer.
+//
+// If stop_on_overflow is false, the circular buffer is cleared when it is full.
+func (s *Session) StartSequenceAcquisition(num_images int, interval_ms float64, stop_on_overflow bool) error {
+	if num_images <= 0 || num_images > math.MaxInt32 {
+		return mmcore.ErrInvalidImageSequence
+	}
+	return s.startSequence(num_images, interval_ms, stop_on_overflow)
+}
+
+func (s *Session) StartContinuousSequenceAcquisition(interval_ms float64) error {
+	return s.startSequence(0, interval_ms, false)
+}
+
+func (s *Session) StopSequenceAcquisition() error {
+	s.stopSequence()
+	return nil
+}
+
+func (s *Session) IsSequenceRunning() bool {
+	s.lock()
+	defer s.unlock()
+	return s.seqRunning
+}
+
+// StreamSequence starts a sequence acquisition and sends its images with their metadata
+// to the frame channel until it finishes or ctx is done. See mmcore.StreamSequence.
+func (s *Session) StreamSequence(ctx context.Context, opts mmcore.StreamOptions) (frames <-chan mmcore.Frame, errs <-chan error, err error) {
+	return mmcore.StreamSequence(ctx, s, opts)
+}
+
+// startSequence starts the acquisition goroutine. num_images <= 0 acquires until stopped.
+func (s *Session) startSequence(num_images int, interval_ms float64, stop_on_overflow bool) error {
+	s.lock()
+	defer s.unlock()
+
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return err
+	}
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+
+	period_ms := cam.floatProperty("Exposure")
+	if interval_ms > period_ms {
+		period_ms = interval_ms
+	}
+	period := time.Duration(period_ms * float64(time.Millisecond))
+	if period < time.Millisecond {
+		period = time.Millisecond
+	}
+
+	s.buffer = nil
+	s.lastImage = nil
+	s.bufferOverflow = false
+	s.seqRunning = true
+	s.seqStart = time.Now()
+	s.seqImageNumber = 0
+	s.seqStop = make(chan struct{})
+	s.seqDone = make(chan struct{})
+	go s.runSequence(cam, num_images, period, stop_on_overflow, s.seqStop, s.seqDone)
+	return nil
+}
+
+func (s *Session) runSequence(cam *device, num_images int, period time.Duration, stop_on_overflow bool, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for i := 0; num_images <= 0 || i < num_images; i++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.lock()
+		img := s.render(cam)
+		if !s.insertImage(&bufferedImage{img, s.imageTags(cam, img)}, stop_on_overflow) {
+			s.seqRunning = false
+			s.unlock()
+			return
+		}
+		s.unlock()
+	}
+
+	s.lock()
+	s.seqRunning = false
+	s.unlock()
+}
+
+// stopSequence stops the acquisition goroutine, if any, and waits for it to finish.
+func (s *Session) stopSequence() {
+	s.lock()
+	stop, done := s.seqStop, s.seqDone
+	s.seqStop, s.seqDone = nil, nil
+	s.unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+
+	s.lock()
+	s.seqRunning = false
+	s.unlock()
+}
+
+//
+// Image circular buffer
+//
+
+// bufferedImage is an image of the circular buffer with its metadata tags.
+type bufferedImage struct {
+	*mmcore.Image
+	tags map[string]string
+}
+
+// imageTags returns the metadata tags of the next image of the sequence acquisition,
+// with the keys MMCore uses.
+func (s *Session) imageTags(cam *device, img *mmcore.Image) map[string]string {
+	now := time.Now()
+	x, y, _, _ := roi(cam)
+	tags := map[string]string{
+		mmcore.MetadataBinning:      strconv.Itoa(cam.intProperty("Binning")),
+		mmcore.MetadataCamera:       cam.label,
+		mmcore.MetadataElapsedTime:  strconv.FormatFloat(now.Sub(s.seqStart).Seconds()*1000, 'f', 4, 64),
+		mmcore.MetadataHeight:       strconv.Itoa(img.Height),
+		mmcore.MetadataImageNumber:  strconv.FormatInt(s.seqImageNumber, 10),
+		mmcore.MetadataPixelType:    cam.props["PixelType"].get(),
+		mmcore.MetadataROIX:         strconv.Itoa(x),
+		mmcore.MetadataROIY:         strconv.Itoa(y),
+		mmcore.MetadataTimeReceived: mmcore.FormatMetadataTimeReceived(now),
+		mmcore.MetadataWidth:        strconv.Itoa(img.Width),
+	}
+	s.seqImageNumber++
+	return tags
+}
+
+// copyTags returns a copy of the metadata tags.
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+// insertImage inserts an image into the circular buffer.
+// It returns false if the buffer overflowed and the acquisition should stop.
+func (s *Session) insertImage(img *bufferedImage, stop_on_overflow bool) bool {
+	if len(s.buffer) >= s.capacity(len(img.Pix)) {
+		if stop_on_overflow {
+			s.bufferOverflow = true
+			return false
+		}
+		s.buffer = nil
+	}
+	s.buffer = append(s.buffer, img)
+	s.lastImage = img
+	return true
+}
+
+// capacity returns the number of images of the size that fit into the circular buffer.
+func (s *Session) capacity(image_size int) int {
+	if image_size == 0 {
+		return 0
+	}
+	return int(uint64(s.bufferMB) << 20 / uint64(image_size))
+}
+
+func (s *Session) currentImageSize() int {
+	cam, err := s.cameraDevice()
+	if err != nil {
+		return 0
+	}
+	_, _, w, h := roi(cam)
+	return w * h * bytesPerPixel(cam)
+}
+
+// GetLastImage returns the last image inserted into the circular buffer without removing it.
+func (s *Session) GetLastImage() (buf []byte, err error) {
+	img, err := s.GetLastImageData()
+	if err != nil {
+		return nil, err
+	}
+	return img.Pix, nil
+}
+
+// PopNextImage removes and returns the oldest image from the circular buffer.
+func (s *Session) PopNextImage() (buf []byte, err error) {
+	img, err := s.PopNextImageData()
+	if err != nil {
+		return nil, err
+	}
+	return img.Pix, nil
+}
+
+// GetLastImageData returns the image of GetLastImage with the geometry it was acquired with.
+func (s *Session) GetLastImageData() (img *mmcore.Image, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if s.lastImage == nil {
+		return nil, mmcore.ErrCircularBufferEmpty
+	}
+	return copyImage(s.lastImage.Image), nil
+}
+
+// PopNextImageData returns the image of PopNextImage with the geometry it was acquired with.
+func (s *Session) PopNextImageData() (img *mmcore.Image, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if len(s.buffer) == 0 {
+		return nil, mmcore.ErrCircularBufferEmpty
+	}
+	img = s.buffer[0].Image
+	s.buffer[0] = nil
+	s.buffer = s.buffer[1:]
+	return img, nil
+}
+
+// GetLastImageWithMetadata returns the image of GetLastImageData with its metadata.
+func (s *Session) GetLastImageWithMetadata() (img *mmcore.Image, md *mmcore.ImageMetadata, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if s.lastImage == nil {
+		return nil, nil, mmcore.ErrCircularBufferEmpty
+	}
+	return copyImage(s.lastImage.Image), mmcore.NewImageMetadata(copyTags(s.lastImage.tags)), nil
+}
+
+// PopNextImageWithMetadata returns the image of PopNextImageData with its metadata.
+func (s *Session) PopNextImageWithMetadata() (img *mmcore.Image, md *mmcore.ImageMetadata, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if len(s.buffer) == 0 {
+		return nil, nil, mmcore.ErrCircularBufferEmpty
+	}
+	next := s.buffer[0]
+	s.buffer[0] = nil
+	s.buffer = s.buffer[1:]
+	return next.Image, mmcore.NewImageMetadata(next.tags), nil
+}
+
+// PopNextImageInto removes the oldest image from the circular buffer and copies it into
+// dst[:n], where n is the size of the image. If the capacity of dst is less than n, it
+// returns io.ErrShortBuffer and the image stays in the buffer.
+func (s *Session) PopNextImageInto(dst []byte) (img *mmcore.Image, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if len(s.buffer) == 0 {
+		return nil, mmcore.ErrCircularBufferEmpty
+	}
+	next := s.buffer[0]
+	if cap(dst) < len(next.Pix) {
+		return nil, io.ErrShortBuffer
+	}
+	s.buffer[0] = nil
+	s.buffer = s.buffer[1:]
+	c := *next.Image
+	c.Pix = dst[:len(next.Pix)]
+	copy(c.Pix, next.Pix)
+	return &c, nil
+}
+
+// PopNextImagePooled removes the oldest image from the circular buffer and copies it
+// into a buffer from pool.
+func (s *Session) PopNextImagePooled(pool *mmcore.ImagePool) (img *mmcore.Image, err error) {
+	s.lock()
+	size := 0
+	if len(s.buffer) > 0 {
+		size = len(s.buffer[0].Pix)
+	}
+	s.unlock()
+
+	buf := pool.Get(size)
+	if img, err = s.PopNextImageInto(buf); err != nil {
+		pool.Put(buf)
+		return nil, err
+	}
+	return img, nil
+}
+
+// BorrowNextImage removes the oldest image from the circular buffer. The images of the
+// simulated buffer are not reused, so Release has no effect.
+func (s *Session) BorrowNextImage() (img *mmcore.BorrowedImage, err error) {
+	next, err := s.PopNextImageData()
+	if err != nil {
+		return nil, err
+	}
+	return mmcore.NewBorrowedImage(next, nil), nil
+}
+
+func (s *Session) GetRemainingImageCount() (count int) {
+	s.lock()
+	defer s.unlock()
+	return len(s.buffer)
+}
+
+func (s *Session) GetBufferTotalCapacity() (capacity int) {
+	s.lock()
+	defer s.unlock()
+	return s.capacity(s.currentImageSize())
+}
+
+func (s *Session) GetBufferFreeCapacity() (capacity int) {
+	s.lock()
+	defer s.unlock()
+	return s.capacity(s.currentImageSize()) - len(s.buffer)
+}
+
+func (s *Session) IsBufferOverflowed() (overflowed bool) {
+	s.lock()
+	defer s.unlock()
+	return s.bufferOverflow
+}
+
+func (s *Session) SetCircularBufferMemoryFootprint(size_MB uint32) error {
+	s.lock()
+	defer s.unlock()
+
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	if size_MB == 0 {
+		return mmcore.ErrCircularBufferFailedToInitialize
+	}
+	s.bufferMB = size_MB
+	s.buffer = nil
+	s.lastImage = nil
+	s.bufferOverflow = false
+	return nil
+}
+
+func (s *Session) GetCircularBufferMemoryFootprint() (size_MB uint32) {
+	s.lock()
+	defer s.unlock()
+	return s.bufferMB
+}
+
+func (s *Session) InitializeCircularBuffer() error {
+	s.lock()
+	defer s.unlock()
+
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	s.buffer = nil
+	s.lastImage = nil
+	s.bufferOverflow = false
+	return nil
+}
+
+func (s *Session) ClearCircularBuffer() error {
+	s.lock()
+	defer s.unlock()
+
+	s.buffer = nil
+	s.lastImage = nil
+	s.bufferOverflow = false
+	return nil
+}
@@ -0,0 +1,359 @@
+package sim
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+//
+// Configuration groups and presets
+//
+
+type configGroup struct {
+	name    string
+	configs []*config
+}
+
+type config struct {
+	name     string
+	settings []mmcore.PropertySetting
+
+	// Calibration of pixel size configurations
+	pixelSizeUm float64
+	affine      [6]float64
+}
+
+func (g *configGroup) config(name string) *config {
+	for _, c := range g.configs {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *config) setting(label, property string) int {
+	for i, setting := range c.settings {
+		if setting.Label == label && setting.Property == property {
+			return i
+		}
+	}
+	return -1
+}
+
+// validConfigName checks a group or preset name, which cannot be empty or contain
+// a comma, the field separator of configuration files.
+func validConfigName(name string) bool {
+	return name != "" && !strings.Contains(name, ",")
+}
+
+func (s *Session) group(name string) (int, *configGroup) {
+	for i, g := range s.groups {
+		if g.name == name {
+			return i, g
+		}
+	}
+	return -1, nil
+}
+
+// config returns the preset, or ErrNoConfigGroup or ErrNoConfiguration.
+func (s *Session) config(group_name, config_name string) (*configGroup, *config, error) {
+	_, g := s.group(group_name)
+	if g == nil {
+		return nil, nil, mmcore.ErrNoConfigGroup
+	}
+	c := g.config(config_name)
+	if c == nil {
+		return g, nil, mmcore.ErrNoConfiguration
+	}
+	return g, c, nil
+}
+
+// defineConfig returns the preset, and defines the group and the preset if needed.
+func (s *Session) defineConfig(group_name, config_name string) (*config, error) {
+	if !validConfigName(group_name) || !validConfigName(config_name) {
+		return nil, mmcore.ErrBadConfigName
+	}
+	_, g := s.group(group_name)
+	if g == nil {
+		g = &configGroup{name: group_name}
+		s.groups = append(s.groups, g)
+	}
+	c := g.config(config_name)
+	if c == nil {
+		c = &config{name: config_name}
+		g.configs = append(g.configs, c)
+	}
+	return c, nil
+}
+
+// settingMatches tells whether the property has the value of the setting.
+// Numbers are compared by value, as the devices format them their own way.
+func (s *Session) settingMatches(setting mmcore.PropertySetting) bool {
+	p, err := s.property(setting.Label, setting.Property)
+	if err != nil {
+		return false
+	}
+	value := p.get()
+	if value == setting.Value {
+		return true
+	}
+	if p.typ == propString {
+		return false
+	}
+	a, err_a := strconv.ParseFloat(value, 64)
+	b, err_b := strconv.ParseFloat(setting.Value, 64)
+	return err_a == nil && err_b == nil && a == b
+}
+
+// currentConfig returns the name of the first preset whose settings all match
+// the current property values, or "" if there is none.
+func (s *Session) currentConfig(g *configGroup) string {
+	for _, c := range g.configs {
+		matches := true
+		for _, setting := range c.settings {
+			if !s.settingMatches(setting) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// emitConfigGroupChanges emits the current preset of each group with a setting of the property.
+func (s *Session) emitConfigGroupChanges(label, property string) {
+	for _, g := range s.groups {
+		for _, c := range g.configs {
+			if c.setting(label, property) >= 0 {
+				s.emit(&mmcore.ConfigGroupChangedEvent{GroupName: g.name, ConfigName: s.currentConfig(g)})
+				break
+			}
+		}
+	}
+}
+
+func (s *Session) DefineConfigGroup(group_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	if !validConfigName(group_name) {
+		return mmcore.ErrBadConfigName
+	}
+	if _, g := s.group(group_name); g != nil {
+		return mmcore.ErrDuplicateConfigGroup
+	}
+	s.groups = append(s.groups, &configGroup{name: group_name})
+	return nil
+}
+
+func (s *Session) DeleteConfigGroup(group_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	i, g := s.group(group_name)
+	if g == nil {
+		return mmcore.ErrNoConfigGroup
+	}
+	s.groups = append(s.groups[:i], s.groups[i+1:]...)
+	return nil
+}
+
+func (s *Session) RenameConfigGroup(old_group_name string, new_group_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	_, g := s.group(old_group_name)
+	if g == nil {
+		return mmcore.ErrNoConfigGroup
+	}
+	if !validConfigName(new_group_name) {
+		return mmcore.ErrBadConfigName
+	}
+	if _, other := s.group(new_group_name); other != nil {
+		return mmcore.ErrDuplicateConfigGroup
+	}
+	g.name = new_group_name
+	return nil
+}
+
+func (s *Session) IsGroupDefined(group_name string) (defined bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, g := s.group(group_name)
+	return g != nil, nil
+}
+
+func (s *Session) GetAvailableConfigGroups() (group_names []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	group_names = []string{}
+	for _, g := range s.groups {
+		group_names = append(group_names, g.name)
+	}
+	return group_names, nil
+}
+
+// DefineConfig defines an empty preset, and the group if needed.
+func (s *Session) DefineConfig(group_name string, config_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	_, err := s.defineConfig(group_name, config_name)
+	return err
+}
+
+// DefineConfigSetting adds a property value to a preset, or replaces it.
+// The group and the preset are defined if needed.
+func (s *Session) DefineConfigSetting(group_name string, config_name string, label string, property string, value string) error {
+	s.lock()
+	defer s.unlock()
+
+	if label == "" || property == "" {
+		return mmcore.ErrInvalidLabel
+	}
+	c, err := s.defineConfig(group_name, config_name)
+	if err != nil {
+		return err
+	}
+	if i := c.setting(label, property); i >= 0 {
+		c.settings[i].Value = value
+	} else {
+		c.settings = append(c.settings, mmcore.PropertySetting{Label: label, Property: property, Value: value})
+	}
+	return nil
+}
+
+func (s *Session) DeleteConfig(group_name string, config_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	g, c, err := s.config(group_name, config_name)
+	if err != nil {
+		return err
+	}
+	for i := range g.configs {
+		if g.configs[i] == c {
+			g.configs = append(g.configs[:i], g.configs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// DeleteConfigSetting removes a property value from a preset.
+func (s *Session) DeleteConfigSetting(group_name string, config_name string, label string, property string) error {
+	s.lock()
+	defer s.unlock()
+
+	_, c, err := s.config(group_name, config_name)
+	if err != nil {
+		return err
+	}
+	i := c.setting(label, property)
+	if i < 0 {
+		return mmcore.ErrNoConfiguration
+	}
+	c.settings = append(c.settings[:i], c.settings[i+1:]...)
+	return nil
+}
+
+func (s *Session) RenameConfig(group_name string, old_config_name string, new_config_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	g, c, err := s.config(group_name, old_config_name)
+	if err != nil {
+		return err
+	}
+	if !validConfigName(new_config_name) {
+		return mmcore.ErrBadConfigName
+	}
+	if g.config(new_config_name) != nil {
+		return mmcore.ErrDuplicateConfigGroup
+	}
+	c.name = new_config_name
+	return nil
+}
+
+func (s *Session) IsConfigDefined(group_name string, config_name string) (defined bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, _, err = s.config(group_name, config_name)
+	return err == nil, nil
+}
+
+// GetAvailableConfigs returns the presets of the group, and no preset for an undefined group.
+func (s *Session) GetAvailableConfigs(group_name string) (config_names []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	config_names = []string{}
+	if _, g := s.group(group_name); g != nil {
+		for _, c := range g.configs {
+			config_names = append(config_names, c.name)
+		}
+	}
+	return config_names, nil
+}
+
+// SetConfig sets the property values of the preset in order, stopping at the first error,
+// and then emits a single ConfigGroupChangedEvent.
+func (s *Session) SetConfig(group_name string, config_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	g, c, err := s.config(group_name, config_name)
+	if err != nil {
+		return err
+	}
+	s.applyingConfig = true
+	defer func() { s.applyingConfig = false }()
+	for _, setting := range c.settings {
+		if err := s.setProperty(setting.Label, setting.Property, setting.Value); err != nil {
+			return err
+		}
+	}
+	s.emit(&mmcore.ConfigGroupChangedEvent{GroupName: g.name, ConfigName: c.name})
+	return nil
+}
+
+// GetCurrentConfig returns the preset of the group that matches the current property
+// values, or "" if no preset matches.
+func (s *Session) GetCurrentConfig(group_name string) (config_name string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, g := s.group(group_name)
+	if g == nil {
+		return "", mmcore.ErrNoConfigGroup
+	}
+	return s.currentConfig(g), nil
+}
+
+// WaitForConfig returns when the preset is defined, as simulated devices are never busy.
+func (s *Session) WaitForConfig(ctx context.Context, group_name string, config_name string) error {
+	_, err := s.GetConfigData(group_name, config_name)
+	return err
+}
+
+// GetConfigData returns the property values of the preset.
+func (s *Session) GetConfigData(group_name string, config_name string) (settings []mmcore.PropertySetting, err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, c, err := s.config(group_name, config_name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]mmcore.PropertySetting{}, c.settings...), nil
+}
@@ -0,0 +1,431 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+const (
+	// ModuleName is the name of the simulated device adapter module.
+	ModuleName = "DemoCamera"
+
+	coreLabel = "Core"
+)
+
+type deviceType int
+
+const (
+	coreDevice deviceType = iota
+	cameraDevice
+	shutterDevice
+	stateDevice
+	stageDevice
+	xyStageDevice
+	autoFocusDevice
+	hubDevice
+)
+
+func (t deviceType) mmcoreType() mmcore.DeviceType {
+	switch t {
+	case coreDevice:
+		return mmcore.CoreDeviceType
+	case cameraDevice:
+		return mmcore.CameraDeviceType
+	case shutterDevice:
+		return mmcore.ShutterDeviceType
+	case stateDevice:
+		return mmcore.StateDeviceType
+	case stageDevice:
+		return mmcore.StageDeviceType
+	case xyStageDevice:
+		return mmcore.XYStageDeviceType
+	case autoFocusDevice:
+		return mmcore.AutoFocusDeviceType
+	case hubDevice:
+		return mmcore.HubDeviceType
+	}
+	return mmcore.UnknownType
+}
+
+type propertyType int
+
+const (
+	propString propertyType = iota
+	propFloat
+	propInteger
+)
+
+func (t propertyType) mmcoreType() mmcore.PropertyType {
+	switch t {
+	case propFloat:
+		return mmcore.FloatProperty
+	case propInteger:
+		return mmcore.IntegerProperty
+	}
+	return mmcore.StringProperty
+}
+
+// property is a device property.
+//
+// The value is always kept as a string, as in MMCore.
+// onSet is called with the normalized value before it is stored,
+// and onGet, if set, overrides the stored value.
+type property struct {
+	value        string
+	typ          propertyType
+	readOnly     bool
+	preInit      bool
+	sequenceable bool
+	hasLimits    bool
+	lower, upper float64
+	allowed      []string
+
+	// constructed properties exist before initialization without being pre-init properties.
+	constructed bool
+
+	allowedFunc func() []string
+	onSet       func(value string) error
+	onGet       func() string
+}
+
+func (p *property) allowedValues() []string {
+	if p.allowedFunc != nil {
+		return p.allowedFunc()
+	}
+	return p.allowed
+}
+
+func (p *property) describe(name string) mmcore.PropertyDescriptor {
+	return mmcore.PropertyDescriptor{
+		Name:          name,
+		Value:         p.get(),
+		Type:          p.typ.mmcoreType(),
+		ReadOnly:      p.readOnly,
+		PreInit:       p.preInit,
+		Sequenceable:  p.sequenceable,
+		HasLimits:     p.hasLimits,
+		LowerLimit:    p.lower,
+		UpperLimit:    p.upper,
+		AllowedValues: append([]string{}, p.allowedValues()...),
+	}
+}
+
+func (p *property) get() string {
+	if p.onGet != nil {
+		return p.onGet()
+	}
+	return p.value
+}
+
+// set validates and stores the value.
+func (p *property) set(value string) error {
+	if p.readOnly {
+		return mmcore.ErrDEVICE_GENERIC
+	}
+
+	switch p.typ {
+	case propFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return mmcore.ErrDEVICE_GENERIC
+		}
+		if p.hasLimits && (f < p.lower || f > p.upper) {
+			return mmcore.ErrDEVICE_GENERIC
+		}
+		value = formatFloat(f)
+	case propInteger:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f != float64(int64(f)) {
+			return mmcore.ErrDEVICE_GENERIC
+		}
+		if p.hasLimits && (f < p.lower || f > p.upper) {
+			return mmcore.ErrDEVICE_GENERIC
+		}
+		value = strconv.FormatInt(int64(f), 10)
+	}
+
+	if allowed := p.allowedValues(); len(allowed) > 0 && !contains(allowed, value) {
+		return mmcore.ErrDEVICE_GENERIC
+	}
+
+	if p.onSet != nil {
+		if err := p.onSet(value); err != nil {
+			return err
+		}
+	}
+	p.value = value
+	return nil
+}
+
+// device is a loaded device.
+//
+// Only the fields relevant to the device type are used.
+type device struct {
+	label       string
+	module      string
+	name        string
+	typ         deviceType
+	initialized bool
+	parent      string
+	delayMs     float64
+	props       map[string]*property
+
+	// Hub
+	installed []string
+
+	// State device
+	labels []string
+	state  int
+
+	// Focus (Z) stage
+	pos            float64
+	origin         float64
+	focusDirection int
+
+	// XY stage
+	x, y             float64
+	originX, originY float64
+	stepSize         float64
+
+	// Shutter
+	open bool
+
+	// Camera
+	roiX, roiY, roiW, roiH int
+	roiSet                 bool
+}
+
+func (d *device) addProperty(name string, p *property) *property {
+	d.props[name] = p
+	return p
+}
+
+func (d *device) addReadOnly(name, value string) *property {
+	return d.addProperty(name, &property{value: value, readOnly: true, constructed: true})
+}
+
+// visibleProperty returns the property if it exists in the current state of the device.
+//
+// As with a device adapter, only pre-init properties and a few read-only properties
+// created in the constructor exist before initialization.
+func (d *device) visibleProperty(name string) (*property, bool) {
+	p, ok := d.props[name]
+	if !ok || !(d.initialized || p.preInit || p.constructed) {
+		return nil, false
+	}
+	return p, true
+}
+
+func (d *device) propertyNames() []string {
+	names := make([]string, 0, len(d.props))
+	for name := range d.props {
+		if _, ok := d.visibleProperty(name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *device) intProperty(name string) int {
+	v, _ := strconv.Atoi(d.props[name].get())
+	return v
+}
+
+func (d *device) floatProperty(name string) float64 {
+	v, _ := strconv.ParseFloat(d.props[name].get(), 64)
+	return v
+}
+
+//
+// DemoCamera module
+//
+
+type moduleDevice struct {
+	name        string
+	description string
+	create      func(label, name string) *device
+}
+
+var demoDevices = []moduleDevice{
+	{"DCam", "Demo camera", newCamera},
+	{"DWheel", "Demo filter wheel", newStateDeviceFunc("Demo filter wheel driver", 10)},
+	{"DStateDevice", "Demo State Device", newStateDeviceFunc("Demo state device driver", 10)},
+	{"DObjective", "Demo objective turret", newStateDeviceFunc("Demo objective turret driver", 6)},
+	{"DStage", "Demo stage", newStage},
+	{"DXYStage", "Demo XY stage", newXYStage},
+	{"DLightPath", "Demo light path", newStateDeviceFunc("Demo light-path driver", 3)},
+	{"DAutoFocus", "Demo auto focus", newAutoFocus},
+	{"DShutter", "Demo shutter", newShutter},
+	{"DHub", "DHub", newHub},
+}
+
+func findModuleDevice(name string) (moduleDevice, bool) {
+	for _, md := range demoDevices {
+		if md.name == name {
+			return md, true
+		}
+	}
+	return moduleDevice{}, false
+}
+
+func newDevice(label, name, description string, typ deviceType) *device {
+	d := &device{
+		label:  label,
+		module: ModuleName,
+		name:   name,
+		typ:    typ,
+		props:  make(map[string]*property),
+	}
+	d.addReadOnly("Name", name)
+	d.addReadOnly("Description", description)
+	d.addReadOnly("HubID", "")
+	return d
+}
+
+func newCamera(label, name string) *device {
+	d := newDevice(label, name, "Demo Camera Device Adapter", cameraDevice)
+	d.addProperty("CameraName", &property{value: "DemoCamera-MultiMode", readOnly: true})
+	d.addProperty("CameraID", &property{value: "V1.0", readOnly: true})
+	d.addProperty("MaximumExposureMs", &property{value: "10000.0000", typ: propFloat, preInit: true})
+	d.addProperty("Exposure", &property{value: "10.0000", typ: propFloat, hasLimits: true, lower: 0, upper: 10000})
+	d.addProperty("Gain", &property{value: "0", typ: propInteger, hasLimits: true, lower: -5, upper: 8})
+	d.addProperty("Offset", &property{value: "0", typ: propInteger})
+	d.addProperty("ReadoutTime", &property{value: "0.0000", typ: propFloat})
+	d.addProperty("OnCameraCCDXSize", &property{value: "512", typ: propInteger})
+	d.addProperty("OnCameraCCDYSize", &property{value: "512", typ: propInteger})
+	d.addProperty("BitDepth", &property{value: "8", typ: propInteger, allowed: []string{"10", "12", "14", "16", "8"}})
+	d.addProperty("PixelType", &property{value: "8bit", allowed: []string{"16bit", "32bitRGB", "8bit"}})
+
+	// Changing the binning or the sensor size resets the ROI, as in DemoCamera.
+	clearROI := func(string) error {
+		d.roiSet = false
+		return nil
+	}
+	d.addProperty("Binning", &property{value: "1", typ: propInteger, allowed: []string{"1", "2", "4", "8"}, onSet: clearROI})
+	d.props["OnCameraCCDXSize"].onSet = clearROI
+	d.props["OnCameraCCDYSize"].onSet = clearROI
+	return d
+}
+
+func newStateDeviceFunc(description string, n_states int) func(label, name string) *device {
+	return func(label, name string) *device {
+		return newStateDevice(label, name, description, n_states)
+	}
+}
+
+func newStateDevice(label, name, description string, n_states int) *device {
+	d := newDevice(label, name, description, stateDevice)
+	d.labels = make([]string, n_states)
+	allowedStates := make([]string, n_states)
+	for i := range d.labels {
+		d.labels[i] = fmt.Sprintf("State-%d", i)
+		allowedStates[i] = strconv.Itoa(i)
+	}
+	d.addProperty("ClosedPosition", &property{value: "0", typ: propInteger})
+
+	d.addProperty("State", &property{
+		typ:     propInteger,
+		allowed: allowedStates,
+		onGet:   func() string { return strconv.Itoa(d.state) },
+		onSet: func(value string) error {
+			d.state, _ = strconv.Atoi(value)
+			return nil
+		},
+	})
+	d.addProperty("Label", &property{
+		allowedFunc: func() []string { return append([]string(nil), d.labels...) },
+		onGet:       func() string { return d.labels[d.state] },
+		onSet: func(value string) error {
+			for i, l := range d.labels {
+				if l == value {
+					d.state = i
+					return nil
+				}
+			}
+			return mmcore.ErrDEVICE_GENERIC
+		},
+	})
+	return d
+}
+
+func newStage(label, name string) *device {
+	d := newDevice(label, name, "Demo stage driver", stageDevice)
+	d.addProperty("UseSequences", &property{value: "No", allowed: []string{"No", "Yes"}})
+	d.addProperty("Position", &property{
+		typ:   propFloat,
+		onGet: func() string { return formatFloat(d.pos - d.origin) },
+		onSet: func(value string) error {
+			v, _ := strconv.ParseFloat(value, 64)
+			d.pos = v + d.origin
+			return nil
+		},
+	})
+	return d
+}
+
+func newXYStage(label, name string) *device {
+	d := newDevice(label, name, "Demo XY stage driver", xyStageDevice)
+	d.stepSize = 0.015
+	d.addProperty("TransposeMirrorX", &property{value: "0", typ: propInteger, allowed: []string{"0", "1"}})
+	d.addProperty("TransposeMirrorY", &property{value: "0", typ: propInteger, allowed: []string{"0", "1"}})
+	return d
+}
+
+func newShutter(label, name string) *device {
+	d := newDevice(label, name, "Demo shutter driver", shutterDevice)
+	d.addProperty("State", &property{
+		typ:     propInteger,
+		allowed: []string{"0", "1"},
+		onGet: func() string {
+			if d.open {
+				return "1"
+			}
+			return "0"
+		},
+		onSet: func(value string) error {
+			d.open = value == "1"
+			return nil
+		},
+	})
+	return d
+}
+
+func newAutoFocus(label, name string) *device {
+	return newDevice(label, name, "Demo auto-focus adapter", autoFocusDevice)
+}
+
+func newHub(label, name string) *device {
+	return newDevice(label, name, "Hub (required)", hubDevice)
+}
+
+// peripheralNames returns the devices of the module that can be installed under the hub.
+func peripheralNames() []string {
+	var names []string
+	for _, md := range demoDevices {
+		if md.name != "DHub" {
+			names = append(names, md.name)
+		}
+	}
+	return names
+}
+
+//
+// Helper functions
+//
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
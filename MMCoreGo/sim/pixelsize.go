@@ -0,0 +1,251 @@
+package sim
+
+import (
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+//
+// Pixel size configurations
+//
+
+// pixelSizeConfig returns the pixel size configuration, or ErrNoConfiguration.
+func (s *Session) pixelSizeConfig(resolution_id string) (*config, error) {
+	c := s.pixelSizes.config(resolution_id)
+	if c == nil {
+		return nil, mmcore.ErrNoConfiguration
+	}
+	return c, nil
+}
+
+// binningFactor returns the binning of the current camera, which scales the pixel size.
+func (s *Session) binningFactor() float64 {
+	if cam, ok := s.devices[s.camera]; ok {
+		if _, ok := cam.visibleProperty("Binning"); ok {
+			if binning := cam.intProperty("Binning"); binning >= 1 {
+				return float64(binning)
+			}
+		}
+	}
+	return 1
+}
+
+// currentPixelSize returns the pixel size and the affine transform of the current
+// pixel size configuration, scaled by the binning. Both are 0 if no configuration matches.
+func (s *Session) currentPixelSize() (pixel_size_um float64, affine []float64) {
+	affine = make([]float64, 6)
+	c := s.pixelSizes.config(s.currentConfig(&s.pixelSizes))
+	if c == nil {
+		return 0, affine
+	}
+	factor := s.binningFactor()
+	for i, a := range c.affine {
+		if i%3 == 2 {
+			affine[i] = a
+		} else {
+			affine[i] = a * factor
+		}
+	}
+	return c.pixelSizeUm * factor, affine
+}
+
+// emitPixelSizeChange emits the pixel size if it changed since it was last emitted.
+func (s *Session) emitPixelSizeChange() {
+	if pixel_size_um, _ := s.currentPixelSize(); pixel_size_um != s.pixelSizeUm {
+		s.pixelSizeUm = pixel_size_um
+		s.emit(&mmcore.PixelSizeChangedEvent{PixelSizeUm: pixel_size_um})
+	}
+}
+
+// DefinePixelSizeConfig adds a property value to a pixel size configuration, or replaces it.
+// The configuration is defined if needed, with an identity affine transform.
+func (s *Session) DefinePixelSizeConfig(resolution_id string, label string, property string, value string) error {
+	s.lock()
+	defer s.unlock()
+
+	if label == "" || property == "" {
+		return mmcore.ErrInvalidLabel
+	}
+	if !validConfigName(resolution_id) {
+		return mmcore.ErrBadConfigName
+	}
+	c := s.pixelSizes.config(resolution_id)
+	if c == nil {
+		c = &config{name: resolution_id, affine: [6]float64{1, 0, 0, 0, 1, 0}}
+		s.pixelSizes.configs = append(s.pixelSizes.configs, c)
+	}
+	if i := c.setting(label, property); i >= 0 {
+		c.settings[i].Value = value
+	} else {
+		c.settings = append(c.settings, mmcore.PropertySetting{Label: label, Property: property, Value: value})
+	}
+	s.emitPixelSizeChange()
+	return nil
+}
+
+func (s *Session) SetPixelSizeUm(resolution_id string, pixel_size_um float64) error {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return err
+	}
+	c.pixelSizeUm = pixel_size_um
+	s.emitPixelSizeChange()
+	return nil
+}
+
+// SetPixelSizeAffine sets the affine transform of the configuration, given by the 6
+// elements of its first two rows. It returns ErrBadAffineTransform if affine has
+// another length.
+func (s *Session) SetPixelSizeAffine(resolution_id string, affine []float64) error {
+	s.lock()
+	defer s.unlock()
+
+	if len(affine) != 6 {
+		return mmcore.ErrBadAffineTransform
+	}
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return err
+	}
+	copy(c.affine[:], affine)
+	return nil
+}
+
+func (s *Session) DeletePixelSizeConfig(resolution_id string) error {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return err
+	}
+	for i := range s.pixelSizes.configs {
+		if s.pixelSizes.configs[i] == c {
+			s.pixelSizes.configs = append(s.pixelSizes.configs[:i], s.pixelSizes.configs[i+1:]...)
+			break
+		}
+	}
+	s.emitPixelSizeChange()
+	return nil
+}
+
+func (s *Session) RenamePixelSizeConfig(old_resolution_id string, new_resolution_id string) error {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(old_resolution_id)
+	if err != nil {
+		return err
+	}
+	if !validConfigName(new_resolution_id) {
+		return mmcore.ErrBadConfigName
+	}
+	if s.pixelSizes.config(new_resolution_id) != nil {
+		return mmcore.ErrDuplicateConfigGroup
+	}
+	c.name = new_resolution_id
+	return nil
+}
+
+func (s *Session) IsPixelSizeConfigDefined(resolution_id string) (defined bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.pixelSizes.config(resolution_id) != nil, nil
+}
+
+func (s *Session) GetAvailablePixelSizeConfigs() (resolution_ids []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	resolution_ids = []string{}
+	for _, c := range s.pixelSizes.configs {
+		resolution_ids = append(resolution_ids, c.name)
+	}
+	return resolution_ids, nil
+}
+
+// GetPixelSizeConfigData returns the property values of the pixel size configuration.
+func (s *Session) GetPixelSizeConfigData(resolution_id string) (settings []mmcore.PropertySetting, err error) {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return nil, err
+	}
+	return append([]mmcore.PropertySetting{}, c.settings...), nil
+}
+
+// SetPixelSizeConfig sets the property values of the configuration in order, stopping
+// at the first error. The pixel size change is emitted with the property changes.
+func (s *Session) SetPixelSizeConfig(resolution_id string) error {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return err
+	}
+	for _, setting := range c.settings {
+		if err := s.setProperty(setting.Label, setting.Property, setting.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCurrentPixelSizeConfig returns the pixel size configuration that matches the current
+// property values, or "" if no configuration matches.
+func (s *Session) GetCurrentPixelSizeConfig() (resolution_id string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	return s.currentConfig(&s.pixelSizes), nil
+}
+
+// GetPixelSizeUm returns the pixel size of the current pixel size configuration in um,
+// scaled by the binning of the current camera, or 0 if no configuration matches.
+func (s *Session) GetPixelSizeUm() (pixel_size_um float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	pixel_size_um, _ = s.currentPixelSize()
+	return pixel_size_um, nil
+}
+
+// GetPixelSizeUmByID returns the pixel size of the configuration, without binning.
+func (s *Session) GetPixelSizeUmByID(resolution_id string) (pixel_size_um float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return 0, err
+	}
+	return c.pixelSizeUm, nil
+}
+
+// GetPixelSizeAffine returns the affine transform of the current pixel size configuration,
+// scaled by the binning of the current camera, or zeros if no configuration matches.
+func (s *Session) GetPixelSizeAffine() (affine []float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, affine = s.currentPixelSize()
+	return affine, nil
+}
+
+// GetPixelSizeAffineByID returns the affine transform of the configuration, without binning.
+func (s *Session) GetPixelSizeAffineByID(resolution_id string) (affine []float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	c, err := s.pixelSizeConfig(resolution_id)
+	if err != nil {
+		return nil, err
+	}
+	return append([]float64{}, c.affine[:]...), nil
+}
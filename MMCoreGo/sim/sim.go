@@ -0,0 +1,1013 @@
+// Package sim provides a simulated Micro-Manager core in pure Go.
+//
+// Session implements mmcore.Core against in-memory demo devices modelled on the
+// DemoCamera device adapter: a camera with ROI, exposure, binning and bit depth,
+// a focus (Z) stage, an XY stage, a shutter, state devices with labels,
+// an autofocus and a hub. Errors are reported with the same mmcore.Error codes
+// that MMCore uses, so code written against mmcore.Core can be tested
+// on machines without Micro-Manager.
+//
+// The only device adapter module is "DemoCamera" (ModuleName).
+// SnapImage returns immediately, while sequence acquisitions produce frames
+// in real time at the larger of the exposure time and the requested interval.
+//
+// Package sim does not need cgo. On machines without MMCoreC,
+// build and test it with CGO_ENABLED=0.
+package sim
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+// Session is a simulated core session.
+//
+// It is safe to use from multiple goroutines.
+type Session struct {
+	mu sync.Mutex
+
+	searchPaths []string
+	devices     map[string]*device
+	order       []string // load order of the devices, not including Core
+
+	// Current devices
+	camera      string
+	shutter     string
+	focus       string
+	xyStage     string
+	autoFocus   string
+	autoShutter bool
+
+	// Configuration groups, in definition order
+	groups         []*configGroup
+	applyingConfig bool // set by SetConfig, which emits a single group change
+
+	// Pixel size configurations, and the pixel size last emitted
+	pixelSizes  configGroup
+	pixelSizeUm float64
+
+	// Camera and circular buffer
+	snapped        *mmcore.Image
+	frameNumber    int
+	bufferMB       uint32
+	buffer         []*bufferedImage
+	lastImage      *bufferedImage
+	bufferOverflow bool
+	seqRunning     bool
+	seqStop        chan struct{}
+	seqDone        chan struct{}
+	seqStart       time.Time
+	seqImageNumber int64
+
+	// Autofocus
+	continuousFocus bool
+	autoFocusOffset float64
+	lastFocusScore  float64
+
+	// Events
+	pending []interface{}
+	events  mmcore.EventBus
+}
+
+var _ mmcore.Core = (*Session)(nil)
+
+// NewSession creates a simulated core session without any loaded device.
+func NewSession() *Session {
+	s := &Session{
+		bufferMB:    250,
+		autoShutter: true,
+	}
+	s.devices = map[string]*device{coreLabel: s.newCoreDevice()}
+	return s
+}
+
+// Close stops any running sequence acquisition, unloads all devices and unsubscribes all event subscribers.
+func (s *Session) Close() {
+	s.stopSequence()
+
+	s.lock()
+	s.unloadAll()
+	s.unlock()
+	s.events.Close()
+}
+
+// VersionInfo returns the version of the simulated core.
+func (s *Session) VersionInfo() string {
+	return "MMCore version 10.2.0 (simulated)"
+}
+
+// APIVersionInfo returns module and device interface versions.
+func (s *Session) APIVersionInfo() string {
+	return "Device API version 70, Module API version 10"
+}
+
+//
+// Initialization and setup.
+//
+
+// LoadDevice loads a device of the DemoCamera module and assigns a label name in the session.
+func (s *Session) LoadDevice(label, module_name, dev_name string) error {
+	s.lock()
+	defer s.unlock()
+
+	if label == "" {
+		return mmcore.ErrInvalidLabel
+	}
+	if _, ok := s.devices[label]; ok {
+		return mmcore.ErrDuplicateLabel
+	}
+	if module_name != ModuleName {
+		return mmcore.ErrLoadLibraryFailed
+	}
+	md, ok := findModuleDevice(dev_name)
+	if !ok {
+		return mmcore.ErrCreateFailed
+	}
+
+	d := md.create(label, dev_name)
+	if d.typ == hubDevice {
+		d.installed = peripheralNames()
+	}
+	s.devices[label] = d
+	s.order = append(s.order, label)
+	return nil
+}
+
+func (s *Session) UnloadDevice(label string) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return err
+	}
+	if d.typ == coreDevice {
+		return mmcore.ErrInvalidLabel
+	}
+	if d.typ == cameraDevice && label == s.camera && s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	s.unload(label)
+	return nil
+}
+
+func (s *Session) UnloadAllDevices() error {
+	s.lock()
+	defer s.unlock()
+
+	if s.seqRunning {
+		return mmcore.ErrNotAllowedDuringSequenceAcquisition
+	}
+	s.unloadAll()
+	return nil
+}
+
+// InitializeAllDevices initializes all devices that have not been initialized, hubs first.
+func (s *Session) InitializeAllDevices() error {
+	s.lock()
+	defer s.unlock()
+
+	for _, hubsFirst := range []bool{true, false} {
+		for _, label := range s.order {
+			d := s.devices[label]
+			if (d.typ == hubDevice) == hubsFirst {
+				d.initialized = true
+			}
+		}
+	}
+	s.emit(&mmcore.PropertiesChangedEvent{})
+	return nil
+}
+
+func (s *Session) InitializeDevice(label string) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return err
+	}
+	if d.initialized {
+		return mmcore.ErrGENERIC
+	}
+	d.initialized = true
+	s.emit(&mmcore.PropertiesChangedEvent{})
+	return nil
+}
+
+// Reset unloads all devices and clears the current device assignments.
+func (s *Session) Reset() error {
+	s.stopSequence()
+
+	s.lock()
+	defer s.unlock()
+	s.unloadAll()
+	return nil
+}
+
+func (s *Session) unload(label string) {
+	delete(s.devices, label)
+	for i, l := range s.order {
+		if l == label {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+	for _, current := range []*string{&s.camera, &s.shutter, &s.focus, &s.xyStage, &s.autoFocus} {
+		if *current == label {
+			*current = ""
+		}
+	}
+}
+
+func (s *Session) unloadAll() {
+	for _, label := range append([]string(nil), s.order...) {
+		s.unload(label)
+	}
+	s.snapped = nil
+	s.buffer = nil
+	s.lastImage = nil
+	s.bufferOverflow = false
+	s.continuousFocus = false
+	s.groups = nil
+	s.pixelSizes = configGroup{}
+	s.pixelSizeUm = 0
+}
+
+//
+// Event notification
+//
+
+func (s *Session) NotifyPropertiesChanged(event chan<- *mmcore.PropertiesChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyPropertiesChanged(event, opts...)
+}
+
+func (s *Session) NotifyPropertyChanged(event chan<- *mmcore.PropertyChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyPropertyChanged(event, opts...)
+}
+
+func (s *Session) NotifyConfigGroupChanged(event chan<- *mmcore.ConfigGroupChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyConfigGroupChanged(event, opts...)
+}
+
+func (s *Session) NotifySystemConfigurationLoaded(event chan<- *mmcore.SystemConfigurationLoadedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifySystemConfigurationLoaded(event, opts...)
+}
+
+func (s *Session) NotifyPixelSizeChanged(event chan<- *mmcore.PixelSizeChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyPixelSizeChanged(event, opts...)
+}
+
+func (s *Session) NotifyStagePositionChanged(event chan<- *mmcore.StagePositionChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyStagePositionChanged(event, opts...)
+}
+
+func (s *Session) NotifyXYStagePositionChanged(event chan<- *mmcore.XYStagePositionChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyXYStagePositionChanged(event, opts...)
+}
+
+func (s *Session) NotifyExposureChanged(event chan<- *mmcore.ExposureChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifyExposureChanged(event, opts...)
+}
+
+func (s *Session) NotifySLMExposureChanged(event chan<- *mmcore.SLMExposureChangedEvent, opts ...mmcore.SubscribeOption) *mmcore.Subscription {
+	return s.events.NotifySLMExposureChanged(event, opts...)
+}
+
+func (s *Session) lock() {
+	s.mu.Lock()
+}
+
+// unlock releases the session and then publishes the events queued while it was held.
+func (s *Session) unlock() {
+	events := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, event := range events {
+		s.events.Publish(event)
+	}
+}
+
+func (s *Session) emit(event interface{}) {
+	s.pending = append(s.pending, event)
+}
+
+// emitPropertyChanged emits the property change, followed by the change of the
+// current preset of each group with a setting of the property, as MMCore does,
+// and the change of the pixel size.
+func (s *Session) emitPropertyChanged(label, property, value string) {
+	s.emit(&mmcore.PropertyChangedEvent{Label: label, Property: property, Value: value})
+	if !s.applyingConfig {
+		s.emitConfigGroupChanges(label, property)
+	}
+	s.emitPixelSizeChange()
+}
+
+func (s *Session) emitStagePositionChanged(label string, pos float64) {
+	s.emit(&mmcore.StagePositionChangedEvent{Label: label, Pos: pos})
+}
+
+func (s *Session) emitXYStagePositionChanged(label string, x, y float64) {
+	s.emit(&mmcore.XYStagePositionChangedEvent{Label: label, X: x, Y: y})
+}
+
+func (s *Session) emitExposureChanged(label string, exposure_ms float64) {
+	s.emit(&mmcore.ExposureChangedEvent{Label: label, ExposureMs: exposure_ms})
+}
+
+//
+// Device listing.
+//
+
+func (s *Session) DeviceAdapterSearchPaths() (paths []string) {
+	s.lock()
+	defer s.unlock()
+	return append([]string{}, s.searchPaths...)
+}
+
+// SetDeviceAdapterSearchPaths saves the search paths.
+// They are not used by the simulator.
+func (s *Session) SetDeviceAdapterSearchPaths(paths []string) {
+	s.lock()
+	defer s.unlock()
+	s.searchPaths = append([]string{}, paths...)
+}
+
+func (s *Session) GetDeviceAdapterNames() (names []string, err error) {
+	return []string{ModuleName}, nil
+}
+
+func (s *Session) GetAvailableDevices(module_name string) (dev_names []string, err error) {
+	if module_name != ModuleName {
+		return []string{}, mmcore.ErrLoadLibraryFailed
+	}
+	dev_names = make([]string, 0, len(demoDevices))
+	for _, md := range demoDevices {
+		dev_names = append(dev_names, md.name)
+	}
+	return
+}
+
+func (s *Session) GetAvailableDeviceDescriptions(module_name string) (descriptions []string, err error) {
+	if module_name != ModuleName {
+		return []string{}, mmcore.ErrLoadLibraryFailed
+	}
+	descriptions = make([]string, 0, len(demoDevices))
+	for _, md := range demoDevices {
+		descriptions = append(descriptions, md.description)
+	}
+	return
+}
+
+//
+// Generic device control
+//
+
+// GetLoadedDevices returns the labels of the loaded devices in load order, followed by "Core".
+func (s *Session) GetLoadedDevices() (labels []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	labels = append([]string{}, s.order...)
+	labels = append(labels, coreLabel)
+	return
+}
+
+func (s *Session) GetDeviceType(label string) (device_type mmcore.DeviceType, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return mmcore.UnknownType, err
+	}
+	return d.typ.mmcoreType(), nil
+}
+
+// GetDeviceLibrary returns the module of the device, which is empty for Core.
+func (s *Session) GetDeviceLibrary(label string) (module_name string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return "", err
+	}
+	return d.module, nil
+}
+
+func (s *Session) GetDeviceName(label string) (dev_name string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return "", err
+	}
+	return d.name, nil
+}
+
+func (s *Session) GetDevicePropertyNames(label string) (names []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return []string{}, err
+	}
+	return d.propertyNames(), nil
+}
+
+func (s *Session) HasProperty(label string, property string) (has_property bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return false, err
+	}
+	_, has_property = d.visibleProperty(property)
+	return
+}
+
+func (s *Session) GetProperty(label string, property string) (value string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return "", err
+	}
+	return p.get(), nil
+}
+
+// SetProperty sets the property value of the device.
+//
+// state can be of the same types as with mmcore.Session.
+func (s *Session) SetProperty(label string, property string, state interface{}) (err error) {
+	value, err := mmcore.FormatPropertyValue(label, property, state)
+	if err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	return s.setProperty(label, property, value)
+}
+
+func (s *Session) setProperty(label, property, value string) error {
+	p, err := s.property(label, property)
+	if err != nil {
+		return err
+	}
+	if err := p.set(value); err != nil {
+		return err
+	}
+	s.emitPropertyChanged(label, property, p.get())
+	if d := s.devices[label]; d.typ == cameraDevice && property == "Exposure" {
+		s.emitExposureChanged(label, d.floatProperty("Exposure"))
+	}
+	return nil
+}
+
+func (s *Session) GetAllowedPropertyValues(label string, property string) (values []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return []string{}, err
+	}
+	return append([]string{}, p.allowedValues()...), nil
+}
+
+func (s *Session) IsPropertyReadOnly(label string, property string) (read_only bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return false, err
+	}
+	return p.readOnly, nil
+}
+
+func (s *Session) IsPropertyPreInit(label string, property string) (pre_init bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return false, err
+	}
+	return p.preInit, nil
+}
+
+func (s *Session) IsPropertySequenceable(label string, property string) (sequenceable bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return false, err
+	}
+	return p.sequenceable, nil
+}
+
+func (s *Session) HasPropertyLimits(label string, property string) (has_limits bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return false, err
+	}
+	return p.hasLimits, nil
+}
+
+func (s *Session) GetPropertyLowerLimit(label string, property string) (lower_limit float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return p.lower, nil
+}
+
+func (s *Session) GetPropertyUpperLimit(label string, property string) (upper_limit float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return p.upper, nil
+}
+
+func (s *Session) PropertyType(label string, property string) (property_type mmcore.PropertyType, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return mmcore.UndefProperty, err
+	}
+	return p.typ.mmcoreType(), nil
+}
+
+func (s *Session) DescribeProperty(label string, property string) (desc mmcore.PropertyDescriptor, err error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return mmcore.PropertyDescriptor{}, err
+	}
+	return p.describe(property), nil
+}
+
+func (s *Session) DescribeDevice(label string) (descs []mmcore.PropertyDescriptor, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return nil, err
+	}
+	names := d.propertyNames()
+	descs = make([]mmcore.PropertyDescriptor, len(names))
+	for i, name := range names {
+		descs[i] = d.props[name].describe(name)
+	}
+	return descs, nil
+}
+
+// getPropertyWithType returns the value and the type of the property.
+func (s *Session) getPropertyWithType(label string, property string) (string, mmcore.PropertyType, error) {
+	s.lock()
+	defer s.unlock()
+
+	p, err := s.property(label, property)
+	if err != nil {
+		return "", mmcore.UndefProperty, err
+	}
+	return p.get(), p.typ.mmcoreType(), nil
+}
+
+func (s *Session) GetPropertyFloat(label string, property string) (value float64, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return mmcore.ParsePropertyFloat(label, property, str, property_type)
+}
+
+func (s *Session) GetPropertyInt(label string, property string) (value int, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return 0, err
+	}
+	return mmcore.ParsePropertyInt(label, property, str, property_type)
+}
+
+func (s *Session) GetPropertyBool(label string, property string) (value bool, err error) {
+	str, property_type, err := s.getPropertyWithType(label, property)
+	if err != nil {
+		return false, err
+	}
+	return mmcore.ParsePropertyBool(label, property, str, property_type)
+}
+
+//
+// Device status
+//
+
+// Simulated devices finish their moves immediately, so they are never busy,
+// and the Wait methods return without waiting.
+
+func (s *Session) DeviceBusy(label string) (busy bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.device(label); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *Session) DeviceTypeBusy(device_type mmcore.DeviceType) (busy bool, err error) {
+	return false, nil
+}
+
+func (s *Session) WaitForDevice(ctx context.Context, label string) error {
+	_, err := s.DeviceBusy(label)
+	return err
+}
+
+func (s *Session) WaitForDeviceType(ctx context.Context, device_type mmcore.DeviceType) error {
+	return nil
+}
+
+func (s *Session) WaitForSystem(ctx context.Context) error {
+	return nil
+}
+
+// The delay is stored but not waited for. Like MMCore, the delay of the Core
+// device is ignored and always 0.
+func (s *Session) SetDeviceDelayMs(label string, delay_ms float64) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return err
+	}
+	if label != coreLabel {
+		d.delayMs = delay_ms
+	}
+	return nil
+}
+
+func (s *Session) GetDeviceDelayMs(label string) (delay_ms float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return 0, err
+	}
+	return d.delayMs, nil
+}
+
+// device returns the loaded device with the label.
+func (s *Session) device(label string) (*device, error) {
+	d, ok := s.devices[label]
+	if !ok {
+		return nil, mmcore.ErrInvalidLabel
+	}
+	return d, nil
+}
+
+// deviceOfType returns the device with the label, or the current device of the type if label is empty.
+//
+// It returns errNoDevice if label is empty and no current device is set, and
+// ErrGENERIC if the device is not of the requested type, as MMCore does.
+func (s *Session) deviceOfType(label string, current string, typ deviceType, errNoDevice error) (*device, error) {
+	if label == "" {
+		label = current
+		if label == "" {
+			return nil, errNoDevice
+		}
+	}
+	d, err := s.device(label)
+	if err != nil {
+		return nil, err
+	}
+	if d.typ != typ {
+		return nil, mmcore.ErrGENERIC
+	}
+	return d, nil
+}
+
+func (s *Session) property(label, property string) (*property, error) {
+	d, err := s.device(label)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := d.visibleProperty(property)
+	if !ok {
+		return nil, mmcore.ErrDEVICE_GENERIC
+	}
+	return p, nil
+}
+
+//
+// Manage current devices.
+//
+
+func (s *Session) SetCameraDevice(label string) error {
+	return s.setCurrentDevice("Camera", label)
+}
+
+func (s *Session) SetShutterDevice(label string) error {
+	return s.setCurrentDevice("Shutter", label)
+}
+
+func (s *Session) SetFocusDevice(label string) error {
+	return s.setCurrentDevice("Focus", label)
+}
+
+func (s *Session) SetXYStageDevice(label string) error {
+	return s.setCurrentDevice("XYStage", label)
+}
+
+func (s *Session) SetAutoFocusDevice(label string) error {
+	return s.setCurrentDevice("AutoFocus", label)
+}
+
+func (s *Session) CameraDevice() (label string) {
+	s.lock()
+	defer s.unlock()
+	return s.camera
+}
+
+func (s *Session) ShutterDevice() (label string) {
+	s.lock()
+	defer s.unlock()
+	return s.shutter
+}
+
+func (s *Session) FocusDevice() (label string) {
+	s.lock()
+	defer s.unlock()
+	return s.focus
+}
+
+func (s *Session) XYStageDevice() (label string) {
+	s.lock()
+	defer s.unlock()
+	return s.xyStage
+}
+
+func (s *Session) AutoFocusDevice() (label string) {
+	s.lock()
+	defer s.unlock()
+	return s.autoFocus
+}
+
+// setCurrentDevice sets the current device through the property of the Core device.
+func (s *Session) setCurrentDevice(role string, label string) error {
+	s.lock()
+	defer s.unlock()
+
+	p := s.devices[coreLabel].props[role]
+	if !contains(p.allowedValues(), label) {
+		if _, err := s.device(label); err != nil {
+			return err
+		}
+		return mmcore.ErrGENERIC
+	}
+	if err := p.set(label); err != nil {
+		return err
+	}
+	s.emitPropertyChanged(coreLabel, role, label)
+	return nil
+}
+
+// newCoreDevice creates the Core device, whose properties hold the current devices.
+func (s *Session) newCoreDevice() *device {
+	d := &device{
+		label:       coreLabel,
+		name:        coreLabel,
+		typ:         coreDevice,
+		initialized: true,
+		props:       make(map[string]*property),
+	}
+
+	roles := []struct {
+		name    string
+		typ     deviceType
+		current *string
+	}{
+		{"Camera", cameraDevice, &s.camera},
+		{"Shutter", shutterDevice, &s.shutter},
+		{"Focus", stageDevice, &s.focus},
+		{"XYStage", xyStageDevice, &s.xyStage},
+		{"AutoFocus", autoFocusDevice, &s.autoFocus},
+	}
+	for _, role := range roles {
+		role := role
+		d.addProperty(role.name, &property{
+			allowedFunc: func() []string { return s.labelsOfType(role.typ) },
+			onGet:       func() string { return *role.current },
+			onSet: func(value string) error {
+				if value != "" && s.devices[value].typ == cameraDevice && s.seqRunning {
+					return mmcore.ErrNotAllowedDuringSequenceAcquisition
+				}
+				*role.current = value
+				return nil
+			},
+		})
+	}
+
+	d.addProperty("AutoShutter", &property{
+		typ:     propInteger,
+		allowed: []string{"0", "1"},
+		onGet: func() string {
+			if s.autoShutter {
+				return "1"
+			}
+			return "0"
+		},
+		onSet: func(value string) error {
+			s.autoShutter = value == "1"
+			return nil
+		},
+	})
+	return d
+}
+
+// labelsOfType returns "" followed by the labels of the loaded devices of the type.
+func (s *Session) labelsOfType(typ deviceType) []string {
+	labels := []string{""}
+	for _, label := range s.order {
+		if s.devices[label].typ == typ {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+//
+// Hub and peripheral devices
+//
+
+func (s *Session) SetParentLabel(label string, parent_label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return err
+	}
+	d.parent = parent_label
+	return nil
+}
+
+func (s *Session) GetParentLabel(label string) (parent_label string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.device(label)
+	if err != nil {
+		return "", err
+	}
+	return d.parent, nil
+}
+
+func (s *Session) GetInstalledDevices(hub_label string) (names []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	hub, err := s.deviceOfType(hub_label, "", hubDevice, mmcore.ErrInvalidLabel)
+	if err != nil {
+		return []string{}, err
+	}
+	return append([]string{}, hub.installed...), nil
+}
+
+func (s *Session) GetInstalledDeviceDescription(hub_label string, name string) (descriptions string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	hub, err := s.deviceOfType(hub_label, "", hubDevice, mmcore.ErrInvalidLabel)
+	if err != nil {
+		return "", err
+	}
+	if !contains(hub.installed, name) {
+		return "", mmcore.ErrDEVICE_GENERIC
+	}
+	return "N/A", nil
+}
+
+func (s *Session) GetLoadedPeripheralDevices(hub_label string) (labels []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	labels = []string{}
+	for _, label := range s.order {
+		if s.devices[label].parent == hub_label {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
+
+//
+// System state
+//
+
+// Snapshot records the state of all loaded devices. See mmcore.TakeSnapshot.
+func (s *Session) Snapshot() (*mmcore.Snapshot, error) {
+	return mmcore.TakeSnapshot(s)
+}
+
+// ApplySnapshot restores the state of a snapshot. See mmcore.ApplySnapshot.
+func (s *Session) ApplySnapshot(snap *mmcore.Snapshot) (mmcore.ApplyReport, error) {
+	return mmcore.ApplySnapshot(s, snap)
+}
+
+// LoadSystemConfiguration loads a hardware configuration (.cfg) file.
+// See mmcore.ApplySystemConfiguration.
+func (s *Session) LoadSystemConfiguration(path string) error {
+	cfg, err := mmcore.ReadSystemConfiguration(path)
+	if err != nil {
+		return err
+	}
+	if err := mmcore.ApplySystemConfiguration(s, cfg); err != nil {
+		return err
+	}
+	s.lock()
+	s.emit(&mmcore.SystemConfigurationLoadedEvent{})
+	s.unlock()
+	return nil
+}
+
+// LoadHardwareProfile loads a hardware profile (.json, .yaml or .yml) file.
+// See mmcore.HardwareProfile.
+func (s *Session) LoadHardwareProfile(path string) error {
+	p, err := mmcore.ReadHardwareProfile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := p.SystemConfiguration()
+	if err != nil {
+		return err
+	}
+	if err := mmcore.ApplySystemConfiguration(s, cfg); err != nil {
+		return err
+	}
+	s.lock()
+	s.emit(&mmcore.SystemConfigurationLoadedEvent{})
+	s.unlock()
+	return nil
+}
+
+// SaveSystemConfiguration writes the configuration of the loaded devices to a
+// hardware configuration (.cfg) file. See mmcore.TakeSystemConfiguration.
+func (s *Session) SaveSystemConfiguration(path string) error {
+	cfg, err := mmcore.TakeSystemConfiguration(s)
+	if err != nil {
+		return err
+	}
+	return mmcore.WriteSystemConfiguration(path, cfg)
+}
+
+//
+// Miscellaneous
+//
+
+func (s *Session) UserId() (userid string) {
+	if userid = os.Getenv("USER"); userid == "" {
+		userid = os.Getenv("USERNAME")
+	}
+	return
+}
+
+func (s *Session) HostName() (hostname string) {
+	hostname, _ = os.Hostname()
+	return
+}
+
+func (s *Session) MACAddresses() (addresses []string) {
+	return []string{}
+}
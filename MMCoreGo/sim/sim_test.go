@@ -0,0 +1,1010 @@
+package sim_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+// snapImage runs the acquisition against any mmcore.Core.
+func snapImage(mmc mmcore.Core, cameraLabel string) ([]byte, error) {
+	err := mmc.SetCameraDevice(cameraLabel)
+	if err != nil {
+		return nil, err
+	}
+	err = mmc.SetExposureTime(100)
+	if err != nil {
+		return nil, err
+	}
+	err = mmc.SnapImage()
+	if err != nil {
+		return nil, err
+	}
+	return mmc.GetImage()
+}
+
+func ExampleSession_SnapImage() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	err := mmc.LoadDevice("Camera", "DemoCamera", "DCam")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.InitializeAllDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	buf, err := snapImage(mmc, "Camera")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("width=%d, height=%d, bytesPerPixel=%d, bitDepth=%d\n", mmc.ImageWidth(), mmc.ImageHeight(), mmc.BytesPerPixel(), mmc.ImageBitDepth())
+	fmt.Printf("len(buf)=%d\n", len(buf))
+
+	err = mmc.SetProperty("Camera", "Binning", 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetProperty("Camera", "PixelType", "16bit")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetProperty("Camera", "BitDepth", 12)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetROI(10, 20, 100, 50)
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf, err = snapImage(mmc, "Camera")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("width=%d, height=%d, bytesPerPixel=%d, bitDepth=%d\n", mmc.ImageWidth(), mmc.ImageHeight(), mmc.BytesPerPixel(), mmc.ImageBitDepth())
+	fmt.Printf("len(buf)=%d\n", len(buf))
+
+	// Output:
+	// width=512, height=512, bytesPerPixel=1, bitDepth=8
+	// len(buf)=262144
+	// width=100, height=50, bytesPerPixel=2, bitDepth=12
+	// len(buf)=10000
+}
+
+func ExampleSession_GetState() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	wheelLabel := "DWheel"
+	err := mmc.LoadDevice(wheelLabel, "DemoCamera", "DWheel")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.InitializeAllDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n_states, err := mmc.NumberOfStates(wheelLabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Number of States: %d\n", n_states)
+
+	err = mmc.DefineStateLabel(wheelLabel, 1, "GFP")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetStateLabel(wheelLabel, "GFP")
+	if err != nil {
+		log.Fatal(err)
+	}
+	state, err := mmc.GetState(wheelLabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Current State: %d\n", state)
+
+	err = mmc.SetProperty(wheelLabel, "State", 4)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state_label, err := mmc.GetStateLabel(wheelLabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Current state label: %q\n", state_label)
+
+	// Output:
+	// Number of States: 10
+	// Current State: 1
+	// Current state label: "State-4"
+}
+
+func ExampleSession_GetXYPosition() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	xyStageLabel := "DXYStage"
+	err := mmc.LoadDevice(xyStageLabel, "DemoCamera", "DXYStage")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.InitializeAllDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = mmc.SetXYPosition(xyStageLabel, 10.2, 20)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pos_x, pos_y, err := mmc.GetXYPosition(xyStageLabel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Position: %.3f, %.3f\n", pos_x, pos_y)
+
+	// Output:
+	// Position: 10.200, 19.995
+}
+
+func ExampleSession_GetImageData() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetProperty("Camera", "PixelType", "16bit"),
+		mmc.SetProperty("Camera", "BitDepth", 12),
+		mmc.SetROI(10, 20, 100, 50),
+		mmc.SnapImage(),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	img, err := mmc.GetImageData()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("bounds:", img.Bounds(), "bit depth:", img.BitDepth, "max:", img.MaxValue())
+	fmt.Println("gray16:", img.ColorModel() == color.Gray16Model)
+	fmt.Println("raw:", img.Value(5, 0), "scaled:", img.At(5, 0))
+
+	// Output:
+	// bounds: (0,0)-(100,50) bit depth: 12 max: 4095
+	// gray16: true
+	// raw: 35 scaled: {560}
+}
+
+func TestImageData(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(1),
+		mmc.SetProperty("Camera", "PixelType", "32bitRGB"),
+		mmc.SetROI(0, 0, 64, 32),
+		mmc.SnapImage(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	img, err := mmc.GetImageData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ColorModel() != color.RGBAModel || img.NumComponents != 4 || img.BitDepth != 8 {
+		t.Errorf("RGB image: %v components, bit depth %d", img.NumComponents, img.BitDepth)
+	}
+	if c := img.At(3, 2); c != (color.RGBA{R: 5, G: 2, B: 3, A: 0xff}) {
+		t.Errorf("At(3, 2) = %v", c)
+	}
+
+	// The image can be used with the standard library.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.RGBAModel.Convert(decoded.At(10, 20)); got != img.At(10, 20) {
+		t.Errorf("decoded PNG: %v, want %v", got, img.At(10, 20))
+	}
+
+	// Images in the circular buffer keep the geometry they were acquired with.
+	if err := mmc.StartSequenceAcquisition(2, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	for mmc.IsSequenceRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := mmc.SetProperty("Camera", "PixelType", "8bit"); err != nil {
+		t.Fatal(err)
+	}
+	last, err := mmc.GetLastImageData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := mmc.PopNextImageData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.BytesPerPixel != 4 || next.BytesPerPixel != 4 || mmc.BytesPerPixel() != 1 {
+		t.Errorf("bytes per pixel: last %d, next %d, camera %d", last.BytesPerPixel, next.BytesPerPixel, mmc.BytesPerPixel())
+	}
+
+	for _, test := range []struct {
+		len, width, height, bytes_per_pixel, n_components int
+	}{
+		{100, 10, 10, 2, 1},
+		{300, 10, 10, 3, 1},
+		{400, 10, 10, 4, 1},
+	} {
+		_, err := mmcore.NewImage(make([]byte, test.len), test.width, test.height, test.bytes_per_pixel, 0, test.n_components)
+		if _, ok := err.(*mmcore.ImageFormatError); !ok {
+			t.Errorf("NewImage%v: %v, want an ImageFormatError", test, err)
+		}
+	}
+	if img, err := mmcore.NewImage(make([]byte, 200), 10, 10, 2, 0, 1); err != nil || img.BitDepth != 16 {
+		t.Errorf("NewImage with bit depth 0: %v, %v", img, err)
+	}
+}
+
+func ExampleSession_PopNextImagePooled() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(1),
+		mmc.StartSequenceAcquisition(3, 0, true),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Each image is put back into the pool once it is processed,
+	// and its buffer is reused by a later PopNextImagePooled.
+	var pool mmcore.ImagePool
+	for n := 0; n < 3; {
+		img, err := mmc.PopNextImagePooled(&pool)
+		if err == mmcore.ErrCircularBufferEmpty {
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(img.Bounds(), "first pixel:", img.Value(0, 0))
+		pool.Put(img.Pix)
+		n++
+	}
+
+	// Output:
+	// (0,0)-(512,512) first pixel: 0
+	// (0,0)-(512,512) first pixel: 1
+	// (0,0)-(512,512) first pixel: 2
+}
+
+func TestImageRetrieval(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(1),
+		mmc.SetROI(0, 0, 16, 8),
+		mmc.StartSequenceAcquisition(2, 0, true),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for mmc.IsSequenceRunning() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := mmc.PopNextImageInto(make([]byte, 16*8-1)); err != io.ErrShortBuffer {
+		t.Errorf("PopNextImageInto a short buffer: %v", err)
+	}
+	dst := make([]byte, 0, 1024)
+	img, err := mmc.PopNextImageInto(dst)
+	if err != nil || len(img.Pix) != 16*8 || &img.Pix[0] != &dst[:1][0] {
+		t.Errorf("PopNextImageInto: %v, %+v", err, img)
+	}
+	borrowed, err := mmc.BorrowNextImage()
+	if err != nil || borrowed.Value(0, 0) != 1 {
+		t.Fatalf("BorrowNextImage: %v, %+v", err, borrowed)
+	}
+	borrowed.Release()
+	if _, err := mmc.PopNextImagePooled(new(mmcore.ImagePool)); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("PopNextImagePooled on empty buffer: %v", err)
+	}
+}
+
+func ExampleSession_PopNextImageWithMetadata() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(5),
+		mmc.SetROI(8, 4, 16, 8),
+		mmc.StartSequenceAcquisition(3, 0, true),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The metadata records when each frame was acquired, rather than when it was popped.
+	var last time.Duration
+	for n := 0; n < 3; {
+		img, md, err := mmc.PopNextImageWithMetadata()
+		if err == mmcore.ErrCircularBufferEmpty {
+			time.Sleep(time.Millisecond)
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(md.Camera, md.ImageNumber, img.Bounds(), md.ROIX, md.ROIY, md.Tags[mmcore.MetadataPixelType],
+			md.ElapsedTime > last, !md.TimeReceived.IsZero())
+		last = md.ElapsedTime
+		n++
+	}
+
+	// Output:
+	// Camera 0 (0,0)-(16,8) 8 4 8bit true true
+	// Camera 1 (0,0)-(16,8) 8 4 8bit true true
+	// Camera 2 (0,0)-(16,8) 8 4 8bit true true
+}
+
+func ExampleSession_StreamSequence() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(1),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	frames, errs, err := mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: 3})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for frame := range frames {
+		fmt.Println(frame.Metadata.ImageNumber, frame.Bounds(), "first pixel:", frame.Value(0, 0))
+	}
+	if err := <-errs; err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 0 (0,0)-(512,512) first pixel: 0
+	// 1 (0,0)-(512,512) first pixel: 1
+	// 2 (0,0)-(512,512) first pixel: 2
+}
+
+func TestStreamSequence(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetExposureTime(1),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: -1}); err != mmcore.ErrInvalidImageSequence {
+		t.Errorf("StreamSequence of -1 images: %v", err)
+	}
+
+	// Canceling stops a continuous acquisition.
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, errs, err := mmc.StreamSequence(ctx, mmcore.StreamOptions{BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mmc.StreamSequence(ctx, mmcore.StreamOptions{}); err != mmcore.ErrNotAllowedDuringSequenceAcquisition {
+		t.Errorf("StreamSequence during a sequence acquisition: %v", err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if frame := <-frames; frame.Metadata.ImageNumber != i {
+			t.Errorf("frame %d: image number %d", i, frame.Metadata.ImageNumber)
+		}
+	}
+	cancel()
+	for range frames {
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("after cancel: %v", err)
+	}
+	if mmc.IsSequenceRunning() {
+		t.Error("the sequence acquisition is running after cancel")
+	}
+
+	// The 1 MB buffer holds 4 images, so a consumer that does not keep up
+	// overflows it. Once it has overflowed, the images left in the buffer are
+	// not sent: only the one being sent when it overflowed, if any.
+	if err := mmc.SetCircularBufferMemoryFootprint(1); err != nil {
+		t.Fatal(err)
+	}
+	frames, errs, err = mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: 10, StopOnOverflow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mmc.IsSequenceRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	n := 0
+	for range frames {
+		n++
+	}
+	if err, ok := (<-errs).(*mmcore.SequenceOverflowError); !ok || err.Received != int64(n) || n > 1 {
+		t.Errorf("overflow: received %d frames, error %v", n, err)
+	}
+
+	// Without StopOnOverflow, the core clears the full buffer and the acquisition
+	// goes on. The overflow is reported, and the stream ends with the last image.
+	frames, errs, err = mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mmc.IsSequenceRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	var last mmcore.Frame
+	for frame := range frames {
+		last = frame
+	}
+	var stream_errs []error
+	for err := range errs {
+		stream_errs = append(stream_errs, err)
+	}
+	if len(stream_errs) != 1 || last.Metadata == nil || last.Metadata.ImageNumber != 9 {
+		t.Errorf("overflow without StopOnOverflow: errors %v, last frame %+v", stream_errs, last)
+	} else if _, ok := stream_errs[0].(*mmcore.SequenceOverflowError); !ok {
+		t.Errorf("overflow without StopOnOverflow: errors %v, last image %d", stream_errs, last.Metadata.ImageNumber)
+	}
+
+	if _, _, err := mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: 1, BufferSize: -1}); err != mmcore.ErrInvalidImageSequence {
+		t.Errorf("StreamSequence with a negative buffer size: %v", err)
+	}
+	if mmc.IsSequenceRunning() {
+		t.Error("the sequence acquisition is running after an invalid buffer size")
+	}
+}
+
+func ExampleSession_StartSequenceAcquisition() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	err := mmc.LoadDevice("Camera", "DemoCamera", "DCam")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.InitializeAllDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetCameraDevice("Camera")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mmc.SetExposureTime(1)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = mmc.StartSequenceAcquisition(5, 0, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n_images := 0
+	for n_images < 5 {
+		if mmc.GetRemainingImageCount() == 0 {
+			continue
+		}
+		_, err := mmc.PopNextImage()
+		if err != nil {
+			log.Fatal(err)
+		}
+		n_images++
+	}
+	err = mmc.StopSequenceAcquisition()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Finished acquiring %d images with SequenceAcquisition.\n", n_images)
+	// Output:
+	// Finished acquiring 5 images with SequenceAcquisition.
+}
+
+func ExampleSession_DescribeProperty() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	if err := mmc.LoadDevice("Camera", "DemoCamera", "DCam"); err != nil {
+		log.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, name := range []string{"Binning", "Exposure"} {
+		desc, err := mmc.DescribeProperty("Camera", name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s (%s) = %s", desc.Name, desc.Type, desc.Value)
+		if desc.HasLimits {
+			fmt.Printf(", range [%g, %g]", desc.LowerLimit, desc.UpperLimit)
+		}
+		if len(desc.AllowedValues) > 0 {
+			fmt.Printf(", allowed %v", desc.AllowedValues)
+		}
+		fmt.Println()
+	}
+
+	// Output:
+	// Binning (Integer) = 1, allowed [1 2 4 8]
+	// Exposure (Float) = 10.0000, range [0, 10000]
+}
+
+func ExampleSession_SetConfig() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.LoadDevice("Wheel", "DemoCamera", "DWheel"),
+		mmc.InitializeAllDevices(),
+		mmc.DefineStateLabel("Wheel", 1, "Blue"),
+		mmc.DefineStateLabel("Wheel", 2, "Green"),
+		mmc.DefineConfigSetting("Channel", "DAPI", "Wheel", "Label", "Blue"),
+		mmc.DefineConfigSetting("Channel", "DAPI", "Camera", "Exposure", "50"),
+		mmc.DefineConfigSetting("Channel", "FITC", "Wheel", "Label", "Green"),
+		mmc.DefineConfigSetting("Channel", "FITC", "Camera", "Exposure", "20"),
+	} {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := mmc.SetConfig("Channel", "FITC"); err != nil {
+		log.Fatal(err)
+	}
+	current, _ := mmc.GetCurrentConfig("Channel")
+	state, _ := mmc.GetState("Wheel")
+	exposure, _ := mmc.GetProperty("Camera", "Exposure")
+	fmt.Println(current, state, exposure)
+
+	// A change of a property of the preset changes the current preset.
+	mmc.SetProperty("Camera", "Exposure", 25.0)
+	current, _ = mmc.GetCurrentConfig("Channel")
+	fmt.Printf("%q\n", current)
+
+	// Output:
+	// FITC 2 20.0000
+	// ""
+}
+
+func TestErrors(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, dev := range []struct{ label, name string }{
+		{"Camera", "DCam"},
+		{"Wheel", "DWheel"},
+		{"Z", "DStage"},
+		{"XY", "DXYStage"},
+		{"Shutter", "DShutter"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			t.Fatalf("LoadDevice(%q): %v", dev.label, err)
+		}
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want mmcore.Error
+	}{
+		{"duplicate label", mmc.LoadDevice("Camera", "DemoCamera", "DCam"), mmcore.ErrDuplicateLabel},
+		{"unknown module", mmc.LoadDevice("Other", "NoSuchModule", "DCam"), mmcore.ErrLoadLibraryFailed},
+		{"unknown label", mmc.SetState("NoSuchDevice", 1), mmcore.ErrInvalidLabel},
+		{"no camera", mmc.SnapImage(), mmcore.ErrCameraNotAvailable},
+		{"no focus device", mmc.SetPosition("", 1), mmcore.ErrNoDevice},
+		// MMCore reports a device of the wrong type with a generic error.
+		{"not a state device", mmc.SetState("Camera", 1), mmcore.ErrGENERIC},
+		{"not a stage", mmc.SetPosition("XY", 1), mmcore.ErrGENERIC},
+		{"not an XY stage", mmc.SetXYPosition("Z", 1, 1), mmcore.ErrGENERIC},
+		{"not a shutter", mmc.SetShutterOpen("Wheel", true), mmcore.ErrGENERIC},
+		{"not a camera", mmc.SetCameraDevice("Wheel"), mmcore.ErrGENERIC},
+		{"no autofocus", mmc.FullFocus(), mmcore.ErrAutoFocusNotAvailable},
+		{"empty buffer", func() error { _, err := mmc.PopNextImage(); return err }(), mmcore.ErrCircularBufferEmpty},
+		{"value not allowed", mmc.SetProperty("Camera", "Binning", 3), mmcore.ErrDEVICE_GENERIC},
+		{"value out of limits", mmc.SetProperty("Camera", "Exposure", -1.0), mmcore.ErrDEVICE_GENERIC},
+		{"read-only", mmc.SetProperty("Camera", "Name", "x"), mmcore.ErrDEVICE_GENERIC},
+	}
+	for _, tt := range tests {
+		if tt.err != tt.want {
+			t.Errorf("%s: got error %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func TestPreInitProperties(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	if err := mmc.LoadDevice("Camera", "DemoCamera", "DCam"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := mmc.GetDevicePropertyNames("Camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[Description HubID MaximumExposureMs Name]" {
+		t.Errorf("properties before initialization: %q", names)
+	}
+	if _, err := mmc.GetProperty("Camera", "Exposure"); err != mmcore.ErrDEVICE_GENERIC {
+		t.Errorf("GetProperty Exposure before initialization: %v", err)
+	}
+
+	if err := mmc.InitializeDevice("Camera"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := mmc.GetProperty("Camera", "Exposure"); err != nil || value != "10.0000" {
+		t.Errorf("GetProperty Exposure = %q, %v", value, err)
+	}
+}
+
+func TestTypedProperties(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	if err := mmc.LoadDevice("Camera", "DemoCamera", "DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+
+	if typ, err := mmc.PropertyType("Camera", "PixelType"); err != nil || typ != mmcore.StringProperty {
+		t.Errorf("PropertyType(PixelType) = %v, %v", typ, err)
+	}
+	if v, err := mmc.GetPropertyFloat("Camera", "Exposure"); err != nil || v != 10 {
+		t.Errorf("GetPropertyFloat(Exposure) = %v, %v", v, err)
+	}
+	if v, err := mmc.GetPropertyFloat("Camera", "Gain"); err != nil || v != 0 {
+		t.Errorf("GetPropertyFloat(Gain) = %v, %v", v, err)
+	}
+	if v, err := mmc.GetPropertyInt("Camera", "BitDepth"); err != nil || v != 8 {
+		t.Errorf("GetPropertyInt(BitDepth) = %v, %v", v, err)
+	}
+	if v, err := mmc.GetPropertyBool("Core", "AutoShutter"); err != nil || !v {
+		t.Errorf("GetPropertyBool(AutoShutter) = %v, %v", v, err)
+	}
+
+	_, err := mmc.GetPropertyInt("Camera", "Exposure")
+	if err, ok := err.(*mmcore.PropertyTypeError); !ok || err.Type != mmcore.FloatProperty {
+		t.Errorf("GetPropertyInt(Exposure): got error %v", err)
+	}
+	_, err = mmc.GetPropertyFloat("Camera", "PixelType")
+	if want := `property "PixelType" of "Camera" is not a float64: type String, value "8bit"`; err == nil || err.Error() != want {
+		t.Errorf("GetPropertyFloat(PixelType): got error %v, want %s", err, want)
+	}
+	if _, err := mmc.GetPropertyBool("Camera", "NoSuchProperty"); err != mmcore.ErrDEVICE_GENERIC {
+		t.Errorf("GetPropertyBool(NoSuchProperty): got error %v, want %v", err, mmcore.ErrDEVICE_GENERIC)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	propertiesEvents := make(chan *mmcore.PropertiesChangedEvent, 1)
+	stageEvents := make(chan *mmcore.StagePositionChangedEvent, 1)
+	xyStageEvents := make(chan *mmcore.XYStagePositionChangedEvent, 1)
+	exposureEvents := make(chan *mmcore.ExposureChangedEvent, 2)
+	mmc.NotifyPropertiesChanged(propertiesEvents)
+	mmc.NotifyStagePositionChanged(stageEvents)
+	mmc.NotifyXYStagePositionChanged(xyStageEvents)
+	mmc.NotifyExposureChanged(exposureEvents)
+
+	for _, dev := range []struct{ label, name string }{
+		{"Camera", "DCam"},
+		{"Z", "DStage"},
+		{"XY", "DXYStage"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	<-propertiesEvents
+
+	if err := mmc.SetPosition("Z", 12.5); err != nil {
+		t.Fatal(err)
+	}
+	event := <-stageEvents
+	if event.Label != "Z" || event.Pos != 12.5 {
+		t.Errorf("StagePositionChangedEvent = %+v", event)
+	}
+
+	if err := mmc.SetXYPosition("XY", 30, -15); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-xyStageEvents; *event != (mmcore.XYStagePositionChangedEvent{Label: "XY", X: 30, Y: -15}) {
+		t.Errorf("XYStagePositionChangedEvent = %+v", event)
+	}
+
+	// The exposure can be changed with SetExposureTime or through the property.
+	if err := mmc.SetCameraDevice("Camera"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetExposureTime(25); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetProperty("Camera", "Exposure", 50.0); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []float64{25, 50} {
+		if event := <-exposureEvents; *event != (mmcore.ExposureChangedEvent{Label: "Camera", ExposureMs: want}) {
+			t.Errorf("ExposureChangedEvent = %+v", event)
+		}
+	}
+}
+
+func TestConfigGroups(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	if err := mmc.LoadDevice("Wheel", "DemoCamera", "DWheel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *mmcore.ConfigGroupChangedEvent, 16)
+	mmc.NotifyConfigGroupChanged(events)
+
+	for _, test := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"DefineConfigGroup", mmc.DefineConfigGroup("Channel"), nil},
+		{"DefineConfigGroup duplicate", mmc.DefineConfigGroup("Channel"), mmcore.ErrDuplicateConfigGroup},
+		{"DefineConfigGroup empty", mmc.DefineConfigGroup(""), mmcore.ErrBadConfigName},
+		{"DefineConfig comma", mmc.DefineConfig("Channel", "a,b"), mmcore.ErrBadConfigName},
+		{"DefineConfigSetting", mmc.DefineConfigSetting("Channel", "One", "Wheel", "State", "1"), nil},
+		{"DefineConfigSetting", mmc.DefineConfigSetting("Channel", "Three", "Wheel", "State", "3"), nil},
+		{"SetConfig no group", mmc.SetConfig("Objective", "One"), mmcore.ErrNoConfigGroup},
+		{"SetConfig no preset", mmc.SetConfig("Channel", "Two"), mmcore.ErrNoConfiguration},
+		{"RenameConfig", mmc.RenameConfig("Channel", "Three", "3"), nil},
+		{"RenameConfig duplicate", mmc.RenameConfig("Channel", "3", "One"), mmcore.ErrDuplicateConfigGroup},
+		{"DeleteConfigSetting missing", mmc.DeleteConfigSetting("Channel", "One", "Wheel", "Label"), mmcore.ErrNoConfiguration},
+		{"DeleteConfigGroup missing", mmc.DeleteConfigGroup("Objective"), mmcore.ErrNoConfigGroup},
+	} {
+		if test.err != test.want {
+			t.Errorf("%s: %v, want %v", test.name, test.err, test.want)
+		}
+	}
+
+	configs, _ := mmc.GetAvailableConfigs("Channel")
+	if fmt.Sprint(configs) != "[One 3]" {
+		t.Errorf("GetAvailableConfigs: %v", configs)
+	}
+	settings, err := mmc.GetConfigData("Channel", "3")
+	if want := []mmcore.PropertySetting{{Label: "Wheel", Property: "State", Value: "3"}}; err != nil || !reflect.DeepEqual(settings, want) {
+		t.Errorf("GetConfigData: %v, %v, want %v", settings, err, want)
+	}
+
+	// SetConfig emits one event for the preset, a property change one for the new current preset.
+	if err := mmc.SetConfig("Channel", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetState("Wheel", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetState("Wheel", 5); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(got) < 3 {
+		select {
+		case event := <-events:
+			got = append(got, event.GroupName+"="+event.ConfigName)
+		case <-time.After(time.Second):
+			t.Fatalf("events: %v", got)
+		}
+	}
+	if fmt.Sprint(got) != "[Channel=3 Channel=One Channel=]" {
+		t.Errorf("events: %v", got)
+	}
+
+	if err := mmc.UnloadAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := mmc.GetAvailableConfigGroups(); len(groups) != 0 {
+		t.Errorf("groups after UnloadAllDevices: %v", groups)
+	}
+}
+
+func TestPixelSizeConfigs(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.LoadDevice("Camera", "DemoCamera", "DCam"),
+		mmc.LoadDevice("Objective", "DemoCamera", "DObjective"),
+		mmc.LoadDevice("XY", "DemoCamera", "DXYStage"),
+		mmc.InitializeAllDevices(),
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetXYStageDevice("XY"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := make(chan *mmcore.PixelSizeChangedEvent, 16)
+	mmc.NotifyPixelSizeChanged(events)
+
+	for _, test := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"DefinePixelSizeConfig", mmc.DefinePixelSizeConfig("Res10x", "Objective", "State", "1"), nil},
+		{"DefinePixelSizeConfig", mmc.DefinePixelSizeConfig("Res20x", "Objective", "State", "2"), nil},
+		{"DefinePixelSizeConfig comma", mmc.DefinePixelSizeConfig("a,b", "Objective", "State", "3"), mmcore.ErrBadConfigName},
+		{"SetPixelSizeUm", mmc.SetPixelSizeUm("Res10x", 0.65), nil},
+		{"SetPixelSizeUm", mmc.SetPixelSizeUm("Res20x", 0.325), nil},
+		{"SetPixelSizeUm missing", mmc.SetPixelSizeUm("Res40x", 1), mmcore.ErrNoConfiguration},
+		{"SetPixelSizeAffine", mmc.SetPixelSizeAffine("Res10x", []float64{0, -0.65, 0, 0.65, 0, 0}), nil},
+		{"SetPixelSizeAffine short", mmc.SetPixelSizeAffine("Res10x", []float64{0.65, 0, 0}), mmcore.ErrBadAffineTransform},
+		{"RenamePixelSizeConfig duplicate", mmc.RenamePixelSizeConfig("Res10x", "Res20x"), mmcore.ErrDuplicateConfigGroup},
+		{"SetPixelSizeConfig missing", mmc.SetPixelSizeConfig("Res40x"), mmcore.ErrNoConfiguration},
+	} {
+		if test.err != test.want {
+			t.Errorf("%s: %v, want %v", test.name, test.err, test.want)
+		}
+	}
+	ids, _ := mmc.GetAvailablePixelSizeConfigs()
+	if fmt.Sprint(ids) != "[Res10x Res20x]" {
+		t.Errorf("GetAvailablePixelSizeConfigs: %v", ids)
+	}
+
+	// The current pixel size follows the objective and the binning of the camera.
+	for _, err := range []error{
+		mmc.SetPixelSizeConfig("Res10x"),
+		mmc.SetProperty("Camera", "Binning", 2),
+		mmc.SetState("Objective", 3),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []float64
+	for len(got) < 3 {
+		select {
+		case event := <-events:
+			got = append(got, event.PixelSizeUm)
+		case <-time.After(time.Second):
+			t.Fatalf("events: %v", got)
+		}
+	}
+	if fmt.Sprint(got) != "[0.65 1.3 0]" {
+		t.Errorf("events: %v", got)
+	}
+	if id, _ := mmc.GetCurrentPixelSizeConfig(); id != "" {
+		t.Errorf("GetCurrentPixelSizeConfig: %q, want none", id)
+	}
+	if _, err := mmcore.CurrentPixelTransform(mmc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mmc.SetPixelSizeConfig("Res10x"); err != nil {
+		t.Fatal(err)
+	}
+	size, _ := mmc.GetPixelSizeUm()
+	affine, _ := mmc.GetPixelSizeAffine()
+	raw, _ := mmc.GetPixelSizeAffineByID("Res10x")
+	if size != 1.3 || fmt.Sprint(affine, raw) != "[0 -1.3 0 1.3 0 0] [0 -0.65 0 0.65 0 0]" {
+		t.Errorf("GetPixelSizeUm %g, GetPixelSizeAffine %v, GetPixelSizeAffineByID %v", size, affine, raw)
+	}
+
+	// The image is 256x256 binned pixels, centered on the stage at (100, 200), and
+	// rotated by 90 degrees.
+	if err := mmc.SetXYPosition("XY", 100, 200); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := mmcore.CurrentPixelTransform(mmc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(tr.StageX-100) > 0.01 || math.Abs(tr.StageY-200) > 0.01 {
+		t.Fatalf("stage position %g, %g", tr.StageX, tr.StageY)
+	}
+	if x, y := tr.PixelToStage(138, 128); x != tr.StageX || y != tr.StageY+10*1.3 {
+		t.Errorf("PixelToStage: %g, %g", x, y)
+	}
+	if x, y, err := tr.StageToPixel(tr.StageX-13, tr.StageY); err != nil || math.Abs(x-128) > 1e-9 || math.Abs(y-138) > 1e-9 {
+		t.Errorf("StageToPixel: %g, %g, %v", x, y, err)
+	}
+	if _, _, err := (mmcore.PixelTransform{}).StageToPixel(0, 0); err != mmcore.ErrBadAffineTransform {
+		t.Errorf("StageToPixel without a configuration: %v", err)
+	}
+
+	// Pixel sizes are saved to and loaded from configuration files.
+	cfg, err := mmcore.TakeSystemConfiguration(mmc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := sim.NewSession()
+	defer other.Close()
+	if err := mmcore.ApplySystemConfiguration(other, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"Res10x", "Res20x"} {
+		a, _ := mmc.GetPixelSizeConfigData(id)
+		b, err := other.GetPixelSizeConfigData(id)
+		size_a, _ := mmc.GetPixelSizeUmByID(id)
+		size_b, _ := other.GetPixelSizeUmByID(id)
+		affine_a, _ := mmc.GetPixelSizeAffineByID(id)
+		affine_b, _ := other.GetPixelSizeAffineByID(id)
+		if err != nil || !reflect.DeepEqual(a, b) || size_a != size_b || !reflect.DeepEqual(affine_a, affine_b) {
+			t.Errorf("%s after loading: %v %g %v, %v, want %v %g %v", id, b, size_b, affine_b, err, a, size_a, affine_a)
+		}
+	}
+
+	if err := mmc.DeletePixelSizeConfig("Res10x"); err != nil {
+		t.Fatal(err)
+	}
+	if defined, _ := mmc.IsPixelSizeConfigDefined("Res10x"); defined {
+		t.Error("Res10x is defined after DeletePixelSizeConfig")
+	}
+}
@@ -0,0 +1,512 @@
+package sim
+
+import (
+	"math"
+	"strconv"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+//
+// Shutter control
+//
+
+func (s *Session) shutterDevice(label string) (*device, error) {
+	return s.deviceOfType(label, s.shutter, shutterDevice, mmcore.ErrNoDevice)
+}
+
+// SetShutterOpen opens or closes the shutter. An empty label selects the current shutter device.
+func (s *Session) SetShutterOpen(label string, is_open bool) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.shutterDevice(label)
+	if err != nil {
+		return err
+	}
+	d.open = is_open
+	s.emitPropertyChanged(d.label, "State", d.props["State"].get())
+	return nil
+}
+
+func (s *Session) GetShutterOpen(label string) (is_open bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.shutterDevice(label)
+	if err != nil {
+		return false, err
+	}
+	return d.open, nil
+}
+
+//
+// Autofocus control
+//
+
+func (s *Session) autoFocusDevice(errNoDevice error) (*device, error) {
+	return s.deviceOfType("", s.autoFocus, autoFocusDevice, errNoDevice)
+}
+
+func (s *Session) LastFocusScore() (score float64) {
+	s.lock()
+	defer s.unlock()
+	return s.lastFocusScore
+}
+
+func (s *Session) CurrentFocusScore() (score float64) {
+	s.lock()
+	defer s.unlock()
+	return s.lastFocusScore
+}
+
+func (s *Session) EnableContinuousFocus() error {
+	return s.enableContinuousFocus(true)
+}
+
+func (s *Session) DisableContinuousFocus() error {
+	return s.enableContinuousFocus(false)
+}
+
+func (s *Session) enableContinuousFocus(enable bool) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.autoFocusDevice(mmcore.ErrContFocusNotAvailable); err != nil {
+		return err
+	}
+	s.continuousFocus = enable
+	return nil
+}
+
+func (s *Session) IsContinuousFocusEnabled() (enabled bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.autoFocusDevice(mmcore.ErrContFocusNotAvailable); err != nil {
+		return false, err
+	}
+	return s.continuousFocus, nil
+}
+
+// IsContinuousFocusLocked reports whether continuous focus is locked, which is always
+// the case when it is enabled in the simulator.
+func (s *Session) IsContinuousFocusLocked() (locked bool, err error) {
+	return s.IsContinuousFocusEnabled()
+}
+
+func (s *Session) IsContinuousFocusDrive(label string) (is_continuous_focus_drive bool, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.device(label); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// FullFocus moves the current focus device to the focal plane, which is at
+// the autofocus offset in the simulator.
+func (s *Session) FullFocus() (err error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.autoFocusDevice(mmcore.ErrAutoFocusNotAvailable); err != nil {
+		return err
+	}
+	if stage, err := s.stageDevice(""); err == nil {
+		s.moveStage(stage, s.autoFocusOffset+stage.origin)
+	}
+	s.lastFocusScore = 1
+	return nil
+}
+
+func (s *Session) IncrementalFocus() (err error) {
+	return s.FullFocus()
+}
+
+func (s *Session) SetAutoFocusOffset(offset float64) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.autoFocusDevice(mmcore.ErrAutoFocusNotAvailable); err != nil {
+		return err
+	}
+	s.autoFocusOffset = offset
+	return nil
+}
+
+func (s *Session) GetAutoFocusOffset() (offset float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	if _, err := s.autoFocusDevice(mmcore.ErrAutoFocusNotAvailable); err != nil {
+		return 0, err
+	}
+	return s.autoFocusOffset, nil
+}
+
+//
+// State device control.
+//
+
+func (s *Session) stateDevice(label string) (*device, error) {
+	return s.deviceOfType(label, "", stateDevice, mmcore.ErrInvalidLabel)
+}
+
+func (s *Session) SetState(label string, state int) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return err
+	}
+	return s.setState(d, state)
+}
+
+func (s *Session) setState(d *device, state int) error {
+	if state < 0 || state >= len(d.labels) {
+		return mmcore.ErrDEVICE_GENERIC
+	}
+	d.state = state
+	s.emitPropertyChanged(d.label, "State", strconv.Itoa(state))
+	s.emitPropertyChanged(d.label, "Label", d.labels[state])
+	return nil
+}
+
+func (s *Session) GetState(label string) (state int, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return 0, err
+	}
+	return d.state, nil
+}
+
+func (s *Session) NumberOfStates(label string) (n_states int, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return 0, err
+	}
+	return len(d.labels), nil
+}
+
+func (s *Session) SetStateLabel(label string, state_label string) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return err
+	}
+	for i, l := range d.labels {
+		if l == state_label {
+			return s.setState(d, i)
+		}
+	}
+	return mmcore.ErrDEVICE_GENERIC
+}
+
+func (s *Session) GetStateLabel(label string) (state_label string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return "", err
+	}
+	return d.labels[d.state], nil
+}
+
+// DefineStateLabel gives a label to the state. A label can only be used for one state.
+func (s *Session) DefineStateLabel(label string, state int, state_label string) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return err
+	}
+	if state < 0 || state >= len(d.labels) || state_label == "" {
+		return mmcore.ErrDEVICE_GENERIC
+	}
+	for i, l := range d.labels {
+		if l == state_label && i != state {
+			return mmcore.ErrDEVICE_GENERIC
+		}
+	}
+	d.labels[state] = state_label
+	return nil
+}
+
+func (s *Session) GetStateLabels(label string) (state_labels []string, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return []string{}, err
+	}
+	return append([]string{}, d.labels...), nil
+}
+
+func (s *Session) GetStateFromLabel(label string, state_label string) (state int, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stateDevice(label)
+	if err != nil {
+		return 0, err
+	}
+	for i, l := range d.labels {
+		if l == state_label {
+			return i, nil
+		}
+	}
+	return 0, mmcore.ErrDEVICE_GENERIC
+}
+
+//
+// Focus (Z) stage control
+//
+
+// stageDevice returns the focus stage with the label, or the current focus device if label is empty.
+func (s *Session) stageDevice(label string) (*device, error) {
+	return s.deviceOfType(label, s.focus, stageDevice, mmcore.ErrNoDevice)
+}
+
+// moveStage moves the stage to the position in device coordinates.
+func (s *Session) moveStage(d *device, pos float64) {
+	d.pos = pos
+	s.emitStagePositionChanged(d.label, d.pos-d.origin)
+}
+
+func (s *Session) SetPosition(label string, position float64) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return err
+	}
+	s.moveStage(d, position+d.origin)
+	return nil
+}
+
+func (s *Session) SetRelativePosition(label string, delta float64) error {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return err
+	}
+	s.moveStage(d, d.pos+delta)
+	return nil
+}
+
+func (s *Session) GetPosition(label string) (position float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return 0, err
+	}
+	return d.pos - d.origin, nil
+}
+
+// SetOrigin makes the current position the origin (zero) of the stage.
+func (s *Session) SetOrigin(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.origin = d.pos
+	return nil
+}
+
+// SetAdapterOrigin sets the current position of the stage to new_z_um.
+func (s *Session) SetAdapterOrigin(label string, new_z_um float64) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.origin = d.pos - new_z_um
+	return nil
+}
+
+func (s *Session) SetFocusDirection(label string, sign int) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return
+	}
+	switch {
+	case sign > 0:
+		d.focusDirection = 1
+	case sign < 0:
+		d.focusDirection = -1
+	default:
+		d.focusDirection = 0
+	}
+}
+
+func (s *Session) GetFocusDirection(label string) (sign int, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.stageDevice(label)
+	if err != nil {
+		return 0, err
+	}
+	return d.focusDirection, nil
+}
+
+//
+// XY stage control
+//
+
+// xyStageDevice returns the XY stage with the label, or the current XY stage device if label is empty.
+func (s *Session) xyStageDevice(label string) (*device, error) {
+	return s.deviceOfType(label, s.xyStage, xyStageDevice, mmcore.ErrNoDevice)
+}
+
+// moveXYStage moves the stage to the position in device coordinates,
+// rounded to the step size of the stage.
+func (s *Session) moveXYStage(d *device, x, y float64) {
+	d.x = math.Round(x/d.stepSize) * d.stepSize
+	d.y = math.Round(y/d.stepSize) * d.stepSize
+	s.emitXYStagePositionChanged(d.label, d.x-d.originX, d.y-d.originY)
+}
+
+// SetXYPosition moves the XY stage. The position is rounded to the 0.015 um step size of the stage.
+func (s *Session) SetXYPosition(label string, x float64, y float64) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	s.moveXYStage(d, x+d.originX, y+d.originY)
+	return nil
+}
+
+func (s *Session) SetRelativeXYPosition(label string, dx float64, dy float64) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	s.moveXYStage(d, d.x+dx, d.y+dy)
+	return nil
+}
+
+func (s *Session) GetXYPosition(label string) (x float64, y float64, err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d.x - d.originX, d.y - d.originY, nil
+}
+
+func (s *Session) GetXPosition(label string) (x float64, err error) {
+	x, _, err = s.GetXYPosition(label)
+	return
+}
+
+func (s *Session) GetYPosition(label string) (y float64, err error) {
+	_, y, err = s.GetXYPosition(label)
+	return
+}
+
+// Stop stops the XY stage. Simulated moves finish immediately, so it only checks the label.
+func (s *Session) Stop(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	_, err = s.xyStageDevice(label)
+	return
+}
+
+// Home moves the XY stage to the home position at (0, 0) in device coordinates.
+func (s *Session) Home(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	s.moveXYStage(d, 0, 0)
+	return nil
+}
+
+func (s *Session) SetOriginXY(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.originX, d.originY = d.x, d.y
+	return nil
+}
+
+func (s *Session) SetOriginX(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.originX = d.x
+	return nil
+}
+
+func (s *Session) SetOriginY(label string) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.originY = d.y
+	return nil
+}
+
+// SetAdpaterOriginXY sets the current position of the XY stage to (new_x_um, new_y_um).
+func (s *Session) SetAdpaterOriginXY(label string, new_x_um float64, new_y_um float64) (err error) {
+	s.lock()
+	defer s.unlock()
+
+	d, err := s.xyStageDevice(label)
+	if err != nil {
+		return err
+	}
+	d.originX, d.originY = d.x-new_x_um, d.y-new_y_um
+	return nil
+}
@@ -0,0 +1,473 @@
+package mmcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const coreLabel = "Core"
+
+// Snapshot is the state of all loaded devices of a session: the property values
+// and metadata, the positions of state devices and stages, and the current devices.
+// It can be stored as JSON, to record the state of the microscope with the data.
+type Snapshot struct {
+	Time           time.Time        `json:"time"`
+	Version        string           `json:"version"`
+	CurrentDevices CurrentDevices   `json:"current_devices"`
+	Devices        []DeviceSnapshot `json:"devices"` // in the order of GetLoadedDevices
+}
+
+// CurrentDevices are the labels of the current devices, as set with SetCameraDevice and the like.
+type CurrentDevices struct {
+	Camera    string `json:"camera"`
+	Shutter   string `json:"shutter"`
+	Focus     string `json:"focus"`
+	XYStage   string `json:"xy_stage"`
+	AutoFocus string `json:"auto_focus"`
+}
+
+// DeviceSnapshot is the state of a device. Module and Device are the device adapter
+// module and device name it was loaded from, and are empty for Core.
+// State, Position and XY are only set for state devices, stages and XY stages.
+type DeviceSnapshot struct {
+	Label      string               `json:"label"`
+	Module     string               `json:"module,omitempty"`
+	Device     string               `json:"device,omitempty"`
+	Parent     string               `json:"parent,omitempty"`
+	Properties []PropertyDescriptor `json:"properties"` // sorted by name
+	State      *int                 `json:"state,omitempty"`
+	StateLabel string               `json:"state_label,omitempty"`
+	Position   *float64             `json:"position,omitempty"`
+	XY         *XYPosition          `json:"xy,omitempty"`
+}
+
+// XYPosition is the position of an XY stage in um.
+type XYPosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Device returns the snapshot of the device with the label, or nil.
+func (s *Snapshot) Device(label string) *DeviceSnapshot {
+	for i := range s.Devices {
+		if s.Devices[i].Label == label {
+			return &s.Devices[i]
+		}
+	}
+	return nil
+}
+
+// Property returns the descriptor of the property with the name, or nil.
+func (d *DeviceSnapshot) Property(name string) *PropertyDescriptor {
+	for i := range d.Properties {
+		if d.Properties[i].Name == name {
+			return &d.Properties[i]
+		}
+	}
+	return nil
+}
+
+// TakeSnapshot records the state of all loaded devices of c.
+func TakeSnapshot(c Core) (*Snapshot, error) {
+	labels, err := c.GetLoadedDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Time:    time.Now(),
+		Version: c.VersionInfo(),
+		CurrentDevices: CurrentDevices{
+			Camera:    c.CameraDevice(),
+			Shutter:   c.ShutterDevice(),
+			Focus:     c.FocusDevice(),
+			XYStage:   c.XYStageDevice(),
+			AutoFocus: c.AutoFocusDevice(),
+		},
+		Devices: make([]DeviceSnapshot, 0, len(labels)),
+	}
+	for _, label := range labels {
+		d, err := snapshotDevice(c, label)
+		if err != nil {
+			return nil, err
+		}
+		snap.Devices = append(snap.Devices, d)
+	}
+	return snap, nil
+}
+
+func snapshotDevice(c Core, label string) (d DeviceSnapshot, err error) {
+	d.Label = label
+	if d.Properties, err = c.DescribeDevice(label); err != nil {
+		return
+	}
+	if label == coreLabel {
+		return
+	}
+	if d.Module, err = c.GetDeviceLibrary(label); err != nil {
+		return
+	}
+	if d.Device, err = c.GetDeviceName(label); err != nil {
+		return
+	}
+	if d.Parent, err = c.GetParentLabel(label); err != nil {
+		return
+	}
+
+	device_type, err := c.GetDeviceType(label)
+	if err != nil {
+		return d, err
+	}
+	switch device_type {
+	case StateDeviceType:
+		state, err := c.GetState(label)
+		if err != nil {
+			return d, err
+		}
+		d.State = &state
+		if d.StateLabel, err = c.GetStateLabel(label); err != nil {
+			return d, err
+		}
+	case StageDeviceType:
+		pos, err := c.GetPosition(label)
+		if err != nil {
+			return d, err
+		}
+		d.Position = &pos
+	case XYStageDeviceType:
+		x, y, err := c.GetXYPosition(label)
+		if err != nil {
+			return d, err
+		}
+		d.XY = &XYPosition{x, y}
+	}
+	return d, nil
+}
+
+// WriteJSON writes the snapshot as indented JSON.
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSnapshot reads a snapshot written by WriteJSON.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Change is a difference of a property between two snapshots.
+// Old is nil if the property is only in the second snapshot,
+// and New is nil if it is only in the first one.
+type Change struct {
+	Label    string              `json:"label"`
+	Property string              `json:"property"`
+	Old      *PropertyDescriptor `json:"old,omitempty"`
+	New      *PropertyDescriptor `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s.%s: added %q", c.Label, c.Property, c.New.Value)
+	case c.New == nil:
+		return fmt.Sprintf("%s.%s: removed %q", c.Label, c.Property, c.Old.Value)
+	case c.Old.Value != c.New.Value:
+		return fmt.Sprintf("%s.%s: %q -> %q", c.Label, c.Property, c.Old.Value, c.New.Value)
+	}
+	return fmt.Sprintf("%s.%s: metadata changed", c.Label, c.Property)
+}
+
+// Diff returns the properties that differ between the snapshots a and b, in value
+// or in metadata. The properties of devices loaded in only one of the snapshots are
+// all reported. The positions of state devices and focus stages are covered by their
+// properties; the positions of XY stages, which have none, are not compared.
+func Diff(a, b *Snapshot) []Change {
+	var changes []Change
+	for i := range a.Devices {
+		da := &a.Devices[i]
+		changes = append(changes, diffDevice(da, b.Device(da.Label))...)
+	}
+	for i := range b.Devices {
+		db := &b.Devices[i]
+		if a.Device(db.Label) == nil {
+			changes = append(changes, diffDevice(&DeviceSnapshot{Label: db.Label}, db)...)
+		}
+	}
+	return changes
+}
+
+func diffDevice(a, b *DeviceSnapshot) []Change {
+	if b == nil {
+		b = &DeviceSnapshot{Label: a.Label}
+	}
+	var changes []Change
+	for i := range a.Properties {
+		pa := &a.Properties[i]
+		pb := b.Property(pa.Name)
+		if pb == nil || !reflect.DeepEqual(*pa, *pb) {
+			changes = append(changes, Change{a.Label, pa.Name, pa, pb})
+		}
+	}
+	for i := range b.Properties {
+		pb := &b.Properties[i]
+		if a.Property(pb.Name) == nil {
+			changes = append(changes, Change{a.Label, pb.Name, nil, pb})
+		}
+	}
+	return changes
+}
+
+// ApplyStatus tells what ApplySnapshot did with a property.
+type ApplyStatus int
+
+const (
+	Applied ApplyStatus = iota
+	Skipped
+	Failed
+)
+
+func (s ApplyStatus) String() string {
+	switch s {
+	case Applied:
+		return "applied"
+	case Skipped:
+		return "skipped"
+	}
+	return "failed"
+}
+
+// ApplyResult is the outcome of restoring one property. Property is empty for the
+// loading and initialization of a device and for devices that cannot be loaded, and it is
+// "XYPosition" for the position of an XY stage.
+type ApplyResult struct {
+	Label    string
+	Property string
+	Value    string
+	Status   ApplyStatus
+	Reason   string // why the property was skipped
+	Err      error  // why the property failed
+}
+
+func (r ApplyResult) String() string {
+	s := fmt.Sprintf("%s.%s = %q: %s", r.Label, r.Property, r.Value, r.Status)
+	if r.Status == Skipped {
+		s += " (" + r.Reason + ")"
+	} else if r.Status == Failed {
+		s += ": " + r.Err.Error()
+	}
+	return s
+}
+
+// ApplyReport lists the outcome for each property of the snapshot.
+type ApplyReport []ApplyResult
+
+// Failed returns the results of the properties that could not be set.
+func (r ApplyReport) Failed() []ApplyResult {
+	var failed []ApplyResult
+	for _, result := range r {
+		if result.Status == Failed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Core properties that are actions rather than settings.
+var coreActionProperties = map[string]bool{
+	"Initialize": true,
+}
+
+// Names of the properties that mirror the position of state devices and focus stages.
+const (
+	stateProperty    = "State"
+	labelProperty    = "Label"
+	positionProperty = "Position"
+)
+
+// ApplySnapshot restores the property values of a snapshot onto c. It works in the
+// same order as a configuration file:
+//
+//   - The devices of the snapshot that are not loaded in c are loaded, their parents
+//     are set, their pre-init properties are set, and they are initialized, hubs first.
+//     Devices that are already loaded are taken as they are and never initialized
+//     again; their pre-init properties are skipped, because the device adapters only
+//     read them at initialization.
+//   - The other properties are set, except the read-only properties and the properties
+//     of the positions, and those that already have the value.
+//   - State devices are set by label with SetStateLabel.
+//   - Stages and XY stages are moved last.
+//
+// A failure of a property does not stop the others. The returned error is only for
+// failures to read the current state of c.
+func ApplySnapshot(c Core, snap *Snapshot) (ApplyReport, error) {
+	var report ApplyReport
+	skip := func(label, property, value, reason string) {
+		report = append(report, ApplyResult{label, property, value, Skipped, reason, nil})
+	}
+	result := func(label, property, value string, err error) {
+		if err != nil {
+			report = append(report, ApplyResult{label, property, value, Failed, "", err})
+		} else {
+			report = append(report, ApplyResult{label, property, value, Applied, "", nil})
+		}
+	}
+
+	loaded, err := c.GetLoadedDevices()
+	if err != nil {
+		return nil, err
+	}
+	is_loaded := make(map[string]bool)
+	for _, label := range loaded {
+		is_loaded[label] = true
+	}
+	var devices, just_loaded []*DeviceSnapshot
+	for i := range snap.Devices {
+		d := &snap.Devices[i]
+		switch {
+		case is_loaded[d.Label]:
+			devices = append(devices, d)
+		case d.Module == "" || d.Device == "":
+			skip(d.Label, "", "", "device not loaded")
+		default:
+			if err := c.LoadDevice(d.Label, d.Module, d.Device); err != nil {
+				result(d.Label, "", "", err)
+				continue
+			}
+			devices = append(devices, d)
+			just_loaded = append(just_loaded, d)
+		}
+	}
+
+	// Parents, pre-init properties and initialization of the devices loaded above
+	for _, d := range just_loaded {
+		if d.Parent == "" {
+			continue
+		}
+		if err := c.SetParentLabel(d.Label, d.Parent); err != nil {
+			result(d.Label, "", "", err)
+		}
+	}
+	for _, d := range just_loaded {
+		for _, p := range d.Properties {
+			if p.PreInit && !p.ReadOnly {
+				result(d.Label, p.Name, p.Value, c.SetProperty(d.Label, p.Name, p.Value))
+			}
+		}
+	}
+	for _, d := range hubsFirst(just_loaded) {
+		result(d.Label, "", "", c.InitializeDevice(d.Label))
+	}
+	// The devices that were loaded before may be initialized, and only read
+	// their pre-init properties at initialization.
+	for _, d := range devices {
+		if !is_loaded[d.Label] {
+			continue
+		}
+		descs, err := c.DescribeDevice(d.Label)
+		if err != nil {
+			return report, err
+		}
+		current := make(map[string]PropertyDescriptor)
+		for _, desc := range descs {
+			current[desc.Name] = desc
+		}
+		for _, p := range d.Properties {
+			if !p.PreInit || p.ReadOnly {
+				continue
+			}
+			if current[p.Name].Value == p.Value {
+				skip(d.Label, p.Name, p.Value, "unchanged")
+			} else {
+				skip(d.Label, p.Name, p.Value, "pre-init property of an initialized device")
+			}
+		}
+	}
+
+	// Other properties
+	for _, d := range devices {
+		descs, err := c.DescribeDevice(d.Label)
+		if err != nil {
+			return report, err
+		}
+		now := make(map[string]PropertyDescriptor)
+		for _, desc := range descs {
+			now[desc.Name] = desc
+		}
+		for _, p := range d.Properties {
+			switch {
+			case p.PreInit:
+				continue
+			case p.ReadOnly:
+				skip(d.Label, p.Name, p.Value, "read-only")
+				continue
+			case d.Label == coreLabel && coreActionProperties[p.Name]:
+				skip(d.Label, p.Name, p.Value, "action of the core")
+				continue
+			case d.State != nil && (p.Name == stateProperty || p.Name == labelProperty):
+				continue
+			case d.Position != nil && p.Name == positionProperty:
+				continue
+			}
+			desc, ok := now[p.Name]
+			switch {
+			case !ok:
+				skip(d.Label, p.Name, p.Value, "no such property")
+			case desc.Value == p.Value:
+				skip(d.Label, p.Name, p.Value, "unchanged")
+			default:
+				result(d.Label, p.Name, p.Value, c.SetProperty(d.Label, p.Name, p.Value))
+			}
+		}
+	}
+
+	// State devices
+	for _, d := range devices {
+		if d.State == nil {
+			continue
+		}
+		if d.StateLabel != "" {
+			result(d.Label, labelProperty, d.StateLabel, c.SetStateLabel(d.Label, d.StateLabel))
+		} else {
+			result(d.Label, stateProperty, strconv.Itoa(*d.State), c.SetState(d.Label, *d.State))
+		}
+	}
+
+	// Stages
+	for _, d := range devices {
+		if d.Position != nil {
+			result(d.Label, positionProperty, strconv.FormatFloat(*d.Position, 'g', -1, 64), c.SetPosition(d.Label, *d.Position))
+		}
+		if d.XY != nil {
+			result(d.Label, "XYPosition", fmt.Sprintf("%g,%g", d.XY.X, d.XY.Y), c.SetXYPosition(d.Label, d.XY.X, d.XY.Y))
+		}
+	}
+	return report, nil
+}
+
+// hubsFirst orders the devices so that the parents come before their peripherals.
+func hubsFirst(devices []*DeviceSnapshot) []*DeviceSnapshot {
+	is_parent := make(map[string]bool)
+	for _, d := range devices {
+		if d.Parent != "" {
+			is_parent[d.Parent] = true
+		}
+	}
+	ordered := make([]*DeviceSnapshot, 0, len(devices))
+	for _, hubs := range []bool{true, false} {
+		for _, d := range devices {
+			if is_parent[d.Label] == hubs {
+				ordered = append(ordered, d)
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,288 @@
+package mmcore_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+	"github.com/Andeling/MMCoreAPI/MMCoreGo/sim"
+)
+
+func ExampleDiff() {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, dev := range []struct{ label, name string }{
+		{"Camera", "DCam"},
+		{"Wheel", "DWheel"},
+		{"Z", "DStage"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		log.Fatal(err)
+	}
+	before, err := mmc.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mmc.SetProperty("Camera", "Exposure", 25.0)
+	mmc.SetState("Wheel", 2)
+	mmc.SetPosition("Z", 1.5)
+	after, err := mmc.Snapshot()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Wheel:", *after.Device("Wheel").State, after.Device("Wheel").StateLabel)
+	for _, change := range mmcore.Diff(before, after) {
+		fmt.Println(change)
+	}
+
+	// Output:
+	// Wheel: 2 State-2
+	// Camera.Exposure: "10.0000" -> "25.0000"
+	// Wheel.Label: "State-0" -> "State-2"
+	// Wheel.State: "0" -> "2"
+	// Z.Position: "0.0000" -> "1.5000"
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+
+	for _, dev := range []struct{ label, name string }{
+		{"Camera", "DCam"},
+		{"XY", "DXYStage"},
+		{"Shutter", "DShutter"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetCameraDevice("Camera"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetXYPosition("XY", 30, -15); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%d %s %v", len(snap.Devices), snap.CurrentDevices.Camera, *snap.Device("XY").XY); got != "4 Camera {30 -15}" {
+		t.Errorf("snapshot: %s", got)
+	}
+	if d := snap.Device("Camera"); d.State != nil || d.Position != nil || d.XY != nil {
+		t.Errorf("camera has a position: %+v", d)
+	}
+
+	var buf bytes.Buffer
+	if err := snap.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := mmcore.ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Time.Equal(snap.Time) {
+		t.Errorf("time %v, want %v", read.Time, snap.Time)
+	}
+	read.Time = snap.Time
+	if !reflect.DeepEqual(read, snap) {
+		t.Errorf("snapshot changed in JSON:\n%+v\n%+v", read, snap)
+	}
+	if changes := mmcore.Diff(snap, read); len(changes) != 0 {
+		t.Errorf("Diff of the same snapshot: %v", changes)
+	}
+
+	// A device that is loaded later is reported with all its properties.
+	if err := mmc.LoadDevice("Z", "DemoCamera", "DStage"); err != nil {
+		t.Fatal(err)
+	}
+	later, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added []string
+	for _, change := range mmcore.Diff(snap, later) {
+		if change.Label == "Z" && change.Old == nil {
+			added = append(added, change.Property)
+		} else if change.Label != "Core" {
+			t.Errorf("unexpected change %v", change)
+		}
+	}
+	if fmt.Sprint(added) != "[Description HubID Name]" {
+		t.Errorf("added properties %v", added)
+	}
+}
+
+func TestApplySnapshot(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	for _, dev := range []struct{ label, name string }{
+		{"Camera", "DCam"},
+		{"Wheel", "DWheel"},
+		{"Z", "DStage"},
+		{"XY", "DXYStage"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.SetProperty("Camera", "MaximumExposureMs", 500.0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		mmc.SetCameraDevice("Camera"),
+		mmc.SetProperty("Camera", "Binning", 2),
+		mmc.SetProperty("Camera", "Exposure", 50.0),
+		mmc.SetStateLabel("Wheel", "State-4"),
+		mmc.SetPosition("Z", 12),
+		mmc.SetXYPosition("XY", 30, -15),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ApplySnapshot loads and initializes the devices in another session.
+	other := sim.NewSession()
+	defer other.Close()
+	report, err := other.ApplySnapshot(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := report.Failed(); len(failed) != 0 {
+		t.Errorf("failed: %v", failed)
+	}
+	restored, err := other.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := mmcore.Diff(saved, restored); len(changes) != 0 {
+		t.Errorf("changes after ApplySnapshot: %v", changes)
+	}
+
+	results := make(map[string]string)
+	for _, r := range report {
+		results[r.Label+"."+r.Property] = r.Status.String() + " " + r.Reason
+	}
+	for key, want := range map[string]string{
+		"Camera.MaximumExposureMs": "applied ",
+		"Camera.":                  "applied ",
+		"Camera.Exposure":          "applied ",
+		"Camera.Name":              "skipped read-only",
+		"Camera.Gain":              "skipped unchanged",
+		"Wheel.Label":              "applied ",
+		"Z.Position":               "applied ",
+		"XY.XYPosition":            "applied ",
+		"Core.Camera":              "applied ",
+	} {
+		if results[key] != want {
+			t.Errorf("%s: %q, want %q", key, results[key], want)
+		}
+	}
+	// The position comes after all properties.
+	if last := report[len(report)-1]; last.Property != "XYPosition" {
+		t.Errorf("last result %v", last)
+	}
+
+	// Pre-init properties of initialized devices cannot be changed.
+	if err := mmc.SetProperty("Camera", "Exposure", 10.0); err != nil {
+		t.Fatal(err)
+	}
+	saved.Device("Camera").Property("MaximumExposureMs").Value = "1000.0000"
+	report, err = mmc.ApplySnapshot(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range report {
+		switch r.Property {
+		case "Exposure", "Label", "Position", "XYPosition":
+		default:
+			if r.Status == mmcore.Applied {
+				t.Errorf("applied %v", r)
+			}
+		}
+		if r.Property == "MaximumExposureMs" && r.Reason != "pre-init property of an initialized device" {
+			t.Errorf("pre-init property: %v", r)
+		}
+	}
+}
+
+func TestApplySnapshotInitializedDevices(t *testing.T) {
+	mmc := sim.NewSession()
+	defer mmc.Close()
+	// The hub has no properties but the read-only ones of its constructor.
+	for _, dev := range []struct{ label, name string }{
+		{"Hub", "DHub"},
+		{"Camera", "DCam"},
+	} {
+		if err := mmc.LoadDevice(dev.label, "DemoCamera", dev.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.SetParentLabel("Camera", "Hub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The camera lacks a property of the snapshot, but is initialized all the same.
+	saved.Device("Camera").Properties = append(saved.Device("Camera").Properties,
+		mmcore.PropertyDescriptor{Name: "Cooler", Value: "On", Type: mmcore.StringProperty})
+	report, err := mmc.ApplySnapshot(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range report {
+		if r.Property == "" || r.Status == mmcore.Failed {
+			t.Errorf("initialized device: %v", r)
+		}
+		if r.Property == "Cooler" && r.Reason != "no such property" {
+			t.Errorf("missing property: %v", r)
+		}
+	}
+
+	// In a session without devices, both are loaded and initialized, hub first.
+	other := sim.NewSession()
+	defer other.Close()
+	if report, err = other.ApplySnapshot(saved); err != nil {
+		t.Fatal(err)
+	}
+	var initialized []string
+	for _, r := range report {
+		if r.Property == "" {
+			initialized = append(initialized, r.String())
+		}
+	}
+	if want := []string{`Hub. = "": applied`, `Camera. = "": applied`}; !reflect.DeepEqual(initialized, want) {
+		t.Errorf("initialized %q, want %q", initialized, want)
+	}
+	if parent, _ := other.GetParentLabel("Camera"); parent != "Hub" {
+		t.Errorf("parent of the camera: %q", parent)
+	}
+}
@@ -0,0 +1,162 @@
+package mmcore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// streamPollInterval is how often StreamSequence polls the circular buffer while it is empty.
+const streamPollInterval = time.Millisecond
+
+// StreamOptions configures StreamSequence.
+type StreamOptions struct {
+	// NumImages is the number of images to acquire with StartSequenceAcquisition.
+	// If it is 0, the acquisition is continuous until ctx is done.
+	NumImages int
+
+	IntervalMs float64 // interval between images; the exposure time if it is longer
+
+	// StopOnOverflow stops the acquisition and the stream when the circular buffer
+	// overflows. Otherwise the core clears the full buffer and the acquisition goes
+	// on: the overflow is reported on the error channel, without waiting for it to be
+	// received, and the stream goes on. A continuous acquisition never stops on
+	// overflow, as in MMCore.
+	StopOnOverflow bool
+
+	// BufferSize is the capacity of the frame channel. Frames that do not fit wait in
+	// the circular buffer.
+	BufferSize int
+}
+
+// Frame is an image of a sequence acquisition with its metadata.
+type Frame struct {
+	*Image
+	Metadata *ImageMetadata
+}
+
+// SequenceOverflowError is sent by StreamSequence when the circular buffer overflowed,
+// so that images were lost. It ends the stream if StopOnOverflow is set. Otherwise it
+// is dropped if an earlier one has not been received yet.
+type SequenceOverflowError struct {
+	Received int64 // number of frames sent before the overflow was detected
+}
+
+func (e *SequenceOverflowError) Error() string {
+	return fmt.Sprintf("circular buffer overflowed after %d images", e.Received)
+}
+
+// StreamSequence starts a sequence acquisition with the current camera and sends its
+// images to the frame channel, from a goroutine that pops them from the circular buffer.
+//
+// The acquisition stops when all the images are sent, at the first error, or when ctx is
+// done. The frame channel is then closed, and the error channel receives the error, if
+// any, and is closed: ctx.Err() if ctx is done, a *SequenceOverflowError if the circular
+// buffer overflowed and StopOnOverflow is set, or the error of the core. The frames must
+// be received until the frame channel is closed, or ctx must be canceled.
+//
+// The error of starting the acquisition is returned directly. A negative NumImages or
+// BufferSize is ErrInvalidImageSequence.
+func StreamSequence(ctx context.Context, c Core, opts StreamOptions) (frames <-chan Frame, errs <-chan error, err error) {
+	if opts.BufferSize < 0 {
+		return nil, nil, ErrInvalidImageSequence
+	}
+	switch {
+	case opts.NumImages < 0:
+		return nil, nil, ErrInvalidImageSequence
+	case opts.NumImages > 0:
+		err = c.StartSequenceAcquisition(opts.NumImages, opts.IntervalMs, opts.StopOnOverflow)
+	default:
+		err = c.StartContinuousSequenceAcquisition(opts.IntervalMs)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	frame_ch := make(chan Frame, opts.BufferSize)
+	// One overflow report may wait in the channel, next to the final error.
+	err_ch := make(chan error, 2)
+	stop_on_overflow := opts.NumImages > 0 && opts.StopOnOverflow
+	go func() {
+		defer close(err_ch)
+		defer close(frame_ch)
+		if err := streamFrames(ctx, c, frame_ch, err_ch, stop_on_overflow); err != nil {
+			err_ch <- err
+		}
+	}()
+	return frame_ch, err_ch, nil
+}
+
+// streamFrames sends the images of the running sequence acquisition to frames
+// until it finishes, and stops it on return.
+//
+// The overflow flag is checked before every pop. If stop_on_overflow is set, an
+// overflow ends the stream: the images still in the buffer are not consecutive, so
+// none of them is sent. Otherwise the core clears the buffer instead of setting the
+// flag, so an overflow shows as a gap in the image numbers. It is reported on errs
+// if no report is waiting there, and the stream goes on.
+func streamFrames(ctx context.Context, c Core, frames chan<- Frame, errs chan<- error, stop_on_overflow bool) error {
+	defer c.StopSequenceAcquisition()
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	var received, next_number int64
+	overflowed := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.IsBufferOverflowed() {
+			if stop_on_overflow {
+				return &SequenceOverflowError{received}
+			}
+			if !overflowed {
+				reportOverflow(errs, received)
+			}
+			overflowed = true
+		} else {
+			overflowed = false
+		}
+		img, md, err := c.PopNextImageWithMetadata()
+		if err == nil {
+			if !stop_on_overflow && md.ImageNumber > next_number {
+				reportOverflow(errs, received)
+			}
+			next_number = md.ImageNumber + 1
+			select {
+			case frames <- Frame{img, md}:
+				received++
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if err != ErrCircularBufferEmpty {
+			return err
+		}
+
+		// The buffer is empty. Images inserted before the acquisition stopped
+		// are still to be popped.
+		if !c.IsSequenceRunning() && c.GetRemainingImageCount() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportOverflow sends a *SequenceOverflowError to errs without waiting, unless
+// an earlier report is still waiting there. streamFrames is the only sender, so
+// the final error still fits into errs.
+func reportOverflow(errs chan<- error, received int64) {
+	if len(errs) > 0 {
+		return
+	}
+	select {
+	case errs <- &SequenceOverflowError{received}:
+	default:
+	}
+}
@@ -0,0 +1,4 @@
+//go:build mmcorestub
+// +build mmcorestub
+
+#include "stub/MMCoreC_stub.c"
@@ -0,0 +1,1203 @@
+//go:build mmcorestub
+// +build mmcorestub
+
+package mmcore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mmcore "github.com/Andeling/MMCoreAPI/MMCoreGo"
+)
+
+// The tests in this file run against the stub MMCoreC:
+//
+//	go test -tags mmcorestub ./MMCoreGo/
+
+func newStubSession(t *testing.T, devices ...string) *mmcore.Session {
+	mmc := mmcore.NewSession()
+	for _, name := range devices {
+		if err := mmc.LoadDevice(name, "DemoCamera", name); err != nil {
+			mmc.Close()
+			t.Fatalf("LoadDevice(%q): %v", name, err)
+		}
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		mmc.Close()
+		t.Fatal(err)
+	}
+	return mmc
+}
+
+func ExampleSession_GetAvailableDevices_stub() {
+	mmc := mmcore.NewSession()
+	defer mmc.Close()
+
+	fmt.Printf("Version Info: %s\n", mmc.VersionInfo())
+
+	dev_names, err := mmc.GetAvailableDevices("DemoCamera")
+	if err != nil {
+		log.Fatal(err)
+	}
+	descriptions, err := mmc.GetAvailableDeviceDescriptions("DemoCamera")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range dev_names {
+		fmt.Printf("%s: %s\n", dev_names[i], descriptions[i])
+	}
+
+	// Output:
+	// Version Info: MMCore version 10.2.0 (stub)
+	// DCam: Demo camera
+	// DWheel: Demo filter wheel
+	// DStateDevice: Demo State Device
+	// DObjective: Demo objective turret
+	// DStage: Demo stage
+	// DXYStage: Demo XY stage
+	// DLightPath: Demo light path
+	// DAutoFocus: Demo auto focus
+	// DShutter: Demo shutter
+	// DHub: DHub
+}
+
+func TestStubStringLists(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel")
+	defer mmc.Close()
+
+	paths := []string{"/opt/micro-manager", "", "C:\\Program Files\\Micro-Manager-2.0"}
+	mmc.SetDeviceAdapterSearchPaths(paths)
+	if got := mmc.DeviceAdapterSearchPaths(); !reflect.DeepEqual(got, paths) {
+		t.Errorf("DeviceAdapterSearchPaths() = %q, want %q", got, paths)
+	}
+
+	labels, err := mmc.GetLoadedDevices()
+	if err != nil || !reflect.DeepEqual(labels, []string{"DCam", "DWheel", "Core"}) {
+		t.Errorf("GetLoadedDevices() = %q, %v", labels, err)
+	}
+
+	values, err := mmc.GetAllowedPropertyValues("DCam", "PixelType")
+	if err != nil || !reflect.DeepEqual(values, []string{"16bit", "32bitRGB", "8bit"}) {
+		t.Errorf("GetAllowedPropertyValues(PixelType) = %q, %v", values, err)
+	}
+
+	// An empty list is returned as an empty slice.
+	if addresses := mmc.MACAddresses(); len(addresses) != 0 {
+		t.Errorf("MACAddresses() = %q", addresses)
+	}
+
+	if _, err := mmc.GetAvailableDevices("NoSuchModule"); err != mmcore.ErrLoadLibraryFailed {
+		t.Errorf("GetAvailableDevices(NoSuchModule): got error %v, want %v", err, mmcore.ErrLoadLibraryFailed)
+	}
+}
+
+func TestStubTypedProperties(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DXYStage")
+	defer mmc.Close()
+
+	types := map[string]mmcore.PropertyType{
+		"PixelType": mmcore.StringProperty,
+		"Exposure":  mmcore.FloatProperty,
+		"Binning":   mmcore.IntegerProperty,
+	}
+	for name, want := range types {
+		if typ, err := mmc.PropertyType("DCam", name); err != nil || typ != want {
+			t.Errorf("PropertyType(%s) = %v, %v, want %v", name, typ, err, want)
+		}
+	}
+
+	if err := mmc.SetProperty("DXYStage", "TransposeMirrorX", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mmc.GetPropertyBool("DXYStage", "TransposeMirrorX"); err != nil || !v {
+		t.Errorf("GetPropertyBool(TransposeMirrorX) = %v, %v", v, err)
+	}
+	if v, err := mmc.GetPropertyFloat("DCam", "Exposure"); err != nil || v != 10 {
+		t.Errorf("GetPropertyFloat(Exposure) = %v, %v", v, err)
+	}
+	if v, err := mmc.GetPropertyInt("DCam", "Binning"); err != nil || v != 1 {
+		t.Errorf("GetPropertyInt(Binning) = %v, %v", v, err)
+	}
+	if _, err := mmc.GetPropertyInt("DCam", "PixelType"); err == nil {
+		t.Error("GetPropertyInt(PixelType) did not fail")
+	} else if _, ok := err.(*mmcore.PropertyTypeError); !ok {
+		t.Errorf("GetPropertyInt(PixelType): got error %T %v", err, err)
+	}
+}
+
+func TestStubDescribeDevice(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel")
+	defer mmc.Close()
+
+	// The descriptors match the properties read one by one.
+	for _, label := range []string{"DCam", "DWheel", "Core"} {
+		descs, err := mmc.DescribeDevice(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, _ := mmc.GetDevicePropertyNames(label)
+		if len(descs) != len(names) {
+			t.Fatalf("DescribeDevice(%s) returned %d descriptors for %d properties", label, len(descs), len(names))
+		}
+		for i, desc := range descs {
+			want := mmcore.PropertyDescriptor{Name: names[i]}
+			want.Value, _ = mmc.GetProperty(label, names[i])
+			want.Type, _ = mmc.PropertyType(label, names[i])
+			want.ReadOnly, _ = mmc.IsPropertyReadOnly(label, names[i])
+			want.PreInit, _ = mmc.IsPropertyPreInit(label, names[i])
+			want.Sequenceable, _ = mmc.IsPropertySequenceable(label, names[i])
+			want.HasLimits, _ = mmc.HasPropertyLimits(label, names[i])
+			if want.HasLimits {
+				want.LowerLimit, _ = mmc.GetPropertyLowerLimit(label, names[i])
+				want.UpperLimit, _ = mmc.GetPropertyUpperLimit(label, names[i])
+			}
+			want.AllowedValues, _ = mmc.GetAllowedPropertyValues(label, names[i])
+			if !reflect.DeepEqual(desc, want) {
+				t.Errorf("%s: got %+v, want %+v", label, desc, want)
+			}
+			if one, err := mmc.DescribeProperty(label, names[i]); err != nil || !reflect.DeepEqual(one, desc) {
+				t.Errorf("DescribeProperty(%s, %s) = %+v, %v", label, names[i], one, err)
+			}
+		}
+	}
+
+	if _, err := mmc.DescribeDevice("NoSuchDevice"); err != mmcore.ErrInvalidLabel {
+		t.Errorf("DescribeDevice(NoSuchDevice): got error %v, want %v", err, mmcore.ErrInvalidLabel)
+	}
+	if _, err := mmc.DescribeProperty("DCam", "NoSuchProperty"); err == nil {
+		t.Error("DescribeProperty(NoSuchProperty) did not fail")
+	}
+}
+
+func TestStubSnapshot(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel", "DStateDevice", "DObjective", "DStage",
+		"DXYStage", "DLightPath", "DAutoFocus", "DShutter", "DHub")
+	defer mmc.Close()
+
+	snap, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, d := range snap.Devices {
+		switch {
+		case d.State != nil:
+			kinds = append(kinds, d.Label+":state")
+		case d.Position != nil:
+			kinds = append(kinds, d.Label+":stage")
+		case d.XY != nil:
+			kinds = append(kinds, d.Label+":xy")
+		}
+	}
+	want := "[DWheel:state DStateDevice:state DObjective:state DStage:stage DXYStage:xy DLightPath:state]"
+	if fmt.Sprint(kinds) != want {
+		t.Errorf("devices with positions: %v, want %v", kinds, want)
+	}
+
+	if err := mmc.SetStateLabel("DWheel", "State-3"); err != nil {
+		t.Fatal(err)
+	}
+	later, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	for _, change := range mmcore.Diff(snap, later) {
+		changes = append(changes, change.String())
+	}
+	if want := `[DWheel.Label: "State-0" -> "State-3" DWheel.State: "0" -> "3"]`; fmt.Sprint(changes) != want {
+		t.Errorf("Diff: %v, want %v", changes, want)
+	}
+}
+
+func TestStubApplySnapshot(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel", "DStage", "DXYStage")
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.SetCameraDevice("DCam"),
+		mmc.SetProperty("DCam", "PixelType", "16bit"),
+		mmc.SetStateLabel("DWheel", "State-2"),
+		mmc.SetPosition("DStage", -3.5),
+		mmc.SetXYPosition("DXYStage", 30, -15),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ApplySnapshot loads the devices again.
+	if err := mmc.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := mmc.ApplySnapshot(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := report.Failed(); len(failed) != 0 {
+		t.Errorf("failed: %v", failed)
+	}
+	restored, err := mmc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := mmcore.Diff(saved, restored); len(changes) != 0 {
+		t.Errorf("changes after ApplySnapshot: %v", changes)
+	}
+}
+
+func TestStubSetProperty(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	var pixelType strings.Builder
+	pixelType.WriteString("16bit")
+	for _, tt := range []struct {
+		property string
+		value    interface{}
+		want     string
+	}{
+		{"Offset", uint16(12), "12"},
+		{"Offset", int64(1) << 40, "1099511627776"},
+		{"Exposure", time.Duration(20), "20.0000"},
+		{"PixelType", &pixelType, "16bit"},
+	} {
+		if err := mmc.SetProperty("DCam", tt.property, tt.value); err != nil {
+			t.Errorf("SetProperty(%s, %T): %v", tt.property, tt.value, err)
+			continue
+		}
+		if got, _ := mmc.GetProperty("DCam", tt.property); got != tt.want {
+			t.Errorf("SetProperty(%s, %T): value is %q, want %q", tt.property, tt.value, got, tt.want)
+		}
+	}
+
+	if err := mmc.SetProperty("DCam", "Gain", struct{}{}); err == nil {
+		t.Error("SetProperty(struct{}) did not fail")
+	} else if _, ok := err.(*mmcore.PropertyValueError); !ok {
+		t.Errorf("SetProperty(struct{}): got error %T %v", err, err)
+	}
+	if err := mmcore.SetPropertyChecked(mmc, "DCam", "PixelType", "12bit"); err == nil {
+		t.Error("SetPropertyChecked(PixelType, 12bit) did not fail")
+	}
+}
+
+func TestStubImages(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	if _, err := mmc.GetImage(); err != mmcore.ErrCameraNotAvailable {
+		t.Errorf("GetImage without camera: got error %v, want %v", err, mmcore.ErrCameraNotAvailable)
+	}
+	if err := mmc.SetCameraDevice("DCam"); err != nil {
+		t.Fatal(err)
+	}
+	for _, prop := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"PixelType", "16bit"},
+		{"BitDepth", 12},
+	} {
+		if err := mmc.SetProperty("DCam", prop.name, prop.value); err != nil {
+			t.Fatalf("SetProperty(%s): %v", prop.name, err)
+		}
+	}
+	if err := mmc.SetROI(10, 20, 64, 32); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SnapImage(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := mmc.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 64*32*2 {
+		t.Fatalf("len(buf) = %d, want %d", len(buf), 64*32*2)
+	}
+
+	// The stub renders a ramp of x0+x+y0+y in little-endian 16-bit pixels.
+	for _, px := range []struct{ x, y int }{{0, 0}, {63, 0}, {5, 31}} {
+		i := (px.y*64 + px.x) * 2
+		got := int(buf[i]) | int(buf[i+1])<<8
+		if want := 10 + px.x + 20 + px.y; got != want {
+			t.Errorf("pixel (%d, %d) = %d, want %d", px.x, px.y, got, want)
+		}
+	}
+
+	// The image is copied into Go memory, and stays valid after the next snap.
+	if err := mmc.SnapImage(); err != nil {
+		t.Fatal(err)
+	}
+	if got := int(buf[0]) | int(buf[1])<<8; got != 30 {
+		t.Errorf("pixel (0, 0) of the first image changed to %d", got)
+	}
+
+	// GetImageData returns the second image with the geometry of the camera.
+	img, err := mmc.GetImageData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 64 || img.Height != 32 || img.BytesPerPixel != 2 || img.BitDepth != 12 || len(img.Pix) != len(buf) {
+		t.Errorf("GetImageData: %dx%d, %d bytes per pixel, bit depth %d", img.Width, img.Height, img.BytesPerPixel, img.BitDepth)
+	}
+	if v, c := img.Value(63, 31), img.At(63, 31); v != 125 || c != (color.Gray16{Y: 2000}) {
+		t.Errorf("pixel (63, 31): value %d, color %v", v, c)
+	}
+}
+
+func TestStubSequenceAcquisition(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	if err := mmc.SetCameraDevice("DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetExposureTime(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mmc.PopNextImage(); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("PopNextImage on empty buffer: got error %v, want %v", err, mmcore.ErrCircularBufferEmpty)
+	}
+
+	if err := mmc.StartSequenceAcquisition(5, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var images [][]byte
+	for len(images) < 5 && time.Now().Before(deadline) {
+		if mmc.GetRemainingImageCount() == 0 {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		buf, err := mmc.PopNextImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, buf)
+	}
+	if err := mmc.StopSequenceAcquisition(); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 5 {
+		t.Fatalf("acquired %d images, want 5", len(images))
+	}
+
+	// Each frame shifts the ramp by one, so the first pixel counts the frames.
+	for i, buf := range images {
+		if len(buf) != 512*512 || int(buf[0]) != i {
+			t.Errorf("image %d: len=%d, first pixel=%d", i, len(buf), buf[0])
+		}
+	}
+	if last, err := mmc.GetLastImage(); err != nil || last[0] != 4 {
+		t.Errorf("GetLastImage() = %v, %v", last[:1], err)
+	}
+}
+
+func TestStubLargeSequence(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	// 16x16 8-bit images fill the 250 MB buffer with more than 32767 images.
+	for _, err := range []error{
+		mmc.SetCameraDevice("DCam"),
+		mmc.SetExposureTime(1),
+		mmc.SetROI(0, 0, 16, 16),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const capacity = 250 << 20 / (16 * 16)
+	if n := mmc.GetBufferTotalCapacity(); n != capacity {
+		t.Errorf("GetBufferTotalCapacity() = %d, want %d", n, capacity)
+	}
+	if err := mmc.StartSequenceAcquisition(40000, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mmc.GetRemainingImageCount() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := mmc.StopSequenceAcquisition(); err != nil {
+		t.Fatal(err)
+	}
+	remaining := mmc.GetRemainingImageCount()
+	if free := mmc.GetBufferFreeCapacity(); remaining < 3 || free != capacity-remaining {
+		t.Errorf("remaining %d images, free capacity %d", remaining, free)
+	}
+	too_many := int64(math.MaxInt32) + 1
+	for _, num_images := range []int64{too_many, 0, -1} {
+		if err := mmc.StartSequenceAcquisition(int(num_images), 0, true); err != mmcore.ErrInvalidImageSequence {
+			t.Errorf("StartSequenceAcquisition of %d images: %v", num_images, err)
+		}
+	}
+
+	// The version 1 functions saturate instead of wrapping around.
+	if r, total, free := mmc.StubBufferCountsV1(); int(r) != remaining || total != math.MaxInt16 || free != math.MaxInt16 {
+		t.Errorf("version 1 counts: remaining %d, total %d, free %d", r, total, free)
+	}
+	if err := mmc.StubStartSequenceAcquisitionV1(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.StopSequenceAcquisition(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.StubStartSequenceAcquisitionV1(-1); err != mmcore.ErrInvalidImageSequence {
+		t.Errorf("version 1 StartSequenceAcquisition of -1 images: %v", err)
+	}
+}
+
+func TestStubImageRetrieval(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel")
+	defer mmc.Close()
+
+	for _, err := range []error{
+		mmc.SetCameraDevice("DCam"),
+		mmc.SetExposureTime(1),
+		mmc.SetROI(0, 0, 64, 32),
+		mmc.StartSequenceAcquisition(4, 0, true),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mmc.IsSequenceRunning() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := mmc.GetRemainingImageCount(); n != 4 {
+		t.Fatalf("acquired %d images, want 4", n)
+	}
+
+	// A short buffer leaves the image in the circular buffer.
+	if _, err := mmc.PopNextImageInto(make([]byte, 100)); err != io.ErrShortBuffer {
+		t.Errorf("PopNextImageInto a short buffer: %v", err)
+	}
+	dst := make([]byte, 64*32)
+	img, err := mmc.PopNextImageInto(dst)
+	if err != nil || &img.Pix[0] != &dst[0] || img.Width != 64 || img.Pix[0] != 0 {
+		t.Errorf("PopNextImageInto: %v, %+v", err, img)
+	}
+
+	var pool mmcore.ImagePool
+	img, err = mmc.PopNextImagePooled(&pool)
+	if err != nil || len(img.Pix) != 64*32 || img.Pix[0] != 1 {
+		t.Fatalf("PopNextImagePooled: %v, %+v", err, img)
+	}
+	pool.Put(img.Pix)
+
+	// The calls that could free a borrowed image wait until it is released.
+	// The others do not, even from the goroutine that borrowed the image.
+	borrowed, err := mmc.BorrowNextImage()
+	if err != nil || borrowed.Pix[0] != 2 {
+		t.Fatalf("BorrowNextImage: %v, %+v", err, borrowed)
+	}
+	set := make(chan error)
+	go func() {
+		set <- mmc.SetProperty("DWheel", "State", 1)
+	}()
+	select {
+	case err := <-set:
+		if err != nil {
+			t.Errorf("SetProperty of DWheel while an image is borrowed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetProperty of DWheel waited for the borrowed image")
+	}
+	if err := mmc.SetProperty("DWheel", "State", 2); err != nil {
+		t.Errorf("SetProperty of DWheel from the borrowing goroutine: %v", err)
+	}
+	if err := mmc.SnapImage(); err != nil {
+		t.Errorf("SnapImage while an image is borrowed: %v", err)
+	}
+	popped := make(chan []byte)
+	go func() {
+		buf, _ := mmc.PopNextImage()
+		popped <- buf
+	}()
+	select {
+	case <-popped:
+		t.Error("PopNextImage returned before the borrowed image was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+	borrowed.Release()
+	borrowed.Release()
+	if buf := <-popped; len(buf) == 0 || buf[0] != 3 || borrowed.Image != nil {
+		t.Errorf("after Release: popped %d bytes, borrowed image %v", len(buf), borrowed.Image)
+	}
+
+	if _, err := mmc.BorrowNextImage(); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("BorrowNextImage on empty buffer: %v", err)
+	}
+	if _, err := mmc.PopNextImage(); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("PopNextImage on empty buffer: %v", err)
+	}
+}
+
+func TestStubImageMetadata(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	if _, _, err := mmc.GetLastImageWithMetadata(); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("GetLastImageWithMetadata on empty buffer: %v", err)
+	}
+	start := time.Now().Add(-time.Second)
+	for _, err := range []error{
+		mmc.SetCameraDevice("DCam"),
+		mmc.SetExposureTime(1),
+		mmc.SetROI(4, 2, 64, 32),
+		mmc.StartSequenceAcquisition(3, 0, true),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mmc.IsSequenceRunning() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	img, md, err := mmc.GetLastImageWithMetadata()
+	if err != nil || img.Width != 64 || md.ImageNumber != 2 {
+		t.Fatalf("GetLastImageWithMetadata: %v, %+v, %+v", err, img, md)
+	}
+	var elapsed time.Duration = -1
+	for i := int64(0); i < 3; i++ {
+		img, md, err := mmc.PopNextImageWithMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Height != 32 || md.Camera != "DCam" || md.ImageNumber != i || md.Binning != 1 ||
+			md.PixelType != "8bit" || md.ROIX != 4 || md.ROIY != 2 || md.Tags[mmcore.MetadataWidth] != "64" {
+			t.Errorf("image %d: %+v", i, md)
+		}
+		if md.ElapsedTime <= elapsed {
+			t.Errorf("image %d: elapsed time %v after %v", i, md.ElapsedTime, elapsed)
+		}
+		elapsed = md.ElapsedTime
+		if md.TimeReceived.Before(start) || md.TimeReceived.After(time.Now().Add(time.Second)) {
+			t.Errorf("image %d: received at %v", i, md.TimeReceived)
+		}
+	}
+	if _, _, err := mmc.PopNextImageWithMetadata(); err != mmcore.ErrCircularBufferEmpty {
+		t.Errorf("PopNextImageWithMetadata on empty buffer: %v", err)
+	}
+}
+
+func TestStubStreamSequence(t *testing.T) {
+	mmc := newStubSession(t, "DCam")
+	defer mmc.Close()
+
+	if err := mmc.SetCameraDevice("DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetExposureTime(1); err != nil {
+		t.Fatal(err)
+	}
+	frames, errs, err := mmc.StreamSequence(context.Background(), mmcore.StreamOptions{NumImages: 4, BufferSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	for frame := range frames {
+		if frame.Metadata.ImageNumber != n || frame.Metadata.Camera != "DCam" || int64(frame.Value(0, 0)) != n {
+			t.Errorf("frame %d: first pixel %d, %+v", n, frame.Value(0, 0), frame.Metadata)
+		}
+		n++
+	}
+	if err := <-errs; err != nil || n != 4 {
+		t.Errorf("received %d frames, error %v", n, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	frames, errs, err = mmc.StreamSequence(ctx, mmcore.StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range frames {
+	}
+	if err := <-errs; err != context.DeadlineExceeded || mmc.IsSequenceRunning() {
+		t.Errorf("after the deadline: %v, running %v", err, mmc.IsSequenceRunning())
+	}
+}
+
+func TestStubEvents(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DStage", "DXYStage")
+	defer mmc.Close()
+
+	propertiesEvents := make(chan *mmcore.PropertiesChangedEvent, 16)
+	propertyEvents := make(chan *mmcore.PropertyChangedEvent, 16)
+	configGroupEvents := make(chan *mmcore.ConfigGroupChangedEvent, 16)
+	systemConfigurationEvents := make(chan *mmcore.SystemConfigurationLoadedEvent, 16)
+	pixelSizeEvents := make(chan *mmcore.PixelSizeChangedEvent, 16)
+	stageEvents := make(chan *mmcore.StagePositionChangedEvent, 16)
+	xyStageEvents := make(chan *mmcore.XYStagePositionChangedEvent, 16)
+	exposureEvents := make(chan *mmcore.ExposureChangedEvent, 16)
+	slmExposureEvents := make(chan *mmcore.SLMExposureChangedEvent, 16)
+	mmc.NotifyPropertiesChanged(propertiesEvents)
+	mmc.NotifyPropertyChanged(propertyEvents)
+	mmc.NotifyConfigGroupChanged(configGroupEvents)
+	mmc.NotifySystemConfigurationLoaded(systemConfigurationEvents)
+	mmc.NotifyPixelSizeChanged(pixelSizeEvents)
+	mmc.NotifyStagePositionChanged(stageEvents)
+	mmc.NotifyXYStagePositionChanged(xyStageEvents)
+	mmc.NotifyExposureChanged(exposureEvents)
+	mmc.NotifySLMExposureChanged(slmExposureEvents)
+
+	// The events are delivered from the callback thread of the stub.
+	timeout := time.After(5 * time.Second)
+	expect := func(ch interface{}, want interface{}) {
+		t.Helper()
+		chosen, event, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout)},
+		})
+		if chosen != 0 {
+			t.Fatalf("timeout waiting for %T", want)
+		}
+		if got := event.Elem().Interface(); got != want {
+			t.Errorf("got %T %+v, want %+v", got, got, want)
+		}
+	}
+
+	if err := mmc.InitializeDevice("DCam"); err != mmcore.ErrGENERIC {
+		t.Errorf("InitializeDevice of an initialized device: got error %v, want %v", err, mmcore.ErrGENERIC)
+	}
+	if err := mmc.LoadDevice("Shutter", "DemoCamera", "DShutter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeDevice("Shutter"); err != nil {
+		t.Fatal(err)
+	}
+	expect(propertiesEvents, mmcore.PropertiesChangedEvent{})
+
+	if err := mmc.SetProperty("DCam", "Gain", 3); err != nil {
+		t.Fatal(err)
+	}
+	expect(propertyEvents, mmcore.PropertyChangedEvent{Label: "DCam", Property: "Gain", Value: "3"})
+
+	if err := mmc.SetPosition("DStage", 12.5); err != nil {
+		t.Fatal(err)
+	}
+	expect(stageEvents, mmcore.StagePositionChangedEvent{Label: "DStage", Pos: 12.5})
+
+	if err := mmc.SetXYPosition("DXYStage", 30, -15); err != nil {
+		t.Fatal(err)
+	}
+	expect(xyStageEvents, mmcore.XYStagePositionChangedEvent{Label: "DXYStage", X: 30, Y: -15})
+
+	if err := mmc.SetCameraDevice("DCam"); err != nil {
+		t.Fatal(err)
+	}
+	expect(propertyEvents, mmcore.PropertyChangedEvent{Label: "Core", Property: "Camera", Value: "DCam"})
+	if err := mmc.SetExposureTime(25); err != nil {
+		t.Fatal(err)
+	}
+	expect(propertyEvents, mmcore.PropertyChangedEvent{Label: "DCam", Property: "Exposure", Value: "25.0000"})
+	expect(exposureEvents, mmcore.ExposureChangedEvent{Label: "DCam", ExposureMs: 25})
+
+	mmc.StubPostConfigGroupChanged("Channel", "DAPI")
+	expect(configGroupEvents, mmcore.ConfigGroupChangedEvent{GroupName: "Channel", ConfigName: "DAPI"})
+	mmc.StubPostSystemConfigurationLoaded()
+	expect(systemConfigurationEvents, mmcore.SystemConfigurationLoadedEvent{})
+	mmc.StubPostPixelSizeChanged(0.65)
+	expect(pixelSizeEvents, mmcore.PixelSizeChangedEvent{PixelSizeUm: 0.65})
+	mmc.StubPostSLMExposureChanged("SLM", 5)
+	expect(slmExposureEvents, mmcore.SLMExposureChangedEvent{Label: "SLM", ExposureMs: 5})
+}
+
+// Run with -race to check the concurrency guarantees of Session.
+func TestStubConcurrentUse(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mmc := mmcore.NewSession()
+			events := make(chan *mmcore.PropertyChangedEvent, 1)
+			sub := mmc.NotifyPropertyChanged(events)
+			mmc.SetProperty("Core", "TimeoutMs", 1000)
+			sub.Unsubscribe()
+			mmc.Close()
+			mmc.Close()
+		}()
+	}
+	wg.Wait()
+
+	mmc := newStubSession(t, "DCam", "DStage")
+	defer mmc.Close()
+	if err := mmc.SetCameraDevice("DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.SetExposureTime(1); err != nil {
+		t.Fatal(err)
+	}
+	stageEvents := make(chan *mmcore.StagePositionChangedEvent, 1)
+	sub := mmc.NotifyStagePositionChanged(stageEvents)
+	if err := mmc.StartContinuousSequenceAcquisition(0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 20; {
+			if mmc.GetRemainingImageCount() == 0 {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			buf, err := mmc.PopNextImage()
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			if len(buf) != 512*512 {
+				t.Errorf("len(buf) = %d", len(buf))
+			}
+			n++
+		}
+		close(done)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := mmc.SetPosition("DStage", float64(i)); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := mmc.GetPosition("DStage"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-stageEvents:
+			}
+		}
+	}()
+	wg.Wait()
+	sub.Unsubscribe()
+
+	// Changing the camera during the sequence is refused.
+	if err := mmc.SnapImage(); err != mmcore.ErrNotAllowedDuringSequenceAcquisition {
+		t.Errorf("SnapImage during sequence: got error %v, want %v", err, mmcore.ErrNotAllowedDuringSequenceAcquisition)
+	}
+	if err := mmc.StopSequenceAcquisition(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscriptions stay active after Reset.
+	propertiesEvents := make(chan *mmcore.PropertiesChangedEvent, 1)
+	mmc.NotifyPropertiesChanged(propertiesEvents)
+	if err := mmc.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.LoadDevice("DCam", "DemoCamera", "DCam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.InitializeAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-propertiesEvents:
+	case <-time.After(5 * time.Second):
+		t.Error("no PropertiesChangedEvent after Reset")
+	}
+}
+
+func TestStubWaitForDevice(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DStage")
+	defer mmc.Close()
+
+	if err := mmc.WaitForDevice(context.Background(), "NoSuchDevice"); err != mmcore.ErrInvalidLabel {
+		t.Errorf("WaitForDevice(NoSuchDevice): got error %v, want %v", err, mmcore.ErrInvalidLabel)
+	}
+
+	if err := mmc.StubSetDeviceBusy("DStage", 50); err != nil {
+		t.Fatal(err)
+	}
+	if busy, err := mmc.DeviceBusy("DStage"); err != nil || !busy {
+		t.Errorf("DeviceBusy(DStage) = %v, %v", busy, err)
+	}
+	if busy, err := mmc.DeviceTypeBusy(mmcore.CameraDeviceType); err != nil || busy {
+		t.Errorf("DeviceTypeBusy(CameraDeviceType) = %v, %v", busy, err)
+	}
+	if busy, err := mmc.DeviceTypeBusy(mmcore.StageDeviceType); err != nil || !busy {
+		t.Errorf("DeviceTypeBusy(StageDeviceType) = %v, %v", busy, err)
+	}
+
+	// The deadline is exceeded before the stage stops.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := mmc.WaitForSystem(ctx); err != mmcore.ErrDevicePollingTimeout {
+		t.Errorf("WaitForSystem: got error %v, want %v", err, mmcore.ErrDevicePollingTimeout)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := mmc.WaitForDeviceType(ctx, mmcore.StageDeviceType); err != context.Canceled {
+		t.Errorf("WaitForDeviceType after cancel: got error %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mmc.WaitForDevice(ctx, "DStage"); err != nil {
+		t.Fatal(err)
+	}
+	if busy, err := mmc.DeviceBusy("DStage"); err != nil || busy {
+		t.Errorf("DeviceBusy(DStage) after WaitForDevice = %v, %v", busy, err)
+	}
+}
+
+func TestStubLoadSystemConfiguration(t *testing.T) {
+	mmc := mmcore.NewSession()
+	defer mmc.Close()
+	loaded := make(chan *mmcore.SystemConfigurationLoadedEvent, 1)
+	mmc.NotifySystemConfigurationLoaded(loaded)
+
+	dir, err := ioutil.TempDir("", "mmcorestub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, text string) string {
+		path := dir + "/" + name
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := write("good.cfg", `# Stub devices
+Property,Core,Initialize,0
+Device,Hub,DemoCamera,DHub
+Device,Cam,DemoCamera,DCam
+Device,Wheel,DemoCamera,DWheel
+Parent,Cam,Hub
+Property,Core,Initialize,1
+Delay,Wheel,12.5
+Label,Wheel,1,GFP
+Property,Cam,Binning,2
+Property,Core,Camera,Cam
+`)
+	if err := mmc.LoadSystemConfiguration(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Error("no SystemConfigurationLoadedEvent")
+	}
+	parent, _ := mmc.GetParentLabel("Cam")
+	state, _ := mmc.GetStateFromLabel("Wheel", "GFP")
+	binning, _ := mmc.GetPropertyInt("Cam", "Binning")
+	if mmc.CameraDevice() != "Cam" || parent != "Hub" || state != 1 || binning != 2 {
+		t.Errorf("camera %q, parent %q, GFP state %d, binning %d", mmc.CameraDevice(), parent, state, binning)
+	}
+	if delay_ms, err := mmc.GetDeviceDelayMs("Wheel"); err != nil || delay_ms != 12.5 {
+		t.Errorf("GetDeviceDelayMs: %g, %v, want 12.5", delay_ms, err)
+	}
+
+	path = write("bad.cfg", "Property,Core,Initialize,0\nDevice,Cam,DemoCamera,DCam\nDevice,Cam,DemoCamera,DCam\n")
+	err = mmc.LoadSystemConfiguration(path)
+	if cfg_err, ok := err.(*mmcore.CFGError); !ok || cfg_err.Line != 3 {
+		t.Errorf("LoadSystemConfiguration: %v, want an error at line 3", err)
+	}
+	if !errors.Is(err, mmcore.ErrInvalidCFGEntry) || !errors.Is(err, mmcore.ErrDuplicateLabel) {
+		t.Errorf("LoadSystemConfiguration: %v, want an invalid entry caused by a duplicate label", err)
+	}
+	if labels, _ := mmc.GetLoadedDevices(); fmt.Sprint(labels) != "[Core]" {
+		t.Errorf("devices %v are still loaded after the error", labels)
+	}
+}
+
+func TestStubSaveSystemConfiguration(t *testing.T) {
+	mmc := newStubSession(t, "DHub", "DCam", "DWheel", "DStage")
+	defer mmc.Close()
+	for _, err := range []error{
+		mmc.SetParentLabel("DCam", "DHub"),
+		mmc.InitializeAllDevices(),
+		mmc.DefineStateLabel("DWheel", 2, "Cy5"),
+		mmc.SetCameraDevice("DCam"),
+		mmc.SetFocusDevice("DStage"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name, err := mmc.GetDeviceName("DWheel"); name != "DWheel" || err != nil {
+		t.Errorf("GetDeviceName: %q, %v", name, err)
+	}
+	if device_type, err := mmc.GetDeviceType("DStage"); device_type != mmcore.StageDeviceType || err != nil {
+		t.Errorf("GetDeviceType: %v, %v", device_type, err)
+	}
+
+	cfg, err := mmcore.TakeSystemConfiguration(mmc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved strings.Builder
+	cfg.WriteTo(&saved)
+	for _, line := range []string{
+		"Device,DWheel,DemoCamera,DWheel",
+		"Parent,DCam,DHub",
+		"Property,Core,Camera,DCam",
+		"Property,Core,Focus,DStage",
+		"Label,DWheel,2,Cy5",
+	} {
+		if !strings.Contains(saved.String(), line+"\n") {
+			t.Errorf("no line %q in:\n%s", line, saved.String())
+		}
+	}
+
+	loaded := mmcore.NewSession()
+	defer loaded.Close()
+	cfg, err = mmcore.ParseSystemConfiguration(strings.NewReader(saved.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mmcore.ApplySystemConfiguration(loaded, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = mmcore.TakeSystemConfiguration(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resaved strings.Builder
+	cfg.WriteTo(&resaved)
+	if resaved.String() != saved.String() {
+		t.Errorf("saved again:\n%s\nwant:\n%s", resaved.String(), saved.String())
+	}
+}
+
+func TestStubConfigGroups(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DWheel")
+	defer mmc.Close()
+	events := make(chan *mmcore.ConfigGroupChangedEvent, 16)
+	mmc.NotifyConfigGroupChanged(events)
+
+	for _, err := range []error{
+		mmc.InitializeAllDevices(),
+		mmc.DefineConfigSetting("Channel", "DAPI", "DWheel", "State", "1"),
+		mmc.DefineConfigSetting("Channel", "DAPI", "DCam", "Exposure", "50"),
+		mmc.DefineConfigSetting("Channel", "FITC", "DWheel", "State", "2"),
+		mmc.DefineConfig("Channel", "Empty"),
+		mmc.DefineConfigGroup("Objective"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.DefineConfigGroup("Channel"); err != mmcore.ErrDuplicateConfigGroup {
+		t.Errorf("DefineConfigGroup duplicate: %v", err)
+	}
+	if _, err := mmc.GetCurrentConfig("Filter"); err != mmcore.ErrNoConfigGroup {
+		t.Errorf("GetCurrentConfig of an undefined group: %v", err)
+	}
+	groups, _ := mmc.GetAvailableConfigGroups()
+	configs, _ := mmc.GetAvailableConfigs("Channel")
+	if fmt.Sprint(groups, configs) != "[Channel Objective] [DAPI FITC Empty]" {
+		t.Errorf("groups %v, presets %v", groups, configs)
+	}
+	settings, err := mmc.GetConfigData("Channel", "DAPI")
+	want := []mmcore.PropertySetting{{"DWheel", "State", "1"}, {"DCam", "Exposure", "50"}}
+	if err != nil || !reflect.DeepEqual(settings, want) {
+		t.Errorf("GetConfigData: %v, %v, want %v", settings, err, want)
+	}
+
+	if err := mmc.SetConfig("Channel", "DAPI"); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := mmc.GetCurrentConfig("Channel")
+	exposure, _ := mmc.GetPropertyFloat("DCam", "Exposure")
+	if current != "DAPI" || exposure != 50 {
+		t.Errorf("after SetConfig: current %q, exposure %g", current, exposure)
+	}
+	if err := mmc.SetState("DWheel", 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"DAPI", "FITC"} {
+		select {
+		case event := <-events:
+			if event.GroupName != "Channel" || event.ConfigName != want {
+				t.Errorf("event %+v, want Channel %s", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %s", want)
+		}
+	}
+
+	// WaitForConfig waits for the devices of the preset only.
+	if err := mmc.StubSetDeviceBusy("DCam", 50); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := mmc.WaitForConfig(context.Background(), "Channel", "FITC"); err != nil || time.Since(start) > 40*time.Millisecond {
+		t.Errorf("WaitForConfig FITC: %v after %v", err, time.Since(start))
+	}
+	if err := mmc.WaitForConfig(context.Background(), "Channel", "DAPI"); err != nil || time.Since(start) < 40*time.Millisecond {
+		t.Errorf("WaitForConfig DAPI: %v after %v", err, time.Since(start))
+	}
+
+	if err := mmc.DeleteConfig("Channel", "Empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmc.RenameConfigGroup("Channel", "Filter"); err != nil {
+		t.Fatal(err)
+	}
+	if defined, _ := mmc.IsConfigDefined("Filter", "FITC"); !defined {
+		t.Error("FITC is not defined after renaming the group")
+	}
+}
+
+func TestStubPixelSizeConfigs(t *testing.T) {
+	mmc := newStubSession(t, "DCam", "DObjective")
+	defer mmc.Close()
+	events := make(chan *mmcore.PixelSizeChangedEvent, 16)
+	mmc.NotifyPixelSizeChanged(events)
+
+	for _, err := range []error{
+		mmc.SetCameraDevice("DCam"),
+		mmc.DefinePixelSizeConfig("Res10x", "DObjective", "State", "1"),
+		mmc.DefinePixelSizeConfig("Res20x", "DObjective", "State", "2"),
+		mmc.SetPixelSizeUm("Res10x", 0.65),
+		mmc.SetPixelSizeAffine("Res10x", []float64{0.65, 0, 1, 0, 0.65, 2}),
+		mmc.SetPixelSizeUm("Res20x", 0.325),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmc.SetPixelSizeAffine("Res10x", []float64{1}); err != mmcore.ErrBadAffineTransform {
+		t.Errorf("SetPixelSizeAffine with 1 element: %v", err)
+	}
+	if err := mmc.SetPixelSizeConfig("Res40x"); err != mmcore.ErrNoConfiguration {
+		t.Errorf("SetPixelSizeConfig of an undefined configuration: %v", err)
+	}
+	ids, _ := mmc.GetAvailablePixelSizeConfigs()
+	settings, err := mmc.GetPixelSizeConfigData("Res20x")
+	want := []mmcore.PropertySetting{{"DObjective", "State", "2"}}
+	if fmt.Sprint(ids) != "[Res10x Res20x]" || err != nil || !reflect.DeepEqual(settings, want) {
+		t.Errorf("configurations %v, Res20x %v, %v", ids, settings, err)
+	}
+
+	for _, err := range []error{
+		mmc.SetPixelSizeConfig("Res10x"),
+		mmc.SetProperty("DCam", "Binning", 2),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []float64{0.65, 1.3} {
+		select {
+		case event := <-events:
+			if event.PixelSizeUm != want {
+				t.Errorf("event %+v, want %g", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %g", want)
+		}
+	}
+	id, _ := mmc.GetCurrentPixelSizeConfig()
+	size, _ := mmc.GetPixelSizeUm()
+	affine, _ := mmc.GetPixelSizeAffine()
+	if id != "Res10x" || size != 1.3 || fmt.Sprint(affine) != "[1.3 0 1 0 1.3 2]" {
+		t.Errorf("current %q, %g um, affine %v", id, size, affine)
+	}
+
+	// Without a current XY stage, the transform is relative to the stage position.
+	if err := mmc.SetROI(0, 0, 100, 50); err != nil {
+		t.Fatal(err)
+	}
+	_, _, width, height, err := mmc.GetROI()
+	if err != nil || width != 100 || height != 50 {
+		t.Errorf("GetROI: %dx%d, %v", width, height, err)
+	}
+	tr, err := mmcore.CurrentPixelTransform(mmc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, y := tr.PixelToStage(60, 25); x != 14 || y != 2 {
+		t.Errorf("PixelToStage: %g, %g", x, y)
+	}
+
+	if err := mmc.RenamePixelSizeConfig("Res10x", "Res20x"); err != mmcore.ErrDuplicateConfigGroup {
+		t.Errorf("RenamePixelSizeConfig to an existing configuration: %v", err)
+	}
+	if err := mmc.DeletePixelSizeConfig("Res10x"); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := mmc.GetPixelSizeUm(); size != 0 {
+		t.Errorf("GetPixelSizeUm without a matching configuration: %g", size)
+	}
+}
+
+func TestStubStartupConfig(t *testing.T) {
+	mmc := mmcore.NewSession()
+	defer mmc.Close()
+
+	text := `Device,Cam,DemoCamera,DCam
+Property,Core,Initialize,1
+ConfigGroup,System,Startup,Cam,Binning,2
+ConfigGroup,System,Startup,Core,Camera,Cam
+ConfigGroup,Objective
+`
+	cfg, err := mmcore.ParseSystemConfiguration(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mmcore.ApplySystemConfiguration(mmc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	binning, _ := mmc.GetPropertyInt("Cam", "Binning")
+	if binning != 2 || mmc.CameraDevice() != "Cam" {
+		t.Errorf("after the startup preset: binning %d, camera %q", binning, mmc.CameraDevice())
+	}
+
+	saved, err := mmcore.TakeSystemConfiguration(mmc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	saved.WriteTo(&b)
+	for _, line := range []string{
+		"ConfigGroup,System,Startup,Cam,Binning,2",
+		"ConfigGroup,System,Startup,Core,Camera,Cam",
+		"ConfigGroup,Objective",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("no line %q in:\n%s", line, b.String())
+		}
+	}
+}